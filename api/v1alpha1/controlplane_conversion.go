@@ -0,0 +1,152 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	claiov1beta1 "claio/api/v1beta1"
+)
+
+// ConversionDataAnnotation keeps the v1beta1 spec and status, so an object read and written back
+// as v1alpha1 does not lose the fields which have no v1alpha1 counterpart.
+const ConversionDataAnnotation = "claio.github.com/conversion-data"
+
+// defaultDNSDomain is the cluster domain all v1alpha1 control-planes have been created with
+const defaultDNSDomain = "cluster.local"
+
+// conversionData is the content of the ConversionDataAnnotation, the fields v1alpha1 carries
+// itself take precedence over the stored ones
+type conversionData struct {
+	Spec   *claiov1beta1.ControlPlaneSpec   `json:"spec,omitempty"`
+	Status *claiov1beta1.ControlPlaneStatus `json:"status,omitempty"`
+}
+
+// ConvertTo converts this ControlPlane (v1alpha1) to the Hub version (v1beta1).
+func (src *ControlPlane) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*claiov1beta1.ControlPlane)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	// restore the fields v1alpha1 does not know about
+	restored, err := restoreConversionData(dst.Annotations[ConversionDataAnnotation])
+	if err != nil {
+		return fmt.Errorf("failed to restore conversion data of %s: %s", src.Name, err)
+	}
+	if _, ok := dst.Annotations[ConversionDataAnnotation]; ok {
+		delete(dst.Annotations, ConversionDataAnnotation)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	dst.Spec = specToHub(&src.Spec, restored.Spec)
+	dst.Status = *restored.Status
+	dst.Status.TargetSpec = specToHub(&src.Status.TargetSpec, &restored.Status.TargetSpec)
+	dst.Status.Phase = claiov1beta1.ControlPlanePhase(src.Status.Phase)
+	dst.Status.ObservedGeneration = src.Status.ObservedGeneration
+	dst.Status.LastError = src.Status.LastError
+	dst.Status.Conditions = src.Status.Conditions
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version (v1alpha1).
+func (dst *ControlPlane) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*claiov1beta1.ControlPlane)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec = specFromHub(&src.Spec)
+	dst.Status = ControlPlaneStatus{
+		TargetSpec:         specFromHub(&src.Status.TargetSpec),
+		Phase:              ControlPlanePhase(src.Status.Phase),
+		ObservedGeneration: src.Status.ObservedGeneration,
+		LastError:          src.Status.LastError,
+		Conditions:         src.Status.Conditions,
+	}
+
+	// keep the whole spec and status if v1alpha1 can not represent them, the conditions
+	// are carried by v1alpha1 itself
+	converted := &claiov1beta1.ControlPlane{}
+	if err := (&ControlPlane{Spec: dst.Spec, Status: dst.Status}).ConvertTo(converted); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(converted.Spec, src.Spec) && reflect.DeepEqual(converted.Status, src.Status) {
+		return nil
+	}
+	status := src.Status.DeepCopy()
+	status.Conditions = nil
+	data, err := json.Marshal(conversionData{Spec: &src.Spec, Status: status})
+	if err != nil {
+		return fmt.Errorf("failed to store conversion data of %s: %s", src.Name, err)
+	}
+	if dst.Annotations == nil {
+		dst.Annotations = map[string]string{}
+	}
+	dst.Annotations[ConversionDataAnnotation] = string(data)
+	return nil
+}
+
+// restoreConversionData reads the ConversionDataAnnotation, objects without it have been created as
+// v1alpha1 with the default DNS domain
+func restoreConversionData(data string) (*conversionData, error) {
+	restored := &conversionData{}
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), restored); err != nil {
+			return nil, err
+		}
+	}
+	if restored.Spec == nil {
+		restored.Spec = &claiov1beta1.ControlPlaneSpec{Network: claiov1beta1.NetworkSpec{DNSDomain: defaultDNSDomain}}
+	}
+	if restored.Status == nil {
+		restored.Status = &claiov1beta1.ControlPlaneStatus{
+			TargetSpec: claiov1beta1.ControlPlaneSpec{Network: claiov1beta1.NetworkSpec{DNSDomain: defaultDNSDomain}},
+		}
+	}
+	return restored, nil
+}
+
+// specToHub maps the flat v1alpha1 spec onto the grouped v1beta1 spec, fields
+// without v1alpha1 counterpart are taken from restored
+func specToHub(spec *ControlPlaneSpec, restored *claiov1beta1.ControlPlaneSpec) claiov1beta1.ControlPlaneSpec {
+	hub := *restored.DeepCopy()
+	hub.Name = spec.Name
+	hub.Version = spec.Version
+	hub.Endpoint.Host = spec.AdvertiseHost
+	hub.Endpoint.Address = spec.AdvertiseAddress
+	hub.Endpoint.Port = spec.Port
+	hub.Network.ClusterCIDR = spec.ClusterCIDR
+	hub.Network.ServiceCIDR = spec.ServiceCIDR
+	hub.Datastore.Driver = spec.Database
+	return hub
+}
+
+func specFromHub(spec *claiov1beta1.ControlPlaneSpec) ControlPlaneSpec {
+	return ControlPlaneSpec{
+		Name:             spec.Name,
+		Port:             spec.Endpoint.Port,
+		Version:          spec.Version,
+		Database:         spec.Datastore.Driver,
+		ClusterCIDR:      spec.Network.ClusterCIDR,
+		ServiceCIDR:      spec.Network.ServiceCIDR,
+		AdvertiseAddress: spec.Endpoint.Address,
+		AdvertiseHost:    spec.Endpoint.Host,
+	}
+}
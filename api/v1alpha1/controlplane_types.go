@@ -28,26 +28,94 @@ type ControlPlaneSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// Foo is an example field of ControlPlane. Edit controlplane_types.go to remove/update
-	Name             string `json:"name"`
-	Port             int    `json:"port"`
-	Version          string `json:"version"`
-	Database         string `json:"database"`
-	ClusterCIDR      string `json:"cluster-cidr"`
-	ServiceCIDR      string `json:"service-cidr"`
+	// Name of the tenant, the control-plane runs in the namespace tenant-<name>
+	Name string `json:"name"`
+	// Port of the apiserver (defaulted by the manager, see --default-port)
+	// +optional
+	Port int `json:"port,omitempty"`
+	// Version of kubernetes without leading 'v' (defaulted by the manager, see --default-version)
+	// +optional
+	Version string `json:"version,omitempty"`
+	// Database is the datastore driver of the tenant: nats, postgres, mysql, sqlite or etcd
+	// (defaulted by the manager, see --default-database)
+	// +optional
+	Database string `json:"database,omitempty"`
+	// ClusterCIDR is the pod network (defaulted by the manager, see --default-cluster-cidr)
+	// +optional
+	ClusterCIDR string `json:"cluster-cidr,omitempty"`
+	// ServiceCIDR is the service network (defaulted by the manager, see --default-service-cidr)
+	// +optional
+	ServiceCIDR      string `json:"service-cidr,omitempty"`
 	AdvertiseAddress string `json:"advertise-address"`
 	AdvertiseHost    string `json:"advertise-host"`
 }
 
+// ControlPlanePhase is a simple, high-level summary of where the ControlPlane is in its lifecycle
+type ControlPlanePhase string
+
+const (
+	// ControlPlanePhasePending means the ControlPlane has been accepted but not reconciled yet
+	ControlPlanePhasePending ControlPlanePhase = "Pending"
+	// ControlPlanePhaseProvisioning means certificates, kubeconfigs, deployment or service are being created
+	ControlPlanePhaseProvisioning ControlPlanePhase = "Provisioning"
+	// ControlPlanePhaseReady means all components are up and the apiserver is available
+	ControlPlanePhaseReady ControlPlanePhase = "Ready"
+	// ControlPlanePhaseDegraded means the ControlPlane was ready before but is not anymore
+	ControlPlanePhaseDegraded ControlPlanePhase = "Degraded"
+	// ControlPlanePhaseDeleting means the ControlPlane is being torn down
+	ControlPlanePhaseDeleting ControlPlanePhase = "Deleting"
+)
+
+// Condition types of a ControlPlane
+const (
+	// ConditionCertificatesReady reports whether all PKI secrets exist and are valid
+	ConditionCertificatesReady = "CertificatesReady"
+	// ConditionKubeconfigsReady reports whether all kubeconfig secrets exist
+	ConditionKubeconfigsReady = "KubeconfigsReady"
+	// ConditionDeploymentAvailable reports whether the control-plane deployment has available replicas
+	ConditionDeploymentAvailable = "DeploymentAvailable"
+	// ConditionServiceReady reports whether the apiserver service exists
+	ConditionServiceReady = "ServiceReady"
+	// ConditionDatastoreReady reports whether the datastore secret and volume of kine exist
+	ConditionDatastoreReady = "DatastoreReady"
+	// ConditionJoinConfigurationReady reports whether the bootstrap token, cluster-info and join configuration are current
+	ConditionJoinConfigurationReady = "JoinConfigurationReady"
+	// ConditionAddonsReady reports whether the enabled addons are applied to the tenant
+	ConditionAddonsReady = "AddonsReady"
+	// ConditionReady is true when all other conditions are true
+	ConditionReady = "Ready"
+)
+
 // ControlPlaneStatus defines the observed state of ControlPlane
 type ControlPlaneStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// TargetSpec is the spec the running control-plane has been created from
 	TargetSpec ControlPlaneSpec `json:"target-spec"`
+
+	// Phase is a high-level summary of the ControlPlane lifecycle
+	// +optional
+	Phase ControlPlanePhase `json:"phase,omitempty"`
+
+	// ObservedGeneration is the generation of the spec last reconciled successfully
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastError is the message of the last failed reconciliation (empty on success)
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+
+	// Conditions describe the state of the single control-plane components
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.spec.version`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // ControlPlane is the Schema for the controlplanes API
 type ControlPlane struct {
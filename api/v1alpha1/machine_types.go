@@ -17,29 +17,110 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
-// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
-
 // MachineSpec defines the desired state of Machine
 type MachineSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ControlPlaneRef references the ControlPlane (in the namespace of the Machine) the node joins
+	ControlPlaneRef ControlPlaneReference `json:"controlPlaneRef"`
+
+	// Version of the kubelet without leading 'v', defaults to the version of the control-plane
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// ProviderRef selects how the host of the Machine is provisioned
+	// +optional
+	ProviderRef *MachineProviderReference `json:"providerRef,omitempty"`
+
+	// NodeName is the name of the node in the tenant cluster, defaults to the name of the Machine
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+
+	// Labels are applied to the node in the tenant cluster
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Taints are applied to the node in the tenant cluster
+	// +optional
+	Taints []corev1.Taint `json:"taints,omitempty"`
+}
+
+// ControlPlaneReference references a ControlPlane in the namespace of the Machine
+type ControlPlaneReference struct {
+	// Name of the ControlPlane
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+// MachineProviderReference selects a provider and passes provider specific parameters
+type MachineProviderReference struct {
+	// Name of the provider
+	Name string `json:"name"`
 
-	// Foo is an example field of Machine. Edit machine_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+	// Parameters are passed to the provider
+	// +optional
+	Parameters map[string]string `json:"parameters,omitempty"`
 }
 
+// MachinePhase is a simple, high-level summary of where the Machine is in its lifecycle
+type MachinePhase string
+
+const (
+	// MachinePhasePending means the Machine waits for its ControlPlane to become ready
+	MachinePhasePending MachinePhase = "Pending"
+	// MachinePhaseProvisioning means the host of the Machine is being provisioned
+	MachinePhaseProvisioning MachinePhase = "Provisioning"
+	// MachinePhaseJoining means the host is provisioned and the node is joining the tenant
+	MachinePhaseJoining MachinePhase = "Joining"
+	// MachinePhaseRunning means the node has joined the tenant
+	MachinePhaseRunning MachinePhase = "Running"
+	// MachinePhaseDeleting means the node is removed from the tenant and the host is released
+	MachinePhaseDeleting MachinePhase = "Deleting"
+)
+
+// Condition types of a Machine
+const (
+	// MachineConditionNodeReady mirrors the Ready condition of the tenant node
+	MachineConditionNodeReady = "NodeReady"
+)
+
 // MachineStatus defines the observed state of Machine
 type MachineStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Phase is a high-level summary of the Machine lifecycle
+	// +optional
+	Phase MachinePhase `json:"phase,omitempty"`
+
+	// NodeName is the name of the node in the tenant cluster once it has joined
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+
+	// NodeInfo is the system information reported by the node
+	// +optional
+	NodeInfo *corev1.NodeSystemInfo `json:"nodeInfo,omitempty"`
+
+	// ObservedGeneration is the generation of the spec last reconciled
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastError is the message of the last failed reconciliation (empty on success)
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+
+	// Conditions describe the state of the Machine
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="ControlPlane",type=string,JSONPath=`.spec.controlPlaneRef.name`
+// +kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.status.nodeName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Machine is the Schema for the machines API
 type Machine struct {
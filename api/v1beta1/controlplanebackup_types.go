@@ -0,0 +1,173 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Keys of the secret of an S3 target, the CA certificate of the service uses
+// DatastoreSecretKeyCACert ("ca.crt") and is optional
+const (
+	BackupSecretKeyAccessKeyID     = "accessKeyID"
+	BackupSecretKeySecretAccessKey = "secretAccessKey"
+)
+
+// Phases of a ControlPlaneBackup and a ControlPlaneRestore
+const (
+	BackupPhasePending   = "Pending"
+	BackupPhaseRunning   = "Running"
+	BackupPhaseCompleted = "Completed"
+	BackupPhaseFailed    = "Failed"
+)
+
+// BackupTarget is the place of the archives, a volume or an S3 bucket
+// +kubebuilder:validation:XValidation:rule="has(self.volume) != has(self.s3)",message="either volume or s3 is required"
+type BackupTarget struct {
+	// Volume stores the archives on a PersistentVolumeClaim
+	// +optional
+	Volume *BackupVolumeTarget `json:"volume,omitempty"`
+
+	// S3 stores the archives in a bucket of an S3 compatible service
+	// +optional
+	S3 *BackupS3Target `json:"s3,omitempty"`
+}
+
+// BackupVolumeTarget is a PersistentVolumeClaim in the namespace of the backup
+type BackupVolumeTarget struct {
+	// ClaimName of the volume
+	// +kubebuilder:validation:MinLength=1
+	ClaimName string `json:"claimName"`
+
+	// Path is the directory of the archives on the volume, the root if not set
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// BackupS3Target is a bucket of an S3 compatible service, e.g. MinIO
+type BackupS3Target struct {
+	// Endpoint is the URL of the service, e.g. https://minio.example.com:9000. The bucket is
+	// addressed with path style URLs.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	Endpoint string `json:"endpoint"`
+
+	// Bucket of the archives
+	// +kubebuilder:validation:MinLength=1
+	Bucket string `json:"bucket"`
+
+	// Region of the bucket
+	// +kubebuilder:default=us-east-1
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// Prefix is prepended to the object keys of the archives, e.g. "claio/"
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// SecretRef is a Secret in the namespace of the backup with the credentials ("accessKeyID"
+	// and "secretAccessKey") and optionally the CA certificate ("ca.crt") of the service
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+}
+
+// ControlPlaneBackupSpec defines the control-plane to back up and the target of the archive
+type ControlPlaneBackupSpec struct {
+	// ControlPlaneName is a ControlPlane in the namespace of the backup
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="controlPlaneName is immutable"
+	ControlPlaneName string `json:"controlPlaneName"`
+
+	// Target is where the archive is written to
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="target is immutable"
+	Target BackupTarget `json:"target"`
+
+	// DeletionPolicy is applied to the archive when the backup is deleted: Retain (default)
+	// keeps it, Delete removes it and its checksum file with a job
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +optional
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+}
+
+// ControlPlaneBackupStatus defines the observed state of ControlPlaneBackup
+type ControlPlaneBackupStatus struct {
+	// Phase is Pending, Running, Completed or Failed
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// Message explains the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Archive is the path of the archive on the volume or its object key in the bucket
+	// +optional
+	Archive string `json:"archive,omitempty"`
+
+	// Size of the archive in bytes
+	// +optional
+	Size int64 `json:"size,omitempty"`
+
+	// Checksum of the archive, sha256:<hex>
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
+
+	// Revision of the datastore the keys were read at
+	// +optional
+	Revision int64 `json:"revision,omitempty"`
+
+	// Keys is the number of keys in the archive
+	// +optional
+	Keys int64 `json:"keys,omitempty"`
+
+	// StartTime is when the job of the backup was created
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the backup completed or failed
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=cpbackup
+// +kubebuilder:printcolumn:name="ControlPlane",type=string,JSONPath=`.spec.controlPlaneName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Size",type=integer,JSONPath=`.status.size`
+// +kubebuilder:printcolumn:name="Revision",type=integer,JSONPath=`.status.revision`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ControlPlaneBackup is a snapshot of all keys of a tenant in a portable, checksummed archive
+type ControlPlaneBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ControlPlaneBackupSpec   `json:"spec,omitempty"`
+	Status ControlPlaneBackupStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ControlPlaneBackupList contains a list of ControlPlaneBackup
+type ControlPlaneBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ControlPlaneBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ControlPlaneBackup{}, &ControlPlaneBackupList{})
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BackupScheduleLabel is the label of the ControlPlaneBackups of a schedule, its value is the
+// name of the ControlPlaneBackupSchedule
+const BackupScheduleLabel = "claio.github.com/backup-schedule"
+
+// BackupRetention selects the completed backups of a schedule which are kept, a backup is kept
+// if any rule selects it. Without rules the last 7 backups are kept.
+type BackupRetention struct {
+	// KeepLast keeps the newest backups
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepLast int32 `json:"keepLast,omitempty"`
+
+	// KeepDaily keeps the newest backup of each of the last days with a backup (UTC)
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepDaily int32 `json:"keepDaily,omitempty"`
+
+	// KeepWeekly keeps the newest backup of each of the last ISO weeks with a backup (UTC)
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepWeekly int32 `json:"keepWeekly,omitempty"`
+}
+
+// ControlPlaneBackupScheduleSpec defines when a control-plane is backed up and how long the
+// archives are kept
+type ControlPlaneBackupScheduleSpec struct {
+	// ControlPlaneName is a ControlPlane in the namespace of the schedule
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="controlPlaneName is immutable"
+	ControlPlaneName string `json:"controlPlaneName"`
+
+	// Schedule is a cron expression in UTC, e.g. "30 2 * * *" or "@daily"
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+
+	// Target is where the archives are written to, changes apply to the next backup
+	Target BackupTarget `json:"target"`
+
+	// Retention selects the backups which are kept, the others are deleted with their archives
+	// +optional
+	Retention BackupRetention `json:"retention,omitempty"`
+
+	// Suspend stops new backups, the retention still applies
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// ControlPlaneBackupScheduleStatus defines the observed state of ControlPlaneBackupSchedule
+type ControlPlaneBackupScheduleStatus struct {
+	// LastScheduleTime is the time of the last backup the schedule has created
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// NextScheduleTime is the time of the next backup
+	// +optional
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"`
+
+	// LastSuccessfulTime is when the last completed backup was created
+	// +optional
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+
+	// LastBackup is the name of the newest ControlPlaneBackup of the schedule
+	// +optional
+	LastBackup string `json:"lastBackup,omitempty"`
+
+	// LastBackupPhase is the phase of the newest ControlPlaneBackup
+	// +optional
+	LastBackupPhase string `json:"lastBackupPhase,omitempty"`
+
+	// Completed is the number of completed backups the retention keeps
+	// +optional
+	Completed int32 `json:"completed,omitempty"`
+
+	// Message explains why no backup is created, e.g. an invalid schedule
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=cpbackupschedule
+// +kubebuilder:printcolumn:name="ControlPlane",type=string,JSONPath=`.spec.controlPlaneName`
+// +kubebuilder:printcolumn:name="Schedule",type=string,JSONPath=`.spec.schedule`
+// +kubebuilder:printcolumn:name="Suspend",type=boolean,JSONPath=`.spec.suspend`
+// +kubebuilder:printcolumn:name="Last",type=string,JSONPath=`.status.lastBackupPhase`
+// +kubebuilder:printcolumn:name="Completed",type=integer,JSONPath=`.status.completed`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ControlPlaneBackupSchedule creates ControlPlaneBackups of a control-plane on a schedule and
+// prunes them with their archives
+type ControlPlaneBackupSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ControlPlaneBackupScheduleSpec   `json:"spec,omitempty"`
+	Status ControlPlaneBackupScheduleStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ControlPlaneBackupScheduleList contains a list of ControlPlaneBackupSchedule
+type ControlPlaneBackupScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ControlPlaneBackupSchedule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ControlPlaneBackupSchedule{}, &ControlPlaneBackupScheduleList{})
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RestoreAnnotation pauses a ControlPlane while it is restored, the deployment is scaled down
+// until the ControlPlaneRestore named in the annotation removes it again
+const RestoreAnnotation = "claio.github.com/restore"
+
+// RestoreSource is a ControlPlaneBackup or an archive on a target
+// +kubebuilder:validation:XValidation:rule="has(self.backupName) != has(self.target)",message="either backupName or target is required"
+// +kubebuilder:validation:XValidation:rule="has(self.target) == has(self.archive)",message="target and archive go together"
+type RestoreSource struct {
+	// BackupName is a ControlPlaneBackup in the namespace of the restore
+	// +optional
+	BackupName string `json:"backupName,omitempty"`
+
+	// Target is the volume or the bucket of the archive, e.g. of a backup of another cluster
+	// +optional
+	Target *BackupTarget `json:"target,omitempty"`
+
+	// Archive is the path of the archive on the volume or its object key in the bucket
+	// +optional
+	Archive string `json:"archive,omitempty"`
+
+	// Checksum of the archive (sha256:<hex>), the checksum of a backup is taken from its status
+	// +kubebuilder:validation:Pattern=`^sha256:[0-9a-f]{64}$`
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// ControlPlaneRestoreSpec defines the archive and the control-plane it is restored into
+type ControlPlaneRestoreSpec struct {
+	// ControlPlaneName is the ControlPlane in the namespace of the restore whose keys are
+	// replaced by the keys of the archive. It may be another tenant than the one of the backup.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="controlPlaneName is immutable"
+	ControlPlaneName string `json:"controlPlaneName"`
+
+	// ControlPlane is the spec of the ControlPlane created if it does not exist, e.g. to
+	// recreate a deleted tenant
+	// +optional
+	ControlPlane *ControlPlaneSpec `json:"controlPlane,omitempty"`
+
+	// Source is the archive to restore
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="source is immutable"
+	Source RestoreSource `json:"source"`
+}
+
+// ControlPlaneRestoreStatus defines the observed state of ControlPlaneRestore
+type ControlPlaneRestoreStatus struct {
+	// Phase is Pending, Running, Completed or Failed
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// Message explains the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Keys is the number of restored keys
+	// +optional
+	Keys int64 `json:"keys,omitempty"`
+
+	// Revision of the datastore after the restore
+	// +optional
+	Revision int64 `json:"revision,omitempty"`
+
+	// StartTime is when the control-plane was paused for the restore
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the restore completed or failed
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=cprestore
+// +kubebuilder:printcolumn:name="ControlPlane",type=string,JSONPath=`.spec.controlPlaneName`
+// +kubebuilder:printcolumn:name="Backup",type=string,JSONPath=`.spec.source.backupName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ControlPlaneRestore replaces the keys of a tenant by the keys of an archive
+type ControlPlaneRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ControlPlaneRestoreSpec   `json:"spec,omitempty"`
+	Status ControlPlaneRestoreStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ControlPlaneRestoreList contains a list of ControlPlaneRestore
+type ControlPlaneRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ControlPlaneRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ControlPlaneRestore{}, &ControlPlaneRestoreList{})
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Keys of the DataStore secret, the TLS files use the keys of the datastore secret
+// (DatastoreSecretKeyCACert, DatastoreSecretKeyTLSCert and DatastoreSecretKeyTLSKey)
+const (
+	// DataStoreSecretKeyUsername is the admin user of a postgres, mysql or nats server
+	DataStoreSecretKeyUsername = "username"
+	// DataStoreSecretKeyPassword is the password of the admin user
+	DataStoreSecretKeyPassword = "password"
+)
+
+// DataStoreSpec defines a datastore server shared by several tenants
+type DataStoreSpec struct {
+	// Driver of the datastore: nats, postgres or mysql
+	// +kubebuilder:validation:Enum=nats;postgres;mysql
+	Driver string `json:"driver"`
+
+	// Endpoint of the server without credentials and database, e.g.
+	// nats://nats.claio-system.svc:4222, postgres://postgres.example.com:5432 or
+	// mysql://mysql.example.com:3306
+	// +kubebuilder:validation:MinLength=1
+	Endpoint string `json:"endpoint"`
+
+	// SecretRef is a Secret with the admin credentials ("username" and "password") and the
+	// TLS files ("ca.crt", "tls.crt" and "tls.key"), all keys are optional
+	// +optional
+	SecretRef *corev1.SecretReference `json:"secretRef,omitempty"`
+
+	// NATS configures how tenants are isolated on a nats server
+	// +optional
+	NATS *NATSDataStoreSpec `json:"nats,omitempty"`
+
+	// Limits restricts the placement of tenants on the datastore
+	// +optional
+	Limits DataStoreLimits `json:"limits,omitempty"`
+}
+
+// NATSDataStoreSpec defines the accounts of the tenants on a nats server
+type NATSDataStoreSpec struct {
+	// AccountsSecretRef is the Secret with "accounts.conf" the nats config includes into its
+	// accounts. Every tenant gets an account with an nkey user limited to its bucket. Without
+	// it all tenants share the admin credentials.
+	// +optional
+	AccountsSecretRef *corev1.SecretReference `json:"accountsSecretRef,omitempty"`
+
+	// Replicas is the number of copies kine creates of the KV bucket of a new tenant, the
+	// nats default (1) if not set. It must not exceed the size of the JetStream cluster.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=5
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+}
+
+// DataStoreLimits are the capacity limits of a datastore
+type DataStoreLimits struct {
+	// MaxTenants is the number of control-planes the datastore takes, unlimited if not set
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxTenants *int32 `json:"maxTenants,omitempty"`
+}
+
+// Condition types of a DataStore
+const (
+	// DataStoreConditionReachable reports whether the manager can connect to the endpoint
+	DataStoreConditionReachable = "Reachable"
+)
+
+// DataStoreStatus defines the observed state of DataStore
+type DataStoreStatus struct {
+	// Tenants are the names of the control-planes placed on the datastore
+	// +optional
+	Tenants []string `json:"tenants,omitempty"`
+
+	// TenantCount is the number of tenants placed on the datastore
+	// +optional
+	TenantCount int32 `json:"tenantCount,omitempty"`
+
+	// ObservedGeneration is the generation of the spec last reconciled
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe the state of the datastore
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Driver",type=string,JSONPath=`.spec.driver`
+// +kubebuilder:printcolumn:name="Endpoint",type=string,JSONPath=`.spec.endpoint`
+// +kubebuilder:printcolumn:name="Tenants",type=integer,JSONPath=`.status.tenantCount`
+// +kubebuilder:printcolumn:name="Reachable",type=string,JSONPath=`.status.conditions[?(@.type=="Reachable")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// DataStore is the Schema for the datastores API
+type DataStore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DataStoreSpec   `json:"spec,omitempty"`
+	Status DataStoreStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DataStoreList contains a list of DataStore
+type DataStoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DataStore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DataStore{}, &DataStoreList{})
+}
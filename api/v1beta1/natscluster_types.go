@@ -0,0 +1,182 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NATSClusterSpec defines a JetStream cluster the manager deploys as datastore of tenants
+// +kubebuilder:validation:XValidation:rule="self.replicas == 1 || self.replicas >= 3",message="a cluster needs 3 or more replicas"
+// +kubebuilder:validation:XValidation:rule="self.replicationFactor <= self.replicas",message="replicationFactor must not exceed replicas"
+type NATSClusterSpec struct {
+	// Replicas is the number of nats servers, 1 runs a single server without clustering
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// ReplicationFactor is the number of copies of the KV bucket of every tenant
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=5
+	// +optional
+	ReplicationFactor int32 `json:"replicationFactor,omitempty"`
+
+	// Image of the nats server
+	// +kubebuilder:default="nats:2.10.22-alpine"
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Storage is the JetStream volume of every server
+	// +optional
+	Storage NATSStorageSpec `json:"storage,omitempty"`
+
+	// Resources of the nats container
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// DataStoreName is the DataStore the manager registers for the cluster, defaults to the
+	// name of the NATSCluster
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="dataStoreName is immutable"
+	// +optional
+	DataStoreName string `json:"dataStoreName,omitempty"`
+}
+
+// NATSStorageSpec defines the persistent volumes of the servers, they cannot be changed
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="storage is immutable"
+type NATSStorageSpec struct {
+	// Size of the volume, also the limit of the JetStream file store
+	// +kubebuilder:default="10Gi"
+	// +optional
+	Size resource.Quantity `json:"size,omitempty"`
+
+	// StorageClassName of the volume, the default storage class if not set
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+}
+
+// Condition types of a NATSCluster
+const (
+	// NATSClusterConditionAvailable is true if all servers are healthy and the JetStream
+	// cluster has a meta leader
+	NATSClusterConditionAvailable = "Available"
+	// NATSClusterConditionUpToDate is true if all servers run the current configuration,
+	// false while the manager rolls the servers one by one
+	NATSClusterConditionUpToDate = "UpToDate"
+)
+
+// NATSServerStatus is the state of a single nats server
+type NATSServerStatus struct {
+	// Name of the server (the pod)
+	Name string `json:"name"`
+
+	// Healthy reports the result of the health check of the server
+	Healthy bool `json:"healthy"`
+
+	// UpToDate is true if the server runs the current configuration
+	UpToDate bool `json:"upToDate"`
+
+	// Message is the error of the health check
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// NATSBucketStatus is the replica state of the KV bucket of a tenant
+type NATSBucketStatus struct {
+	// Name of the bucket
+	Name string `json:"name"`
+
+	// Account the bucket belongs to
+	Account string `json:"account"`
+
+	// Leader is the server the bucket is written on
+	// +optional
+	Leader string `json:"leader,omitempty"`
+
+	// Replicas is the number of copies of the bucket
+	Replicas int32 `json:"replicas"`
+
+	// CurrentReplicas is the number of copies which are online and caught up with the leader
+	CurrentReplicas int32 `json:"currentReplicas"`
+}
+
+// NATSClusterStatus defines the observed state of NATSCluster
+type NATSClusterStatus struct {
+	// ReadyReplicas is the number of ready servers
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// MetaLeader is the server leading the JetStream cluster
+	// +optional
+	MetaLeader string `json:"metaLeader,omitempty"`
+
+	// Servers is the state of every server
+	// +optional
+	Servers []NATSServerStatus `json:"servers,omitempty"`
+
+	// Buckets is the replica state of the KV buckets of the tenants
+	// +optional
+	Buckets []NATSBucketStatus `json:"buckets,omitempty"`
+
+	// ConfigHash is the hash of the configuration the servers are rolled to
+	// +optional
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// ObservedGeneration is the generation of the spec last reconciled
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe the state of the cluster
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.spec.replicas`
+// +kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyReplicas`
+// +kubebuilder:printcolumn:name="Leader",type=string,JSONPath=`.status.metaLeader`
+// +kubebuilder:printcolumn:name="Available",type=string,JSONPath=`.status.conditions[?(@.type=="Available")].status`
+// +kubebuilder:printcolumn:name="UpToDate",type=string,JSONPath=`.status.conditions[?(@.type=="UpToDate")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NATSCluster is the Schema for the natsclusters API
+type NATSCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NATSClusterSpec   `json:"spec,omitempty"`
+	Status NATSClusterStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NATSClusterList contains a list of NATSCluster
+type NATSClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NATSCluster `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NATSCluster{}, &NATSClusterList{})
+}
@@ -0,0 +1,1181 @@
+//go:build !ignore_autogenerated
+
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AddonSpec) DeepCopyInto(out *AddonSpec) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AddonSpec.
+func (in *AddonSpec) DeepCopy() *AddonSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AddonSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AddonsSpec) DeepCopyInto(out *AddonsSpec) {
+	*out = *in
+	in.BootstrapRBAC.DeepCopyInto(&out.BootstrapRBAC)
+	in.KubeProxy.DeepCopyInto(&out.KubeProxy)
+	in.CoreDNS.DeepCopyInto(&out.CoreDNS)
+	in.Konnectivity.DeepCopyInto(&out.Konnectivity)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AddonsSpec.
+func (in *AddonsSpec) DeepCopy() *AddonsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AddonsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupRetention) DeepCopyInto(out *BackupRetention) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupRetention.
+func (in *BackupRetention) DeepCopy() *BackupRetention {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupRetention)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupS3Target) DeepCopyInto(out *BackupS3Target) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupS3Target.
+func (in *BackupS3Target) DeepCopy() *BackupS3Target {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupS3Target)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupTarget) DeepCopyInto(out *BackupTarget) {
+	*out = *in
+	if in.Volume != nil {
+		in, out := &in.Volume, &out.Volume
+		*out = new(BackupVolumeTarget)
+		**out = **in
+	}
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(BackupS3Target)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupTarget.
+func (in *BackupTarget) DeepCopy() *BackupTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupVolumeTarget) DeepCopyInto(out *BackupVolumeTarget) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupVolumeTarget.
+func (in *BackupVolumeTarget) DeepCopy() *BackupVolumeTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupVolumeTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CARotationStatus) DeepCopyInto(out *CARotationStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastTransitionTime != nil {
+		in, out := &in.LastTransitionTime, &out.LastTransitionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CARotationStatus.
+func (in *CARotationStatus) DeepCopy() *CARotationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CARotationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CASource) DeepCopyInto(out *CASource) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CASource.
+func (in *CASource) DeepCopy() *CASource {
+	if in == nil {
+		return nil
+	}
+	out := new(CASource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificatesSpec) DeepCopyInto(out *CertificatesSpec) {
+	*out = *in
+	if in.ExtraSANs != nil {
+		in, out := &in.ExtraSANs, &out.ExtraSANs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RenewBefore != nil {
+		in, out := &in.RenewBefore, &out.RenewBefore
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.CA != nil {
+		in, out := &in.CA, &out.CA
+		*out = new(CASource)
+		**out = **in
+	}
+	if in.FrontProxyCA != nil {
+		in, out := &in.FrontProxyCA, &out.FrontProxyCA
+		*out = new(CASource)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificatesSpec.
+func (in *CertificatesSpec) DeepCopy() *CertificatesSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificatesSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComponentSpec) DeepCopyInto(out *ComponentSpec) {
+	*out = *in
+	if in.ExtraArgs != nil {
+		in, out := &in.ExtraArgs, &out.ExtraArgs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComponentSpec.
+func (in *ComponentSpec) DeepCopy() *ComponentSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ComponentSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComponentsSpec) DeepCopyInto(out *ComponentsSpec) {
+	*out = *in
+	in.APIServer.DeepCopyInto(&out.APIServer)
+	in.ControllerManager.DeepCopyInto(&out.ControllerManager)
+	in.Scheduler.DeepCopyInto(&out.Scheduler)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComponentsSpec.
+func (in *ComponentsSpec) DeepCopy() *ComponentsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ComponentsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlane) DeepCopyInto(out *ControlPlane) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlane.
+func (in *ControlPlane) DeepCopy() *ControlPlane {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlane)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ControlPlane) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneBackup) DeepCopyInto(out *ControlPlaneBackup) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneBackup.
+func (in *ControlPlaneBackup) DeepCopy() *ControlPlaneBackup {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneBackup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ControlPlaneBackup) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneBackupList) DeepCopyInto(out *ControlPlaneBackupList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ControlPlaneBackup, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneBackupList.
+func (in *ControlPlaneBackupList) DeepCopy() *ControlPlaneBackupList {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneBackupList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ControlPlaneBackupList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneBackupSchedule) DeepCopyInto(out *ControlPlaneBackupSchedule) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneBackupSchedule.
+func (in *ControlPlaneBackupSchedule) DeepCopy() *ControlPlaneBackupSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneBackupSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ControlPlaneBackupSchedule) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneBackupScheduleList) DeepCopyInto(out *ControlPlaneBackupScheduleList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ControlPlaneBackupSchedule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneBackupScheduleList.
+func (in *ControlPlaneBackupScheduleList) DeepCopy() *ControlPlaneBackupScheduleList {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneBackupScheduleList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ControlPlaneBackupScheduleList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneBackupScheduleSpec) DeepCopyInto(out *ControlPlaneBackupScheduleSpec) {
+	*out = *in
+	in.Target.DeepCopyInto(&out.Target)
+	out.Retention = in.Retention
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneBackupScheduleSpec.
+func (in *ControlPlaneBackupScheduleSpec) DeepCopy() *ControlPlaneBackupScheduleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneBackupScheduleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneBackupScheduleStatus) DeepCopyInto(out *ControlPlaneBackupScheduleStatus) {
+	*out = *in
+	if in.LastScheduleTime != nil {
+		in, out := &in.LastScheduleTime, &out.LastScheduleTime
+		*out = (*in).DeepCopy()
+	}
+	if in.NextScheduleTime != nil {
+		in, out := &in.NextScheduleTime, &out.NextScheduleTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSuccessfulTime != nil {
+		in, out := &in.LastSuccessfulTime, &out.LastSuccessfulTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneBackupScheduleStatus.
+func (in *ControlPlaneBackupScheduleStatus) DeepCopy() *ControlPlaneBackupScheduleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneBackupScheduleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneBackupSpec) DeepCopyInto(out *ControlPlaneBackupSpec) {
+	*out = *in
+	in.Target.DeepCopyInto(&out.Target)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneBackupSpec.
+func (in *ControlPlaneBackupSpec) DeepCopy() *ControlPlaneBackupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneBackupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneBackupStatus) DeepCopyInto(out *ControlPlaneBackupStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneBackupStatus.
+func (in *ControlPlaneBackupStatus) DeepCopy() *ControlPlaneBackupStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneBackupStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneList) DeepCopyInto(out *ControlPlaneList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ControlPlane, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneList.
+func (in *ControlPlaneList) DeepCopy() *ControlPlaneList {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ControlPlaneList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneRestore) DeepCopyInto(out *ControlPlaneRestore) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneRestore.
+func (in *ControlPlaneRestore) DeepCopy() *ControlPlaneRestore {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneRestore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ControlPlaneRestore) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneRestoreList) DeepCopyInto(out *ControlPlaneRestoreList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ControlPlaneRestore, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneRestoreList.
+func (in *ControlPlaneRestoreList) DeepCopy() *ControlPlaneRestoreList {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneRestoreList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ControlPlaneRestoreList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneRestoreSpec) DeepCopyInto(out *ControlPlaneRestoreSpec) {
+	*out = *in
+	if in.ControlPlane != nil {
+		in, out := &in.ControlPlane, &out.ControlPlane
+		*out = new(ControlPlaneSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Source.DeepCopyInto(&out.Source)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneRestoreSpec.
+func (in *ControlPlaneRestoreSpec) DeepCopy() *ControlPlaneRestoreSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneRestoreSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneRestoreStatus) DeepCopyInto(out *ControlPlaneRestoreStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneRestoreStatus.
+func (in *ControlPlaneRestoreStatus) DeepCopy() *ControlPlaneRestoreStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneRestoreStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneSpec) DeepCopyInto(out *ControlPlaneSpec) {
+	*out = *in
+	out.Endpoint = in.Endpoint
+	out.Network = in.Network
+	in.Datastore.DeepCopyInto(&out.Datastore)
+	in.Components.DeepCopyInto(&out.Components)
+	in.Certificates.DeepCopyInto(&out.Certificates)
+	in.Addons.DeepCopyInto(&out.Addons)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneSpec.
+func (in *ControlPlaneSpec) DeepCopy() *ControlPlaneSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneStatus) DeepCopyInto(out *ControlPlaneStatus) {
+	*out = *in
+	in.TargetSpec.DeepCopyInto(&out.TargetSpec)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Migration != nil {
+		in, out := &in.Migration, &out.Migration
+		*out = new(DatastoreMigrationStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DatastoreUsage != nil {
+		in, out := &in.DatastoreUsage, &out.DatastoreUsage
+		*out = new(DatastoreUsageStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CARotation != nil {
+		in, out := &in.CARotation, &out.CARotation
+		*out = new(CARotationStatus)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneStatus.
+func (in *ControlPlaneStatus) DeepCopy() *ControlPlaneStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DataStore) DeepCopyInto(out *DataStore) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DataStore.
+func (in *DataStore) DeepCopy() *DataStore {
+	if in == nil {
+		return nil
+	}
+	out := new(DataStore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DataStore) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DataStoreLimits) DeepCopyInto(out *DataStoreLimits) {
+	*out = *in
+	if in.MaxTenants != nil {
+		in, out := &in.MaxTenants, &out.MaxTenants
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DataStoreLimits.
+func (in *DataStoreLimits) DeepCopy() *DataStoreLimits {
+	if in == nil {
+		return nil
+	}
+	out := new(DataStoreLimits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DataStoreList) DeepCopyInto(out *DataStoreList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]DataStore, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DataStoreList.
+func (in *DataStoreList) DeepCopy() *DataStoreList {
+	if in == nil {
+		return nil
+	}
+	out := new(DataStoreList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DataStoreList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DataStoreSpec) DeepCopyInto(out *DataStoreSpec) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+	if in.NATS != nil {
+		in, out := &in.NATS, &out.NATS
+		*out = new(NATSDataStoreSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Limits.DeepCopyInto(&out.Limits)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DataStoreSpec.
+func (in *DataStoreSpec) DeepCopy() *DataStoreSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DataStoreSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DataStoreStatus) DeepCopyInto(out *DataStoreStatus) {
+	*out = *in
+	if in.Tenants != nil {
+		in, out := &in.Tenants, &out.Tenants
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DataStoreStatus.
+func (in *DataStoreStatus) DeepCopy() *DataStoreStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DataStoreStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DatastoreMigrationStatus) DeepCopyInto(out *DatastoreMigrationStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DatastoreMigrationStatus.
+func (in *DatastoreMigrationStatus) DeepCopy() *DatastoreMigrationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DatastoreMigrationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DatastoreQuotaSpec) DeepCopyInto(out *DatastoreQuotaSpec) {
+	*out = *in
+	if in.MaxKeys != nil {
+		in, out := &in.MaxKeys, &out.MaxKeys
+		*out = new(int64)
+		**out = **in
+	}
+	if in.MaxBytes != nil {
+		in, out := &in.MaxBytes, &out.MaxBytes
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.MaxRevisionHistory != nil {
+		in, out := &in.MaxRevisionHistory, &out.MaxRevisionHistory
+		*out = new(int32)
+		**out = **in
+	}
+	if in.WarningPercent != nil {
+		in, out := &in.WarningPercent, &out.WarningPercent
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DatastoreQuotaSpec.
+func (in *DatastoreQuotaSpec) DeepCopy() *DatastoreQuotaSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DatastoreQuotaSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DatastoreSpec) DeepCopyInto(out *DatastoreSpec) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	if in.Storage != nil {
+		in, out := &in.Storage, &out.Storage
+		*out = new(DatastoreStorageSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SnapshotStorage != nil {
+		in, out := &in.SnapshotStorage, &out.SnapshotStorage
+		*out = new(DatastoreStorageSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Quota != nil {
+		in, out := &in.Quota, &out.Quota
+		*out = new(DatastoreQuotaSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DatastoreSpec.
+func (in *DatastoreSpec) DeepCopy() *DatastoreSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DatastoreSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DatastoreStorageSpec) DeepCopyInto(out *DatastoreStorageSpec) {
+	*out = *in
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DatastoreStorageSpec.
+func (in *DatastoreStorageSpec) DeepCopy() *DatastoreStorageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DatastoreStorageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DatastoreUsageStatus) DeepCopyInto(out *DatastoreUsageStatus) {
+	*out = *in
+	if in.Bytes != nil {
+		in, out := &in.Bytes, &out.Bytes
+		*out = new(int64)
+		**out = **in
+	}
+	if in.NearLimit != nil {
+		in, out := &in.NearLimit, &out.NearLimit
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exceeded != nil {
+		in, out := &in.Exceeded, &out.Exceeded
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.LastUpdateTime.DeepCopyInto(&out.LastUpdateTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DatastoreUsageStatus.
+func (in *DatastoreUsageStatus) DeepCopy() *DatastoreUsageStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DatastoreUsageStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EndpointSpec) DeepCopyInto(out *EndpointSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EndpointSpec.
+func (in *EndpointSpec) DeepCopy() *EndpointSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EndpointSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NATSBucketStatus) DeepCopyInto(out *NATSBucketStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NATSBucketStatus.
+func (in *NATSBucketStatus) DeepCopy() *NATSBucketStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NATSBucketStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NATSCluster) DeepCopyInto(out *NATSCluster) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NATSCluster.
+func (in *NATSCluster) DeepCopy() *NATSCluster {
+	if in == nil {
+		return nil
+	}
+	out := new(NATSCluster)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NATSCluster) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NATSClusterList) DeepCopyInto(out *NATSClusterList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NATSCluster, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NATSClusterList.
+func (in *NATSClusterList) DeepCopy() *NATSClusterList {
+	if in == nil {
+		return nil
+	}
+	out := new(NATSClusterList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NATSClusterList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NATSClusterSpec) DeepCopyInto(out *NATSClusterSpec) {
+	*out = *in
+	in.Storage.DeepCopyInto(&out.Storage)
+	in.Resources.DeepCopyInto(&out.Resources)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NATSClusterSpec.
+func (in *NATSClusterSpec) DeepCopy() *NATSClusterSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NATSClusterSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NATSClusterStatus) DeepCopyInto(out *NATSClusterStatus) {
+	*out = *in
+	if in.Servers != nil {
+		in, out := &in.Servers, &out.Servers
+		*out = make([]NATSServerStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Buckets != nil {
+		in, out := &in.Buckets, &out.Buckets
+		*out = make([]NATSBucketStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NATSClusterStatus.
+func (in *NATSClusterStatus) DeepCopy() *NATSClusterStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NATSClusterStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NATSDataStoreSpec) DeepCopyInto(out *NATSDataStoreSpec) {
+	*out = *in
+	if in.AccountsSecretRef != nil {
+		in, out := &in.AccountsSecretRef, &out.AccountsSecretRef
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NATSDataStoreSpec.
+func (in *NATSDataStoreSpec) DeepCopy() *NATSDataStoreSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NATSDataStoreSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NATSServerStatus) DeepCopyInto(out *NATSServerStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NATSServerStatus.
+func (in *NATSServerStatus) DeepCopy() *NATSServerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NATSServerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NATSStorageSpec) DeepCopyInto(out *NATSStorageSpec) {
+	*out = *in
+	out.Size = in.Size.DeepCopy()
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NATSStorageSpec.
+func (in *NATSStorageSpec) DeepCopy() *NATSStorageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NATSStorageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkSpec) DeepCopyInto(out *NetworkSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkSpec.
+func (in *NetworkSpec) DeepCopy() *NetworkSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestoreSource) DeepCopyInto(out *RestoreSource) {
+	*out = *in
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		*out = new(BackupTarget)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestoreSource.
+func (in *RestoreSource) DeepCopy() *RestoreSource {
+	if in == nil {
+		return nil
+	}
+	out := new(RestoreSource)
+	in.DeepCopyInto(out)
+	return out
+}
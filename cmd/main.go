@@ -17,9 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -30,13 +33,24 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	claiov1alpha1 "claio/api/v1alpha1"
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/backup"
 	"claio/internal/controller"
+	"claio/internal/gateway"
+	"claio/internal/providers"
+	"claio/internal/providers/fake"
+	"claio/internal/providers/pod"
+	"claio/internal/providers/ssh"
+	"claio/internal/resources/controlplanes"
+	webhookclaiov1beta1 "claio/internal/webhook/v1beta1"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -49,15 +63,35 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(claiov1alpha1.AddToScheme(scheme))
+	utilruntime.Must(claiov1beta1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
+	// the jobs of backups and restores run the manager binary with a command
+	if backup.IsCommand(os.Args[1:]) {
+		os.Exit(backup.Main(os.Args[1:]))
+	}
+	// a dedicated kine gateway runs the manager binary with the gateway command
+	if gateway.IsCommand(os.Args[1:]) {
+		os.Exit(gateway.Main(os.Args[1:]))
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var supportedVersions string
+	var enableFakeProvider bool
+	var enablePodProvider bool
+	var podProviderImage string
+	var backupImage string
+	var enableSSHProvider bool
+	var gatewayEndpoint, gatewayNamespace, gatewayCASecret string
+	var gatewayBackend, gatewayBindAddress, gatewayHostnames string
+	var caRotationGracePeriod time.Duration
+	controlPlaneDefaults := webhookclaiov1beta1.DefaultControlPlaneDefaults
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metric endpoint binds to. "+
 		"Use the port :8080. If not set, it will be 0 in order to disable the metrics server")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
@@ -68,6 +102,49 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&supportedVersions, "supported-versions", strings.Join(webhookclaiov1beta1.DefaultSupportedVersions, ","),
+		"Comma separated list of kubernetes minor versions accepted for control-planes")
+	flag.IntVar(&controlPlaneDefaults.Port, "default-port", controlPlaneDefaults.Port,
+		"The apiserver port of a control-plane if not set in its spec")
+	flag.StringVar(&controlPlaneDefaults.Version, "default-version", controlPlaneDefaults.Version,
+		"The kubernetes version of a control-plane if not set in its spec")
+	flag.StringVar(&controlPlaneDefaults.Database, "default-database", controlPlaneDefaults.Database,
+		"The datastore of a control-plane if not set in its spec")
+	flag.StringVar(&controlPlaneDefaults.DataStore, "default-datastore", controlPlaneDefaults.DataStore,
+		"The DataStore of a nats control-plane without datastore secret if not set in its spec")
+	flag.StringVar(&controlPlaneDefaults.ClusterCIDR, "default-cluster-cidr", controlPlaneDefaults.ClusterCIDR,
+		"The pod network of a control-plane if not set in its spec")
+	flag.StringVar(&controlPlaneDefaults.ServiceCIDR, "default-service-cidr", controlPlaneDefaults.ServiceCIDR,
+		"The service network of a control-plane if not set in its spec")
+	flag.StringVar(&controlPlaneDefaults.DNSDomain, "default-dns-domain", controlPlaneDefaults.DNSDomain,
+		"The cluster domain of a control-plane if not set in its spec")
+	flag.DurationVar(&controlPlaneDefaults.RenewBefore, "default-renew-before", controlPlaneDefaults.RenewBefore,
+		"How long before they expire the certificates of a control-plane are renewed if not set in its spec")
+	flag.BoolVar(&enableFakeProvider, "enable-fake-provider", false,
+		"Register the in-memory machine provider \"fake\" (hosts are never provisioned, for development)")
+	flag.BoolVar(&enablePodProvider, "enable-pod-provider", false,
+		"Register the machine provider \"pod\" (nested nodes as privileged pods in the tenant namespace)")
+	flag.StringVar(&podProviderImage, "pod-provider-image", pod.DefaultImage,
+		"The comma separated node image repositories the machines of the pod provider may use, "+
+			"the first one is the default and tagged with the version of the machine")
+	flag.StringVar(&backupImage, "backup-image", "",
+		"The image of the backup, restore and migration jobs with the manager binary, the image of the manager pod if not set")
+	flag.DurationVar(&caRotationGracePeriod, "ca-rotation-grace-period", controlplanes.DefaultCARotationGracePeriod,
+		"How long a rotation of the tenant CA keeps trusting the old CA after the certificates were reissued")
+	flag.BoolVar(&enableSSHProvider, "enable-ssh-provider", false,
+		"Register the machine provider \"ssh\" (existing hosts joined over SSH)")
+	flag.StringVar(&gatewayEndpoint, "kine-gateway-endpoint", "",
+		"The URL of the kine gateway the apiservers of the gateway driver connect to, the driver is disabled if not set")
+	flag.StringVar(&gatewayNamespace, "kine-gateway-namespace", managerNamespace(),
+		"The namespace of the CA secret of the kine gateway")
+	flag.StringVar(&gatewayCASecret, "kine-gateway-ca-secret", "kine-gateway-ca",
+		"The secret of the CA of the kine gateway, it is created if it does not exist")
+	flag.StringVar(&gatewayBackend, "kine-gateway-backend", "",
+		"The etcd API of the kine the gateway forwards to, the manager serves the gateway in-process if set")
+	flag.StringVar(&gatewayBindAddress, "kine-gateway-bind-address", ":2379",
+		"The address the in-process kine gateway binds to")
+	flag.StringVar(&gatewayHostnames, "kine-gateway-hostnames", "",
+		"Comma separated DNS names and IPs of the serving certificate of the in-process kine gateway")
 	opts := zap.Options{
 		Development: true,
 		TimeEncoder: zapcore.ISO8601TimeEncoder,
@@ -125,20 +202,124 @@ func main() {
 		os.Exit(1)
 	}
 
+	jobImage, err := controlplanes.ResolveJobImage(context.Background(), mgr.GetAPIReader(), backupImage)
+	if err != nil {
+		setupLog.Error(err, "unable to resolve the image of the backup jobs")
+		os.Exit(1)
+	}
+	if jobImage.Image == "" {
+		setupLog.Info("the manager does not run in a pod, backups, restores and datastore migrations need --backup-image")
+	}
+	var kineGateway *controlplanes.Gateway
+	if gatewayEndpoint != "" {
+		kineGateway = &controlplanes.Gateway{
+			Endpoint:    gatewayEndpoint,
+			CANamespace: gatewayNamespace,
+			CAName:      gatewayCASecret,
+		}
+	}
+	if gatewayBackend != "" {
+		if err = addGateway(mgr, gatewayNamespace, gatewayCASecret, &gateway.Options{
+			Backend:     gatewayBackend,
+			BindAddress: gatewayBindAddress,
+			Hostnames:   strings.Split(gatewayHostnames, ","),
+			Registerer:  metrics.Registry,
+		}); err != nil {
+			setupLog.Error(err, "unable to create the kine gateway")
+			os.Exit(1)
+		}
+	}
 	if err = (&controller.ControlPlaneReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		JobImage: jobImage,
+		Gateway:  kineGateway,
+		Recorder: mgr.GetEventRecorderFor("claio-controlplane"),
+
+		CARotationGracePeriod: caRotationGracePeriod,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ControlPlane")
+		os.Exit(1)
+	}
+	if err = (&controller.DataStoreReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ControlPlane")
+		setupLog.Error(err, "unable to create controller", "controller", "DataStore")
 		os.Exit(1)
 	}
-	if err = (&controller.MachineReconciler{
+	if err = (&controller.NATSClusterReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "NATSCluster")
+		os.Exit(1)
+	}
+	if err = (&controller.ControlPlaneBackupReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		JobImage: jobImage,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ControlPlaneBackup")
+		os.Exit(1)
+	}
+	if err = (&controller.ControlPlaneRestoreReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		JobImage: jobImage,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ControlPlaneRestore")
+		os.Exit(1)
+	}
+	if err = (&controller.ControlPlaneBackupScheduleReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ControlPlaneBackupSchedule")
+		os.Exit(1)
+	}
+	machineProviders := providers.NewRegistry()
+	if enableFakeProvider {
+		if err = machineProviders.Register(fake.New()); err != nil {
+			setupLog.Error(err, "unable to register machine provider", "provider", fake.Name)
+			os.Exit(1)
+		}
+	}
+	if enablePodProvider {
+		podProvider := pod.New(mgr.GetClient(), mgr.GetScheme())
+		podProvider.Images = strings.Split(podProviderImage, ",")
+		if err = machineProviders.Register(podProvider); err != nil {
+			setupLog.Error(err, "unable to register machine provider", "provider", pod.Name)
+			os.Exit(1)
+		}
+	}
+	if enableSSHProvider {
+		sshProvider := ssh.New(mgr.GetClient(), mgr.GetEventRecorderFor("claio-ssh-provider"))
+		if err = machineProviders.Register(sshProvider); err != nil {
+			setupLog.Error(err, "unable to register machine provider", "provider", ssh.Name)
+			os.Exit(1)
+		}
+	}
+	setupLog.Info("machine providers", "registered", machineProviders.Names())
+	if err = (&controller.MachineReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Providers: machineProviders,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Machine")
 		os.Exit(1)
 	}
+	// nolint:goconst
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = webhookclaiov1beta1.SetupControlPlaneWebhookWithManager(mgr, webhookclaiov1beta1.Options{
+			SupportedVersions: strings.Split(supportedVersions, ","),
+			Defaults:          controlPlaneDefaults,
+			Gateway:           kineGateway != nil,
+		}); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ControlPlane")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -156,3 +337,28 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// managerNamespace is the namespace of the manager pod
+func managerNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	return "claio-system"
+}
+
+// addGateway serves the kine gateway in the manager, the CA is read (or created) before the
+// manager starts, the cache is not running yet
+func addGateway(mgr ctrl.Manager, namespace, caSecret string, opts *gateway.Options) error {
+	c, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme()})
+	if err != nil {
+		return err
+	}
+	if opts.CA, err = gateway.LoadCA(context.Background(), c, namespace, caSecret); err != nil {
+		return err
+	}
+	g, err := gateway.New(opts)
+	if err != nil {
+		return err
+	}
+	return mgr.Add(g)
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+)
+
+// An archive is a gzipped tar with the keys of a tenant in data.jsonl, one JSON record per line,
+// followed by manifest.json. The keys are relative to the prefix of the tenant, so the archive
+// can be restored into another tenant, and the manifest has the checksum of the data.
+const (
+	ArchiveVersion = 1
+
+	manifestFile = "manifest.json"
+	dataFile     = "data.jsonl"
+)
+
+// Manifest describes the content of an archive
+type Manifest struct {
+	Version      int       `json:"version"`
+	ControlPlane string    `json:"controlPlane"`
+	Prefix       string    `json:"prefix"`
+	Revision     int64     `json:"revision"`
+	Keys         int64     `json:"keys"`
+	Created      time.Time `json:"created"`
+	DataChecksum string    `json:"dataChecksum"`
+}
+
+// record is a line of data.jsonl, key and value are base64 encoded by encoding/json
+type record struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// dataWriter spools the records to a temporary file until the manifest is complete
+type dataWriter struct {
+	file    *os.File
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+	hash    hash.Hash
+	keys    int64
+}
+
+func newDataWriter(dir string) (*dataWriter, error) {
+	file, err := os.CreateTemp(dir, "data-*.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("error creating data file: %s", err)
+	}
+	w := &dataWriter{file: file, hash: sha256.New()}
+	w.buffer = bufio.NewWriter(io.MultiWriter(file, w.hash))
+	w.encoder = json.NewEncoder(w.buffer)
+	return w, nil
+}
+
+func (w *dataWriter) Add(key, value []byte) error {
+	w.keys++
+	return w.encoder.Encode(&record{Key: key, Value: value})
+}
+
+// WriteArchive completes the manifest and writes the archive to out
+func (w *dataWriter) WriteArchive(out io.Writer, manifest *Manifest) error {
+	if err := w.buffer.Flush(); err != nil {
+		return fmt.Errorf("error writing data file: %s", err)
+	}
+	size, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("error writing data file: %s", err)
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading data file: %s", err)
+	}
+	manifest.Version = ArchiveVersion
+	manifest.Keys = w.keys
+	manifest.DataChecksum = checksum(w.hash)
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %s", err)
+	}
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	header := &tar.Header{Name: dataFile, Mode: 0600, Size: size, ModTime: manifest.Created}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("error writing archive: %s", err)
+	}
+	if _, err := io.Copy(tw, w.file); err != nil {
+		return fmt.Errorf("error writing archive: %s", err)
+	}
+	header = &tar.Header{Name: manifestFile, Mode: 0600, Size: int64(len(manifestData)), ModTime: manifest.Created}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("error writing archive: %s", err)
+	}
+	if _, err := tw.Write(manifestData); err != nil {
+		return fmt.Errorf("error writing archive: %s", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("error writing archive: %s", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("error writing archive: %s", err)
+	}
+	return nil
+}
+
+func (w *dataWriter) Close() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// dataReader is the verified content of an archive
+type dataReader struct {
+	Manifest *Manifest
+	file     *os.File
+}
+
+// readArchive unpacks the data of the archive into a temporary file and verifies it with the
+// manifest
+func readArchive(in io.Reader, dir string) (*dataReader, error) {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive: %s", err)
+	}
+	file, err := os.CreateTemp(dir, "data-*.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("error creating data file: %s", err)
+	}
+	r := &dataReader{file: file}
+	dataHash := sha256.New()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("error reading archive: %s", err)
+		}
+		switch header.Name {
+		case dataFile:
+			if _, err := io.Copy(io.MultiWriter(file, dataHash), tr); err != nil {
+				r.Close()
+				return nil, fmt.Errorf("error reading %s: %s", dataFile, err)
+			}
+		case manifestFile:
+			r.Manifest = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(r.Manifest); err != nil {
+				r.Close()
+				return nil, fmt.Errorf("error reading %s: %s", manifestFile, err)
+			}
+		}
+	}
+	switch {
+	case r.Manifest == nil:
+		err = fmt.Errorf("archive has no %s", manifestFile)
+	case r.Manifest.Version != ArchiveVersion:
+		err = fmt.Errorf("archive version %d is not supported", r.Manifest.Version)
+	case r.Manifest.DataChecksum != checksum(dataHash):
+		err = fmt.Errorf("checksum of %s does not match the manifest", dataFile)
+	}
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Records calls fn for every record of the data, it fails if the number of keys does not match
+// the manifest
+func (r *dataReader) Records(fn func(key, value []byte) error) error {
+	if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading data file: %s", err)
+	}
+	decoder := json.NewDecoder(bufio.NewReader(r.file))
+	var keys int64
+	for {
+		item := record{}
+		if err := decoder.Decode(&item); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("error reading record %d: %s", keys+1, err)
+		}
+		keys++
+		if err := fn(item.Key, item.Value); err != nil {
+			return err
+		}
+	}
+	if keys != r.Manifest.Keys {
+		return fmt.Errorf("archive has %d keys, the manifest %d", keys, r.Manifest.Keys)
+	}
+	return nil
+}
+
+func (r *dataReader) Close() {
+	r.file.Close()
+	os.Remove(r.file.Name())
+}
+
+// checksum formats the hash like the checksums in the status, sha256:<hex>
+func checksum(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
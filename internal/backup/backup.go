@@ -0,0 +1,419 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package backup implements the backup, the restore, the delete and the migrate commands of
+// the manager binary. The jobs of ControlPlaneBackup, ControlPlaneRestore and datastore
+// migrations run them next to kine sidecars which are connected to the datastores of the
+// tenant. It also evaluates the schedules and the
+// retention rules of ControlPlaneBackupSchedule.
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+var log = ctrl.Log.WithName("backup")
+
+const (
+	// Commands of the manager binary
+	CommandBackup  = "backup"
+	CommandRestore = "restore"
+	CommandDelete  = "delete"
+	CommandMigrate = "migrate"
+
+	// environment variables with the credentials of the S3 target
+	EnvAccessKeyID     = "AWS_ACCESS_KEY_ID"
+	EnvSecretAccessKey = "AWS_SECRET_ACCESS_KEY"
+
+	// pageSize is the number of keys read with one range request
+	pageSize = 500
+	// readyTimeout is how long the commands wait for the kine sidecar
+	readyTimeout = 2 * time.Minute
+)
+
+// Options of the commands, the archive is either File or S3 and S3Key
+type Options struct {
+	// Endpoint is the etcd API of kine
+	Endpoint string
+	// TargetEndpoint is the etcd API of the kine a migration copies the keys to
+	TargetEndpoint string
+	// Prefix is the --etcd-prefix of the apiserver of the tenant
+	Prefix string
+	// ControlPlane is recorded in the manifest of a backup
+	ControlPlane string
+	File         string
+	S3           *S3
+	S3Key        string
+	// Checksum is the expected checksum of the archive to restore, not checked if empty
+	Checksum string
+	// WorkDir takes the temporary files
+	WorkDir string
+}
+
+// Result of a command, the job reports it as the termination message of its container
+type Result struct {
+	Location string `json:"location,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+	Revision int64  `json:"revision,omitempty"`
+	Keys     int64  `json:"keys"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Backup writes all keys under the prefix into an archive at the revision of the first read
+func Backup(ctx context.Context, kv *KV, opts *Options) (*Result, error) {
+	if err := waitReady(ctx, kv, opts.Prefix); err != nil {
+		return nil, err
+	}
+	data, err := newDataWriter(opts.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	prefix := keyPrefix(opts.Prefix)
+	revision, err := kv.List(ctx, prefix, pageSize, func(item KeyValue) error {
+		return data.Add(item.Key[len(prefix):], item.Value)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading keys: %s", err)
+	}
+	manifest := &Manifest{
+		ControlPlane: opts.ControlPlane,
+		Prefix:       opts.Prefix,
+		Revision:     revision,
+		Created:      time.Now().UTC().Truncate(time.Second),
+	}
+
+	// the archive is written next to its destination on a volume, else into the work dir
+	path := opts.File + ".partial"
+	if opts.File == "" {
+		path = filepath.Join(opts.WorkDir, "archive.tar.gz")
+	} else if err := os.MkdirAll(filepath.Dir(opts.File), 0700); err != nil {
+		return nil, fmt.Errorf("error creating directory of %s: %s", opts.File, err)
+	}
+	archive, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating archive: %s", err)
+	}
+	defer os.Remove(path)
+	defer archive.Close()
+	archiveHash := sha256.New()
+	if err := data.WriteArchive(io.MultiWriter(archive, archiveHash), manifest); err != nil {
+		return nil, err
+	}
+	size, err := archive.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("error writing archive: %s", err)
+	}
+	result := &Result{
+		Size:     size,
+		Checksum: checksum(archiveHash),
+		Revision: revision,
+		Keys:     manifest.Keys,
+	}
+	// the checksum file can be verified with sha256sum -c
+	sum := []byte(strings.TrimPrefix(result.Checksum, "sha256:") + "  " + filepath.Base(opts.File+opts.S3Key) + "\n")
+
+	if opts.File != "" {
+		if err := archive.Sync(); err != nil {
+			return nil, fmt.Errorf("error writing archive: %s", err)
+		}
+		if err := os.Rename(path, opts.File); err != nil {
+			return nil, fmt.Errorf("error writing archive: %s", err)
+		}
+		if err := os.WriteFile(opts.File+".sha256", sum, 0600); err != nil {
+			return nil, fmt.Errorf("error writing checksum file: %s", err)
+		}
+		result.Location = opts.File
+		return result, nil
+	}
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error reading archive: %s", err)
+	}
+	if err := opts.S3.Put(ctx, opts.S3Key, archive, size, hex.EncodeToString(archiveHash.Sum(nil))); err != nil {
+		return nil, err
+	}
+	sumHash := sha256.Sum256(sum)
+	if err := opts.S3.Put(ctx, opts.S3Key+".sha256", strings.NewReader(string(sum)), int64(len(sum)), hex.EncodeToString(sumHash[:])); err != nil {
+		return nil, err
+	}
+	result.Location = "s3://" + opts.S3.Bucket + "/" + strings.TrimPrefix(opts.S3Key, "/")
+	return result, nil
+}
+
+// Restore replaces all keys under the prefix by the keys of the archive. The apiserver of the
+// tenant must not run, the keys are deleted one by one and created again.
+func Restore(ctx context.Context, kv *KV, opts *Options) (*Result, error) {
+	path := opts.File
+	if path == "" {
+		path = filepath.Join(opts.WorkDir, "archive.tar.gz")
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("error creating archive: %s", err)
+		}
+		defer os.Remove(path)
+		err = opts.S3.Get(ctx, opts.S3Key, file)
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("error writing archive: %s", closeErr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	archive, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening archive: %s", err)
+	}
+	defer archive.Close()
+	archiveHash := sha256.New()
+	size, err := io.Copy(archiveHash, archive)
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive: %s", err)
+	}
+	if opts.Checksum != "" && opts.Checksum != checksum(archiveHash) {
+		return nil, fmt.Errorf("checksum of the archive is %s, expected %s", checksum(archiveHash), opts.Checksum)
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error reading archive: %s", err)
+	}
+	data, err := readArchive(archive, opts.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+	log.Info("read archive", "controlPlane", data.Manifest.ControlPlane, "keys", data.Manifest.Keys, "revision", data.Manifest.Revision)
+
+	if err := waitReady(ctx, kv, opts.Prefix); err != nil {
+		return nil, err
+	}
+	prefix := keyPrefix(opts.Prefix)
+	if err := deleteKeys(ctx, kv, prefix); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Location: opts.File, Size: size, Checksum: checksum(archiveHash)}
+	if opts.File == "" {
+		result.Location = "s3://" + opts.S3.Bucket + "/" + strings.TrimPrefix(opts.S3Key, "/")
+	}
+	err = data.Records(func(key, value []byte) error {
+		fullKey := append(append([]byte{}, prefix...), key...)
+		revision, err := kv.Create(ctx, fullKey, value)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %s", fullKey, err)
+		}
+		result.Keys++
+		result.Revision = revision
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// deleteKeys deletes all keys under the prefix one by one
+func deleteKeys(ctx context.Context, kv *KV, prefix []byte) error {
+	existing := []KeyValue{}
+	if _, err := kv.List(ctx, prefix, pageSize, func(item KeyValue) error {
+		existing = append(existing, KeyValue{Key: item.Key, ModRevision: item.ModRevision})
+		return nil
+	}); err != nil {
+		return fmt.Errorf("error reading keys: %s", err)
+	}
+	log.Info("deleting keys", "keys", len(existing), "prefix", string(prefix))
+	for _, item := range existing {
+		if err := kv.Delete(ctx, item.Key, item.ModRevision); err != nil {
+			return fmt.Errorf("error deleting %s: %s", item.Key, err)
+		}
+	}
+	return nil
+}
+
+// Delete removes the archive and its checksum file, missing files are no error
+func Delete(ctx context.Context, opts *Options) (*Result, error) {
+	if opts.File != "" {
+		for _, file := range []string{opts.File, opts.File + ".sha256"} {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("error deleting %s: %s", file, err)
+			}
+		}
+		return &Result{Location: opts.File}, nil
+	}
+	for _, key := range []string{opts.S3Key, opts.S3Key + ".sha256"} {
+		if err := opts.S3.Delete(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+	return &Result{Location: "s3://" + opts.S3.Bucket + "/" + strings.TrimPrefix(opts.S3Key, "/")}, nil
+}
+
+// IsCommand is true if the arguments of the manager binary start with a command of the jobs
+func IsCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case CommandBackup, CommandRestore, CommandDelete, CommandMigrate:
+		return true
+	}
+	return false
+}
+
+// waitReady waits until kine answers, the sidecar may still be connecting to the datastore
+func waitReady(ctx context.Context, kv *KV, prefix string) error {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+	for {
+		_, err := kv.Revision(ctx, keyPrefix(prefix))
+		if err == nil {
+			return nil
+		}
+		log.Info("waiting for kine", "error", err.Error())
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("kine is not ready: %s", err)
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// Main runs a command with the arguments of the job and writes the result to the result file,
+// it returns the exit code
+func Main(args []string) int {
+	if !IsCommand(args) {
+		fmt.Fprintf(os.Stderr, "usage: %s|%s|%s|%s [flags]\n", CommandBackup, CommandRestore, CommandDelete, CommandMigrate)
+		return 2
+	}
+	opts := &Options{}
+	s3 := &S3{}
+	var resultFile, caFile string
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.StringVar(&opts.Endpoint, "endpoint", "http://127.0.0.1:2379", "The etcd API of kine.")
+	flags.StringVar(&opts.TargetEndpoint, "target-endpoint", "", "The etcd API of the kine a migration copies the keys to.")
+	flags.StringVar(&opts.Prefix, "prefix", "", "The --etcd-prefix of the tenant.")
+	flags.StringVar(&opts.ControlPlane, "control-plane", "", "The name of the control-plane recorded in the archive.")
+	flags.StringVar(&opts.File, "file", "", "The archive on a volume.")
+	flags.StringVar(&s3.Endpoint, "s3-endpoint", "", "The URL of the S3 service, the credentials are read from "+
+		EnvAccessKeyID+" and "+EnvSecretAccessKey+".")
+	flags.StringVar(&s3.Bucket, "s3-bucket", "", "The bucket of the archive.")
+	flags.StringVar(&s3.Region, "s3-region", "us-east-1", "The region of the bucket.")
+	flags.StringVar(&opts.S3Key, "s3-key", "", "The object key of the archive.")
+	flags.StringVar(&caFile, "s3-ca-file", "", "The CA certificate of the S3 service.")
+	flags.StringVar(&opts.Checksum, "checksum", "", "The expected checksum of the archive to restore.")
+	flags.StringVar(&opts.WorkDir, "work-dir", os.TempDir(), "The directory of the temporary files.")
+	flags.StringVar(&resultFile, "result-file", "/dev/termination-log", "The file the result is written to.")
+	logOpts := zap.Options{
+		Development: true,
+		TimeEncoder: zapcore.ISO8601TimeEncoder,
+	}
+	logOpts.BindFlags(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&logOpts)))
+
+	result, err := run(args[0], opts, s3, caFile)
+	if err != nil {
+		log.Error(err, "command failed", "command", args[0])
+		result = &Result{Error: err.Error()}
+	} else if args[0] == CommandDelete {
+		log.Info("command completed", "command", args[0], "location", result.Location)
+	} else {
+		log.Info("command completed", "command", args[0], "keys", result.Keys, "revision", result.Revision,
+			"location", result.Location)
+	}
+	if data, jsonErr := json.Marshal(result); jsonErr == nil {
+		if writeErr := os.WriteFile(resultFile, data, 0600); writeErr != nil {
+			log.Error(writeErr, "error writing result", "file", resultFile)
+		}
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+func run(command string, opts *Options, s3 *S3, caFile string) (*Result, error) {
+	if opts.Prefix == "" && command != CommandDelete {
+		return nil, fmt.Errorf("--prefix is required")
+	}
+	if command == CommandMigrate {
+		if opts.TargetEndpoint == "" {
+			return nil, fmt.Errorf("--target-endpoint is required")
+		}
+	} else if (opts.File == "") == (s3.Endpoint == "") {
+		return nil, fmt.Errorf("either --file or --s3-endpoint is required")
+	}
+	if s3.Endpoint != "" {
+		if s3.Bucket == "" || opts.S3Key == "" {
+			return nil, fmt.Errorf("--s3-bucket and --s3-key are required")
+		}
+		s3.AccessKeyID = os.Getenv(EnvAccessKeyID)
+		s3.SecretAccessKey = os.Getenv(EnvSecretAccessKey)
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if caFile != "" {
+			ca, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading %s: %s", caFile, err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("%s has no certificate", caFile)
+			}
+			transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		}
+		s3.Client = &http.Client{Transport: transport}
+		opts.S3 = s3
+	}
+	ctx := context.Background()
+	if command == CommandDelete {
+		return Delete(ctx, opts)
+	}
+	kv, err := NewKV(opts.Endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch command {
+	case CommandBackup:
+		return Backup(ctx, kv, opts)
+	case CommandMigrate:
+		target, err := NewKV(opts.TargetEndpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		return Migrate(ctx, kv, target, opts)
+	}
+	return Restore(ctx, kv, opts)
+}
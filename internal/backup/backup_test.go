@@ -0,0 +1,205 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Backup", func() {
+	var (
+		ctx  = context.Background()
+		kine *kineStandIn
+		kv   *KV
+		dir  string
+	)
+
+	BeforeEach(func() {
+		var err error
+		kine = newKineStandIn()
+		kv, err = NewKV(kine.server.URL, nil)
+		Expect(err).NotTo(HaveOccurred())
+		dir = GinkgoT().TempDir()
+
+		kine.Put("/tenant-a/pods/default/web", "pod")
+		kine.Put("/tenant-a/secrets/default/token", string([]byte{0, 1, 0xff}))
+		kine.Put("/tenant-ab/pods/default/other", "other tenant")
+		kine.Put("/tenant-b/pods/default/stale", "stale")
+	})
+
+	AfterEach(func() {
+		kine.Close()
+	})
+
+	options := func(prefix string) *Options {
+		return &Options{Prefix: prefix, ControlPlane: "a", File: filepath.Join(dir, "backups", "a.tar.gz"), WorkDir: dir}
+	}
+
+	It("should restore the keys of a tenant into another tenant", func() {
+		backup, err := Backup(ctx, kv, options("/tenant-a"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backup.Keys).To(BeEquivalentTo(2))
+		Expect(backup.Revision).To(BeEquivalentTo(5))
+		Expect(backup.Checksum).To(HavePrefix("sha256:"))
+		info, err := os.Stat(backup.Location)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backup.Size).To(Equal(info.Size()))
+		Expect(os.ReadFile(backup.Location + ".sha256")).To(HaveSuffix("  a.tar.gz\n"))
+
+		restoreOptions := options("/tenant-b")
+		restoreOptions.Checksum = backup.Checksum
+		restore, err := Restore(ctx, kv, restoreOptions)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restore.Keys).To(BeEquivalentTo(2))
+		Expect(kine.Data()).To(Equal(map[string]string{
+			"/tenant-a/pods/default/web":      "pod",
+			"/tenant-a/secrets/default/token": string([]byte{0, 1, 0xff}),
+			"/tenant-ab/pods/default/other":   "other tenant",
+			"/tenant-b/pods/default/web":      "pod",
+			"/tenant-b/secrets/default/token": string([]byte{0, 1, 0xff}),
+		}))
+	})
+
+	It("should read all pages at the same revision", func() {
+		for i := 0; i < 2*pageSize+10; i++ {
+			kine.Put(fmt.Sprintf("/tenant-c/configmaps/default/%04d", i), "data")
+		}
+		backup, err := Backup(ctx, kv, options("/tenant-c"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backup.Keys).To(BeEquivalentTo(2*pageSize + 10))
+	})
+
+	It("should refuse an archive with another checksum", func() {
+		backup, err := Backup(ctx, kv, options("/tenant-a"))
+		Expect(err).NotTo(HaveOccurred())
+
+		restoreOptions := options("/tenant-b")
+		restoreOptions.Checksum = "sha256:0000"
+		_, err = Restore(ctx, kv, restoreOptions)
+		Expect(err).To(MatchError(ContainSubstring("checksum of the archive is " + backup.Checksum)))
+		Expect(kine.Data()).To(HaveKey("/tenant-b/pods/default/stale"))
+	})
+
+	It("should refuse an archive whose data does not match the manifest", func() {
+		_, err := Backup(ctx, kv, options("/tenant-a"))
+		Expect(err).NotTo(HaveOccurred())
+
+		// replace the data but keep the manifest
+		file, err := os.Open(options("").File)
+		Expect(err).NotTo(HaveOccurred())
+		gz, err := gzip.NewReader(file)
+		Expect(err).NotTo(HaveOccurred())
+		var tampered bytes.Buffer
+		gzOut := gzip.NewWriter(&tampered)
+		tw := tar.NewWriter(gzOut)
+		tr := tar.NewReader(gz)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(tr)
+			Expect(err).NotTo(HaveOccurred())
+			if header.Name == dataFile {
+				data = bytes.Replace(data, []byte("cG9k"), []byte("UE9E"), 1)
+			}
+			header.Size = int64(len(data))
+			Expect(tw.WriteHeader(header)).To(Succeed())
+			_, err = tw.Write(data)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		file.Close()
+		Expect(tw.Close()).To(Succeed())
+		Expect(gzOut.Close()).To(Succeed())
+		Expect(os.WriteFile(options("").File, tampered.Bytes(), 0600)).To(Succeed())
+
+		_, err = Restore(ctx, kv, options("/tenant-b"))
+		Expect(err).To(MatchError(ContainSubstring("does not match the manifest")))
+	})
+
+	It("should upload the archive to S3 and download it again", func() {
+		s3 := newS3StandIn("eu-central-1", "minio", "minio123")
+		defer s3.Close()
+		target := &S3{
+			Endpoint:        s3.server.URL,
+			Bucket:          "backups",
+			Region:          "eu-central-1",
+			AccessKeyID:     "minio",
+			SecretAccessKey: "minio123",
+			Client:          s3.server.Client(),
+		}
+		opts := &Options{Prefix: "/tenant-a", S3: target, S3Key: "tenants/a 1.tar.gz", WorkDir: dir}
+
+		backup, err := Backup(ctx, kv, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backup.Location).To(Equal("s3://backups/tenants/a 1.tar.gz"))
+		objects := s3.Objects()
+		Expect(objects).To(HaveKey("backups/tenants/a 1.tar.gz"))
+		Expect(objects).To(HaveKey("backups/tenants/a 1.tar.gz.sha256"))
+		Expect(int64(len(objects["backups/tenants/a 1.tar.gz"]))).To(Equal(backup.Size))
+
+		opts.Prefix = "/tenant-b"
+		opts.Checksum = backup.Checksum
+		restore, err := Restore(ctx, kv, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restore.Keys).To(BeEquivalentTo(2))
+		Expect(kine.Data()).To(HaveKeyWithValue("/tenant-b/pods/default/web", "pod"))
+
+		_, err = Delete(ctx, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s3.Objects()).To(BeEmpty())
+
+		target.SecretAccessKey = "wrong"
+		_, err = Backup(ctx, kv, opts)
+		Expect(err).To(MatchError(ContainSubstring("SignatureDoesNotMatch")))
+	})
+
+	It("should write the result of the command to the result file", func() {
+		resultFile := filepath.Join(dir, "result")
+		Expect(Main([]string{CommandBackup, "--endpoint=" + kine.server.URL, "--prefix=/tenant-a",
+			"--file=" + filepath.Join(dir, "a.tar.gz"), "--work-dir=" + dir, "--result-file=" + resultFile})).To(Equal(0))
+		result := &Result{}
+		data, err := os.ReadFile(resultFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(json.Unmarshal(data, result)).To(Succeed())
+		Expect(result.Keys).To(BeEquivalentTo(2))
+
+		Expect(Main([]string{CommandRestore, "--endpoint=" + kine.server.URL, "--prefix=/tenant-b",
+			"--file=" + filepath.Join(dir, "missing.tar.gz"), "--work-dir=" + dir, "--result-file=" + resultFile})).To(Equal(1))
+		data, err = os.ReadFile(resultFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(json.Unmarshal(data, result)).To(Succeed())
+		Expect(result.Error).To(ContainSubstring("missing.tar.gz"))
+
+		Expect(Main([]string{CommandDelete, "--file=" + filepath.Join(dir, "a.tar.gz"), "--result-file=" + resultFile})).To(Equal(0))
+		Expect(filepath.Join(dir, "a.tar.gz")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(dir, "a.tar.gz.sha256")).NotTo(BeAnExistingFile())
+		Expect(Main([]string{CommandDelete, "--file=" + filepath.Join(dir, "a.tar.gz"), "--result-file=" + resultFile})).To(Equal(0))
+	})
+})
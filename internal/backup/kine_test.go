@@ -0,0 +1,221 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// kineStandIn serves the KV API like kine: ranges with limits and transactions which create
+// or delete a single key
+type kineStandIn struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	revision int64
+	keys     map[string]KeyValue
+	// corrupt stores a different value than the one put, like a broken backend
+	corrupt bool
+}
+
+func newKineStandIn() *kineStandIn {
+	k := &kineStandIn{revision: 1, keys: map[string]KeyValue{}}
+	k.server = httptest.NewServer(h2c.NewHandler(http.HandlerFunc(k.handle), &http2.Server{}))
+	return k
+}
+
+func (k *kineStandIn) Close() {
+	k.server.Close()
+}
+
+func (k *kineStandIn) Put(key, value string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.revision++
+	k.keys[key] = KeyValue{Key: []byte(key), Value: []byte(value), ModRevision: k.revision}
+}
+
+func (k *kineStandIn) SetCorrupt(corrupt bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.corrupt = corrupt
+}
+
+// Data returns the values of all keys
+func (k *kineStandIn) Data() map[string]string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	data := map[string]string{}
+	for key, item := range k.keys {
+		data[key] = string(item.Value)
+	}
+	return data
+}
+
+func (k *kineStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	var resp []byte
+	var err error
+	switch r.URL.Path {
+	case "/etcdserverpb.KV/Range":
+		resp, err = k.rangeKeys(body[5:])
+	case "/etcdserverpb.KV/Txn":
+		resp, err = k.txn(body[5:])
+	default:
+		err = fmt.Errorf("%s is not supported", r.URL.Path)
+	}
+	if err != nil {
+		w.Header().Set("Grpc-Status", "12")
+		w.Header().Set("Grpc-Message", err.Error())
+		return
+	}
+	frame := make([]byte, 5, 5+len(resp))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(resp)))
+	_, _ = w.Write(append(frame, resp...))
+	w.Header().Set("Grpc-Status", "0")
+}
+
+func (k *kineStandIn) header() []byte {
+	var header []byte
+	header = protowire.AppendTag(header, headerRevision, protowire.VarintType)
+	return protowire.AppendVarint(header, uint64(k.revision))
+}
+
+func (k *kineStandIn) rangeKeys(req []byte) ([]byte, error) {
+	var key, end []byte
+	var limit int
+	if err := parseMessage(req, func(num protowire.Number, value []byte, varint uint64) error {
+		switch num {
+		case rangeKey:
+			key = value
+		case rangeEnd:
+			end = value
+		case rangeLimit:
+			limit = int(varint)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	names := []string{}
+	for name := range k.keys {
+		if end == nil && name == string(key) ||
+			end != nil && bytes.Compare([]byte(name), key) >= 0 && bytes.Compare([]byte(name), end) < 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	more := limit > 0 && len(names) > limit
+	if more {
+		names = names[:limit]
+	}
+	resp := appendMessage(nil, rangeResponseHeader, k.header())
+	for _, name := range names {
+		item := k.keys[name]
+		var kv []byte
+		kv = protowire.AppendTag(kv, kvKey, protowire.BytesType)
+		kv = protowire.AppendBytes(kv, item.Key)
+		kv = protowire.AppendTag(kv, kvModRevision, protowire.VarintType)
+		kv = protowire.AppendVarint(kv, uint64(item.ModRevision))
+		kv = protowire.AppendTag(kv, kvValue, protowire.BytesType)
+		kv = protowire.AppendBytes(kv, item.Value)
+		resp = appendMessage(resp, rangeResponseKVs, kv)
+	}
+	resp = protowire.AppendTag(resp, rangeResponseMore, protowire.VarintType)
+	return protowire.AppendVarint(resp, protowire.EncodeBool(more)), nil
+}
+
+func (k *kineStandIn) txn(req []byte) ([]byte, error) {
+	var key []byte
+	var modRevision int64
+	var put, del []byte
+	if err := parseMessage(req, func(num protowire.Number, value []byte, varint uint64) error {
+		switch num {
+		case txnCompare:
+			return parseMessage(value, func(num protowire.Number, value []byte, varint uint64) error {
+				switch num {
+				case compareKey:
+					key = value
+				case compareModRevision:
+					modRevision = int64(varint)
+				}
+				return nil
+			})
+		case txnSuccess:
+			return parseMessage(value, func(num protowire.Number, value []byte, varint uint64) error {
+				switch num {
+				case requestPut:
+					put = value
+				case requestDeleteRange:
+					del = value
+				}
+				return nil
+			})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	current := k.keys[string(key)]
+	succeeded := current.ModRevision == modRevision
+	if succeeded {
+		k.revision++
+		switch {
+		case put != nil:
+			item := KeyValue{ModRevision: k.revision}
+			_ = parseMessage(put, func(num protowire.Number, value []byte, varint uint64) error {
+				switch num {
+				case putKey:
+					item.Key = value
+				case putValue:
+					item.Value = value
+				}
+				return nil
+			})
+			if k.corrupt {
+				item.Value = append(item.Value, '!')
+			}
+			k.keys[string(item.Key)] = item
+		case del != nil:
+			delete(k.keys, string(key))
+		default:
+			return nil, fmt.Errorf("transaction is not supported")
+		}
+	}
+	resp := appendMessage(nil, txnResponseHeader, k.header())
+	resp = protowire.AppendTag(resp, txnResponseSucceeded, protowire.VarintType)
+	return protowire.AppendVarint(resp, protowire.EncodeBool(succeeded)), nil
+}
@@ -0,0 +1,409 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// KV speaks the KV API of etcd v3 over gRPC. It implements the subset kine serves: ranges and
+// transactions which create or delete a single key, without the etcd client and its
+// dependencies. The messages are encoded with protowire.
+type KV struct {
+	endpoint string
+	client   *http.Client
+}
+
+// KeyValue is a key of the store with its value and its last modification
+type KeyValue struct {
+	Key         []byte
+	Value       []byte
+	ModRevision int64
+}
+
+// field numbers of the etcdserverpb messages
+const (
+	rangeKey      = 1
+	rangeEnd      = 2
+	rangeLimit    = 3
+	rangeRevision = 4
+
+	rangeResponseHeader = 1
+	rangeResponseKVs    = 2
+	rangeResponseMore   = 3
+
+	headerRevision = 3
+
+	kvKey         = 1
+	kvModRevision = 3
+	kvValue       = 5
+
+	txnCompare = 1
+	txnSuccess = 2
+	txnFailure = 3
+
+	txnResponseHeader    = 1
+	txnResponseSucceeded = 2
+
+	compareResult      = 1
+	compareTarget      = 2
+	compareKey         = 3
+	compareModRevision = 6
+	// Compare_EQUAL and Compare_MOD
+	compareEqual = 0
+	compareMod   = 2
+
+	requestRange       = 1
+	requestPut         = 2
+	requestDeleteRange = 3
+
+	putKey   = 1
+	putValue = 2
+)
+
+// NewKV connects to an etcd endpoint (http://host:port or https://host:port), the TLS config
+// is only used for https
+func NewKV(endpoint string, tlsConfig *tls.Config) (*KV, error) {
+	transport, base, err := NewTransport(endpoint, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &KV{endpoint: base.String(), client: &http.Client{Transport: transport}}, nil
+}
+
+// NewTransport is the HTTP/2 transport of gRPC calls to an etcd endpoint (http://host:port or
+// https://host:port) and the base url of the calls, the TLS config is only used for https
+func NewTransport(endpoint string, tlsConfig *tls.Config) (*http2.Transport, *url.URL, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid endpoint %s: %s", endpoint, err)
+	}
+	transport := &http2.Transport{TLSClientConfig: tlsConfig}
+	switch u.Scheme {
+	case "http":
+		// gRPC without TLS is HTTP/2 with prior knowledge
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		}
+	case "https":
+	default:
+		return nil, nil, fmt.Errorf("invalid endpoint %s: scheme must be http or https", endpoint)
+	}
+	return transport, &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
+
+// List calls fn for every key with the prefix in the order of the keys. All pages are read at
+// the revision of the first one, which is returned.
+func (kv *KV) List(ctx context.Context, prefix []byte, pageSize int64, fn func(KeyValue) error) (int64, error) {
+	end := prefixEnd(prefix)
+	key := prefix
+	var revision int64
+	var last []byte
+	for {
+		page, more, pageRevision, err := kv.rangeKeys(ctx, key, end, pageSize, revision)
+		if err != nil {
+			return 0, err
+		}
+		if revision == 0 {
+			revision = pageRevision
+		}
+		for _, item := range page {
+			// kine may return the start key of the next page again
+			if last != nil && bytes.Compare(item.Key, last) <= 0 {
+				continue
+			}
+			if err := fn(item); err != nil {
+				return 0, err
+			}
+			last = item.Key
+		}
+		if !more || len(page) == 0 {
+			return revision, nil
+		}
+		key = append(append([]byte{}, last...), 0)
+	}
+}
+
+// Revision is the current revision of the store
+func (kv *KV) Revision(ctx context.Context, key []byte) (int64, error) {
+	_, _, revision, err := kv.rangeKeys(ctx, key, nil, 1, 0)
+	return revision, err
+}
+
+// Create adds a key which must not exist, it returns the revision of the store
+func (kv *KV) Create(ctx context.Context, key, value []byte) (int64, error) {
+	var put []byte
+	put = protowire.AppendTag(put, putKey, protowire.BytesType)
+	put = protowire.AppendBytes(put, key)
+	put = protowire.AppendTag(put, putValue, protowire.BytesType)
+	put = protowire.AppendBytes(put, value)
+
+	var req []byte
+	req = appendMessage(req, txnCompare, modRevisionEquals(key, 0))
+	req = appendMessage(req, txnSuccess, appendMessage(nil, requestPut, put))
+	succeeded, revision, err := kv.txn(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	if !succeeded {
+		return 0, fmt.Errorf("key %s exists", key)
+	}
+	return revision, nil
+}
+
+// Delete removes a key if it was not modified after modRevision
+func (kv *KV) Delete(ctx context.Context, key []byte, modRevision int64) error {
+	var del []byte
+	del = protowire.AppendTag(del, rangeKey, protowire.BytesType)
+	del = protowire.AppendBytes(del, key)
+	var get []byte
+	get = protowire.AppendTag(get, rangeKey, protowire.BytesType)
+	get = protowire.AppendBytes(get, key)
+
+	var req []byte
+	req = appendMessage(req, txnCompare, modRevisionEquals(key, modRevision))
+	req = appendMessage(req, txnSuccess, appendMessage(nil, requestDeleteRange, del))
+	req = appendMessage(req, txnFailure, appendMessage(nil, requestRange, get))
+	succeeded, _, err := kv.txn(ctx, req)
+	if err != nil {
+		return err
+	}
+	if !succeeded {
+		return fmt.Errorf("key %s was modified", key)
+	}
+	return nil
+}
+
+func (kv *KV) rangeKeys(ctx context.Context, key, end []byte, limit, revision int64) ([]KeyValue, bool, int64, error) {
+	var req []byte
+	req = protowire.AppendTag(req, rangeKey, protowire.BytesType)
+	req = protowire.AppendBytes(req, key)
+	if len(end) > 0 {
+		req = protowire.AppendTag(req, rangeEnd, protowire.BytesType)
+		req = protowire.AppendBytes(req, end)
+	}
+	if limit > 0 {
+		req = protowire.AppendTag(req, rangeLimit, protowire.VarintType)
+		req = protowire.AppendVarint(req, uint64(limit))
+	}
+	if revision > 0 {
+		req = protowire.AppendTag(req, rangeRevision, protowire.VarintType)
+		req = protowire.AppendVarint(req, uint64(revision))
+	}
+	resp, err := kv.call(ctx, "Range", req)
+	if err != nil {
+		return nil, false, 0, err
+	}
+
+	kvs := []KeyValue{}
+	var more bool
+	var header []byte
+	err = parseMessage(resp, func(num protowire.Number, value []byte, varint uint64) error {
+		switch num {
+		case rangeResponseHeader:
+			header = value
+		case rangeResponseKVs:
+			item := KeyValue{}
+			if err := parseMessage(value, func(num protowire.Number, value []byte, varint uint64) error {
+				switch num {
+				case kvKey:
+					item.Key = value
+				case kvValue:
+					item.Value = value
+				case kvModRevision:
+					item.ModRevision = int64(varint)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			kvs = append(kvs, item)
+		case rangeResponseMore:
+			more = varint != 0
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("invalid range response: %s", err)
+	}
+	revision, err = headerRevisionOf(header)
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("invalid range response: %s", err)
+	}
+	return kvs, more, revision, nil
+}
+
+func (kv *KV) txn(ctx context.Context, req []byte) (bool, int64, error) {
+	resp, err := kv.call(ctx, "Txn", req)
+	if err != nil {
+		return false, 0, err
+	}
+	var succeeded bool
+	var header []byte
+	err = parseMessage(resp, func(num protowire.Number, value []byte, varint uint64) error {
+		switch num {
+		case txnResponseHeader:
+			header = value
+		case txnResponseSucceeded:
+			succeeded = varint != 0
+		}
+		return nil
+	})
+	if err != nil {
+		return false, 0, fmt.Errorf("invalid txn response: %s", err)
+	}
+	revision, err := headerRevisionOf(header)
+	if err != nil {
+		return false, 0, fmt.Errorf("invalid txn response: %s", err)
+	}
+	return succeeded, revision, nil
+}
+
+// call sends a unary gRPC request of the KV service and returns the response message
+func (kv *KV) call(ctx context.Context, method string, message []byte) ([]byte, error) {
+	body := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(message)))
+	body = append(body, message...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, kv.endpoint+"/etcdserverpb.KV/"+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := kv.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling %s: %s", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error calling %s: http status %s", method, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s response: %s", method, err)
+	}
+	// errors without a message come as headers only
+	status, statusMessage := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, statusMessage = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		if decoded, err := url.PathUnescape(statusMessage); err == nil {
+			statusMessage = decoded
+		}
+		return nil, fmt.Errorf("%s failed (grpc status %s): %s", method, status, statusMessage)
+	}
+	if len(data) < 5 || data[0] != 0 || int(binary.BigEndian.Uint32(data[1:5])) != len(data)-5 {
+		return nil, fmt.Errorf("invalid %s response frame", method)
+	}
+	return data[5:], nil
+}
+
+// modRevisionEquals is a Compare of the mod revision of the key, 0 means it does not exist
+func modRevisionEquals(key []byte, modRevision int64) []byte {
+	var cmp []byte
+	cmp = protowire.AppendTag(cmp, compareResult, protowire.VarintType)
+	cmp = protowire.AppendVarint(cmp, compareEqual)
+	cmp = protowire.AppendTag(cmp, compareTarget, protowire.VarintType)
+	cmp = protowire.AppendVarint(cmp, compareMod)
+	cmp = protowire.AppendTag(cmp, compareKey, protowire.BytesType)
+	cmp = protowire.AppendBytes(cmp, key)
+	// a field of the target oneof, it is encoded even if it is 0
+	cmp = protowire.AppendTag(cmp, compareModRevision, protowire.VarintType)
+	return protowire.AppendVarint(cmp, uint64(modRevision))
+}
+
+func appendMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+// parseMessage calls fn for every field, value is set for length-delimited fields and varint
+// for varint fields. Other wire types are skipped.
+func parseMessage(b []byte, fn func(num protowire.Number, value []byte, varint uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var value []byte
+		var varint uint64
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ == protowire.BytesType || typ == protowire.VarintType {
+			if err := fn(num, value, varint); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func headerRevisionOf(header []byte) (int64, error) {
+	var revision int64
+	err := parseMessage(header, func(num protowire.Number, _ []byte, varint uint64) error {
+		if num == headerRevision {
+			revision = int64(varint)
+		}
+		return nil
+	})
+	return revision, err
+}
+
+// prefixEnd is the end of the range of all keys with the prefix
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// all keys
+	return []byte{0}
+}
+
+// keyPrefix is the prefix of the keys of the apiserver with --etcd-prefix
+func keyPrefix(etcdPrefix string) []byte {
+	return []byte(strings.TrimSuffix(etcdPrefix, "/") + "/")
+}
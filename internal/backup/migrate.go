@@ -0,0 +1,122 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+)
+
+// Migrate copies all keys under the prefix from the source to the target, keys of the tenant
+// which exist on the target already are deleted first. The copy is verified: the number of
+// keys and a checksum of the keys and values of the target must match the source, which is
+// read again to detect writes during the copy. If the verification fails the copied keys are
+// deleted from the target. The revisions are assigned by the target.
+func Migrate(ctx context.Context, source, target *KV, opts *Options) (*Result, error) {
+	if err := waitReady(ctx, source, opts.Prefix); err != nil {
+		return nil, fmt.Errorf("source: %s", err)
+	}
+	if err := waitReady(ctx, target, opts.Prefix); err != nil {
+		return nil, fmt.Errorf("target: %s", err)
+	}
+	prefix := keyPrefix(opts.Prefix)
+	if err := deleteKeys(ctx, target, prefix); err != nil {
+		return nil, fmt.Errorf("target: %s", err)
+	}
+
+	result := &Result{}
+	copied := newKeysHash()
+	revision, err := source.List(ctx, prefix, pageSize, func(item KeyValue) error {
+		targetRevision, err := target.Create(ctx, item.Key, item.Value)
+		if err != nil {
+			return fmt.Errorf("error creating %s on target: %s", item.Key, err)
+		}
+		copied.Add(item)
+		result.Keys++
+		result.Revision = targetRevision
+		return nil
+	})
+	if err != nil {
+		return nil, rollback(ctx, target, prefix, err)
+	}
+	log.Info("copied keys", "keys", result.Keys, "sourceRevision", revision)
+
+	current, err := hashKeys(ctx, source, prefix)
+	if err != nil {
+		return nil, rollback(ctx, target, prefix, fmt.Errorf("source: %s", err))
+	}
+	if current.keys != copied.keys || current.Sum() != copied.Sum() {
+		return nil, rollback(ctx, target, prefix, fmt.Errorf("verification failed: the source has changed during the copy "+
+			"(%d keys copied, %d keys now)", copied.keys, current.keys))
+	}
+	migrated, err := hashKeys(ctx, target, prefix)
+	if err != nil {
+		return nil, rollback(ctx, target, prefix, fmt.Errorf("target: %s", err))
+	}
+	if migrated.keys != copied.keys || migrated.Sum() != copied.Sum() {
+		return nil, rollback(ctx, target, prefix, fmt.Errorf("verification failed: the target has %d keys with checksum %s, "+
+			"the source %d keys with checksum %s", migrated.keys, migrated.Sum(), copied.keys, copied.Sum()))
+	}
+	result.Checksum = copied.Sum()
+	return result, nil
+}
+
+// rollback deletes the copied keys from the target and returns the error of the migration
+func rollback(ctx context.Context, target *KV, prefix []byte, err error) error {
+	log.Error(err, "migration failed, deleting the copied keys")
+	if deleteErr := deleteKeys(ctx, target, prefix); deleteErr != nil {
+		return fmt.Errorf("%s (rollback failed: %s)", err, deleteErr)
+	}
+	return err
+}
+
+// keysHash is a checksum of keys and values in the order they are added
+type keysHash struct {
+	hash hash.Hash
+	keys int64
+}
+
+func newKeysHash() *keysHash {
+	return &keysHash{hash: sha256.New()}
+}
+
+func (h *keysHash) Add(item KeyValue) {
+	for _, b := range [][]byte{item.Key, item.Value} {
+		h.hash.Write(binary.AppendUvarint(nil, uint64(len(b))))
+		h.hash.Write(b)
+	}
+	h.keys++
+}
+
+func (h *keysHash) Sum() string {
+	return checksum(h.hash)
+}
+
+// hashKeys reads all keys under the prefix into a keysHash
+func hashKeys(ctx context.Context, kv *KV, prefix []byte) (*keysHash, error) {
+	h := newKeysHash()
+	if _, err := kv.List(ctx, prefix, pageSize, func(item KeyValue) error {
+		h.Add(item)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("error reading keys: %s", err)
+	}
+	return h, nil
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Migrate", func() {
+	var (
+		ctx            = context.Background()
+		source, target *kineStandIn
+		sourceKV       *KV
+		targetKV       *KV
+	)
+
+	BeforeEach(func() {
+		var err error
+		source = newKineStandIn()
+		target = newKineStandIn()
+		sourceKV, err = NewKV(source.server.URL, nil)
+		Expect(err).NotTo(HaveOccurred())
+		targetKV, err = NewKV(target.server.URL, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		for i := 0; i < 7; i++ {
+			source.Put(fmt.Sprintf("/tenant-a/pods/default/web-%d", i), fmt.Sprintf("pod %d", i))
+		}
+		source.Put("/tenant-b/pods/default/other", "other tenant")
+		target.Put("/tenant-a/pods/default/stale", "stale")
+		target.Put("/tenant-c/pods/default/neighbour", "neighbour")
+	})
+
+	AfterEach(func() {
+		source.Close()
+		target.Close()
+	})
+
+	It("should copy the keys of the tenant and verify them", func() {
+		result, err := Migrate(ctx, sourceKV, targetKV, &Options{Prefix: "/tenant-a"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Keys).To(BeEquivalentTo(7))
+		Expect(result.Checksum).To(HavePrefix("sha256:"))
+
+		data := target.Data()
+		Expect(data).To(HaveLen(8))
+		Expect(data).To(HaveKeyWithValue("/tenant-a/pods/default/web-6", "pod 6"))
+		Expect(data).To(HaveKeyWithValue("/tenant-c/pods/default/neighbour", "neighbour"))
+		Expect(data).NotTo(HaveKey("/tenant-a/pods/default/stale"))
+		Expect(data).NotTo(HaveKey("/tenant-b/pods/default/other"))
+	})
+
+	It("should delete the copied keys if the verification fails", func() {
+		target.SetCorrupt(true)
+		_, err := Migrate(ctx, sourceKV, targetKV, &Options{Prefix: "/tenant-a"})
+		Expect(err).To(MatchError(ContainSubstring("verification failed: the target has 7 keys")))
+		Expect(target.Data()).To(Equal(map[string]string{"/tenant-c/pods/default/neighbour": "neighbour"}))
+		Expect(source.Data()).To(HaveLen(8))
+	})
+
+	It("should run as command of the manager", func() {
+		Expect(Main([]string{CommandMigrate, "--endpoint=" + source.server.URL, "--prefix=/tenant-a",
+			"--result-file=" + GinkgoT().TempDir() + "/result"})).To(Equal(1))
+		Expect(Main([]string{CommandMigrate, "--endpoint=" + source.server.URL, "--target-endpoint=" + target.server.URL,
+			"--prefix=/tenant-a", "--result-file=" + GinkgoT().TempDir() + "/result"})).To(Equal(0))
+		Expect(target.Data()).To(HaveKey("/tenant-a/pods/default/web-0"))
+	})
+})
@@ -0,0 +1,193 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// emptyPayloadHash is the sha256 of an empty body
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	amzDateFormat    = "20060102T150405Z"
+)
+
+// S3 reads and writes objects of a bucket of an S3 compatible service (e.g. MinIO) with path
+// style URLs and requests signed with AWS signature version 4
+type S3 struct {
+	// Endpoint is the URL of the service, e.g. https://minio.example.com:9000
+	Endpoint        string
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Client          *http.Client
+}
+
+// Put uploads the object, payloadHash is the hex encoded sha256 of the body
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, payloadHash string) error {
+	req, err := s.request(ctx, http.MethodPut, key, body, payloadHash)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %s", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error uploading %s: %s", key, responseError(resp))
+	}
+	return nil
+}
+
+// Get downloads the object into w
+func (s *S3) Get(ctx context.Context, key string, w io.Writer) error {
+	req, err := s.request(ctx, http.MethodGet, key, nil, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error downloading %s: %s", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error downloading %s: %s", key, responseError(resp))
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("error downloading %s: %s", key, err)
+	}
+	return nil
+}
+
+// Delete removes the object, a missing object is no error
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error deleting %s: %s", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("error deleting %s: %s", key, responseError(resp))
+	}
+	return nil
+}
+
+func (s *S3) request(ctx context.Context, method, key string, body io.Reader, payloadHash string) (*http.Request, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint %s: %s", s.Endpoint, err)
+	}
+	endpoint.Path = "/" + s.Bucket + "/" + strings.TrimPrefix(key, "/")
+	endpoint.RawPath = uriEncode(endpoint.Path)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", time.Now().UTC().Format(amzDateFormat))
+	req.Header.Set("Authorization", s.authorization(req))
+	return req, nil
+}
+
+// authorization signs the method, the path, the host and the x-amz headers of the request
+func (s *S3) authorization(req *http.Request) string {
+	amzDate := req.Header.Get("X-Amz-Date")
+	scope := amzDate[:8] + "/" + s.Region + "/s3/aws4_request"
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	return fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncode escapes everything but the unreserved characters and the slashes of the path
+func uriEncode(path string) string {
+	var b strings.Builder
+	for _, c := range []byte(path) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// responseError is the status and the start of the error document of the service
+func responseError(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if len(body) == 0 {
+		return resp.Status
+	}
+	return resp.Status + ": " + strings.TrimSpace(string(body))
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// s3StandIn stores the objects of any bucket in memory and checks the signature of requests
+type s3StandIn struct {
+	server *httptest.Server
+	signer *S3
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newS3StandIn(region, accessKeyID, secretAccessKey string) *s3StandIn {
+	s := &s3StandIn{objects: map[string][]byte{}}
+	s.signer = &S3{Region: region, AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *s3StandIn) Close() {
+	s.server.Close()
+}
+
+func (s *s3StandIn) Objects() map[string][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects := map[string][]byte{}
+	for key, data := range s.objects {
+		objects[key] = data
+	}
+	return objects
+}
+
+func (s *s3StandIn) handle(w http.ResponseWriter, r *http.Request) {
+	r.URL.Host = r.Host
+	if r.Header.Get("Authorization") != s.signer.authorization(r) {
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != r.Header.Get("X-Amz-Content-Sha256") {
+			http.Error(w, "<Error><Code>XAmzContentSHA256Mismatch</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = data
+	case http.MethodGet:
+		data, ok := s.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron schedule with the fields minute, hour, day of month, month and day of
+// week. The times are evaluated in UTC.
+type Schedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// like cron a day matches either field if both the day of month and the day of week are
+	// restricted
+	anyDay, anyWeekday bool
+}
+
+// scheduleMacros are the abbreviations of common schedules
+var scheduleMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSchedule parses a cron expression, e.g. "30 2 * * *" or "@daily". A field is "*", a
+// value, a range "a-b" or a list of them, each optionally with a step "/n". Sunday is 0 or 7.
+func ParseSchedule(spec string) (*Schedule, error) {
+	expression := strings.TrimSpace(spec)
+	if macro, ok := scheduleMacros[expression]; ok {
+		expression = macro
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", spec, len(fields))
+	}
+	s := &Schedule{}
+	var err error
+	if s.minutes, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute of schedule %q: %s", spec, err)
+	}
+	if s.hours, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour of schedule %q: %s", spec, err)
+	}
+	if s.days, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month of schedule %q: %s", spec, err)
+	}
+	if s.months, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month of schedule %q: %s", spec, err)
+	}
+	if s.weekdays, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week of schedule %q: %s", spec, err)
+	}
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+	s.anyDay = strings.HasPrefix(fields[2], "*")
+	s.anyWeekday = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseField returns the bits of the values of a field
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart = part[:i]
+		}
+		first, last := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if first, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+		default:
+			value, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			first, last = value, value
+			// a single value with a step starts a range, e.g. 5/15
+			if step > 1 {
+				last = max
+			}
+		}
+		if first < min || last > max || first > last {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for value := first; value <= last; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// Next is the first time of the schedule after t, zero if there is none within five years
+// (e.g. February 30)
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hours&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// Retention are the rules which backups of a schedule are kept, a backup is kept if any rule
+// selects it
+type Retention struct {
+	// KeepLast keeps the newest backups
+	KeepLast int
+	// KeepDaily keeps the newest backup of each of the last days with a backup
+	KeepDaily int
+	// KeepWeekly keeps the newest backup of each of the last ISO weeks with a backup
+	KeepWeekly int
+}
+
+// Retain returns which of the times of completed backups the rules keep
+func (r Retention) Retain(times []time.Time) []bool {
+	order := make([]int, len(times))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return times[order[a]].After(times[order[b]]) })
+
+	keep := make([]bool, len(times))
+	for n, i := range order {
+		if n < r.KeepLast {
+			keep[i] = true
+		}
+	}
+	retainPeriods(times, order, keep, r.KeepDaily, func(t time.Time) string {
+		return t.UTC().Format(time.DateOnly)
+	})
+	retainPeriods(times, order, keep, r.KeepWeekly, func(t time.Time) string {
+		year, week := t.UTC().ISOWeek()
+		return fmt.Sprintf("%d-%d", year, week)
+	})
+	return keep
+}
+
+// retainPeriods keeps the newest time of each of the last count periods
+func retainPeriods(times []time.Time, order []int, keep []bool, count int, period func(time.Time) string) {
+	last := ""
+	for _, i := range order {
+		if count <= 0 {
+			return
+		}
+		if p := period(times[i]); p != last {
+			keep[i] = true
+			last = p
+			count--
+		}
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schedule", func() {
+	at := func(value string) time.Time {
+		t, err := time.Parse(time.RFC3339, value)
+		Expect(err).NotTo(HaveOccurred())
+		return t
+	}
+	next := func(spec, after string) string {
+		schedule, err := ParseSchedule(spec)
+		Expect(err).NotTo(HaveOccurred())
+		return schedule.Next(at(after)).Format(time.RFC3339)
+	}
+
+	It("should find the next time of a schedule", func() {
+		Expect(next("30 2 * * *", "2026-10-17T01:00:00Z")).To(Equal("2026-10-17T02:30:00Z"))
+		Expect(next("30 2 * * *", "2026-10-17T02:30:00Z")).To(Equal("2026-10-18T02:30:00Z"))
+		Expect(next("*/15 * * * *", "2026-10-17T10:50:12Z")).To(Equal("2026-10-17T11:00:00Z"))
+		Expect(next("@weekly", "2026-10-17T10:00:00Z")).To(Equal("2026-10-18T00:00:00Z"))
+		Expect(next("0 3 * * 7", "2026-10-17T10:00:00Z")).To(Equal("2026-10-18T03:00:00Z"))
+		Expect(next("0 0 1 */3 *", "2026-10-17T10:00:00Z")).To(Equal("2027-01-01T00:00:00Z"))
+		Expect(next("0 12 29 2 *", "2026-10-17T10:00:00Z")).To(Equal("2028-02-29T12:00:00Z"))
+		Expect(next("0 8-18/5,22 * * 1-5", "2026-10-16T19:00:00Z")).To(Equal("2026-10-16T22:00:00Z"))
+		// the day of month or the day of week matches if both are restricted
+		Expect(next("0 0 1 * 1", "2026-10-17T10:00:00Z")).To(Equal("2026-10-19T00:00:00Z"))
+	})
+
+	It("should refuse invalid schedules", func() {
+		for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@often"} {
+			_, err := ParseSchedule(spec)
+			Expect(err).To(HaveOccurred(), spec)
+		}
+		schedule, err := ParseSchedule("0 0 30 2 *")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(schedule.Next(at("2026-10-17T10:00:00Z")).IsZero()).To(BeTrue())
+	})
+
+	It("should keep the backups selected by any retention rule", func() {
+		times := []time.Time{
+			at("2026-10-17T12:00:00Z"),
+			at("2026-10-17T06:00:00Z"),
+			at("2026-10-16T12:00:00Z"),
+			at("2026-10-16T06:00:00Z"),
+			at("2026-10-15T12:00:00Z"),
+			at("2026-10-11T12:00:00Z"),
+			at("2026-10-04T12:00:00Z"),
+			at("2026-09-27T12:00:00Z"),
+		}
+		Expect(Retention{KeepLast: 3}.Retain(times)).To(Equal(
+			[]bool{true, true, true, false, false, false, false, false}))
+		Expect(Retention{KeepDaily: 3}.Retain(times)).To(Equal(
+			[]bool{true, false, true, false, true, false, false, false}))
+		Expect(Retention{KeepLast: 1, KeepWeekly: 3}.Retain(times)).To(Equal(
+			[]bool{true, false, false, false, false, true, true, false}))
+		Expect(Retention{}.Retain(times)).To(HaveEach(false))
+	})
+})
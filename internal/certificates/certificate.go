@@ -17,16 +17,40 @@ limitations under the License.
 package certificates
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"math/big"
+	"net"
+	"slices"
+	"time"
+)
+
+// KeyAlgorithm is the algorithm of the key of a certificate. RSA keys are encoded as PKCS#1,
+// the others as PKCS#8, public keys as PKIX.
+type KeyAlgorithm string
+
+const (
+	RSA       KeyAlgorithm = "RSA"
+	ECDSAP256 KeyAlgorithm = "ECDSA-P256"
+	ECDSAP384 KeyAlgorithm = "ECDSA-P384"
+	Ed25519   KeyAlgorithm = "Ed25519"
 )
 
 type Certificate struct {
 	Key  string
 	Pub  string
 	Cert string
+	// Chain are the certificates of the issuers of an intermediate CA up to the root, the
+	// certificates it signs carry them after their own
+	Chain string
 }
 
 func NewCertificateFromSecretData(name string, data map[string][]byte) (*Certificate, error) {
@@ -46,6 +70,7 @@ func NewCertificateFromSecretData(name string, data map[string][]byte) (*Certifi
 	} else {
 		return nil, fmt.Errorf("missing crt for certificate %s", name)
 	}
+	cert.Chain = string(data[name+"-chain.crt"])
 
 	return &cert, nil
 }
@@ -62,30 +87,366 @@ func (c *Certificate) RawCert() (*x509.Certificate, error) {
 	return cert, nil
 }
 
-func (c *Certificate) RawKey() (*rsa.PrivateKey, error) {
+// RawKey parses the private key, a PKCS#1 RSA, a SEC1 ECDSA or a PKCS#8 key
+func (c *Certificate) RawKey() (crypto.Signer, error) {
 	block, _ := pem.Decode([]byte(c.Key))
 	if block == nil {
 		return nil, fmt.Errorf("failed to decode key from PEM")
 	}
-	cert, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %s", block.Type)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse private key: %s", err)
 	}
-	return cert, nil
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+	return signer, nil
 }
 
-func (c *Certificate) RawPub() (*rsa.PublicKey, error) {
+// RawPub parses the public key, a PKIX or a PKCS#1 RSA key
+func (c *Certificate) RawPub() (crypto.PublicKey, error) {
 	block, _ := pem.Decode([]byte(c.Pub))
 	if block == nil {
 		return nil, fmt.Errorf("failed to decode public-key from PEM")
 	}
-	pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	var pub any
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported public-key type %s", block.Type)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse public-key: %s", err)
 	}
 	return pub, nil
 }
 
-func (c *Certificate) IsValid() bool {
-	return true
+// KeyAlgorithm returns the algorithm of the key of the certificate
+func (c *Certificate) KeyAlgorithm() (KeyAlgorithm, error) {
+	cert, err := c.RawCert()
+	if err != nil {
+		return "", err
+	}
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return RSA, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return ECDSAP256, nil
+		case elliptic.P384():
+			return ECDSAP384, nil
+		}
+		return "", fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return Ed25519, nil
+	}
+	return "", fmt.Errorf("unsupported public key %T", cert.PublicKey)
+}
+
+// IsValid is true if the certificate can be used until the renewal window before it expires,
+// see Validate
+func (c *Certificate) IsValid(ca *Certificate, template *x509.Certificate, renewBefore time.Duration) bool {
+	return c.Validate(ca, template, renewBefore) == nil
+}
+
+// Validate returns why the certificate has to be replaced: it cannot be parsed, the key does not
+// belong to it, it is not valid yet or expires within renewBefore, it is not signed by the CA or
+// its subject and names differ from the template. The CA and the template are optional.
+func (c *Certificate) Validate(ca *Certificate, template *x509.Certificate, renewBefore time.Duration) error {
+	if err := c.CheckKey(); err != nil {
+		return err
+	}
+	cert, err := c.RawCert()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("the certificate is not valid before %s", cert.NotBefore.Format(time.RFC3339))
+	}
+	if !now.Add(renewBefore).Before(cert.NotAfter) {
+		return fmt.Errorf("the certificate expires at %s", cert.NotAfter.Format(time.RFC3339))
+	}
+	if ca != nil {
+		caCert, err := ca.RawCert()
+		if err != nil {
+			return fmt.Errorf("failed to get ca certificate: %s", err)
+		}
+		if err := cert.CheckSignatureFrom(caCert); err != nil {
+			return fmt.Errorf("the certificate is not issued by the CA %s: %s", caCert.Subject.CommonName, err)
+		}
+	}
+	if template != nil {
+		return matchTemplate(cert, template)
+	}
+	return nil
+}
+
+// CheckKey returns an error if the certificate or the key cannot be parsed or the key does not
+// belong to the certificate
+func (c *Certificate) CheckKey() error {
+	cert, err := c.RawCert()
+	if err != nil {
+		return err
+	}
+	key, err := c.RawKey()
+	if err != nil {
+		return err
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return fmt.Errorf("the private key does not belong to the certificate")
+	}
+	return nil
+}
+
+// matchTemplate compares the parts of the certificate which come from the spec
+func matchTemplate(cert, template *x509.Certificate) error {
+	if cert.Subject.CommonName != template.Subject.CommonName ||
+		!sameElements(cert.Subject.Organization, template.Subject.Organization) {
+		return fmt.Errorf("the subject is %s instead of %s", cert.Subject, template.Subject)
+	}
+	if cert.IsCA != template.IsCA {
+		return fmt.Errorf("the certificate is a CA: %t", cert.IsCA)
+	}
+	if !sameElements(cert.DNSNames, template.DNSNames) {
+		return fmt.Errorf("the DNS names are %v instead of %v", cert.DNSNames, template.DNSNames)
+	}
+	ips := func(addresses []net.IP) []string {
+		var result []string
+		for _, ip := range addresses {
+			result = append(result, ip.String())
+		}
+		return result
+	}
+	if !sameElements(ips(cert.IPAddresses), ips(template.IPAddresses)) {
+		return fmt.Errorf("the IP addresses are %v instead of %v", cert.IPAddresses, template.IPAddresses)
+	}
+	return nil
+}
+
+// sameElements is true if both lists hold the same strings in any order
+func sameElements(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(slices.Compact(a), slices.Compact(b))
+}
+
+// FromChain returns the CA of a PEM encoded key and chain, the first certificate of the chain is
+// the CA, the others are its issuers. The roots may be given separately, their keys are not needed.
+func FromChain(key, chain, roots []byte) (*Certificate, error) {
+	block, rest := pem.Decode(chain)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode certificate from PEM")
+	}
+	raw, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %s", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(raw.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %s", err)
+	}
+	var issuers []byte
+	for _, data := range [][]byte{rest, roots} {
+		for block, next := pem.Decode(data); block != nil; block, next = pem.Decode(next) {
+			issuers = append(issuers, pem.EncodeToMemory(block)...)
+		}
+	}
+	return &Certificate{
+		Key:   string(key),
+		Pub:   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		Cert:  string(pem.EncodeToMemory(block)),
+		Chain: string(issuers),
+	}, nil
+}
+
+// FromKeyPair reads a certificate followed by the chain of its issuers as it is served, e.g. the
+// tls.crt of a kubernetes.io/tls secret
+func FromKeyPair(key, cert []byte) (*Certificate, error) {
+	c, err := FromChain(key, cert, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.Cert, c.Chain = c.Cert+c.Chain, ""
+	return c, nil
+}
+
+// CheckCA returns an error if the certificate cannot sign other certificates: it is no CA, the key
+// does not belong to it, it is expired or it does not chain up to a root of its chain
+func (c *Certificate) CheckCA() error {
+	if err := c.CheckKey(); err != nil {
+		return err
+	}
+	cert, err := c.RawCert()
+	if err != nil {
+		return err
+	}
+	if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return fmt.Errorf("%s is no CA", cert.Subject.CommonName)
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	hasRoot := selfSigned(cert)
+	for block, rest := pem.Decode([]byte(c.Chain)); block != nil; block, rest = pem.Decode(rest) {
+		issuer, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse the chain of %s: %s", cert.Subject.CommonName, err)
+		}
+		if selfSigned(issuer) {
+			roots.AddCert(issuer)
+			hasRoot = true
+		} else {
+			intermediates.AddCert(issuer)
+		}
+	}
+	if selfSigned(cert) {
+		roots.AddCert(cert)
+	}
+	if !hasRoot {
+		// the root is not known, only the validity can be checked
+		if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			return fmt.Errorf("%s is not valid at %s", cert.Subject.CommonName, now.Format(time.RFC3339))
+		}
+		return nil
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("%s does not chain up to its root: %s", cert.Subject.CommonName, err)
+	}
+	return nil
+}
+
+// selfSigned is true for a root certificate
+func selfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// Serial is a random serial number of a certificate
+func Serial() *big.Int {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 62)
+	serial, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return big.NewInt(1)
+	}
+	return serial
+}
+
+// Create generates an RSA key and signs the certificate with the CA, the certificate is
+// self-signed if the CA is nil
+func Create(cert *x509.Certificate, ca *Certificate) (*Certificate, error) {
+	return CreateWithAlgorithm(cert, ca, RSA)
+}
+
+// CreateWithAlgorithm generates a key of the algorithm and signs the certificate with the CA, the
+// certificate is self-signed if the CA is nil
+func CreateWithAlgorithm(cert *x509.Certificate, ca *Certificate, algorithm KeyAlgorithm) (*Certificate, error) {
+	// create private key
+	privateKey, err := GenerateKey(algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %s", err)
+	}
+
+	// private PEM
+	block, err := privateKeyBlock(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	privateKeyPEM := new(bytes.Buffer)
+	pem.Encode(privateKeyPEM, block)
+
+	// public PEM
+	der, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %s", err)
+	}
+	publicKeyPEM := new(bytes.Buffer)
+	pem.Encode(publicKeyPEM, &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: der,
+	})
+
+	// certificate
+	caCert := cert
+	var caKey crypto.Signer = privateKey
+	if ca != nil {
+		caCert, err = ca.RawCert()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ca certificate: %s", err)
+		}
+		caKey, err = ca.RawKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ca private key: %s", err)
+		}
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, cert, caCert, privateKey.Public(), caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %s", err)
+	}
+	certPEM := new(bytes.Buffer)
+	pem.Encode(certPEM, &pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: certBytes,
+	})
+	// the certificates of an intermediate CA are presented with its chain
+	if ca != nil && !selfSigned(caCert) {
+		certPEM.WriteString(ca.Cert)
+		certPEM.WriteString(ca.Chain)
+	}
+
+	return &Certificate{
+		Key:  privateKeyPEM.String(),
+		Pub:  publicKeyPEM.String(),
+		Cert: certPEM.String(),
+	}, nil
+}
+
+// GenerateKey generates a private key of the algorithm
+func GenerateKey(algorithm KeyAlgorithm) (crypto.Signer, error) {
+	switch algorithm {
+	case RSA, "":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case ECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unsupported key algorithm %s", algorithm)
+}
+
+// privateKeyBlock encodes RSA keys as PKCS#1 like the keys of existing tenants, the others as
+// PKCS#8
+func privateKeyBlock(key crypto.Signer) (*pem.Block, error) {
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %s", err)
+	}
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
 }
@@ -0,0 +1,126 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificates_test
+
+import (
+	"crypto/x509"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"claio/internal/certificates"
+)
+
+var _ = Describe("Certificate", func() {
+	var ca *certificates.Certificate
+
+	BeforeEach(func() {
+		ca = mustCreate(caTemplate("kubernetes"), nil)
+	})
+
+	DescribeTable("validates a certificate against its CA and the template of the spec",
+		func(prepare func(cert, template *x509.Certificate), issuer func() *certificates.Certificate, mismatch string) {
+			cert, template := leafTemplate(), leafTemplate()
+			prepare(cert, template)
+			leaf := mustCreate(cert, ca)
+
+			err := leaf.Validate(issuer(), template, 24*time.Hour)
+			if mismatch == "" {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(leaf.IsValid(issuer(), template, 24*time.Hour)).To(BeTrue())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(mismatch)))
+				Expect(leaf.IsValid(issuer(), template, 24*time.Hour)).To(BeFalse())
+			}
+		},
+		Entry("a certificate of the spec is valid",
+			func(cert, template *x509.Certificate) {}, func() *certificates.Certificate { return ca }, ""),
+		Entry("the names may be listed in another order",
+			func(cert, template *x509.Certificate) {
+				template.DNSNames = []string{"sample.example.com", "kubernetes"}
+				template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(10, 96, 0, 1)}
+			}, func() *certificates.Certificate { return ca }, ""),
+		Entry("the CA is optional",
+			func(cert, template *x509.Certificate) {}, func() *certificates.Certificate { return nil }, ""),
+		Entry("an expired certificate is renewed",
+			func(cert, template *x509.Certificate) {
+				cert.NotBefore = time.Now().AddDate(-1, 0, 0)
+				cert.NotAfter = time.Now().Add(-time.Hour)
+			}, func() *certificates.Certificate { return ca }, "expires at"),
+		Entry("a certificate inside the renew window is renewed",
+			func(cert, template *x509.Certificate) {
+				cert.NotAfter = time.Now().Add(23 * time.Hour)
+			}, func() *certificates.Certificate { return ca }, "expires at"),
+		Entry("a certificate which is not valid yet is renewed",
+			func(cert, template *x509.Certificate) {
+				cert.NotBefore = time.Now().Add(time.Hour)
+			}, func() *certificates.Certificate { return ca }, "not valid before"),
+		Entry("a certificate of another CA is renewed",
+			func(cert, template *x509.Certificate) {}, func() *certificates.Certificate {
+				return mustCreate(caTemplate("kubernetes"), nil)
+			}, "not issued by the CA kubernetes"),
+		Entry("a changed DNS name is renewed",
+			func(cert, template *x509.Certificate) {
+				template.DNSNames = append(template.DNSNames, "api.example.com")
+			}, func() *certificates.Certificate { return ca }, "DNS names"),
+		Entry("a changed IP address is renewed",
+			func(cert, template *x509.Certificate) {
+				template.IPAddresses[1] = net.IPv4(192, 168, 1, 10)
+			}, func() *certificates.Certificate { return ca }, "IP addresses"),
+		Entry("a changed organization is renewed",
+			func(cert, template *x509.Certificate) {
+				template.Subject.Organization = nil
+			}, func() *certificates.Certificate { return ca }, "subject"),
+		Entry("a changed common name is renewed",
+			func(cert, template *x509.Certificate) {
+				template.Subject.CommonName = "front-proxy-client"
+			}, func() *certificates.Certificate { return ca }, "subject"),
+	)
+
+	It("Should renew a certificate which became a CA", func() {
+		leaf := mustCreate(leafTemplate(), ca)
+		template := leafTemplate()
+		template.IsCA = true
+		Expect(leaf.Validate(ca, template, 0)).To(MatchError(ContainSubstring("is a CA")))
+	})
+
+	Context("When the key is checked", func() {
+		It("Should accept the key of the certificate", func() {
+			leaf := mustCreate(leafTemplate(), ca)
+			Expect(leaf.CheckKey()).To(Succeed())
+		})
+
+		It("Should deny the key of another certificate", func() {
+			leaf := mustCreate(leafTemplate(), ca)
+			leaf.Key = mustCreate(leafTemplate(), ca).Key
+			Expect(leaf.CheckKey()).To(MatchError(ContainSubstring("does not belong to the certificate")))
+			Expect(leaf.Validate(ca, nil, 0)).To(MatchError(ContainSubstring("does not belong to the certificate")))
+			Expect(leaf.IsValid(ca, nil, 0)).To(BeFalse())
+		})
+
+		It("Should deny a key or a certificate which cannot be parsed", func() {
+			leaf := mustCreate(leafTemplate(), ca)
+			leaf.Key = "invalid"
+			Expect(leaf.CheckKey()).To(MatchError(ContainSubstring("failed to decode key")))
+			leaf = mustCreate(leafTemplate(), ca)
+			leaf.Cert = "invalid"
+			Expect(leaf.CheckKey()).To(MatchError(ContainSubstring("failed to decode certificate")))
+		})
+	})
+})
@@ -0,0 +1,73 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificates_test
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"claio/internal/certificates"
+)
+
+// These tests use Ginkgo (BDD-style Go testing framework). Refer to
+// http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
+
+func TestCertificates(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Certificates Suite")
+}
+
+// caTemplate is a CA valid from now for a year
+func caTemplate(name string) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber:          certificates.Serial(),
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+}
+
+// leafTemplate is a serving certificate valid from now for a year
+func leafTemplate() *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: certificates.Serial(),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		Subject:      pkix.Name{CommonName: "kube-apiserver", Organization: []string{"system:masters"}},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"kubernetes", "sample.example.com"},
+		IPAddresses:  []net.IP{net.IPv4(10, 96, 0, 1), net.IPv4(127, 0, 0, 1)},
+	}
+}
+
+// mustCreate signs the template with the CA or self-signed
+func mustCreate(template *x509.Certificate, ca *certificates.Certificate) *certificates.Certificate {
+	cert, err := certificates.Create(template, ca)
+	Expect(err).NotTo(HaveOccurred())
+	return cert
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificates_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"claio/internal/certificates"
+)
+
+var _ = Describe("Chain", func() {
+	var root, intermediate *certificates.Certificate
+
+	BeforeEach(func() {
+		root = mustCreate(caTemplate("root"), nil)
+		intermediate = mustCreate(caTemplate("intermediate"), root)
+	})
+
+	// the secret of a CA holds the key pair in tls.key and tls.crt, the root in ca.crt
+	DescribeTable("reads a CA from the secret of the spec",
+		func(secret func() (key, chain, roots string), issuers func() string, served int, invalid string) {
+			key, chain, roots := secret()
+			ca, err := certificates.FromChain([]byte(key), []byte(chain), []byte(roots))
+			Expect(err).NotTo(HaveOccurred())
+			if invalid != "" {
+				Expect(ca.CheckCA()).To(MatchError(ContainSubstring(invalid)))
+				return
+			}
+			Expect(ca.CheckCA()).To(Succeed())
+			Expect(ca.Chain).To(Equal(issuers()))
+			Expect(ca.CheckKey()).To(Succeed())
+
+			// the certificates an intermediate CA signs are served with its chain
+			leaf := mustCreate(leafTemplate(), ca)
+			Expect(leaf.Validate(ca, nil, 0)).To(Succeed())
+			Expect(strings.Count(leaf.Cert, "BEGIN CERTIFICATE")).To(Equal(served))
+		},
+		Entry("an own root CA",
+			func() (string, string, string) { return root.Key, root.Cert, "" },
+			func() string { return "" }, 1, ""),
+		Entry("an intermediate CA followed by its root",
+			func() (string, string, string) { return intermediate.Key, intermediate.Cert + root.Cert, "" },
+			func() string { return root.Cert }, 3, ""),
+		Entry("an intermediate CA with the root in ca.crt, the key of the root is not needed",
+			func() (string, string, string) { return intermediate.Key, intermediate.Cert, root.Cert },
+			func() string { return root.Cert }, 3, ""),
+		Entry("an intermediate CA of an unknown root",
+			func() (string, string, string) { return intermediate.Key, intermediate.Cert, "" },
+			func() string { return "" }, 2, ""),
+		Entry("an intermediate CA of another root",
+			func() (string, string, string) {
+				return intermediate.Key, intermediate.Cert, mustCreate(caTemplate("root"), nil).Cert
+			}, nil, 0, "does not chain up to its root"),
+		Entry("a key which does not belong to the certificate",
+			func() (string, string, string) { return root.Key, intermediate.Cert + root.Cert, "" },
+			nil, 0, "does not belong to the certificate"),
+		Entry("a certificate which is no CA",
+			func() (string, string, string) {
+				leaf := mustCreate(leafTemplate(), root)
+				return leaf.Key, leaf.Cert, root.Cert
+			}, nil, 0, "is no CA"),
+		Entry("a key which cannot be parsed",
+			func() (string, string, string) { return "invalid", root.Cert, "" },
+			nil, 0, "failed to decode key"),
+	)
+
+	It("Should deny an expired CA of an unknown root", func() {
+		template := caTemplate("intermediate")
+		template.NotBefore = time.Now().AddDate(-1, 0, 0)
+		template.NotAfter = time.Now().Add(-time.Hour)
+		expired := mustCreate(template, root)
+		ca, err := certificates.FromChain([]byte(expired.Key), []byte(expired.Cert), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ca.CheckCA()).To(MatchError(ContainSubstring("is not valid at")))
+	})
+
+	It("Should deny a chain which is no PEM", func() {
+		_, err := certificates.FromChain([]byte(root.Key), []byte("invalid"), nil)
+		Expect(err).To(MatchError(ContainSubstring("failed to decode certificate")))
+	})
+
+	It("Should read a served key pair with its chain", func() {
+		leaf := mustCreate(leafTemplate(), intermediate)
+		pair, err := certificates.FromKeyPair([]byte(leaf.Key), []byte(leaf.Cert+root.Cert))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pair.Cert).To(Equal(leaf.Cert + root.Cert))
+		Expect(pair.Chain).To(BeEmpty())
+		Expect(pair.CheckKey()).To(Succeed())
+		Expect(pair.Validate(intermediate, nil, 0)).To(Succeed())
+	})
+})
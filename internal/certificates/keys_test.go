@@ -0,0 +1,174 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificates_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"claio/internal/certificates"
+)
+
+// pemType is the type of the first PEM block
+func pemType(data string) string {
+	block, _ := pem.Decode([]byte(data))
+	Expect(block).NotTo(BeNil())
+	return block.Type
+}
+
+// equalKeys is true if the public key belongs to the private key
+func equalKeys(key crypto.Signer, pub crypto.PublicKey) bool {
+	return key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub)
+}
+
+var _ = Describe("Keys", func() {
+	DescribeTable("creates, encodes and parses the keys of an algorithm",
+		func(algorithm certificates.KeyAlgorithm, keyType string, key any, expected certificates.KeyAlgorithm) {
+			ca, err := certificates.CreateWithAlgorithm(caTemplate("kubernetes"), nil, algorithm)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pemType(ca.Key)).To(Equal(keyType))
+			Expect(pemType(ca.Pub)).To(Equal("PUBLIC KEY"))
+			Expect(pemType(ca.Cert)).To(Equal("CERTIFICATE"))
+
+			// the secret of the certificate is read back
+			cert, err := certificates.NewCertificateFromSecretData("ca", map[string][]byte{
+				"ca.key": []byte(ca.Key), "ca.pub": []byte(ca.Pub), "ca.crt": []byte(ca.Cert),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			signer, err := cert.RawKey()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(signer).To(BeAssignableToTypeOf(key))
+			pub, err := cert.RawPub()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(equalKeys(signer, pub)).To(BeTrue())
+			Expect(cert.CheckKey()).To(Succeed())
+			Expect(cert.CheckCA()).To(Succeed())
+			Expect(cert.KeyAlgorithm()).To(Equal(expected))
+
+			// it signs certificates with keys of other algorithms
+			for _, leafAlgorithm := range []certificates.KeyAlgorithm{certificates.RSA, certificates.ECDSAP256, certificates.Ed25519} {
+				leaf, err := certificates.CreateWithAlgorithm(leafTemplate(), cert, leafAlgorithm)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(leaf.Validate(cert, leafTemplate(), 0)).To(Succeed())
+				Expect(leaf.KeyAlgorithm()).To(Equal(leafAlgorithm))
+			}
+		},
+		Entry("RSA as PKCS#1", certificates.RSA, "RSA PRIVATE KEY", &rsa.PrivateKey{}, certificates.RSA),
+		Entry("RSA by default", certificates.KeyAlgorithm(""), "RSA PRIVATE KEY", &rsa.PrivateKey{}, certificates.RSA),
+		Entry("ECDSA P-256 as PKCS#8", certificates.ECDSAP256, "PRIVATE KEY", &ecdsa.PrivateKey{}, certificates.ECDSAP256),
+		Entry("ECDSA P-384 as PKCS#8", certificates.ECDSAP384, "PRIVATE KEY", &ecdsa.PrivateKey{}, certificates.ECDSAP384),
+		Entry("Ed25519 as PKCS#8", certificates.Ed25519, "PRIVATE KEY", ed25519.PrivateKey{}, certificates.Ed25519),
+	)
+
+	It("Should deny an unknown algorithm", func() {
+		_, err := certificates.GenerateKey("DSA")
+		Expect(err).To(MatchError(ContainSubstring("unsupported key algorithm DSA")))
+		_, err = certificates.CreateWithAlgorithm(leafTemplate(), nil, "DSA")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should create RSA keys with Create", func() {
+		cert := mustCreate(caTemplate("kubernetes"), nil)
+		Expect(cert.KeyAlgorithm()).To(Equal(certificates.RSA))
+		key, err := cert.RawKey()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key.(*rsa.PrivateKey).N.BitLen()).To(Equal(2048))
+	})
+
+	// the secrets of existing tenants, cert-manager and other tools encode keys differently
+	DescribeTable("reads keys written by others",
+		func(encode func() (key crypto.Signer, keyBlock, pubBlock *pem.Block), expected certificates.KeyAlgorithm) {
+			key, keyBlock, pubBlock := encode()
+			der, err := x509.CreateCertificate(rand.Reader, caTemplate("kubernetes"), caTemplate("kubernetes"), key.Public(), key)
+			Expect(err).NotTo(HaveOccurred())
+			cert := &certificates.Certificate{
+				Key:  string(pem.EncodeToMemory(keyBlock)),
+				Pub:  string(pem.EncodeToMemory(pubBlock)),
+				Cert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+			}
+			Expect(cert.CheckKey()).To(Succeed())
+			signer, err := cert.RawKey()
+			Expect(err).NotTo(HaveOccurred())
+			pub, err := cert.RawPub()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(equalKeys(signer, pub)).To(BeTrue())
+			Expect(equalKeys(key, pub)).To(BeTrue())
+			Expect(cert.KeyAlgorithm()).To(Equal(expected))
+			Expect(mustCreate(leafTemplate(), cert).Validate(cert, nil, 0)).To(Succeed())
+		},
+		Entry("a PKCS#1 RSA key with a PKIX public key as the manager wrote them before key algorithms",
+			func() (crypto.Signer, *pem.Block, *pem.Block) {
+				key, err := rsa.GenerateKey(rand.Reader, 2048)
+				Expect(err).NotTo(HaveOccurred())
+				der, err := x509.MarshalPKIXPublicKey(key.Public())
+				Expect(err).NotTo(HaveOccurred())
+				return key, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+					&pem.Block{Type: "PUBLIC KEY", Bytes: der}
+			}, certificates.RSA),
+		Entry("a PKCS#1 RSA key with a PKCS#1 public key",
+			func() (crypto.Signer, *pem.Block, *pem.Block) {
+				key, err := rsa.GenerateKey(rand.Reader, 2048)
+				Expect(err).NotTo(HaveOccurred())
+				return key, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+					&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)}
+			}, certificates.RSA),
+		Entry("a PKCS#8 RSA key",
+			func() (crypto.Signer, *pem.Block, *pem.Block) {
+				key, err := rsa.GenerateKey(rand.Reader, 2048)
+				Expect(err).NotTo(HaveOccurred())
+				der, err := x509.MarshalPKCS8PrivateKey(key)
+				Expect(err).NotTo(HaveOccurred())
+				pub, err := x509.MarshalPKIXPublicKey(key.Public())
+				Expect(err).NotTo(HaveOccurred())
+				return key, &pem.Block{Type: "PRIVATE KEY", Bytes: der}, &pem.Block{Type: "PUBLIC KEY", Bytes: pub}
+			}, certificates.RSA),
+		Entry("a SEC1 ECDSA key",
+			func() (crypto.Signer, *pem.Block, *pem.Block) {
+				key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+				Expect(err).NotTo(HaveOccurred())
+				der, err := x509.MarshalECPrivateKey(key)
+				Expect(err).NotTo(HaveOccurred())
+				pub, err := x509.MarshalPKIXPublicKey(key.Public())
+				Expect(err).NotTo(HaveOccurred())
+				return key, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, &pem.Block{Type: "PUBLIC KEY", Bytes: pub}
+			}, certificates.ECDSAP384),
+	)
+
+	DescribeTable("denies keys it cannot use",
+		func(key, pub, message string) {
+			cert := &certificates.Certificate{Key: key, Pub: pub}
+			_, keyErr := cert.RawKey()
+			_, pubErr := cert.RawPub()
+			Expect([]error{keyErr, pubErr}).To(ContainElement(MatchError(ContainSubstring(message))))
+		},
+		Entry("a key of an unknown type",
+			string(pem.EncodeToMemory(&pem.Block{Type: "DSA PRIVATE KEY", Bytes: []byte{1}})), "", "unsupported private key type"),
+		Entry("a public key of an unknown type",
+			"", string(pem.EncodeToMemory(&pem.Block{Type: "DSA PUBLIC KEY", Bytes: []byte{1}})), "unsupported public-key type"),
+		Entry("a key which cannot be parsed",
+			string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{1}})), "", "failed to parse private key"),
+	)
+})
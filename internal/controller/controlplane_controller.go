@@ -19,33 +19,56 @@ package controller
 import (
 	"context"
 	"reflect"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
-	claiov1alpha1 "claio/api/v1alpha1"
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/kubernetes"
 	"claio/internal/resources/controlplanes"
 
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // ControlPlaneReconciler reconciles a ControlPlane object
 type ControlPlaneReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// JobImage runs the migrate command of the manager when the datastore changes
+	JobImage *controlplanes.JobImage
+	// Gateway is the kine gateway of the gateway driver, nil if the manager has none
+	Gateway *controlplanes.Gateway
+	// Recorder records the events of the datastore quota and the CA rotation
+	Recorder record.EventRecorder
+	// CARotationGracePeriod is how long the old CA is trusted after a rotation reissued the
+	// certificates
+	CARotationGracePeriod time.Duration
 }
 
 // +kubebuilder:rbac:groups=claio.github.com,resources=controlplanes,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=claio.github.com,resources=controlplanes/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=claio.github.com,resources=controlplanes/finalizers,verbs=update
+// +kubebuilder:rbac:groups=claio.github.com,resources=datastores,verbs=get;list;watch
+// +kubebuilder:rbac:groups=claio.github.com,resources=machines,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups="batch",resources=jobs,verbs=get;list;watch;create;delete
 // +kubebuilder:rbac:groups="apps",resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=cert-manager.io,resources=issuers;certificates,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -64,40 +87,132 @@ func (r *ControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	if controlPlane == nil {
 		return ctrl.Result{}, nil
 	}
+	controlPlane.JobImage = r.JobImage
+	controlPlane.Gateway = r.Gateway
+	controlPlane.Recorder = r.Recorder
+	controlPlane.CARotationGracePeriod = r.CARotationGracePeriod
 	controlPlane.LogHeader("--- Reconciling --------------------------------------")
-	err = controlPlane.Reconcile()
+	result, err := controlPlane.Reconcile()
 	controlPlane.LogHeader("--- Reconciling Done ---------------------------------")
-	return ctrl.Result{}, err
+	return result, err
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ControlPlaneReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&claiov1alpha1.ControlPlane{}).
+		For(&claiov1beta1.ControlPlane{}).
 		Owns(&corev1.Secret{}).
 		Owns(&corev1.Service{}).
 		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.PersistentVolumeClaim{}).
+		Owns(&batchv1.Job{}).
+		Watches(&claiov1beta1.DataStore{}, handler.EnqueueRequestsFromMapFunc(r.controlPlanesOfDataStore)).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.controlPlanesOfSecret)).
 		WithOptions(controller.Options{
 			MaxConcurrentReconciles: 1,
 		}).
 		WithEventFilter(predicate.Funcs{
 			CreateFunc: func(e event.CreateEvent) bool {
-				return reflect.TypeOf(e.Object) == reflect.TypeOf(&claiov1alpha1.ControlPlane{})
+				// a DataStore, an own CA or a certificate of cert-manager created after its tenants
+				// lets them continue
+				if secret, ok := e.Object.(*corev1.Secret); ok {
+					return !controlledByControlPlane(secret)
+				}
+				return reflect.TypeOf(e.Object) == reflect.TypeOf(&claiov1beta1.ControlPlane{}) ||
+					reflect.TypeOf(e.Object) == reflect.TypeOf(&claiov1beta1.DataStore{})
 			},
 			UpdateFunc: func(e event.UpdateEvent) bool {
-				if reflect.TypeOf(e.ObjectNew) == reflect.TypeOf(&claiov1alpha1.ControlPlane{}) {
+				if reflect.TypeOf(e.ObjectNew) == reflect.TypeOf(&claiov1beta1.ControlPlane{}) ||
+					reflect.TypeOf(e.ObjectNew) == reflect.TypeOf(&claiov1beta1.DataStore{}) {
 					if e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration() {
 						return true
 					}
 				}
+				// a ControlPlaneRestore pauses and resumes the control-plane with an annotation, another
+				// one starts a rotation of the CA
+				if _, ok := e.ObjectNew.(*claiov1beta1.ControlPlane); ok {
+					for _, annotation := range []string{claiov1beta1.RestoreAnnotation, claiov1beta1.CARotationAnnotation} {
+						if e.ObjectOld.GetAnnotations()[annotation] != e.ObjectNew.GetAnnotations()[annotation] {
+							return true
+						}
+					}
+					return false
+				}
+				// availability changes of the deployment are reflected in the status conditions
+				if oldDeployment, ok := e.ObjectOld.(*appsv1.Deployment); ok {
+					newDeployment := e.ObjectNew.(*appsv1.Deployment)
+					return oldDeployment.Status.AvailableReplicas != newDeployment.Status.AvailableReplicas
+				}
+				// a changed own CA or a renewed certificate of cert-manager is taken over, the secrets
+				// of the manager are owned by their tenant
+				if oldSecret, ok := e.ObjectOld.(*corev1.Secret); ok {
+					newSecret := e.ObjectNew.(*corev1.Secret)
+					return !controlledByControlPlane(newSecret) && !reflect.DeepEqual(oldSecret.Data, newSecret.Data)
+				}
+				// a finished migration job switches the datastore
+				if oldJob, ok := e.ObjectOld.(*batchv1.Job); ok {
+					oldFinished, _ := kubernetes.JobFinished(oldJob)
+					newFinished, _ := kubernetes.JobFinished(e.ObjectNew.(*batchv1.Job))
+					return oldFinished != newFinished
+				}
 				return false
 			},
 			DeleteFunc: func(e event.DeleteEvent) bool {
 				isSecret := reflect.TypeOf(e.Object) == reflect.TypeOf(&corev1.Secret{})
 				isService := reflect.TypeOf(e.Object) == reflect.TypeOf(&corev1.Service{})
 				isDeployment := reflect.TypeOf(e.Object) == reflect.TypeOf(&appsv1.Deployment{})
-				return isSecret || isDeployment || isService
+				isClaim := reflect.TypeOf(e.Object) == reflect.TypeOf(&corev1.PersistentVolumeClaim{})
+				// deleting a failed migration job retries the migration
+				isJob := reflect.TypeOf(e.Object) == reflect.TypeOf(&batchv1.Job{})
+				return isSecret || isDeployment || isService || isClaim || isJob
 			},
 		}).
 		Complete(r)
 }
+
+// controlPlanesOfDataStore maps a DataStore to the ControlPlanes referencing it
+func (r *ControlPlaneReconciler) controlPlanesOfDataStore(ctx context.Context, obj client.Object) []reconcile.Request {
+	controlPlaneList := &claiov1beta1.ControlPlaneList{}
+	if err := r.List(ctx, controlPlaneList); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, controlPlane := range controlPlaneList.Items {
+		if controlPlane.Spec.Datastore.DataStoreName == obj.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: controlPlane.Name, Namespace: controlPlane.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+// controlPlanesOfSecret are the control-planes which take a CA from the secret or whose
+// certificate cert-manager issued into it
+func (r *ControlPlaneReconciler) controlPlanesOfSecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	if name, ok := obj.GetLabels()[controlplanes.CertManagerLabel]; ok {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: obj.GetNamespace()}}}
+	}
+	controlPlaneList := &claiov1beta1.ControlPlaneList{}
+	if err := r.List(ctx, controlPlaneList, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, controlPlane := range controlPlaneList.Items {
+		for _, source := range []*claiov1beta1.CASource{controlPlane.Spec.Certificates.CA, controlPlane.Spec.Certificates.FrontProxyCA} {
+			if source != nil && source.SecretRef.Name == obj.GetName() {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: controlPlane.Name, Namespace: controlPlane.Namespace},
+				})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// controlledByControlPlane is true for the objects the manager creates for a tenant
+func controlledByControlPlane(obj client.Object) bool {
+	owner := metav1.GetControllerOf(obj)
+	return owner != nil && owner.Kind == "ControlPlane"
+}
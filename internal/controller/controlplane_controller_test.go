@@ -27,7 +27,7 @@ import (
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
-	claiov1alpha1 "claio/api/v1alpha1"
+	claiov1beta1 "claio/api/v1beta1"
 )
 
 var _ = Describe("ControlPlane Controller", func() {
@@ -40,13 +40,13 @@ var _ = Describe("ControlPlane Controller", func() {
 			Name:      resourceName,
 			Namespace: "default", // TODO(user):Modify as needed
 		}
-		controlplane := &claiov1alpha1.ControlPlane{}
+		controlplane := &claiov1beta1.ControlPlane{}
 
 		BeforeEach(func() {
 			By("creating the custom resource for the Kind ControlPlane")
 			err := k8sClient.Get(ctx, typeNamespacedName, controlplane)
 			if err != nil && errors.IsNotFound(err) {
-				resource := &claiov1alpha1.ControlPlane{
+				resource := &claiov1beta1.ControlPlane{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:      resourceName,
 						Namespace: "default",
@@ -59,7 +59,7 @@ var _ = Describe("ControlPlane Controller", func() {
 
 		AfterEach(func() {
 			// TODO(user): Cleanup logic after each test, like removing the resource instance.
-			resource := &claiov1alpha1.ControlPlane{}
+			resource := &claiov1beta1.ControlPlane{}
 			err := k8sClient.Get(ctx, typeNamespacedName, resource)
 			Expect(err).NotTo(HaveOccurred())
 
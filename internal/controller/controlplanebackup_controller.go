@@ -0,0 +1,72 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/resources/backups"
+	"claio/internal/resources/controlplanes"
+)
+
+// ControlPlaneBackupReconciler reconciles a ControlPlaneBackup object
+type ControlPlaneBackupReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// JobImage runs the backup command of the manager
+	JobImage *controlplanes.JobImage
+}
+
+// +kubebuilder:rbac:groups=claio.github.com,resources=controlplanebackups,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=claio.github.com,resources=controlplanebackups/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=claio.github.com,resources=controlplanes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="batch",resources=jobs,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// Reconcile runs the job which writes the archive of the tenant and records its result.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.18.2/pkg/reconcile
+func (r *ControlPlaneBackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	backup, err := backups.NewBackup(ctx, req, r.Client, r.Scheme)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if backup == nil {
+		return ctrl.Result{}, nil
+	}
+	backup.JobImage = r.JobImage
+	backup.LogHeader("--- Reconciling %s -----------------------------------", req.Name)
+	result, err := backup.Reconcile()
+	backup.LogHeader("--- Reconciling %s Done ------------------------------", req.Name)
+	return result, err
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ControlPlaneBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&claiov1beta1.ControlPlaneBackup{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/resources/controlplanes"
+)
+
+var _ = Describe("ControlPlaneBackup Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "test-backup"
+		const controlPlaneName = "test-backup-source"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{Name: resourceName, Namespace: "default"}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind ControlPlaneBackup")
+			err := k8sClient.Get(ctx, typeNamespacedName, &claiov1beta1.ControlPlaneBackup{})
+			if err != nil && errors.IsNotFound(err) {
+				resource := &claiov1beta1.ControlPlaneBackup{
+					ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+					Spec: claiov1beta1.ControlPlaneBackupSpec{
+						ControlPlaneName: controlPlaneName,
+						Target: claiov1beta1.BackupTarget{
+							Volume: &claiov1beta1.BackupVolumeTarget{ClaimName: "archives", Path: "tenants"},
+						},
+					},
+				}
+				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			}
+		})
+
+		It("should start the backup job once the control-plane exists", func() {
+			controllerReconciler := &ControlPlaneBackupReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				JobImage: &controlplanes.JobImage{Image: "claio:test", Command: []string{"/manager"}},
+			}
+
+			By("Reconciling without control-plane")
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			backup := &claiov1beta1.ControlPlaneBackup{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, backup)).To(Succeed())
+			Expect(backup.Status.Phase).To(Equal(claiov1beta1.BackupPhasePending))
+
+			By("Reconciling with control-plane")
+			controlPlane := &claiov1beta1.ControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: controlPlaneName, Namespace: "default"},
+				Spec: claiov1beta1.ControlPlaneSpec{
+					Name:      "source",
+					Datastore: claiov1beta1.DatastoreSpec{Driver: claiov1beta1.DatastoreDriverSQLite},
+				},
+			}
+			Expect(k8sClient.Create(ctx, controlPlane)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, backup)).To(Succeed())
+			Expect(backup.Status.Phase).To(Equal(claiov1beta1.BackupPhaseRunning))
+			Expect(backup.Status.Archive).To(Equal("tenants/" + resourceName + ".tar.gz"))
+
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "backup-" + resourceName, Namespace: "default"}, job)).To(Succeed())
+			pod := job.Spec.Template.Spec
+			Expect(pod.InitContainers).To(HaveLen(1))
+			Expect(pod.InitContainers[0].Name).To(Equal("kine"))
+			Expect(pod.Containers[0].Command).To(Equal([]string{"/manager"}))
+			Expect(pod.Containers[0].Args).To(ContainElements("backup", "--prefix=/tenant-source",
+				"--file=/archives/tenants/"+resourceName+".tar.gz"))
+
+			Expect(k8sClient.Delete(ctx, backup)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, controlPlane)).To(Succeed())
+		})
+	})
+})
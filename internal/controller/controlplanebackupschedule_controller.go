@@ -0,0 +1,78 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/resources/backups"
+)
+
+// ControlPlaneBackupScheduleReconciler reconciles a ControlPlaneBackupSchedule object
+type ControlPlaneBackupScheduleReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=claio.github.com,resources=controlplanebackupschedules,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=claio.github.com,resources=controlplanebackupschedules/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=claio.github.com,resources=controlplanebackups,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=claio.github.com,resources=controlplanes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=claio.github.com,resources=controlplanes/status,verbs=get;update;patch
+
+// Reconcile creates the backups of the schedule and prunes them by its retention rules.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.18.2/pkg/reconcile
+func (r *ControlPlaneBackupScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	schedule, err := backups.NewSchedule(ctx, req, r.Client, r.Scheme)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if schedule == nil {
+		return ctrl.Result{}, nil
+	}
+	schedule.LogHeader("--- Reconciling %s -----------------------------------", req.Name)
+	result, err := schedule.Reconcile()
+	schedule.LogHeader("--- Reconciling %s Done ------------------------------", req.Name)
+	return result, err
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ControlPlaneBackupScheduleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&claiov1beta1.ControlPlaneBackupSchedule{}).
+		Watches(&claiov1beta1.ControlPlaneBackup{}, handler.EnqueueRequestsFromMapFunc(r.scheduleOfBackup)).
+		Complete(r)
+}
+
+// scheduleOfBackup maps a ControlPlaneBackup to the schedule which has created it
+func (r *ControlPlaneBackupScheduleReconciler) scheduleOfBackup(ctx context.Context, obj client.Object) []reconcile.Request {
+	name := obj.GetLabels()[claiov1beta1.BackupScheduleLabel]
+	if name == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: obj.GetNamespace()}}}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	claiov1beta1 "claio/api/v1beta1"
+)
+
+var _ = Describe("ControlPlaneBackupSchedule Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "test-schedule"
+		const controlPlaneName = "test-schedule-source"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{Name: resourceName, Namespace: "default"}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind ControlPlaneBackupSchedule")
+			err := k8sClient.Get(ctx, typeNamespacedName, &claiov1beta1.ControlPlaneBackupSchedule{})
+			if err != nil && errors.IsNotFound(err) {
+				resource := &claiov1beta1.ControlPlaneBackupSchedule{
+					ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+					Spec: claiov1beta1.ControlPlaneBackupScheduleSpec{
+						ControlPlaneName: controlPlaneName,
+						Schedule:         "* * * * *",
+						Target: claiov1beta1.BackupTarget{
+							Volume: &claiov1beta1.BackupVolumeTarget{ClaimName: "archives"},
+						},
+						Retention: claiov1beta1.BackupRetention{KeepLast: 1},
+					},
+				}
+				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			}
+		})
+
+		It("should create due backups and prune them", func() {
+			controllerReconciler := &ControlPlaneBackupScheduleReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			scheduleBackups := func() []claiov1beta1.ControlPlaneBackup {
+				backupList := &claiov1beta1.ControlPlaneBackupList{}
+				Expect(k8sClient.List(ctx, backupList, client.InNamespace("default"),
+					client.MatchingLabels{claiov1beta1.BackupScheduleLabel: resourceName})).To(Succeed())
+				return backupList.Items
+			}
+			complete := func(backup *claiov1beta1.ControlPlaneBackup) {
+				backup.Status.Phase = claiov1beta1.BackupPhaseCompleted
+				Expect(k8sClient.Status().Update(ctx, backup)).To(Succeed())
+			}
+
+			controlPlane := &claiov1beta1.ControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: controlPlaneName, Namespace: "default"},
+				Spec:       claiov1beta1.ControlPlaneSpec{Name: "scheduled"},
+			}
+			Expect(k8sClient.Create(ctx, controlPlane)).To(Succeed())
+
+			By("Reconciling a due schedule")
+			schedule := &claiov1beta1.ControlPlaneBackupSchedule{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, schedule)).To(Succeed())
+			schedule.Status.LastScheduleTime = &metav1.Time{Time: time.Now().Add(-2 * time.Minute)}
+			Expect(k8sClient.Status().Update(ctx, schedule)).To(Succeed())
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			items := scheduleBackups()
+			Expect(items).To(HaveLen(1))
+			Expect(items[0].Spec.ControlPlaneName).To(Equal(controlPlaneName))
+			Expect(items[0].Spec.DeletionPolicy).To(Equal(claiov1beta1.DeletionPolicyDelete))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, schedule)).To(Succeed())
+			Expect(schedule.Status.LastBackup).To(Equal(items[0].Name))
+			Expect(schedule.Status.NextScheduleTime).NotTo(BeNil())
+
+			By("Reconciling with two completed backups")
+			complete(&items[0])
+			older := &claiov1beta1.ControlPlaneBackup{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName + "-202601010000",
+					Namespace: "default",
+					Labels:    map[string]string{claiov1beta1.BackupScheduleLabel: resourceName},
+				},
+				Spec: items[0].Spec,
+			}
+			Expect(k8sClient.Create(ctx, older)).To(Succeed())
+			complete(older)
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(scheduleBackups()).To(HaveLen(1))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, schedule)).To(Succeed())
+			Expect(schedule.Status.Completed).To(BeEquivalentTo(1))
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: controlPlaneName, Namespace: "default"}, controlPlane)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(controlPlane.Status.Conditions, claiov1beta1.ConditionBackupSucceeded)).To(BeTrue())
+
+			items = scheduleBackups()
+			for i := range items {
+				Expect(k8sClient.Delete(ctx, &items[i])).To(Succeed())
+			}
+			Expect(k8sClient.Delete(ctx, schedule)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, controlPlane)).To(Succeed())
+		})
+	})
+})
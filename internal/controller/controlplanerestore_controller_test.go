@@ -0,0 +1,132 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/resources/controlplanes"
+)
+
+var _ = Describe("ControlPlaneRestore Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "test-restore"
+		const backupName = "test-restore-backup"
+		const controlPlaneName = "test-restore-target"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{Name: resourceName, Namespace: "default"}
+		controlPlaneNamespacedName := types.NamespacedName{Name: controlPlaneName, Namespace: "default"}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind ControlPlaneRestore")
+			err := k8sClient.Get(ctx, typeNamespacedName, &claiov1beta1.ControlPlaneRestore{})
+			if err != nil && errors.IsNotFound(err) {
+				resource := &claiov1beta1.ControlPlaneRestore{
+					ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+					Spec: claiov1beta1.ControlPlaneRestoreSpec{
+						ControlPlaneName: controlPlaneName,
+						ControlPlane: &claiov1beta1.ControlPlaneSpec{
+							Name:      "target",
+							Datastore: claiov1beta1.DatastoreSpec{Driver: claiov1beta1.DatastoreDriverSQLite},
+						},
+						Source: claiov1beta1.RestoreSource{BackupName: backupName},
+					},
+				}
+				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			}
+		})
+
+		It("should pause the control-plane while the keys are restored", func() {
+			controllerReconciler := &ControlPlaneRestoreReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				JobImage: &controlplanes.JobImage{Image: "claio:test"},
+			}
+			reconcileRestore := func() *claiov1beta1.ControlPlaneRestore {
+				_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+				Expect(err).NotTo(HaveOccurred())
+				restore := &claiov1beta1.ControlPlaneRestore{}
+				Expect(k8sClient.Get(ctx, typeNamespacedName, restore)).To(Succeed())
+				return restore
+			}
+
+			By("Reconciling without backup")
+			restore := reconcileRestore()
+			Expect(restore.Status.Phase).To(Equal(claiov1beta1.BackupPhasePending))
+			Expect(restore.Status.Message).To(ContainSubstring("does not exist"))
+
+			By("Reconciling with a completed backup")
+			backup := &claiov1beta1.ControlPlaneBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: backupName, Namespace: "default"},
+				Spec: claiov1beta1.ControlPlaneBackupSpec{
+					ControlPlaneName: "source",
+					Target: claiov1beta1.BackupTarget{
+						Volume: &claiov1beta1.BackupVolumeTarget{ClaimName: "archives"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, backup)).To(Succeed())
+			backup.Status.Phase = claiov1beta1.BackupPhaseCompleted
+			backup.Status.Archive = backupName + ".tar.gz"
+			backup.Status.Checksum = "sha256:0123"
+			Expect(k8sClient.Status().Update(ctx, backup)).To(Succeed())
+
+			restore = reconcileRestore()
+			Expect(restore.Status.Message).To(ContainSubstring("created"))
+			controlPlane := &claiov1beta1.ControlPlane{}
+			Expect(k8sClient.Get(ctx, controlPlaneNamespacedName, controlPlane)).To(Succeed())
+			Expect(controlPlane.Annotations).To(HaveKeyWithValue(claiov1beta1.RestoreAnnotation, resourceName))
+
+			By("Reconciling once the datastore of the control-plane is ready")
+			meta.SetStatusCondition(&controlPlane.Status.Conditions, metav1.Condition{
+				Type:   claiov1beta1.ConditionDatastoreReady,
+				Status: metav1.ConditionTrue,
+				Reason: "Available",
+			})
+			Expect(k8sClient.Status().Update(ctx, controlPlane)).To(Succeed())
+			restore = reconcileRestore()
+			Expect(restore.Status.Phase).To(Equal(claiov1beta1.BackupPhaseRunning))
+
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "restore-" + resourceName, Namespace: "default"}, job)).To(Succeed())
+			Expect(job.Spec.Template.Spec.Containers[0].Args).To(ContainElements("restore", "--prefix=/tenant-target",
+				"--file=/archives/"+backupName+".tar.gz", "--checksum=sha256:0123"))
+
+			By("Deleting the resource")
+			Expect(k8sClient.Delete(ctx, restore)).To(Succeed())
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, controlPlaneNamespacedName, controlPlane)).To(Succeed())
+			Expect(controlPlane.Annotations).NotTo(HaveKey(claiov1beta1.RestoreAnnotation))
+
+			Expect(k8sClient.Delete(ctx, backup)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, controlPlane)).To(Succeed())
+		})
+	})
+})
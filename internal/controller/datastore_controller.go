@@ -0,0 +1,79 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/resources/datastores"
+)
+
+// DataStoreReconciler reconciles a DataStore object
+type DataStoreReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=claio.github.com,resources=datastores,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=claio.github.com,resources=datastores/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=claio.github.com,resources=controlplanes,verbs=get;list;watch
+
+// Reconcile keeps the tenants placed on a DataStore and the reachability of its endpoint in
+// the status.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.18.2/pkg/reconcile
+func (r *DataStoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	dataStore, err := datastores.NewDataStore(ctx, req, r.Client, r.Scheme)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if dataStore == nil {
+		return ctrl.Result{}, nil
+	}
+	dataStore.LogHeader("--- Reconciling %s -----------------------------------", req.Name)
+	result, err := dataStore.Reconcile()
+	dataStore.LogHeader("--- Reconciling %s Done ------------------------------", req.Name)
+	return result, err
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DataStoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&claiov1beta1.DataStore{}).
+		Watches(&claiov1beta1.ControlPlane{}, handler.EnqueueRequestsFromMapFunc(r.dataStoreOfControlPlane)).
+		Complete(r)
+}
+
+// dataStoreOfControlPlane maps a ControlPlane to the DataStore it is placed on
+func (r *DataStoreReconciler) dataStoreOfControlPlane(_ context.Context, obj client.Object) []reconcile.Request {
+	controlPlane, ok := obj.(*claiov1beta1.ControlPlane)
+	if !ok || controlPlane.Spec.Datastore.DataStoreName == "" {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Name: controlPlane.Spec.Datastore.DataStoreName},
+	}}
+}
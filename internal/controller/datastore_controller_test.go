@@ -0,0 +1,83 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	claiov1beta1 "claio/api/v1beta1"
+)
+
+var _ = Describe("DataStore Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "test-datastore"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{Name: resourceName}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind DataStore")
+			err := k8sClient.Get(ctx, typeNamespacedName, &claiov1beta1.DataStore{})
+			if err != nil && errors.IsNotFound(err) {
+				resource := &claiov1beta1.DataStore{
+					ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+					Spec: claiov1beta1.DataStoreSpec{
+						Driver: claiov1beta1.DatastoreDriverPostgres,
+						// nothing listens there
+						Endpoint: "postgres://127.0.0.1:1",
+					},
+				}
+				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			resource := &claiov1beta1.DataStore{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+
+			By("Cleanup the specific resource instance DataStore")
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+		})
+
+		It("should report an unreachable endpoint and no tenants", func() {
+			controllerReconciler := &DataStoreReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).NotTo(BeZero())
+
+			resource := &claiov1beta1.DataStore{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Tenants).To(BeEmpty())
+			Expect(meta.IsStatusConditionFalse(resource.Status.Conditions, claiov1beta1.DataStoreConditionReachable)).To(BeTrue())
+		})
+	})
+})
@@ -20,43 +20,77 @@ import (
 	"context"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	claiov1alpha1 "claio/api/v1alpha1"
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/providers"
+	"claio/internal/resources/machines"
 )
 
 // MachineReconciler reconciles a Machine object
 type MachineReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Providers are the machine providers Machines can select with spec.providerRef
+	Providers *providers.Registry
+	// TenantClient overrides how tenants are reached, nil connects with the admin kubeconfig
+	TenantClient machines.TenantClientFunc
 }
 
 // +kubebuilder:rbac:groups=claio.github.com,resources=machines,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=claio.github.com,resources=machines/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=claio.github.com,resources=machines/finalizers,verbs=update
+// +kubebuilder:rbac:groups=claio.github.com,resources=controlplanes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Machine object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// Reconcile drives a Machine through its phases (Pending, Provisioning, Joining, Running
+// and Deleting) and keeps the matching node of the tenant cluster in its status.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.18.2/pkg/reconcile
 func (r *MachineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
-
-	// TODO(user): your logic here
-
-	return ctrl.Result{}, nil
+	machine, err := machines.NewMachine(ctx, req, r.Client, r.Scheme, r.Providers)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if machine == nil {
+		return ctrl.Result{}, nil
+	}
+	machine.TenantClient = r.TenantClient
+	machine.LogHeader("--- Reconciling %s -----------------------------------", req.Name)
+	result, err := machine.Reconcile()
+	machine.LogHeader("--- Reconciling %s Done ------------------------------", req.Name)
+	return result, err
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *MachineReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&claiov1alpha1.Machine{}).
+		Watches(&claiov1beta1.ControlPlane{}, handler.EnqueueRequestsFromMapFunc(r.machinesOfControlPlane)).
 		Complete(r)
 }
+
+// machinesOfControlPlane maps a ControlPlane to the Machines referencing it
+func (r *MachineReconciler) machinesOfControlPlane(ctx context.Context, obj client.Object) []reconcile.Request {
+	machineList := &claiov1alpha1.MachineList{}
+	if err := r.List(ctx, machineList, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, machine := range machineList.Items {
+		if machine.Spec.ControlPlaneRef.Name == obj.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: machine.Name, Namespace: machine.Namespace},
+			})
+		}
+	}
+	return requests
+}
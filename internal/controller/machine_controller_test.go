@@ -18,16 +18,24 @@ package controller
 
 import (
 	"context"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	claiov1alpha1 "claio/api/v1alpha1"
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/providers"
+	"claio/internal/providers/fake"
+	"claio/internal/resources/controlplanes"
 )
 
 var _ = Describe("Machine Controller", func() {
@@ -51,7 +59,9 @@ var _ = Describe("Machine Controller", func() {
 						Name:      resourceName,
 						Namespace: "default",
 					},
-					// TODO(user): Specify other spec details if needed.
+					Spec: claiov1alpha1.MachineSpec{
+						ControlPlaneRef: claiov1alpha1.ControlPlaneReference{Name: "missing-controlplane"},
+					},
 				}
 				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
 			}
@@ -73,12 +83,117 @@ var _ = Describe("Machine Controller", func() {
 				Scheme: k8sClient.Scheme(),
 			}
 
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).NotTo(BeZero())
+
+			By("Waiting for the referenced control-plane")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, machine)).To(Succeed())
+			Expect(machine.Status.Phase).To(Equal(claiov1alpha1.MachinePhasePending))
+		})
+	})
+
+	Context("When reconciling a resource with a provider", func() {
+		const resourceName = "test-provider-machine"
+		const controlPlaneName = "test-provider-controlplane"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+		fakeProvider := fake.New()
+		registry := providers.NewRegistry()
+
+		BeforeEach(func() {
+			if _, err := registry.Get(fake.Name); err != nil {
+				Expect(registry.Register(fakeProvider)).To(Succeed())
+			}
+
+			By("creating a ready control-plane")
+			controlPlane := &claiov1beta1.ControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: controlPlaneName, Namespace: "default"},
+				Spec: claiov1beta1.ControlPlaneSpec{
+					Name:     "provider",
+					Version:  "1.31.1",
+					Endpoint: claiov1beta1.EndpointSpec{Host: "localhost", Address: "127.0.0.1", Port: 6543},
+					Network: claiov1beta1.NetworkSpec{
+						ClusterCIDR: "192.168.0.0/17",
+						ServiceCIDR: "192.168.128.0/17",
+						DNSDomain:   "cluster.local",
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, controlPlane)).To(Succeed())
+			meta.SetStatusCondition(&controlPlane.Status.Conditions, metav1.Condition{
+				Type: claiov1beta1.ConditionReady, Status: metav1.ConditionTrue, Reason: "Available",
+			})
+			Expect(k8sClient.Status().Update(ctx, controlPlane)).To(Succeed())
+
+			join := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: controlplanes.JoinSecretName, Namespace: "default"},
+				Data: map[string][]byte{
+					controlplanes.JoinSecretKeyToken:           []byte("abcdef.0123456789abcdef"),
+					controlplanes.JoinSecretKeyTokenExpiration: []byte(time.Now().Add(time.Hour).UTC().Format(time.RFC3339)),
+					controlplanes.JoinSecretKeyCACert:          []byte("cert"),
+					controlplanes.JoinSecretKeyKubeletConfig:   []byte("kind: KubeletConfiguration"),
+				},
+			}
+			Expect(k8sClient.Create(ctx, join)).To(Succeed())
+
+			By("creating a machine selecting the fake provider")
+			machine := &claiov1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+				Spec: claiov1alpha1.MachineSpec{
+					ControlPlaneRef: claiov1alpha1.ControlPlaneReference{Name: controlPlaneName},
+					ProviderRef:     &claiov1alpha1.MachineProviderReference{Name: fake.Name},
+				},
+			}
+			Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			machine := &claiov1alpha1.Machine{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, machine)).To(Succeed())
+			machine.Finalizers = nil
+			Expect(k8sClient.Update(ctx, machine)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, machine)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &claiov1beta1.ControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: controlPlaneName, Namespace: "default"},
+			})).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: controlplanes.JoinSecretName, Namespace: "default"},
+			})).To(Succeed())
+		})
+
+		It("should create the host with the provider", func() {
+			controllerReconciler := &MachineReconciler{
+				Client:    k8sClient,
+				Scheme:    k8sClient.Scheme(),
+				Providers: registry,
+				// the test api server plays the tenant
+				TenantClient: func(_ *controlplanes.ControlPlane) (client.Client, error) {
+					return k8sClient, nil
+				},
+			}
+
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
 				NamespacedName: typeNamespacedName,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+
+			machine := &claiov1alpha1.Machine{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, machine)).To(Succeed())
+			Expect(machine.Status.Phase).To(Equal(claiov1alpha1.MachinePhaseProvisioning))
+
+			host := fakeProvider.Host(machine)
+			Expect(host).NotTo(BeNil())
+			Expect(host.State).To(Equal(providers.HostStateReady))
+			Expect(string(host.BootstrapData)).To(ContainSubstring("https://localhost:6543"))
+			Expect(string(host.BootstrapData)).To(ContainSubstring("abcdef.0123456789abcdef"))
 		})
 	})
 })
@@ -0,0 +1,74 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/resources/natsclusters"
+)
+
+// NATSClusterReconciler reconciles a NATSCluster object
+type NATSClusterReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=claio.github.com,resources=natsclusters,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=claio.github.com,resources=natsclusters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=claio.github.com,resources=natsclusters/finalizers,verbs=update
+// +kubebuilder:rbac:groups=claio.github.com,resources=datastores,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=claio.github.com,resources=controlplanes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="apps",resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile deploys a JetStream cluster, rolls changes of its configuration server by server
+// and registers it as DataStore.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.18.2/pkg/reconcile
+func (r *NATSClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	natsCluster, err := natsclusters.NewNATSCluster(ctx, req, r.Client, r.Scheme)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if natsCluster == nil {
+		return ctrl.Result{}, nil
+	}
+	natsCluster.LogHeader("--- Reconciling %s -----------------------------------", req.Name)
+	result, err := natsCluster.Reconcile()
+	natsCluster.LogHeader("--- Reconciling %s Done ------------------------------", req.Name)
+	return result, err
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NATSClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&claiov1beta1.NATSCluster{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&corev1.Secret{}).
+		Complete(r)
+}
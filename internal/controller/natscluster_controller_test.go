@@ -0,0 +1,101 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	claiov1beta1 "claio/api/v1beta1"
+)
+
+var _ = Describe("NATSCluster Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "test-natscluster"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{Name: resourceName, Namespace: "default"}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind NATSCluster")
+			err := k8sClient.Get(ctx, typeNamespacedName, &claiov1beta1.NATSCluster{})
+			if err != nil && errors.IsNotFound(err) {
+				resource := &claiov1beta1.NATSCluster{
+					ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"},
+					Spec: claiov1beta1.NATSClusterSpec{
+						Replicas:          3,
+						ReplicationFactor: 3,
+						Image:             "nats:2.10.22-alpine",
+						Storage:           claiov1beta1.NATSStorageSpec{Size: resource.MustParse("1Gi")},
+					},
+				}
+				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			}
+		})
+
+		It("should deploy the servers and register the datastore", func() {
+			controllerReconciler := &NATSClusterReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			By("Reconciling the created resource")
+			// the first run adds the finalizer
+			for i := 0; i < 2; i++ {
+				_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			statefulSet := &appsv1.StatefulSet{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, statefulSet)).To(Succeed())
+			Expect(*statefulSet.Spec.Replicas).To(Equal(int32(3)))
+			Expect(statefulSet.Spec.UpdateStrategy.Type).To(Equal(appsv1.OnDeleteStatefulSetStrategyType))
+
+			config := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-config", Namespace: "default"}, config)).To(Succeed())
+			Expect(string(config.Data["nats.conf"])).To(ContainSubstring("nats://" + resourceName + "-2." + resourceName + "-headless:6222"))
+
+			dataStore := &claiov1beta1.DataStore{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName}, dataStore)).To(Succeed())
+			Expect(dataStore.Spec.Endpoint).To(Equal("nats://" + resourceName + ".default.svc:4222"))
+			Expect(dataStore.Spec.NATS.Replicas).To(Equal(int32(3)))
+
+			natsCluster := &claiov1beta1.NATSCluster{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, natsCluster)).To(Succeed())
+			Expect(meta.IsStatusConditionFalse(natsCluster.Status.Conditions, claiov1beta1.NATSClusterConditionAvailable)).To(BeTrue())
+			Expect(natsCluster.Status.Servers).To(HaveLen(3))
+
+			By("Deleting the resource")
+			Expect(k8sClient.Delete(ctx, natsCluster)).To(Succeed())
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: resourceName}, dataStore)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+})
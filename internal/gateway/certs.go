@@ -0,0 +1,159 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"claio/internal/certificates"
+	"claio/internal/kubernetes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// caName is the name of the CA in its secret (ca.crt, ca.key and ca.pub)
+	caName = "ca"
+	// tenantPrefix starts the common name of a client certificate, tenant-<name> may use the
+	// keys under /tenant-<name>/
+	tenantPrefix = "tenant-"
+
+	// certificateValidity and renewBefore apply to the serving and the client certificates
+	certificateValidity = 365 * 24 * time.Hour
+	renewBefore         = 30 * 24 * time.Hour
+)
+
+// LoadCA reads the CA of the gateway from the secret, a new CA is stored there if it does not
+// exist yet
+func LoadCA(ctx context.Context, c client.Client, namespace, name string) (*certificates.Certificate, error) {
+	data, err := kubernetes.GetSecret(c, ctx, namespace, name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting secret %s in ns %s: %s", name, namespace, err)
+	}
+	if data != nil {
+		return certificates.NewCertificateFromSecretData(caName, data)
+	}
+	ca, err := certificates.Create(&x509.Certificate{
+		SerialNumber:          certificates.Serial(),
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		Subject:               pkix.Name{CommonName: "claio-kine-gateway"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the gateway CA: %s", err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Data: map[string][]byte{
+			caName + ".crt": []byte(ca.Cert),
+			caName + ".key": []byte(ca.Key),
+			caName + ".pub": []byte(ca.Pub),
+		},
+	}
+	if err := c.Create(ctx, secret); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			// created by the gateway or the controller in the meantime
+			return LoadCA(ctx, c, namespace, name)
+		}
+		return nil, fmt.Errorf("failed to create secret %s in ns %s: %s", name, namespace, err)
+	}
+	return ca, nil
+}
+
+// TenantCommonName is the common name of the client certificate of a tenant, the gateway maps it
+// to the etcd prefix /tenant-<name>
+func TenantCommonName(name string) string {
+	return tenantPrefix + name
+}
+
+// NewClientCert issues the client certificate of a tenant
+func NewClientCert(ca *certificates.Certificate, name string) (*certificates.Certificate, error) {
+	return certificates.Create(&x509.Certificate{
+		SerialNumber: certificates.Serial(),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(certificateValidity),
+		Subject:      pkix.Name{CommonName: TenantCommonName(name)},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+}
+
+// NeedsRenewal is true if the certificate expires soon or was not issued by the CA
+func NeedsRenewal(cert, ca *certificates.Certificate) bool {
+	return !cert.IsValid(ca, nil, renewBefore)
+}
+
+// servingCert issues the serving certificate of the gateway for the hostnames (DNS names or IPs)
+// and renews it before it expires
+type servingCert struct {
+	ca        *certificates.Certificate
+	hostnames []string
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+func (s *servingCert) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cert != nil && time.Until(s.cert.Leaf.NotAfter) > renewBefore {
+		return s.cert, nil
+	}
+	template := &x509.Certificate{
+		SerialNumber: certificates.Serial(),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(certificateValidity),
+		Subject:      pkix.Name{CommonName: "claio-kine-gateway"},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	for _, hostname := range s.hostnames {
+		if ip := net.ParseIP(hostname); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if hostname = strings.TrimSpace(hostname); hostname != "" {
+			template.DNSNames = append(template.DNSNames, hostname)
+		}
+	}
+	cert, err := certificates.Create(template, s.ca)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create serving certificate: %s", err)
+	}
+	pair, err := tls.X509KeyPair([]byte(cert.Cert), []byte(cert.Key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid serving certificate: %s", err)
+	}
+	if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("invalid serving certificate: %s", err)
+	}
+	s.cert = &pair
+	return s.cert, nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"claio/internal/certificates"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/sync/errgroup"
+)
+
+// Command runs the gateway in a Deployment of its own, with the manager image
+const Command = "gateway"
+
+// IsCommand is true if the arguments run the gateway instead of the manager
+func IsCommand(args []string) bool {
+	return len(args) > 0 && args[0] == Command
+}
+
+// stringList is a repeatable flag of comma separated values
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// Main serves the gateway until it is terminated, it returns the exit code
+func Main(args []string) int {
+	if !IsCommand(args) {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags]\n", Command)
+		return 2
+	}
+	opts := &Options{}
+	var caDir, backendCA, backendCert, backendKey, metricsAddr string
+	var hostnames stringList
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.StringVar(&opts.Backend, "backend", "http://127.0.0.1:2379", "The etcd API of kine.")
+	flags.StringVar(&backendCA, "backend-ca-file", "", "The CA certificate of an https backend.")
+	flags.StringVar(&backendCert, "backend-cert-file", "", "The client certificate for an https backend.")
+	flags.StringVar(&backendKey, "backend-key-file", "", "The client key for an https backend.")
+	flags.StringVar(&opts.BindAddress, "bind-address", ":2379", "The address the tenants connect to.")
+	flags.StringVar(&caDir, "ca-dir", "/etc/kine-gateway/ca", "The directory of the mounted CA secret of the gateway "+
+		"(ca.crt, ca.key and ca.pub), the CA the manager issues the client certificates of the tenants with.")
+	flags.Var(&hostnames, "hostname", "A DNS name or IP of the serving certificate, repeatable or comma separated.")
+	flags.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metrics endpoint binds to, 0 disables it.")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	opts.Hostnames = hostnames
+
+	if err := serve(opts, caDir, backendCA, backendCert, backendKey, metricsAddr); err != nil {
+		fmt.Fprintf(os.Stderr, "gateway failed: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// serve runs the gateway and its metrics endpoint until a signal terminates them
+func serve(opts *Options, caDir, backendCA, backendCert, backendKey, metricsAddr string) error {
+	data := map[string][]byte{}
+	for _, ext := range []string{".crt", ".key", ".pub"} {
+		content, err := os.ReadFile(filepath.Join(caDir, caName+ext))
+		if err != nil {
+			return fmt.Errorf("error reading the CA: %s", err)
+		}
+		data[caName+ext] = content
+	}
+	ca, err := certificates.NewCertificateFromSecretData(caName, data)
+	if err != nil {
+		return fmt.Errorf("invalid CA: %s", err)
+	}
+	opts.CA = ca
+
+	if backendCA != "" || backendCert != "" {
+		opts.BackendTLS = &tls.Config{MinVersion: tls.VersionTLS12}
+		if backendCA != "" {
+			pem, err := os.ReadFile(backendCA)
+			if err != nil {
+				return fmt.Errorf("error reading the backend CA: %s", err)
+			}
+			opts.BackendTLS.RootCAs = x509.NewCertPool()
+			if !opts.BackendTLS.RootCAs.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("invalid backend CA %s", backendCA)
+			}
+		}
+		if backendCert != "" {
+			pair, err := tls.LoadX509KeyPair(backendCert, backendKey)
+			if err != nil {
+				return fmt.Errorf("error reading the backend client certificate: %s", err)
+			}
+			opts.BackendTLS.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	opts.Registerer = registry
+	g, err := New(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	group, ctx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		return g.Start(ctx)
+	})
+	if metricsAddr != "0" {
+		server := &http.Server{
+			Addr:              metricsAddr,
+			Handler:           promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		group.Go(func() error {
+			<-ctx.Done()
+			return server.Close()
+		})
+		group.Go(func() error {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		})
+	}
+	return group.Wait()
+}
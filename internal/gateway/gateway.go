@@ -0,0 +1,247 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gateway serves the etcd API of one kine for many tenants, so the control-planes do
+// not need a kine sidecar of their own. A tenant authenticates with a client certificate of
+// the gateway CA, its common name tenant-<name> grants access to the keys under /tenant-<name>/
+// (the --etcd-prefix of its apiserver). The requests are checked and forwarded to kine, the
+// gateway is the place for the metrics (and the limits) of the single tenants.
+//
+// The manager runs the gateway in-process (--kine-gateway-backend) or the gateway command of
+// the manager binary runs it in a Deployment of its own.
+package gateway
+
+import (
+	"bytes"
+	"claio/internal/backup"
+	"claio/internal/certificates"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// maxMessageSize is the size limit of a request message
+	maxMessageSize = 16 << 20
+
+	// gRPC status codes of the gateway
+	codePermissionDenied = 7
+	codeUnavailable      = 14
+	codeUnauthenticated  = 16
+
+	shutdownTimeout = 10 * time.Second
+)
+
+var log = ctrl.Log.WithName("kine-gateway")
+
+// Options configure the gateway
+type Options struct {
+	// Backend is the etcd API of kine, http://host:port or https://host:port
+	Backend string
+	// BackendTLS is the TLS config of an https backend
+	BackendTLS *tls.Config
+	// BindAddress is the address the tenants connect to
+	BindAddress string
+	// CA issues the serving certificate and verifies the client certificates of the tenants
+	CA *certificates.Certificate
+	// Hostnames are the DNS names and IPs of the serving certificate besides localhost
+	Hostnames []string
+	// Registerer registers the metrics of the tenants, e.g. the registry of the manager
+	Registerer prometheus.Registerer
+}
+
+// Gateway checks the requests of the tenants and forwards them to kine
+type Gateway struct {
+	opts    *Options
+	proxy   *httputil.ReverseProxy
+	serving *servingCert
+	clients *x509.CertPool
+
+	requests *prometheus.CounterVec
+	streams  *prometheus.GaugeVec
+}
+
+// New creates the gateway, it serves once it is started
+func New(opts *Options) (*Gateway, error) {
+	if opts.CA == nil {
+		return nil, fmt.Errorf("the gateway needs a CA")
+	}
+	caCert, err := opts.CA.RawCert()
+	if err != nil {
+		return nil, fmt.Errorf("invalid gateway CA: %s", err)
+	}
+	transport, base, err := backup.NewTransport(opts.Backend, opts.BackendTLS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend: %s", err)
+	}
+	g := &Gateway{
+		opts:    opts,
+		serving: &servingCert{ca: opts.CA, hostnames: opts.Hostnames},
+		clients: x509.NewCertPool(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "claio_kine_gateway_requests_total",
+			Help: "Requests of the tenants to the kine gateway by method and result (forwarded or denied)",
+		}, []string{"tenant", "method", "result"}),
+		streams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claio_kine_gateway_streams",
+			Help: "Open watch and lease keep-alive streams of the tenants",
+		}, []string{"tenant", "method"}),
+	}
+	g.clients.AddCert(caCert)
+	g.proxy = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(base)
+		},
+		Transport: transport,
+		// the responses of watches are streamed
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			grpcError(w, codeUnavailable, "kine is not available: "+err.Error())
+		},
+	}
+	if opts.Registerer != nil {
+		for _, collector := range []prometheus.Collector{g.requests, g.streams} {
+			if err := opts.Registerer.Register(collector); err != nil {
+				return nil, fmt.Errorf("error registering metrics: %s", err)
+			}
+		}
+	}
+	return g, nil
+}
+
+// Start serves the tenants until the context is done
+func (g *Gateway) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", g.opts.BindAddress)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %s", g.opts.BindAddress, err)
+	}
+	server := &http.Server{
+		Handler:           g,
+		TLSConfig:         g.tlsConfig(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	log.Info("serving the kine gateway", "address", listener.Addr().String(), "backend", g.opts.Backend)
+	if err := server.ServeTLS(listener, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// tlsConfig serves gRPC to the tenants with a client certificate of the CA
+func (g *Gateway) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: g.serving.GetCertificate,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		ClientCAs:      g.clients,
+		NextProtos:     []string{"h2"},
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// NeedLeaderElection is false, every replica of the manager serves the gateway
+func (g *Gateway) NeedLeaderElection() bool {
+	return false
+}
+
+// ServeHTTP checks a gRPC call of a tenant and forwards it to kine
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+	if tenant == "" {
+		grpcError(w, codeUnauthenticated, "a client certificate of a tenant is required")
+		return
+	}
+	method := r.URL.Path
+	check, ok := methods[method]
+	if !ok {
+		g.requests.WithLabelValues(tenant, "other", "denied").Inc()
+		grpcError(w, codePermissionDenied, fmt.Sprintf("%s is not allowed through the gateway", method))
+		return
+	}
+	body := &frameChecker{body: r.Body, prefix: []byte("/" + tenant + "/"), check: check, max: maxMessageSize}
+	if streams[method] {
+		// a stream is ended by the first request which fails the check
+		body.denied = func() { g.requests.WithLabelValues(tenant, method, "denied").Inc() }
+		r.Body = body
+		streams := g.streams.WithLabelValues(tenant, method)
+		streams.Inc()
+		defer streams.Dec()
+	} else {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			g.requests.WithLabelValues(tenant, method, "denied").Inc()
+			grpcError(w, codePermissionDenied, err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		r.ContentLength = int64(len(data))
+	}
+	g.requests.WithLabelValues(tenant, method, "forwarded").Inc()
+	g.proxy.ServeHTTP(w, r)
+}
+
+// tenantOf is the common name of the verified client certificate, empty if it is not the
+// certificate of a tenant
+func tenantOf(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	name := r.TLS.PeerCertificates[0].Subject.CommonName
+	if !strings.HasPrefix(name, tenantPrefix) || len(name) == len(tenantPrefix) || strings.Contains(name, "/") {
+		return ""
+	}
+	return name
+}
+
+// grpcError answers a call with a status without a message
+func grpcError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", encodeMessage(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeMessage percent-encodes a status message like gRPC, every byte which is not printable
+// ASCII and the percent sign
+func encodeMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"claio/internal/backup"
+	"claio/internal/certificates"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// kineStandIn answers every call with an empty RangeResponse at revision 7 and records the methods
+type kineStandIn struct {
+	server *httptest.Server
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func newKineStandIn() *kineStandIn {
+	k := &kineStandIn{}
+	k.server = httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k.mu.Lock()
+		k.calls = append(k.calls, r.URL.Path)
+		k.mu.Unlock()
+		header := protowire.AppendTag(nil, 3, protowire.VarintType)
+		header = protowire.AppendVarint(header, 7)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write(frame(field(1, header)))
+		w.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	return k
+}
+
+func (k *kineStandIn) Calls() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]string{}, k.calls...)
+}
+
+var _ = Describe("Gateway", func() {
+	var (
+		ctx      context.Context
+		kine     *kineStandIn
+		ca       *certificates.Certificate
+		registry *prometheus.Registry
+		server   *httptest.Server
+	)
+
+	// clientTLS authenticates with a certificate of the CA, the serving certificate of the
+	// gateway is requested by name, the one of httptest is used without
+	clientTLS := func(commonName string) *tls.Config {
+		cert, err := certificates.Create(&x509.Certificate{
+			SerialNumber: certificates.Serial(),
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+			Subject:      pkix.Name{CommonName: commonName},
+			KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, ca)
+		Expect(err).NotTo(HaveOccurred())
+		pair, err := tls.X509KeyPair([]byte(cert.Cert), []byte(cert.Key))
+		Expect(err).NotTo(HaveOccurred())
+		roots := x509.NewCertPool()
+		Expect(roots.AppendCertsFromPEM([]byte(ca.Cert))).To(BeTrue())
+		return &tls.Config{Certificates: []tls.Certificate{pair}, RootCAs: roots, ServerName: "localhost"}
+	}
+
+	tenantKV := func(name string) *backup.KV {
+		kv, err := backup.NewKV(server.URL, clientTLS(TenantCommonName(name)))
+		Expect(err).NotTo(HaveOccurred())
+		return kv
+	}
+
+	// counter is the value of the request counter of the gateway
+	counter := func(tenant, method, result string) float64 {
+		families, err := registry.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() != "claio_kine_gateway_requests_total" {
+				continue
+			}
+			for _, metric := range family.GetMetric() {
+				labels := map[string]string{}
+				for _, label := range metric.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				if labels["tenant"] == tenant && labels["method"] == method && labels["result"] == result {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+		return 0
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		kine = newKineStandIn()
+		DeferCleanup(kine.server.Close)
+
+		var err error
+		ca, err = certificates.Create(&x509.Certificate{
+			SerialNumber:          certificates.Serial(),
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			Subject:               pkix.Name{CommonName: "claio-kine-gateway"},
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		registry = prometheus.NewRegistry()
+		g, err := New(&Options{Backend: kine.server.URL, CA: ca, Registerer: registry})
+		Expect(err).NotTo(HaveOccurred())
+		server = httptest.NewUnstartedServer(g)
+		server.TLS = g.tlsConfig()
+		server.EnableHTTP2 = true
+		server.StartTLS()
+		DeferCleanup(server.Close)
+	})
+
+	It("Should forward the requests of a tenant within its prefix", func() {
+		Expect(tenantKV("a").Revision(ctx, []byte("/tenant-a/registry/health"))).To(Equal(int64(7)))
+		Expect(kine.Calls()).To(Equal([]string{"/etcdserverpb.KV/Range"}))
+		Expect(counter("tenant-a", "/etcdserverpb.KV/Range", "forwarded")).To(Equal(1.0))
+	})
+
+	It("Should deny the keys of other tenants", func() {
+		_, err := tenantKV("a").Revision(ctx, []byte("/tenant-b/registry/health"))
+		Expect(err).To(MatchError(ContainSubstring("grpc status 7")))
+		_, err = tenantKV("a").List(ctx, []byte("/tenant-"), 10, func(backup.KeyValue) error { return nil })
+		Expect(err).To(MatchError(ContainSubstring("grpc status 7")))
+		Expect(kine.Calls()).To(BeEmpty())
+		Expect(counter("tenant-a", "/etcdserverpb.KV/Range", "denied")).To(Equal(2.0))
+	})
+
+	It("Should deny methods which reach beyond a tenant", func() {
+		transport, _, err := backup.NewTransport(server.URL, clientTLS(TenantCommonName("a")))
+		Expect(err).NotTo(HaveOccurred())
+		// leases are not per tenant, their attached keys would leak
+		denied := []string{
+			"/etcdserverpb.Maintenance/Defragment",
+			"/etcdserverpb.Lease/LeaseTimeToLive",
+			"/etcdserverpb.Lease/LeaseLeases",
+			"/etcdserverpb.Lease/LeaseRevoke",
+		}
+		for _, method := range denied {
+			req, err := http.NewRequest(http.MethodPost, server.URL+method, nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/grpc")
+			resp, err := transport.RoundTrip(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Header.Get("Grpc-Status")).To(Equal("7"), method)
+			Expect(resp.Body.Close()).To(Succeed())
+		}
+		Expect(kine.Calls()).To(BeEmpty())
+		Expect(counter("tenant-a", "other", "denied")).To(Equal(float64(len(denied))))
+	})
+
+	It("Should only accept client certificates of tenants", func() {
+		kv, err := backup.NewKV(server.URL, clientTLS("kube-apiserver"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = kv.Revision(ctx, []byte("/tenant-a/registry/health"))
+		Expect(err).To(MatchError(ContainSubstring("grpc status 16")))
+		Expect(kine.Calls()).To(BeEmpty())
+	})
+})
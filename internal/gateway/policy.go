@@ -0,0 +1,253 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// checkFunc checks a request message of a tenant against the prefix of its keys
+type checkFunc func(prefix, message []byte) error
+
+// methods are the gRPC methods tenants may call, the requests of methods with a check are
+// inspected. Other methods are not forwarded, e.g. lease revocation and lookups, compaction,
+// snapshots and alarms, they would reach the keys or the revisions of other tenants.
+var methods = map[string]checkFunc{
+	"/etcdserverpb.KV/Range":             checkRange,
+	"/etcdserverpb.KV/Put":               checkPut,
+	"/etcdserverpb.KV/DeleteRange":       checkRange,
+	"/etcdserverpb.KV/Txn":               checkTxn,
+	"/etcdserverpb.Watch/Watch":          checkWatch,
+	"/etcdserverpb.Lease/LeaseGrant":     nil,
+	"/etcdserverpb.Lease/LeaseKeepAlive": nil,
+	"/etcdserverpb.Maintenance/Status":   nil,
+	"/etcdserverpb.Cluster/MemberList":   nil,
+	"/grpc.health.v1.Health/Check":       nil,
+}
+
+// streams are the methods with a stream of requests, they are checked while they are forwarded
+var streams = map[string]bool{
+	"/etcdserverpb.Watch/Watch":          true,
+	"/etcdserverpb.Lease/LeaseKeepAlive": true,
+}
+
+// field numbers of the etcdserverpb messages
+const (
+	rangeKey    = 1
+	rangeEnd    = 2
+	putKey      = 1
+	compareKey  = 3
+	compareEnd  = 64
+	txnCompare  = 1
+	txnSuccess  = 2
+	txnFailure  = 3
+	opRange     = 1
+	opPut       = 2
+	opDelete    = 3
+	opTxn       = 4
+	watchCreate = 1
+)
+
+// checkKeys allows a key or a range from key to end within the prefix
+func checkKeys(prefix, key, end []byte) error {
+	if !bytes.HasPrefix(key, prefix) {
+		return fmt.Errorf("key %q is outside of %s", key, prefix)
+	}
+	if len(end) == 0 {
+		return nil
+	}
+	// end "\x00" is every key from key on
+	if bytes.Equal(end, []byte{0}) || bytes.Compare(end, prefixEnd(prefix)) > 0 {
+		return fmt.Errorf("range %q to %q is outside of %s", key, end, prefix)
+	}
+	return nil
+}
+
+// checkKeyFields checks the key and the end of the range in a message
+func checkKeyFields(prefix, message []byte, keyField, endField protowire.Number) error {
+	var key, end []byte
+	err := parseMessage(message, func(num protowire.Number, value []byte) error {
+		switch num {
+		case keyField:
+			key = value
+		case endField:
+			end = value
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return checkKeys(prefix, key, end)
+}
+
+// checkRange checks a RangeRequest or a DeleteRangeRequest
+func checkRange(prefix, message []byte) error {
+	return checkKeyFields(prefix, message, rangeKey, rangeEnd)
+}
+
+// checkPut checks a PutRequest
+func checkPut(prefix, message []byte) error {
+	return checkKeyFields(prefix, message, putKey, 0)
+}
+
+// checkTxn checks the compares and the requests of both branches of a TxnRequest
+func checkTxn(prefix, message []byte) error {
+	return parseMessage(message, func(num protowire.Number, value []byte) error {
+		switch num {
+		case txnCompare:
+			return checkKeyFields(prefix, value, compareKey, compareEnd)
+		case txnSuccess, txnFailure:
+			return parseMessage(value, func(num protowire.Number, request []byte) error {
+				switch num {
+				case opRange, opDelete:
+					return checkRange(prefix, request)
+				case opPut:
+					return checkPut(prefix, request)
+				case opTxn:
+					return checkTxn(prefix, request)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// checkWatch checks the create requests of a watch stream, cancel and progress requests refer
+// to watches of the stream
+func checkWatch(prefix, message []byte) error {
+	return parseMessage(message, func(num protowire.Number, value []byte) error {
+		if num == watchCreate {
+			return checkRange(prefix, value)
+		}
+		return nil
+	})
+}
+
+// parseMessage calls fn for the length-delimited fields of a message, other fields are skipped
+func parseMessage(b []byte, fn func(num protowire.Number, value []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixEnd is the end of the range of all keys with the prefix
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+// frameChecker checks the gRPC messages of a request body while it is read, a message which
+// fails the check ends the body with an error. Compressed messages cannot be checked.
+type frameChecker struct {
+	body   io.ReadCloser
+	prefix []byte
+	check  checkFunc
+	// max is the size limit of a message
+	max int
+	// denied is called if a message fails the check
+	denied  func()
+	pending []byte
+	ready   []byte
+	err     error
+}
+
+func (f *frameChecker) Read(p []byte) (int, error) {
+	for len(f.ready) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+		buf := make([]byte, 32*1024)
+		n, err := f.body.Read(buf)
+		f.pending = append(f.pending, buf[:n]...)
+		if checkErr := f.checkFrames(); checkErr != nil {
+			if f.denied != nil {
+				f.denied()
+			}
+			f.err = checkErr
+			return 0, checkErr
+		}
+		if err == io.EOF && len(f.pending) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		f.err = err
+	}
+	n := copy(p, f.ready)
+	f.ready = f.ready[n:]
+	return n, nil
+}
+
+// checkFrames moves the complete messages which pass the check from pending to ready
+func (f *frameChecker) checkFrames() error {
+	for len(f.pending) >= 5 {
+		if f.pending[0] != 0 {
+			return fmt.Errorf("compressed messages are not supported")
+		}
+		size := int(binary.BigEndian.Uint32(f.pending[1:5]))
+		if size > f.max {
+			return fmt.Errorf("message of %d bytes exceeds the limit of %d bytes", size, f.max)
+		}
+		if len(f.pending) < 5+size {
+			return nil
+		}
+		if f.check != nil {
+			if err := f.check(f.prefix, f.pending[5:5+size]); err != nil {
+				return err
+			}
+		}
+		f.ready = append(f.ready, f.pending[:5+size]...)
+		f.pending = f.pending[5+size:]
+	}
+	return nil
+}
+
+func (f *frameChecker) Close() error {
+	return f.body.Close()
+}
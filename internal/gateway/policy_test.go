@@ -0,0 +1,143 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing/iotest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// field is a length-delimited field of a message
+func field(num protowire.Number, value []byte) []byte {
+	b := protowire.AppendTag(nil, num, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+// keyRange is a RangeRequest, a DeleteRangeRequest or a Compare of the keys from key to end
+func keyRange(key, end string) []byte {
+	b := field(rangeKey, []byte(key))
+	if end != "" {
+		b = append(b, field(rangeEnd, []byte(end))...)
+	}
+	return b
+}
+
+// frame is a gRPC message of a request body
+func frame(message []byte) []byte {
+	b := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(message)))
+	return append(b, message...)
+}
+
+var _ = Describe("Policy", func() {
+	prefix := []byte("/tenant-a/")
+
+	Context("When checking keys", func() {
+		It("Should allow keys and ranges within the prefix", func() {
+			Expect(checkKeys(prefix, []byte("/tenant-a/registry/pods"), nil)).To(Succeed())
+			Expect(checkKeys(prefix, []byte("/tenant-a/registry/"), []byte("/tenant-a/registry0"))).To(Succeed())
+			Expect(checkKeys(prefix, prefix, prefixEnd(prefix))).To(Succeed())
+		})
+
+		It("Should deny keys of other tenants", func() {
+			Expect(checkKeys(prefix, []byte("/tenant-ab/registry"), nil)).NotTo(Succeed())
+			Expect(checkKeys(prefix, []byte("/tenant-a"), nil)).NotTo(Succeed())
+			Expect(checkKeys(prefix, nil, nil)).NotTo(Succeed())
+		})
+
+		It("Should deny ranges beyond the prefix", func() {
+			Expect(checkKeys(prefix, prefix, []byte("/tenant-b/"))).NotTo(Succeed())
+			Expect(checkKeys(prefix, prefix, []byte{0})).NotTo(Succeed())
+		})
+	})
+
+	Context("When checking requests", func() {
+		It("Should check both branches and the compares of a transaction", func() {
+			allowed := append(field(txnCompare, field(compareKey, []byte("/tenant-a/x"))),
+				field(txnSuccess, field(opPut, field(putKey, []byte("/tenant-a/x"))))...)
+			Expect(checkTxn(prefix, allowed)).To(Succeed())
+
+			failure := append(allowed, field(txnFailure, field(opRange, keyRange("/tenant-b/x", "")))...)
+			Expect(checkTxn(prefix, failure)).NotTo(Succeed())
+
+			compare := field(txnCompare, field(compareKey, []byte("/tenant-b/x")))
+			Expect(checkTxn(prefix, compare)).NotTo(Succeed())
+
+			nested := field(txnSuccess, field(opTxn, field(txnSuccess, field(opDelete, keyRange("/", "\x00")))))
+			Expect(checkTxn(prefix, nested)).NotTo(Succeed())
+		})
+
+		It("Should check the create requests of a watch", func() {
+			Expect(checkWatch(prefix, field(watchCreate, keyRange("/tenant-a/registry/", "/tenant-a/registry0")))).To(Succeed())
+			Expect(checkWatch(prefix, field(watchCreate, keyRange("/tenant-b/registry/", "/tenant-b/registry0")))).NotTo(Succeed())
+			// a cancel request refers to a watch of the stream
+			cancel := protowire.AppendTag(nil, 2, protowire.BytesType)
+			cancel = protowire.AppendBytes(cancel, protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1))
+			Expect(checkWatch(prefix, cancel)).To(Succeed())
+		})
+
+		It("Should deny invalid messages", func() {
+			Expect(checkRange(prefix, []byte{0x0a, 0x10, 'x'})).NotTo(Succeed())
+		})
+	})
+
+	Context("When reading a request body", func() {
+		newChecker := func(body []byte) *frameChecker {
+			return &frameChecker{
+				body:   io.NopCloser(iotest.OneByteReader(bytes.NewReader(body))),
+				prefix: prefix,
+				check:  checkRange,
+				max:    1024,
+			}
+		}
+
+		It("Should pass the messages which are allowed unchanged", func() {
+			body := append(frame(keyRange("/tenant-a/x", "")), frame(keyRange("/tenant-a/y", ""))...)
+			Expect(io.ReadAll(newChecker(body))).To(Equal(body))
+		})
+
+		It("Should end the body at the first message which is denied", func() {
+			body := append(frame(keyRange("/tenant-a/x", "")), frame(keyRange("/tenant-b/y", ""))...)
+			denied := 0
+			checker := newChecker(body)
+			checker.denied = func() { denied++ }
+			data, err := io.ReadAll(checker)
+			Expect(err).To(MatchError(ContainSubstring("outside of /tenant-a/")))
+			Expect(data).To(Equal(frame(keyRange("/tenant-a/x", ""))))
+			Expect(denied).To(Equal(1))
+		})
+
+		It("Should deny compressed, oversized and truncated messages", func() {
+			compressed := frame(keyRange("/tenant-a/x", ""))
+			compressed[0] = 1
+			_, err := io.ReadAll(newChecker(compressed))
+			Expect(err).To(MatchError(ContainSubstring("compressed")))
+			_, err = io.ReadAll(newChecker(frame([]byte(strings.Repeat("x", 2048)))))
+			Expect(err).To(MatchError(ContainSubstring("exceeds")))
+			truncated := frame(keyRange("/tenant-a/x", ""))
+			_, err = io.ReadAll(newChecker(truncated[:len(truncated)-1]))
+			Expect(err).To(Equal(io.ErrUnexpectedEOF))
+		})
+	})
+})
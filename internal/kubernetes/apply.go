@@ -0,0 +1,91 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// FieldOwner is the field manager of all objects claio applies
+const FieldOwner = "claio"
+
+// ApplyYaml applies all documents of a (multi-document) yaml with server-side apply
+func ApplyYaml(client k8sclient.Client, ctx context.Context, yaml []byte) error {
+	objects, err := DecodeYaml(yaml)
+	if err != nil {
+		return err
+	}
+	return ApplyObjects(client, ctx, objects)
+}
+
+// ApplyObjects applies the objects with server-side apply
+func ApplyObjects(client k8sclient.Client, ctx context.Context, objects []*unstructured.Unstructured) error {
+	for _, obj := range objects {
+		if err := client.Patch(ctx, obj, k8sclient.Apply, k8sclient.FieldOwner(FieldOwner), k8sclient.ForceOwnership); err != nil {
+			return fmt.Errorf("  failed to apply %s %s: %s", obj.GetKind(), objectName(obj), err)
+		}
+	}
+	return nil
+}
+
+// DeleteYaml deletes all objects of a (multi-document) yaml, missing objects are ignored
+func DeleteYaml(client k8sclient.Client, ctx context.Context, yaml []byte) error {
+	objects, err := DecodeYaml(yaml)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := client.Delete(ctx, obj); k8sclient.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("  failed to delete %s %s: %s", obj.GetKind(), objectName(obj), err)
+		}
+	}
+	return nil
+}
+
+// DecodeYaml splits a (multi-document) yaml into objects, empty documents are skipped
+func DecodeYaml(yaml []byte) ([]*unstructured.Unstructured, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(yaml), 4096)
+	objects := []*unstructured.Unstructured{}
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objects, nil
+			}
+			return nil, fmt.Errorf("   cannot decode yaml: %s", err)
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		objects = append(objects, obj)
+	}
+}
+
+func objectName(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
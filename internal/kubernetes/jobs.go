@@ -0,0 +1,117 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func GetJob(client k8sclient.Client, ctx context.Context, namespace, name string) (*batchv1.Job, error) {
+	job := &batchv1.Job{}
+	if err := client.Get(
+		ctx,
+		k8sclient.ObjectKey{
+			Namespace: namespace,
+			Name:      name,
+		},
+		job,
+	); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+func CreateJob(client k8sclient.Client, ctx context.Context, namespace, name string, yaml []byte, reference client.Object, scheme *runtime.Scheme) error {
+	decoder := serializer.NewCodecFactory(scheme).UniversalDecoder()
+	job := &batchv1.Job{}
+	if err := runtime.DecodeInto(decoder, yaml, job); err != nil {
+		return fmt.Errorf("   cannot decode job %s/%s: %s", namespace, name, err)
+	}
+	if reference != nil {
+		if err := ctrl.SetControllerReference(reference, job, scheme); err != nil {
+			return fmt.Errorf("   cannot set owner-reference on job %s/%s: %s", namespace, name, err)
+		}
+	}
+	if err := client.Create(ctx, job); err != nil {
+		return fmt.Errorf("  failed to create job %s/%s: %s", namespace, name, err)
+	}
+	return nil
+}
+
+// DeleteJob deletes the job and its pods
+func DeleteJob(client k8sclient.Client, ctx context.Context, namespace, name string) error {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	if err := client.Delete(ctx, job, k8sclient.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("  failed to delete job %s/%s: %s", namespace, name, err)
+	}
+	return nil
+}
+
+// JobFinished reports whether the job succeeded or failed, the message of a failed job is returned
+func JobFinished(job *batchv1.Job) (finished bool, failed string) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return true, ""
+		case batchv1.JobFailed:
+			return true, condition.Message
+		}
+	}
+	return false, ""
+}
+
+// JobTerminationMessage is the termination message of the container in the pods of the job,
+// empty if no pod has terminated yet
+func JobTerminationMessage(client k8sclient.Client, ctx context.Context, namespace, name, container string) (string, error) {
+	pods := &corev1.PodList{}
+	if err := client.List(ctx, pods, k8sclient.InNamespace(namespace), k8sclient.MatchingLabels{"job-name": name}); err != nil {
+		return "", err
+	}
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == container && status.State.Terminated != nil {
+				return status.State.Terminated.Message, nil
+			}
+		}
+	}
+	return "", nil
+}
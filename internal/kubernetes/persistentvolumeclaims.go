@@ -0,0 +1,78 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func GetPersistentVolumeClaim(client k8sclient.Client, ctx context.Context, namespace, name string) (*corev1.PersistentVolumeClaim, error) {
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := client.Get(
+		ctx,
+		k8sclient.ObjectKey{
+			Namespace: namespace,
+			Name:      name,
+		},
+		pvc,
+	); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return pvc, nil
+}
+
+func CreatePersistentVolumeClaim(client k8sclient.Client, ctx context.Context, namespace, name string, yaml []byte, reference client.Object, scheme *runtime.Scheme) error {
+	decoder := serializer.NewCodecFactory(scheme).UniversalDecoder()
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := runtime.DecodeInto(decoder, yaml, pvc); err != nil {
+		return fmt.Errorf("   cannot decode persistent volume claim %s/%s: %s", namespace, name, err)
+	}
+	if reference != nil {
+		if err := ctrl.SetControllerReference(reference, pvc, scheme); err != nil {
+			return fmt.Errorf("   cannot set owner-reference on persistent volume claim %s/%s: %s", namespace, name, err)
+		}
+	}
+	if err := client.Create(ctx, pvc); err != nil {
+		return fmt.Errorf("  failed to create persistent volume claim %s/%s: %s", namespace, name, err)
+	}
+	return nil
+}
+
+func DeletePersistentVolumeClaim(client k8sclient.Client, ctx context.Context, namespace, name string) error {
+	pvc := &corev1.PersistentVolumeClaim{}
+	pvc.Name = name
+	pvc.Namespace = namespace
+	if err := client.Delete(ctx, pvc); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("  failed to delete persistent volume claim %s/%s: %s", namespace, name, err)
+	}
+	return nil
+}
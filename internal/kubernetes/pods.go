@@ -0,0 +1,82 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func GetPod(client k8sclient.Client, ctx context.Context, namespace, name string) (*corev1.Pod, error) {
+	pod := &corev1.Pod{}
+	if err := client.Get(
+		ctx,
+		k8sclient.ObjectKey{
+			Namespace: namespace,
+			Name:      name,
+		},
+		pod,
+	); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return pod, nil
+}
+
+func CreatePod(client k8sclient.Client, ctx context.Context, namespace, name string, yaml []byte, reference client.Object, scheme *runtime.Scheme) error {
+	decoder := serializer.NewCodecFactory(scheme).UniversalDecoder()
+	pod := &corev1.Pod{}
+	if err := runtime.DecodeInto(decoder, yaml, pod); err != nil {
+		return fmt.Errorf("   cannot decode pod %s/%s: %s", namespace, name, err)
+	}
+	if reference != nil {
+		if err := ctrl.SetControllerReference(reference, pod, scheme); err != nil {
+			return fmt.Errorf("   cannot set owner-reference on pod %s/%s: %s", namespace, name, err)
+		}
+	}
+	if err := client.Create(ctx, pod); err != nil {
+		return fmt.Errorf("  failed to create pod %s/%s: %s", namespace, name, err)
+	}
+	return nil
+}
+
+func DeletePod(client k8sclient.Client, ctx context.Context, namespace, name string) error {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	if err := client.Delete(ctx, pod); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("  failed to delete pod %s/%s: %s", namespace, name, err)
+	}
+	return nil
+}
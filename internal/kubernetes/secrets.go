@@ -66,6 +66,49 @@ func CreateSecret(client k8sclient.Client, ctx context.Context, namespace, name
 	return nil
 }
 
+func UpdateSecret(client k8sclient.Client, ctx context.Context, namespace, name string, data map[string][]byte, reference client.Object, scheme *runtime.Scheme) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Data: data,
+	}
+	if reference != nil {
+		if err := ctrl.SetControllerReference(reference, secret, scheme); err != nil {
+			return fmt.Errorf("   cannot set owner-reference on secret %s/%s: %s", namespace, name, err)
+		}
+	}
+	if err := client.Update(ctx, secret); err != nil {
+		return fmt.Errorf("  failed to update secret %s/%s: %s", namespace, name, err)
+	}
+	return nil
+}
+
+// ApplySecret creates or updates the secret with server-side apply
+func ApplySecret(client k8sclient.Client, ctx context.Context, namespace, name string, data map[string][]byte, reference client.Object, scheme *runtime.Scheme) error {
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Data: data,
+	}
+	if reference != nil {
+		if err := ctrl.SetControllerReference(reference, secret, scheme); err != nil {
+			return fmt.Errorf("   cannot set owner-reference on secret %s/%s: %s", namespace, name, err)
+		}
+	}
+	if err := client.Patch(ctx, secret, k8sclient.Apply, k8sclient.FieldOwner(FieldOwner), k8sclient.ForceOwnership); err != nil {
+		return fmt.Errorf("  failed to apply secret %s/%s: %s", namespace, name, err)
+	}
+	return nil
+}
+
 func DeleteSecret(client k8sclient.Client, ctx context.Context, namespace, name string) error {
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
@@ -0,0 +1,82 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func GetStatefulSet(client k8sclient.Client, ctx context.Context, namespace, name string) (*appsv1.StatefulSet, error) {
+	statefulSet := &appsv1.StatefulSet{}
+	if err := client.Get(
+		ctx,
+		k8sclient.ObjectKey{
+			Namespace: namespace,
+			Name:      name,
+		},
+		statefulSet,
+	); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return statefulSet, nil
+}
+
+func CreateStatefulSet(client k8sclient.Client, ctx context.Context, namespace, name string, yaml []byte, reference client.Object, scheme *runtime.Scheme) error {
+	decoder := serializer.NewCodecFactory(scheme).UniversalDecoder()
+	statefulSet := &appsv1.StatefulSet{}
+	if err := runtime.DecodeInto(decoder, yaml, statefulSet); err != nil {
+		return fmt.Errorf("   cannot decode statefulset %s/%s: %s", namespace, name, err)
+	}
+	if reference != nil {
+		if err := ctrl.SetControllerReference(reference, statefulSet, scheme); err != nil {
+			return fmt.Errorf("   cannot set owner-reference on statefulset %s/%s: %s", namespace, name, err)
+		}
+	}
+	if err := client.Create(ctx, statefulSet); err != nil {
+		return fmt.Errorf("  failed to create statefulset %s/%s: %s", namespace, name, err)
+	}
+	return nil
+}
+
+func UpdateStatefulSet(client k8sclient.Client, ctx context.Context, namespace, name string, yaml []byte, reference client.Object, scheme *runtime.Scheme) error {
+	decoder := serializer.NewCodecFactory(scheme).UniversalDecoder()
+	statefulSet := &appsv1.StatefulSet{}
+	if err := runtime.DecodeInto(decoder, yaml, statefulSet); err != nil {
+		return fmt.Errorf("   cannot decode statefulset %s/%s: %s", namespace, name, err)
+	}
+	if reference != nil {
+		if err := ctrl.SetControllerReference(reference, statefulSet, scheme); err != nil {
+			return fmt.Errorf("   cannot set owner-reference on statefulset %s/%s: %s", namespace, name, err)
+		}
+	}
+	if err := client.Update(ctx, statefulSet); err != nil {
+		return fmt.Errorf("  failed to update statefulset %s/%s: %s", namespace, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providers
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// BootstrapScript renders a bash script which joins a host with kubelet and containerd
+// installed (kubeadm layout, e.g. kindest/node) as node of the tenant. The kubelet
+// authenticates with the bootstrap token and requests its client certificate itself.
+func BootstrapScript(input *BootstrapInput, endpoint string) ([]byte, error) {
+	if input.Token == "" {
+		return nil, fmt.Errorf("no bootstrap token for node %s", input.NodeName)
+	}
+	if len(input.KubeletConfig) == 0 {
+		return nil, fmt.Errorf("no kubelet config for node %s", input.NodeName)
+	}
+	args := []string{"--hostname-override=" + input.NodeName}
+	if len(input.Taints) > 0 {
+		taints := []string{}
+		for _, taint := range input.Taints {
+			taints = append(taints, taint.ToString())
+		}
+		args = append(args, "--register-with-taints="+strings.Join(taints, ","))
+	}
+
+	t, err := template.New("bootstrap").Parse(bootstrapScriptTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template: %s", err)
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, map[string]any{
+		"Input":         input,
+		"Endpoint":      endpoint,
+		"CACert":        strings.TrimSpace(string(input.CACert)),
+		"KubeletConfig": strings.TrimSpace(string(input.KubeletConfig)),
+		"KubeletArgs":   strings.Join(args, " "),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error executing template: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+const bootstrapScriptTemplate = `#!/bin/bash
+# generated by claio: joins this host as node {{ .Input.NodeName }}
+set -euo pipefail
+
+echo "waiting for containerd ..."
+until systemctl is-active --quiet containerd; do sleep 1; done
+
+mkdir -p /etc/kubernetes/pki /etc/kubernetes/manifests /var/lib/kubelet
+
+cat > /etc/kubernetes/pki/ca.crt <<'EOF'
+{{ .CACert }}
+EOF
+
+cat > /etc/kubernetes/bootstrap-kubelet.conf <<'EOF'
+apiVersion: v1
+kind: Config
+clusters:
+- name: tenant
+  cluster:
+    certificate-authority: /etc/kubernetes/pki/ca.crt
+    server: {{ .Endpoint }}
+contexts:
+- name: tenant
+  context:
+    cluster: tenant
+    user: kubelet-bootstrap
+current-context: tenant
+users:
+- name: kubelet-bootstrap
+  user:
+    token: {{ .Input.Token }}
+EOF
+
+cat > /var/lib/kubelet/config.yaml <<'EOF'
+{{ .KubeletConfig }}
+EOF
+
+cat > /var/lib/kubelet/kubeadm-flags.env <<'EOF'
+KUBELET_KUBEADM_ARGS="{{ .KubeletArgs }}"
+EOF
+
+echo "starting kubelet ..."
+systemctl enable kubelet
+systemctl restart kubelet
+echo "kubelet started, node {{ .Input.NodeName }} is joining {{ .Endpoint }}"
+`
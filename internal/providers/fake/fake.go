@@ -0,0 +1,132 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake implements an in-memory machine provider for tests and development.
+// Hosts only exist as map entries, nothing is provisioned.
+package fake
+
+import (
+	claiov1alpha1 "claio/api/v1alpha1"
+	"claio/internal/providers"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+const Name = "fake"
+
+// Host is the in-memory host of a machine
+type Host struct {
+	State         providers.HostState
+	BootstrapData []byte
+}
+
+type Provider struct {
+	mu    sync.Mutex
+	hosts map[string]*Host
+
+	// Provisioned makes created hosts Ready immediately, otherwise they stay Provisioning
+	// until SetState is called
+	Provisioned bool
+	// CreateError and DeleteError are returned by Create and Delete when set
+	CreateError error
+	DeleteError error
+}
+
+var _ providers.Provider = &Provider{}
+
+func New() *Provider {
+	return &Provider{
+		hosts:       map[string]*Host{},
+		Provisioned: true,
+	}
+}
+
+func key(machine *claiov1alpha1.Machine) string {
+	return machine.Namespace + "/" + machine.Name
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+// BootstrapData returns the input as json
+func (p *Provider) BootstrapData(_ context.Context, _ *claiov1alpha1.Machine, input *providers.BootstrapInput) ([]byte, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling bootstrap data: %s", err)
+	}
+	return data, nil
+}
+
+func (p *Provider) Create(_ context.Context, machine *claiov1alpha1.Machine, bootstrapData []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.CreateError != nil {
+		return p.CreateError
+	}
+	if _, ok := p.hosts[key(machine)]; ok {
+		return nil
+	}
+	state := providers.HostStateProvisioning
+	if p.Provisioned {
+		state = providers.HostStateReady
+	}
+	p.hosts[key(machine)] = &Host{State: state, BootstrapData: bootstrapData}
+	return nil
+}
+
+func (p *Provider) Delete(_ context.Context, machine *claiov1alpha1.Machine) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.DeleteError != nil {
+		return p.DeleteError
+	}
+	delete(p.hosts, key(machine))
+	return nil
+}
+
+func (p *Provider) Status(_ context.Context, machine *claiov1alpha1.Machine) (*providers.HostStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	host, ok := p.hosts[key(machine)]
+	if !ok {
+		return &providers.HostStatus{State: providers.HostStateAbsent}, nil
+	}
+	return &providers.HostStatus{State: host.State}, nil
+}
+
+// Host returns a copy of the host of the machine, nil if there is none
+func (p *Provider) Host(machine *claiov1alpha1.Machine) *Host {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	host, ok := p.hosts[key(machine)]
+	if !ok {
+		return nil
+	}
+	hostCopy := *host
+	return &hostCopy
+}
+
+// SetState changes the state of an existing host
+func (p *Provider) SetState(machine *claiov1alpha1.Machine, state providers.HostState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if host, ok := p.hosts[key(machine)]; ok {
+		host.State = state
+	}
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pod implements a machine provider running nested nodes: the kindest/node image
+// as privileged Pod in the namespace of the Machine. The pod joins the tenant on start
+// with the bootstrap script mounted from a Secret.
+package pod
+
+import (
+	"bytes"
+	claiov1alpha1 "claio/api/v1alpha1"
+	"claio/internal/kubernetes"
+	"claio/internal/providers"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	Name = "pod"
+	// DefaultImage is the node image repository, it is tagged with the version of the machine
+	DefaultImage = "kindest/node"
+	// ParameterImage overrides the complete node image of a machine, its repository must be one of
+	// the Images of the provider
+	ParameterImage = "image"
+)
+
+type Provider struct {
+	Client client.Client
+	Scheme *runtime.Scheme
+	// Images are the node image repositories machines may use, the first one is the default
+	// and tagged with v<version>
+	Images []string
+}
+
+var _ providers.Provider = &Provider{}
+
+func New(rClient client.Client, rScheme *runtime.Scheme) *Provider {
+	return &Provider{
+		Client: rClient,
+		Scheme: rScheme,
+		Images: []string{DefaultImage},
+	}
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+// BootstrapData returns the bootstrap script, the pod reaches the apiserver through the
+// cluster internal service
+func (p *Provider) BootstrapData(_ context.Context, _ *claiov1alpha1.Machine, input *providers.BootstrapInput) ([]byte, error) {
+	return providers.BootstrapScript(input, input.InternalEndpoint)
+}
+
+func (p *Provider) Create(ctx context.Context, machine *claiov1alpha1.Machine, bootstrapData []byte) error {
+	image, err := p.image(machine)
+	if err != nil {
+		return err
+	}
+	// the bootstrap data of an earlier attempt may hold an expired token
+	secretName := bootstrapSecretName(machine)
+	data := map[string][]byte{"bootstrap.sh": bootstrapData}
+	if err := kubernetes.ApplySecret(p.Client, ctx, machine.Namespace, secretName, data, machine, p.Scheme); err != nil {
+		return err
+	}
+
+	pod, err := kubernetes.GetPod(p.Client, ctx, machine.Namespace, machine.Name)
+	if err != nil {
+		return fmt.Errorf("error getting pod %s: %s", machine.Name, err)
+	}
+	if pod != nil {
+		return nil
+	}
+	yaml, err := podYaml(input{
+		Name:      machine.Name,
+		Namespace: machine.Namespace,
+		Image:     image,
+		Secret:    secretName,
+	})
+	if err != nil {
+		return err
+	}
+	return kubernetes.CreatePod(p.Client, ctx, machine.Namespace, machine.Name, yaml, machine, p.Scheme)
+}
+
+func (p *Provider) Delete(ctx context.Context, machine *claiov1alpha1.Machine) error {
+	if err := kubernetes.DeletePod(p.Client, ctx, machine.Namespace, machine.Name); err != nil {
+		return err
+	}
+	return kubernetes.DeleteSecret(p.Client, ctx, machine.Namespace, bootstrapSecretName(machine))
+}
+
+func (p *Provider) Status(ctx context.Context, machine *claiov1alpha1.Machine) (*providers.HostStatus, error) {
+	pod, err := kubernetes.GetPod(p.Client, ctx, machine.Namespace, machine.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting pod %s: %s", machine.Name, err)
+	}
+	switch {
+	case pod == nil:
+		return &providers.HostStatus{State: providers.HostStateAbsent}, nil
+	case !pod.DeletionTimestamp.IsZero():
+		return &providers.HostStatus{State: providers.HostStateDeleting}, nil
+	case pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded:
+		return &providers.HostStatus{
+			State:   providers.HostStateFailed,
+			Message: fmt.Sprintf("pod %s terminated: %s", pod.Name, pod.Status.Message),
+		}, nil
+	case pod.Status.Phase == corev1.PodRunning:
+		return &providers.HostStatus{State: providers.HostStateReady}, nil
+	default:
+		return &providers.HostStatus{State: providers.HostStateProvisioning, Message: string(pod.Status.Phase)}, nil
+	}
+}
+
+// image returns the node image of the machine, the image parameter is only allowed from the
+// repositories of the provider
+func (p *Provider) image(machine *claiov1alpha1.Machine) (string, error) {
+	if machine.Spec.ProviderRef != nil {
+		if image, ok := machine.Spec.ProviderRef.Parameters[ParameterImage]; ok {
+			if !slices.Contains(p.Images, imageRepository(image)) {
+				return "", fmt.Errorf("image %q is not allowed, the repository must be one of %s",
+					image, strings.Join(p.Images, ", "))
+			}
+			return image, nil
+		}
+	}
+	return fmt.Sprintf("%s:v%s", p.Images[0], machine.Spec.Version), nil
+}
+
+// imageRepository strips the digest and the tag of an image, a colon before the last slash
+// belongs to the registry port
+func imageRepository(image string) string {
+	repository, _, _ := strings.Cut(image, "@")
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+	return repository
+}
+
+func bootstrapSecretName(machine *claiov1alpha1.Machine) string {
+	return machine.Name + "-bootstrap"
+}
+
+type input struct {
+	Name      string
+	Namespace string
+	Image     string
+	Secret    string
+}
+
+func podYaml(in input) ([]byte, error) {
+	t, err := template.New("pod").Parse(podTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template: %s", err)
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, in); err != nil {
+		return nil, fmt.Errorf("error executing template: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// the containerd state needs a volume, overlayfs can not be stacked on the overlayfs of the pod
+const podTemplate = `apiVersion: v1
+kind: Pod
+metadata:
+  name: "{{ .Name }}"
+  namespace: {{ .Namespace }}
+  labels:
+    app: claio-node
+    claio.github.com/machine: "{{ .Name }}"
+spec:
+  restartPolicy: Always
+  containers:
+  - name: node
+    image: {{ printf "%q" .Image }}
+    securityContext:
+      privileged: true
+    lifecycle:
+      postStart:
+        exec:
+          command:
+          - /bin/bash
+          - -c
+          - setsid nohup /bin/bash /etc/claio/bootstrap.sh > /var/log/claio-bootstrap.log 2>&1 &
+    volumeMounts:
+    - name: bootstrap
+      mountPath: /etc/claio
+      readOnly: true
+    - name: containerd
+      mountPath: /var/lib/containerd
+    - name: run
+      mountPath: /run
+    - name: tmp
+      mountPath: /tmp
+    - name: modules
+      mountPath: /lib/modules
+      readOnly: true
+  volumes:
+  - name: bootstrap
+    secret:
+      secretName: {{ .Secret }}
+      defaultMode: 0500
+  - name: containerd
+    emptyDir: {}
+  - name: run
+    emptyDir:
+      medium: Memory
+  - name: tmp
+    emptyDir:
+      medium: Memory
+  - name: modules
+    hostPath:
+      path: /lib/modules
+`
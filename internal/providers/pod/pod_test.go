@@ -0,0 +1,111 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	claiov1alpha1 "claio/api/v1alpha1"
+)
+
+var _ = Describe("Pod Provider", func() {
+	var (
+		ctx      = context.Background()
+		provider *Provider
+		machine  *claiov1alpha1.Machine
+	)
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(claiov1alpha1.AddToScheme(scheme)).To(Succeed())
+		machine = &claiov1alpha1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "node01", Namespace: "tenant-test", UID: "node01-uid"},
+			Spec: claiov1alpha1.MachineSpec{
+				ControlPlaneRef: claiov1alpha1.ControlPlaneReference{Name: "test"},
+				Version:         "1.31.1",
+				ProviderRef:     &claiov1alpha1.MachineProviderReference{Name: Name},
+			},
+		}
+		// the fake client cannot apply, applied objects are created or updated
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+		applyClient := interceptor.NewClient(fakeClient, interceptor.Funcs{
+			Patch: func(ctx context.Context, cl client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				if patch.Type() != types.ApplyPatchType {
+					return cl.Patch(ctx, obj, patch, opts...)
+				}
+				current := obj.DeepCopyObject().(client.Object)
+				if err := cl.Get(ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+					return cl.Create(ctx, obj)
+				}
+				obj.SetResourceVersion(current.GetResourceVersion())
+				return cl.Update(ctx, obj)
+			},
+		})
+		provider = New(applyClient, scheme)
+	})
+
+	bootstrapScript := func() string {
+		secret := &corev1.Secret{}
+		Expect(provider.Client.Get(ctx, types.NamespacedName{Name: "node01-bootstrap", Namespace: "tenant-test"}, secret)).To(Succeed())
+		return string(secret.Data["bootstrap.sh"])
+	}
+
+	image := func() string {
+		pod := &corev1.Pod{}
+		Expect(provider.Client.Get(ctx, types.NamespacedName{Name: "node01", Namespace: "tenant-test"}, pod)).To(Succeed())
+		return pod.Spec.Containers[0].Image
+	}
+
+	It("creates the pod with the default image tagged with the version", func() {
+		Expect(provider.Create(ctx, machine, []byte("join"))).To(Succeed())
+		Expect(bootstrapScript()).To(Equal("join"))
+		Expect(image()).To(Equal("kindest/node:v1.31.1"))
+	})
+
+	It("replaces the bootstrap data of an earlier attempt", func() {
+		Expect(provider.Client.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "node01-bootstrap", Namespace: "tenant-test"},
+			Data:       map[string][]byte{"bootstrap.sh": []byte("expired token")},
+		})).To(Succeed())
+		Expect(provider.Create(ctx, machine, []byte("fresh token"))).To(Succeed())
+		Expect(bootstrapScript()).To(Equal("fresh token"))
+	})
+
+	It("only allows images of the configured repositories", func() {
+		provider.Images = []string{"kindest/node", "registry.example.com:5000/nodes/kind"}
+		machine.Spec.ProviderRef.Parameters = map[string]string{ParameterImage: "attacker.example.com/node:latest"}
+		Expect(provider.Create(ctx, machine, []byte("join"))).To(MatchError(ContainSubstring("is not allowed")))
+		machine.Spec.ProviderRef.Parameters[ParameterImage] = "kindest/node/evil:v1.31.1"
+		Expect(provider.Create(ctx, machine, []byte("join"))).To(MatchError(ContainSubstring("is not allowed")))
+
+		machine.Spec.ProviderRef.Parameters[ParameterImage] = "registry.example.com:5000/nodes/kind:v1.31.1"
+		Expect(provider.Create(ctx, machine, []byte("join"))).To(Succeed())
+		Expect(image()).To(Equal("registry.example.com:5000/nodes/kind:v1.31.1"))
+	})
+})
@@ -0,0 +1,83 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providers
+
+import (
+	claiov1alpha1 "claio/api/v1alpha1"
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// HostState is the state of the host behind a Machine as seen by its provider
+type HostState string
+
+const (
+	// HostStateAbsent means the provider has no host for the machine (never created or deleted)
+	HostStateAbsent HostState = "Absent"
+	// HostStateProvisioning means the host is being created
+	HostStateProvisioning HostState = "Provisioning"
+	// HostStateReady means the host is up and has been handed its bootstrap data
+	HostStateReady HostState = "Ready"
+	// HostStateDeleting means the host is being released
+	HostStateDeleting HostState = "Deleting"
+	// HostStateFailed means the provider gave up on the host, see the message
+	HostStateFailed HostState = "Failed"
+)
+
+// HostStatus is the status of the host behind a Machine
+type HostStatus struct {
+	State   HostState
+	Message string
+}
+
+// BootstrapInput is everything a host needs to join the tenant
+type BootstrapInput struct {
+	// NodeName is the name the node registers with
+	NodeName string
+	// Version of the kubelet without leading 'v'
+	Version string
+	// Endpoint is the advertised url of the apiserver of the tenant
+	Endpoint string
+	// InternalEndpoint is the url of the apiserver inside the management cluster, for hosts running there
+	InternalEndpoint string
+	// CACert is the PEM encoded CA of the tenant
+	CACert []byte
+	// Token is the bootstrap token the kubelet authenticates with
+	Token string
+	// KubeletConfig is the KubeletConfiguration of the node
+	KubeletConfig []byte
+	// Taints the node registers with. Labels are applied by the Machine controller after the
+	// node has joined, the kubelet is not allowed to set most of them itself.
+	Taints []corev1.Taint
+}
+
+// Provider provisions the hosts of Machines. All methods are called from the Machine
+// reconciler and must be idempotent, a call may be repeated at any time. The machines
+// passed to BootstrapData and Create have spec.version and spec.nodeName defaulted.
+type Provider interface {
+	// Name is the name Machines select the provider with (spec.providerRef.name)
+	Name() string
+	// BootstrapData renders the provider specific bootstrap data (e.g. a script or cloud-init)
+	BootstrapData(ctx context.Context, machine *claiov1alpha1.Machine, input *BootstrapInput) ([]byte, error)
+	// Create provisions the host of the machine and hands it the bootstrap data
+	Create(ctx context.Context, machine *claiov1alpha1.Machine, bootstrapData []byte) error
+	// Delete releases the host of the machine
+	Delete(ctx context.Context, machine *claiov1alpha1.Machine) error
+	// Status reports the state of the host of the machine
+	Status(ctx context.Context, machine *claiov1alpha1.Machine) (*HostStatus, error)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providers
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry holds the providers known to the manager
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[string]Provider
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		providers: map[string]Provider{},
+	}
+}
+
+// Register adds a provider, names must be unique
+func (r *Registry) Register(provider Provider) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.providers[provider.Name()]; ok {
+		return fmt.Errorf("provider %s is already registered", provider.Name())
+	}
+	r.providers[provider.Name()] = provider
+	return nil
+}
+
+// Get returns the provider with the given name
+func (r *Registry) Get(name string) (Provider, error) {
+	if r == nil {
+		return nil, fmt.Errorf("unknown provider %s (no providers registered)", name)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	provider, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %s (registered: %v)", name, r.names())
+	}
+	return provider, nil
+}
+
+// Names returns the sorted names of all registered providers
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.names()
+}
+
+func (r *Registry) names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
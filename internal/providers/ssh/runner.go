@@ -0,0 +1,131 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Runner executes commands on a host over SSH
+type Runner struct {
+	// Address is host:port of the sshd
+	Address string
+	Config  *ssh.ClientConfig
+}
+
+// Run executes the command with stdin and calls output for every line the command writes
+// to stdout or stderr. The session is closed when the context is done.
+func (r *Runner) Run(ctx context.Context, command string, stdin []byte, output func(line string)) error {
+	dialer := net.Dialer{Timeout: r.Config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", r.Address)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %s", r.Address, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, r.Address, r.Config)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error connecting to %s: %s", r.Address, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("error opening session on %s: %s", r.Address, err)
+	}
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error opening stdout on %s: %s", r.Address, err)
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("error opening stderr on %s: %s", r.Address, err)
+	}
+	session.Stdin = bytes.NewReader(stdin)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	scan := func(reader io.Reader) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			mu.Lock()
+			output(scanner.Text())
+			mu.Unlock()
+		}
+	}
+	wg.Add(2)
+	go scan(stdout)
+	go scan(stderr)
+
+	if err := session.Start(command); err != nil {
+		return fmt.Errorf("error starting command on %s: %s", r.Address, err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		wg.Wait()
+		done <- session.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("command failed on %s: %s", r.Address, err)
+		}
+		return nil
+	case <-ctx.Done():
+		client.Close()
+		return fmt.Errorf("command on %s aborted: %s", r.Address, ctx.Err())
+	}
+}
+
+// newClientConfig creates the client config from a PEM encoded private key. The host key is
+// checked against knownHost (authorized_keys format) unless insecure is set.
+func newClientConfig(user string, privateKey, knownHost []byte, insecure bool, timeout time.Duration) (*ssh.ClientConfig, error) {
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key: %s", err)
+	}
+	config := &ssh.ClientConfig{
+		User:    user,
+		Auth:    []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		Timeout: timeout,
+	}
+	switch {
+	case len(knownHost) > 0:
+		hostKey, _, _, _, err := ssh.ParseAuthorizedKey(knownHost)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing host key: %s", err)
+		}
+		config.HostKeyCallback = ssh.FixedHostKey(hostKey)
+	case insecure:
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, fmt.Errorf("no host key to verify the host with")
+	}
+	return config, nil
+}
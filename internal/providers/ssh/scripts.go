@@ -0,0 +1,102 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssh
+
+// stateDir keeps what claio changed on a host, so delete can reverse it
+const stateDir = "/var/lib/claio"
+
+// installScript installs the kubelet (kubeadm layout) if the host does not have one.
+// containerd has to be installed already.
+const installScript = `#!/bin/bash
+# generated by claio: installs kubelet v{{ .Version }}
+set -euo pipefail
+mkdir -p ` + stateDir + `
+
+if ! command -v containerd > /dev/null; then
+  echo "containerd is not installed"
+  exit 1
+fi
+
+if ! command -v kubelet > /dev/null; then
+  echo "installing kubelet v{{ .Version }} ..."
+  arch=$(uname -m | sed -e 's/x86_64/amd64/' -e 's/aarch64/arm64/')
+  curl -fsSLo /usr/bin/kubelet "https://dl.k8s.io/release/v{{ .Version }}/bin/linux/${arch}/kubelet"
+  chmod +x /usr/bin/kubelet
+  touch ` + stateDir + `/installed-kubelet
+fi
+
+if [ ! -f /etc/systemd/system/kubelet.service ] && [ ! -f /lib/systemd/system/kubelet.service ] && [ ! -f /usr/lib/systemd/system/kubelet.service ]; then
+  echo "installing kubelet service ..."
+  cat > /etc/systemd/system/kubelet.service <<'EOF'
+[Unit]
+Description=kubelet: The Kubernetes Node Agent
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+ExecStart=/usr/bin/kubelet
+Restart=always
+StartLimitInterval=0
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+EOF
+  mkdir -p /etc/systemd/system/kubelet.service.d
+  cat > /etc/systemd/system/kubelet.service.d/10-kubeadm.conf <<'EOF'
+[Service]
+Environment="KUBELET_KUBECONFIG_ARGS=--bootstrap-kubeconfig=/etc/kubernetes/bootstrap-kubelet.conf --kubeconfig=/etc/kubernetes/kubelet.conf"
+Environment="KUBELET_CONFIG_ARGS=--config=/var/lib/kubelet/config.yaml"
+EnvironmentFile=-/var/lib/kubelet/kubeadm-flags.env
+ExecStart=
+ExecStart=/usr/bin/kubelet $KUBELET_KUBECONFIG_ARGS $KUBELET_CONFIG_ARGS $KUBELET_KUBEADM_ARGS
+EOF
+  touch ` + stateDir + `/installed-service
+  systemctl daemon-reload
+fi
+`
+
+// joinedMarker is written after the bootstrap script succeeded
+const joinedMarker = stateDir + "/joined"
+
+// teardownScript reverses the install and bootstrap scripts
+const teardownScript = `#!/bin/bash
+# generated by claio: removes the node from this host
+set -uo pipefail
+
+echo "stopping kubelet ..."
+systemctl disable --now kubelet || true
+
+rm -f /etc/kubernetes/bootstrap-kubelet.conf /etc/kubernetes/kubelet.conf /etc/kubernetes/pki/ca.crt
+rm -f /var/lib/kubelet/config.yaml /var/lib/kubelet/kubeadm-flags.env
+rm -rf /var/lib/kubelet/pki
+
+if [ -f ` + stateDir + `/installed-service ]; then
+  echo "removing kubelet service ..."
+  rm -rf /etc/systemd/system/kubelet.service /etc/systemd/system/kubelet.service.d
+  systemctl daemon-reload
+fi
+if [ -f ` + stateDir + `/installed-kubelet ]; then
+  echo "removing kubelet ..."
+  rm -f /usr/bin/kubelet
+fi
+rm -rf ` + stateDir + `
+echo "node removed"
+`
+
+// statusCommand prints "joined" if the bootstrap script succeeded on the host
+const statusCommand = `test -f ` + joinedMarker + ` && echo joined || true`
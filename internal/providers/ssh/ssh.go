@@ -0,0 +1,282 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ssh implements a machine provider joining existing hosts. It connects with a
+// key from a Secret, installs the kubelet if needed and runs the bootstrap script. The
+// output of the scripts is recorded as events of the Machine.
+package ssh
+
+import (
+	"bytes"
+	claiov1alpha1 "claio/api/v1alpha1"
+	"claio/internal/providers"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	Name = "ssh"
+
+	// ParameterHost is the address of the host (required)
+	ParameterHost = "host"
+	// ParameterPort is the port of sshd, default 22
+	ParameterPort = "port"
+	// ParameterUser is the login, default root. Other users need password-less sudo.
+	ParameterUser = "user"
+	// ParameterSecretName is the Secret (namespace of the Machine) with the private key in
+	// "ssh-privatekey" and the host key in "known_hosts" (required)
+	ParameterSecretName = "secretName"
+	// ParameterInsecureIgnoreHostKey skips the host key check if the Secret has no known_hosts
+	ParameterInsecureIgnoreHostKey = "insecureIgnoreHostKey"
+
+	// SecretKeyKnownHost is the public host key in authorized_keys format
+	SecretKeyKnownHost = "known_hosts"
+
+	// DefaultTimeout limits a single run of a script on the host
+	DefaultTimeout = 15 * time.Minute
+
+	reasonBootstrap = "Bootstrap"
+	reasonTeardown  = "Teardown"
+	reasonFailed    = "BootstrapFailed"
+)
+
+type host struct {
+	status providers.HostStatus
+	cancel context.CancelFunc
+}
+
+type Provider struct {
+	Client   client.Reader
+	Recorder record.EventRecorder
+	Timeout  time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+var _ providers.Provider = &Provider{}
+
+func New(rClient client.Reader, recorder record.EventRecorder) *Provider {
+	return &Provider{
+		Client:   rClient,
+		Recorder: recorder,
+		Timeout:  DefaultTimeout,
+		hosts:    map[string]*host{},
+	}
+}
+
+func key(machine *claiov1alpha1.Machine) string {
+	return machine.Namespace + "/" + machine.Name
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+// BootstrapData returns the install script followed by the bootstrap script
+func (p *Provider) BootstrapData(_ context.Context, _ *claiov1alpha1.Machine, input *providers.BootstrapInput) ([]byte, error) {
+	t, err := template.New("install").Parse(installScript)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template: %s", err)
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, input); err != nil {
+		return nil, fmt.Errorf("error executing template: %s", err)
+	}
+	bootstrap, err := providers.BootstrapScript(input, input.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(bootstrap)
+	buf.WriteString("touch " + joinedMarker + "\n")
+	return buf.Bytes(), nil
+}
+
+// Create runs the bootstrap data on the host in the background, Status reports the outcome
+func (p *Provider) Create(_ context.Context, machine *claiov1alpha1.Machine, bootstrapData []byte) error {
+	runner, err := p.runner(machine)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if h, ok := p.hosts[key(machine)]; ok && h.status.State != providers.HostStateAbsent {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	h := &host{
+		status: providers.HostStatus{State: providers.HostStateProvisioning},
+		cancel: cancel,
+	}
+	p.hosts[key(machine)] = h
+
+	go func() {
+		defer cancel()
+		err := runner.Run(ctx, command(machine), bootstrapData, p.eventer(machine, reasonBootstrap))
+		status := providers.HostStatus{State: providers.HostStateReady}
+		if err != nil {
+			p.Recorder.Event(machine, corev1.EventTypeWarning, reasonFailed, err.Error())
+			status = providers.HostStatus{State: providers.HostStateFailed, Message: err.Error()}
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		// a delete in the meantime replaced the host
+		if p.hosts[key(machine)] == h {
+			h.status = status
+			h.cancel = nil
+		}
+	}()
+	return nil
+}
+
+// Delete runs the teardown script on the host
+func (p *Provider) Delete(_ context.Context, machine *claiov1alpha1.Machine) error {
+	p.mu.Lock()
+	if h, ok := p.hosts[key(machine)]; ok {
+		if h.status.State == providers.HostStateAbsent {
+			p.mu.Unlock()
+			return nil
+		}
+		if h.cancel != nil {
+			h.cancel()
+		}
+	}
+	p.mu.Unlock()
+
+	runner, err := p.runner(machine)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+	if err := runner.Run(ctx, command(machine), []byte(teardownScript), p.eventer(machine, reasonTeardown)); err != nil {
+		return err
+	}
+	p.setStatus(machine, providers.HostStatus{State: providers.HostStateAbsent})
+	return nil
+}
+
+// Status reports the state of the last bootstrap. Hosts unknown to this manager (e.g. after
+// a restart) are asked whether they have joined.
+func (p *Provider) Status(ctx context.Context, machine *claiov1alpha1.Machine) (*providers.HostStatus, error) {
+	p.mu.Lock()
+	h, ok := p.hosts[key(machine)]
+	if ok {
+		status := h.status
+		// a failure is reported once, the next status check asks the host again
+		if status.State == providers.HostStateFailed {
+			delete(p.hosts, key(machine))
+		}
+		p.mu.Unlock()
+		return &status, nil
+	}
+	p.mu.Unlock()
+
+	runner, err := p.runner(machine)
+	if err != nil {
+		return nil, err
+	}
+	output := []string{}
+	err = runner.Run(ctx, statusCommand, nil, func(line string) {
+		output = append(output, line)
+	})
+	if err != nil {
+		return nil, err
+	}
+	status := providers.HostStatus{State: providers.HostStateAbsent}
+	if strings.Contains(strings.Join(output, "\n"), "joined") {
+		status.State = providers.HostStateReady
+	}
+	p.setStatus(machine, status)
+	return &status, nil
+}
+
+func (p *Provider) setStatus(machine *claiov1alpha1.Machine, status providers.HostStatus) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hosts[key(machine)] = &host{status: status}
+}
+
+// eventer records every output line as event of the machine
+func (p *Provider) eventer(machine *claiov1alpha1.Machine, reason string) func(string) {
+	return func(line string) {
+		if strings.TrimSpace(line) != "" {
+			p.Recorder.Event(machine, corev1.EventTypeNormal, reason, line)
+		}
+	}
+}
+
+// command runs the script from stdin, with sudo for users other than root
+func command(machine *claiov1alpha1.Machine) string {
+	if user(machine) == "root" {
+		return "bash -s"
+	}
+	return "sudo -n bash -s"
+}
+
+func parameter(machine *claiov1alpha1.Machine, name, defaultValue string) string {
+	if machine.Spec.ProviderRef != nil {
+		if value, ok := machine.Spec.ProviderRef.Parameters[name]; ok && value != "" {
+			return value
+		}
+	}
+	return defaultValue
+}
+
+func user(machine *claiov1alpha1.Machine) string {
+	return parameter(machine, ParameterUser, "root")
+}
+
+// runner creates the ssh runner from the parameters of the machine and its Secret
+func (p *Provider) runner(machine *claiov1alpha1.Machine) (*Runner, error) {
+	address := parameter(machine, ParameterHost, "")
+	if address == "" {
+		return nil, fmt.Errorf("parameter %s of machine %s is missing", ParameterHost, machine.Name)
+	}
+	secretName := parameter(machine, ParameterSecretName, "")
+	if secretName == "" {
+		return nil, fmt.Errorf("parameter %s of machine %s is missing", ParameterSecretName, machine.Name)
+	}
+
+	secret := &corev1.Secret{}
+	if err := p.Client.Get(context.Background(), types.NamespacedName{Namespace: machine.Namespace, Name: secretName}, secret); err != nil {
+		return nil, fmt.Errorf("error getting secret %s: %s", secretName, err)
+	}
+	privateKey, ok := secret.Data[corev1.SSHAuthPrivateKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no %s", secretName, corev1.SSHAuthPrivateKey)
+	}
+	insecure := parameter(machine, ParameterInsecureIgnoreHostKey, "false") == "true"
+	config, err := newClientConfig(user(machine), privateKey, secret.Data[SecretKeyKnownHost], insecure, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh configuration of machine %s: %s", machine.Name, err)
+	}
+	return &Runner{
+		Address: net.JoinHostPort(address, parameter(machine, ParameterPort, "22")),
+		Config:  config,
+	}, nil
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	claiov1alpha1 "claio/api/v1alpha1"
+	"claio/internal/providers"
+)
+
+// secretReader serves a single Secret
+type secretReader struct {
+	secret *corev1.Secret
+}
+
+func (r *secretReader) Get(_ context.Context, key client.ObjectKey, obj client.Object, _ ...client.GetOption) error {
+	if key.Name != r.secret.Name || key.Namespace != r.secret.Namespace {
+		return apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, key.Name)
+	}
+	r.secret.DeepCopyInto(obj.(*corev1.Secret))
+	return nil
+}
+
+func (r *secretReader) List(_ context.Context, _ client.ObjectList, _ ...client.ListOption) error {
+	return nil
+}
+
+var _ = Describe("SSH Provider", func() {
+	var (
+		ctx      = context.Background()
+		sshd     *sshdStandIn
+		secret   *corev1.Secret
+		recorder *record.FakeRecorder
+		provider *Provider
+		machine  *claiov1alpha1.Machine
+	)
+
+	BeforeEach(func() {
+		clientPublic, clientPrivate, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		authorizedKey, err := ssh.NewPublicKey(clientPublic)
+		Expect(err).NotTo(HaveOccurred())
+		privateKeyPEM, err := ssh.MarshalPrivateKey(clientPrivate, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		sshd, err = newSSHDStandIn(authorizedKey)
+		Expect(err).NotTo(HaveOccurred())
+		host, port := sshd.Addr()
+
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-ssh", Namespace: "tenant-test"},
+			Data: map[string][]byte{
+				corev1.SSHAuthPrivateKey: pem.EncodeToMemory(privateKeyPEM),
+				SecretKeyKnownHost:       ssh.MarshalAuthorizedKey(sshd.HostKey),
+			},
+		}
+		recorder = record.NewFakeRecorder(100)
+		provider = New(&secretReader{secret: secret}, recorder)
+		machine = &claiov1alpha1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "node01", Namespace: "tenant-test"},
+			Spec: claiov1alpha1.MachineSpec{
+				Version:  "1.31.1",
+				NodeName: "node01",
+				ProviderRef: &claiov1alpha1.MachineProviderReference{
+					Name: Name,
+					Parameters: map[string]string{
+						ParameterHost:       host,
+						ParameterPort:       port,
+						ParameterSecretName: secret.Name,
+					},
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		sshd.Close()
+	})
+
+	bootstrapData := func() []byte {
+		data, err := provider.BootstrapData(ctx, machine, &providers.BootstrapInput{
+			NodeName:      "node01",
+			Version:       "1.31.1",
+			Endpoint:      "https://tenant.example.com:6543",
+			CACert:        []byte("-----BEGIN CERTIFICATE-----"),
+			Token:         "abcdef.0123456789abcdef",
+			KubeletConfig: []byte("kind: KubeletConfiguration"),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return data
+	}
+
+	hostState := func() providers.HostState {
+		status, err := provider.Status(ctx, machine)
+		Expect(err).NotTo(HaveOccurred())
+		return status.State
+	}
+
+	It("should report an unknown host as absent", func() {
+		Expect(hostState()).To(Equal(providers.HostStateAbsent))
+		commands, _, _ := sshd.Snapshot()
+		Expect(commands).To(Equal([]string{statusCommand}))
+	})
+
+	It("should run the bootstrap data and stream the output into events", func() {
+		data := bootstrapData()
+		Expect(string(data)).To(ContainSubstring("server: https://tenant.example.com:6543"))
+		Expect(string(data)).To(ContainSubstring("release/v1.31.1/bin/linux"))
+
+		Expect(provider.Create(ctx, machine, data)).To(Succeed())
+		Eventually(hostState).Should(Equal(providers.HostStateReady))
+
+		commands, scripts, joined := sshd.Snapshot()
+		Expect(commands).To(Equal([]string{"bash -s"}))
+		Expect(scripts).To(Equal([]string{string(data)}))
+		Expect(joined).To(BeTrue())
+		Expect(recorder.Events).To(Receive(Equal("Normal Bootstrap waiting for containerd ...")))
+	})
+
+	It("should use sudo for other users", func() {
+		machine.Spec.ProviderRef.Parameters[ParameterUser] = "ubuntu"
+		Expect(provider.Create(ctx, machine, bootstrapData())).To(Succeed())
+		Eventually(hostState).Should(Equal(providers.HostStateReady))
+		commands, _, _ := sshd.Snapshot()
+		Expect(commands).To(Equal([]string{"sudo -n bash -s"}))
+	})
+
+	It("should report a failed bootstrap once and ask the host again", func() {
+		sshd.SetFail(true)
+		Expect(provider.Create(ctx, machine, bootstrapData())).To(Succeed())
+		Eventually(hostState).Should(Equal(providers.HostStateFailed))
+		Expect(hostState()).To(Equal(providers.HostStateAbsent))
+		Expect(recorder.Events).To(Receive(Equal("Normal Bootstrap something went wrong")))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning BootstrapFailed command failed")))
+	})
+
+	It("should reverse the bootstrap on delete", func() {
+		Expect(provider.Create(ctx, machine, bootstrapData())).To(Succeed())
+		Eventually(hostState).Should(Equal(providers.HostStateReady))
+
+		Expect(provider.Delete(ctx, machine)).To(Succeed())
+		Expect(hostState()).To(Equal(providers.HostStateAbsent))
+		_, scripts, joined := sshd.Snapshot()
+		Expect(scripts).To(HaveLen(2))
+		Expect(scripts[1]).To(Equal(teardownScript))
+		Expect(joined).To(BeFalse())
+	})
+
+	It("should refuse a host with an unknown host key", func() {
+		otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		otherKey, err := ssh.NewPublicKey(otherPublic)
+		Expect(err).NotTo(HaveOccurred())
+		secret.Data[SecretKeyKnownHost] = ssh.MarshalAuthorizedKey(otherKey)
+
+		_, err = provider.Status(ctx, machine)
+		Expect(err).To(MatchError(ContainSubstring("host key mismatch")))
+	})
+
+	It("should require a host key unless told otherwise", func() {
+		delete(secret.Data, SecretKeyKnownHost)
+		_, err := provider.Status(ctx, machine)
+		Expect(err).To(MatchError(ContainSubstring("no host key")))
+
+		machine.Spec.ProviderRef.Parameters[ParameterInsecureIgnoreHostKey] = "true"
+		Expect(hostState()).To(Equal(providers.HostStateAbsent))
+	})
+})
@@ -0,0 +1,172 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshdStandIn is a minimal sshd which does not execute anything. It records the commands
+// and scripts it receives and pretends the host has joined after a bootstrap script.
+type sshdStandIn struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	HostKey  ssh.PublicKey
+
+	mu       sync.Mutex
+	Commands []string
+	Scripts  []string
+	Joined   bool
+	// Fail makes every script exit with status 1
+	Fail bool
+}
+
+func newSSHDStandIn(authorizedKey ssh.PublicKey) (*sshdStandIn, error) {
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &sshdStandIn{listener: listener, config: config, HostKey: hostSigner.PublicKey()}
+	go s.serve()
+	return s, nil
+}
+
+func (s *sshdStandIn) Addr() (string, string) {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return host, port
+}
+
+func (s *sshdStandIn) Close() {
+	s.listener.Close()
+}
+
+func (s *sshdStandIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *sshdStandIn) handle(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(channel, requests)
+	}
+}
+
+func (s *sshdStandIn) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			_ = req.Reply(false, nil)
+			return
+		}
+		_ = req.Reply(true, nil)
+		stdin, _ := io.ReadAll(channel)
+		status := s.exec(channel, payload.Command, string(stdin))
+		_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
+
+func (s *sshdStandIn) exec(channel ssh.Channel, command, script string) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Commands = append(s.Commands, command)
+	if command == statusCommand {
+		if s.Joined {
+			fmt.Fprintln(channel, "joined")
+		}
+		return 0
+	}
+	s.Scripts = append(s.Scripts, script)
+	if s.Fail {
+		fmt.Fprintln(channel.Stderr(), "something went wrong")
+		return 1
+	}
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(line, "echo ") {
+			fmt.Fprintln(channel, strings.Trim(strings.TrimPrefix(line, "echo "), `"`))
+		}
+	}
+	switch {
+	case strings.Contains(script, "touch "+joinedMarker):
+		s.Joined = true
+	case strings.Contains(script, "rm -rf "+stateDir):
+		s.Joined = false
+	}
+	return 0
+}
+
+func (s *sshdStandIn) Snapshot() (commands, scripts []string, joined bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.Commands...), append([]string{}, s.Scripts...), s.Joined
+}
+
+func (s *sshdStandIn) SetFail(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Fail = fail
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backups
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/backup"
+	"claio/internal/kubernetes"
+	"claio/internal/resources"
+	"claio/internal/resources/controlplanes"
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type Backup struct {
+	resources.Resource[*claiov1beta1.ControlPlaneBackup]
+	// JobImage runs the backup command
+	JobImage *controlplanes.JobImage
+}
+
+func NewBackup(ctx context.Context, req ctrl.Request, rClient client.Client, rScheme *runtime.Scheme) (*Backup, error) {
+	res := &claiov1beta1.ControlPlaneBackup{}
+	if err := rClient.Get(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace}, res); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return &Backup{
+		Resource: *resources.NewResource("ControlPlaneBackup", ctx, req, rClient, rScheme, res),
+	}, nil
+}
+
+func (b *Backup) Check() (string, error) {
+	b.LogHeader("check control-plane backup (init) ...")
+	if b.Object.ObjectMeta.DeletionTimestamp.IsZero() {
+		if b.Object.Spec.DeletionPolicy == claiov1beta1.DeletionPolicyDelete && !b.HasFinalizer() {
+			b.LogInfo("add finalizer")
+			if err := b.AddFinalizer(); err != nil {
+				return b.STATUS_UP, fmt.Errorf("adding finalizer failed")
+			}
+		}
+		return b.STATUS_UP, nil
+	} else {
+		if b.HasFinalizer() {
+			return b.STATUS_WANTDOWN, nil
+		}
+		return b.STATUS_GOINGDOWN, nil
+	}
+}
+
+// Reconcile runs the job of the backup once and records its result, a completed or failed
+// backup is not touched again. The job stays until the backup is deleted, the archive only
+// with the deletion policy Delete.
+func (b *Backup) Reconcile() (ctrl.Result, error) {
+	mode, err := b.Check()
+	if err != nil {
+		b.LogError(err, "check failed")
+		return ctrl.Result{}, err
+	}
+	b.LogInfo("status: %s", mode)
+	if mode == b.STATUS_WANTDOWN {
+		return b.finalize()
+	}
+	if mode != b.STATUS_UP {
+		return ctrl.Result{}, nil
+	}
+
+	status := &b.Object.Status
+	if status.Phase == claiov1beta1.BackupPhaseCompleted || status.Phase == claiov1beta1.BackupPhaseFailed {
+		b.LogInfo("backup is %s", status.Phase)
+		return ctrl.Result{}, nil
+	}
+	name := jobName("backup", b.Name())
+	job, err := b.GetJob(name)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error getting job %s: %s", name, err)
+	}
+	if job == nil {
+		return b.startJob(name)
+	}
+
+	finished, failed := kubernetes.JobFinished(job)
+	if !finished {
+		b.LogInfo("waiting for job %s", name)
+		return ctrl.Result{RequeueAfter: jobInterval}, nil
+	}
+	result, err := controlplanes.JobResult(b.Client, b.Ctx, b.Namespace(), name, backup.CommandBackup)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	now := metav1.Now()
+	status.CompletionTime = &now
+	if failed != "" {
+		if result.Error != "" {
+			failed = result.Error
+		}
+		b.LogInfo("job %s failed: %s", name, failed)
+		status.Phase = claiov1beta1.BackupPhaseFailed
+		status.Message = fmt.Sprintf("job %s failed: %s", name, failed)
+		return ctrl.Result{}, b.updateStatus()
+	}
+	b.LogInfo("backup of %d keys at revision %d completed", result.Keys, result.Revision)
+	status.Phase = claiov1beta1.BackupPhaseCompleted
+	status.Message = fmt.Sprintf("archive of %d keys written", result.Keys)
+	status.Size = result.Size
+	status.Checksum = result.Checksum
+	status.Revision = result.Revision
+	status.Keys = result.Keys
+	return ctrl.Result{}, b.updateStatus()
+}
+
+// startJob creates the job once the control-plane exists
+func (b *Backup) startJob(name string) (ctrl.Result, error) {
+	controlPlane, err := controlplanes.NewControlPlane(b.Ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+		Namespace: b.Namespace(),
+		Name:      b.Object.Spec.ControlPlaneName,
+	}}, b.Client, b.Scheme)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error getting control-plane %s: %s", b.Object.Spec.ControlPlaneName, err)
+	}
+	if controlPlane == nil {
+		return b.pending(fmt.Sprintf("control-plane %s does not exist", b.Object.Spec.ControlPlaneName))
+	}
+	if !controlPlane.Object.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, b.fail(fmt.Errorf("control-plane %s is being deleted", controlPlane.Object.Name))
+	}
+
+	target := &b.Object.Spec.Target
+	archive := archiveName(target, b.Namespace(), b.Name())
+	var s3Secret map[string][]byte
+	if target.S3 != nil {
+		if s3Secret, err = b.GetSecret(target.S3.SecretRef.Name); err != nil {
+			return ctrl.Result{}, fmt.Errorf("error getting secret %s: %s", target.S3.SecretRef.Name, err)
+		}
+	}
+	job, err := newArchiveJob(b.JobImage, backup.CommandBackup, name, target, archive, s3Secret)
+	if err != nil {
+		return ctrl.Result{}, b.fail(err)
+	}
+	// the volume of a sqlite datastore is used by the running apiserver
+	job.SameNode = true
+	job.Args = append(job.Args, "--prefix="+controlPlane.EtcdPrefix(), "--control-plane="+controlPlane.Object.Name)
+	jobYaml, err := controlPlane.KineJobYaml(job)
+	if err != nil {
+		return b.pending(err.Error())
+	}
+	b.LogInfo("start job %s", name)
+	if err := b.CreateJob(name, jobYaml); err != nil {
+		return ctrl.Result{}, err
+	}
+	now := metav1.Now()
+	b.Object.Status.Phase = claiov1beta1.BackupPhaseRunning
+	b.Object.Status.Message = fmt.Sprintf("job %s started", name)
+	b.Object.Status.Archive = archive
+	b.Object.Status.StartTime = &now
+	return ctrl.Result{RequeueAfter: jobInterval}, b.updateStatus()
+}
+
+// finalize deletes the archive with a job if the deletion policy is Delete. A failed job is
+// reported in the status and blocks the deletion, it is retried when the job is deleted.
+func (b *Backup) finalize() (ctrl.Result, error) {
+	b.LogHeader("check control-plane backup (finalize) ...")
+	if b.Object.Spec.DeletionPolicy == claiov1beta1.DeletionPolicyDelete && b.Object.Status.Archive != "" {
+		backupJob, err := b.GetJob(jobName("backup", b.Name()))
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error getting job of backup: %s", err)
+		}
+		if backupJob != nil {
+			if finished, _ := kubernetes.JobFinished(backupJob); !finished {
+				b.LogInfo("waiting for job %s before deleting the archive", backupJob.Name)
+				return ctrl.Result{RequeueAfter: jobInterval}, nil
+			}
+		}
+
+		name := jobName("delete", b.Name())
+		job, err := b.GetJob(name)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error getting job %s: %s", name, err)
+		}
+		if job == nil {
+			return b.startDeleteJob(name)
+		}
+		finished, failed := kubernetes.JobFinished(job)
+		if !finished {
+			b.LogInfo("waiting for job %s", name)
+			return ctrl.Result{RequeueAfter: jobInterval}, nil
+		}
+		if failed != "" {
+			result, err := controlplanes.JobResult(b.Client, b.Ctx, b.Namespace(), name, backup.CommandDelete)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if result.Error != "" {
+				failed = result.Error
+			}
+			b.LogInfo("job %s failed: %s", name, failed)
+			b.Object.Status.Message = fmt.Sprintf("job %s failed to delete the archive: %s", name, failed)
+			return ctrl.Result{}, b.updateStatus()
+		}
+		b.LogInfo("archive %s deleted", b.Object.Status.Archive)
+	}
+	b.LogInfo("remove finalizer")
+	if err := b.RemoveFinalizer(); err != nil {
+		b.LogError(err, "failed to remove finalizer")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// startDeleteJob creates the job which deletes the archive and its checksum file
+func (b *Backup) startDeleteJob(name string) (ctrl.Result, error) {
+	target := &b.Object.Spec.Target
+	var s3Secret map[string][]byte
+	var err error
+	if target.S3 != nil {
+		if s3Secret, err = b.GetSecret(target.S3.SecretRef.Name); err != nil {
+			return ctrl.Result{}, fmt.Errorf("error getting secret %s: %s", target.S3.SecretRef.Name, err)
+		}
+	}
+	job, err := newArchiveJob(b.JobImage, backup.CommandDelete, name, target, b.Object.Status.Archive, s3Secret)
+	if err != nil {
+		b.LogInfo("cannot delete the archive: %s", err)
+		b.Object.Status.Message = fmt.Sprintf("cannot delete the archive: %s", err)
+		return ctrl.Result{RequeueAfter: pendingInterval}, b.updateStatus()
+	}
+	jobYaml, err := b.ToYaml(archiveJobTemplate, &archiveJobValues{KineJob: job, Namespace: b.Namespace()})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error generating yaml: %s", err)
+	}
+	b.LogInfo("start job %s", name)
+	if err := b.CreateJob(name, jobYaml); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: jobInterval}, nil
+}
+
+// pending records why the job cannot start yet
+func (b *Backup) pending(message string) (ctrl.Result, error) {
+	b.LogInfo("pending: %s", message)
+	b.Object.Status.Phase = claiov1beta1.BackupPhasePending
+	b.Object.Status.Message = message
+	return ctrl.Result{RequeueAfter: pendingInterval}, b.updateStatus()
+}
+
+// fail ends the backup, it is not retried
+func (b *Backup) fail(err error) error {
+	b.LogError(err, "backup failed")
+	now := metav1.Now()
+	b.Object.Status.Phase = claiov1beta1.BackupPhaseFailed
+	b.Object.Status.Message = err.Error()
+	b.Object.Status.CompletionTime = &now
+	return b.updateStatus()
+}
+
+func (b *Backup) updateStatus() error {
+	if err := b.Client.Status().Update(b.Ctx, b.Object); err != nil {
+		b.LogError(err, "failed to update status")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backups
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/backup"
+	"claio/internal/resources/controlplanes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"time"
+)
+
+const (
+	// jobInterval is how often a running job is checked
+	jobInterval = 10 * time.Second
+	// pendingInterval is how often a pending backup or restore checks its preconditions
+	pendingInterval = 30 * time.Second
+
+	archivesPath = "/archives"
+	s3CAPath     = "/etc/claio/s3"
+)
+
+// jobName derives the name of a job from the name of the backup or the restore, names which
+// are too long for the job-name label of the pods are shortened with a hash
+func jobName(prefix, name string) string {
+	jobName := prefix + "-" + name
+	if len(jobName) <= 63 {
+		return jobName
+	}
+	hash := sha256.Sum256([]byte(name))
+	return jobName[:52] + "-" + hex.EncodeToString(hash[:])[:10]
+}
+
+// archiveName is the path of the archive of a backup on the target
+func archiveName(target *claiov1beta1.BackupTarget, namespace, name string) string {
+	if target.Volume != nil {
+		return path.Join(target.Volume.Path, name+".tar.gz")
+	}
+	return target.S3.Prefix + namespace + "/" + name + ".tar.gz"
+}
+
+// newArchiveJob is a job with a command of the archive on the target, the backup and the
+// restore job run next to kine (controlplanes.KineJobYaml), the delete job on its own
+// (archiveJobTemplate)
+func newArchiveJob(image *controlplanes.JobImage, command, name string, target *claiov1beta1.BackupTarget, archive string,
+	s3Secret map[string][]byte) (*controlplanes.KineJob, error) {
+	job, err := image.NewKineJob(name, command)
+	if err != nil {
+		return nil, err
+	}
+	if target.Volume != nil {
+		job.Args = append(job.Args, "--file="+path.Join(archivesPath, archive))
+		job.Volumes = append(job.Volumes, controlplanes.KineJobVolume{
+			Name:      "archives",
+			ClaimName: target.Volume.ClaimName,
+			MountPath: archivesPath,
+		})
+		return job, nil
+	}
+
+	s3 := target.S3
+	if s3Secret == nil {
+		return nil, fmt.Errorf("secret %s of the s3 target does not exist", s3.SecretRef.Name)
+	}
+	for _, key := range []string{claiov1beta1.BackupSecretKeyAccessKeyID, claiov1beta1.BackupSecretKeySecretAccessKey} {
+		if len(s3Secret[key]) == 0 {
+			return nil, fmt.Errorf("secret %s of the s3 target has no %s", s3.SecretRef.Name, key)
+		}
+	}
+	region := s3.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	job.Args = append(job.Args,
+		"--s3-endpoint="+s3.Endpoint,
+		"--s3-bucket="+s3.Bucket,
+		"--s3-region="+region,
+		"--s3-key="+archive,
+	)
+	job.Env = []controlplanes.KineJobEnv{
+		{Name: backup.EnvAccessKeyID, SecretName: s3.SecretRef.Name, Key: claiov1beta1.BackupSecretKeyAccessKeyID},
+		{Name: backup.EnvSecretAccessKey, SecretName: s3.SecretRef.Name, Key: claiov1beta1.BackupSecretKeySecretAccessKey},
+	}
+	if len(s3Secret[claiov1beta1.DatastoreSecretKeyCACert]) > 0 {
+		job.Args = append(job.Args, "--s3-ca-file="+path.Join(s3CAPath, claiov1beta1.DatastoreSecretKeyCACert))
+		job.Volumes = append(job.Volumes, controlplanes.KineJobVolume{
+			Name:       "s3-ca",
+			SecretName: s3.SecretRef.Name,
+			MountPath:  s3CAPath,
+		})
+	}
+	return job, nil
+}
+
+// archiveJobValues are the values of the archive job template
+type archiveJobValues struct {
+	*controlplanes.KineJob
+	Namespace string
+}
+
+// archiveJobTemplate runs a command of the manager without kine
+const archiveJobTemplate = `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+  labels:
+    {{- range $key, $value := .Labels }}
+    {{ $key }}: {{ $value }}
+    {{- end }}
+spec:
+  backoffLimit: 0
+  template:
+    metadata:
+      labels:
+        {{- range $key, $value := .Labels }}
+        {{ $key }}: {{ $value }}
+        {{- end }}
+    spec:
+      restartPolicy: Never
+      {{- with .FSGroup }}
+      securityContext:
+        fsGroup: {{ . }}
+      {{- end }}
+      containers:
+        - name: {{ .Container }}
+          image: {{ .Image }}
+          {{- with .Command }}
+          command:
+            {{- range . }}
+            - {{ . }}
+            {{- end }}
+          {{- end }}
+          args:
+            {{- range .Args }}
+            - {{ printf "%q" . }}
+            {{- end }}
+          {{- with .Env }}
+          env:
+            {{- range . }}
+            - name: {{ .Name }}
+              valueFrom:
+                secretKeyRef:
+                  name: {{ .SecretName }}
+                  key: {{ .Key }}
+            {{- end }}
+          {{- end }}
+          {{- with .Volumes }}
+          volumeMounts:
+            {{- range . }}
+            - name: {{ .Name }}
+              mountPath: {{ .MountPath }}
+              {{- if .SecretName }}
+              readOnly: true
+              {{- end }}
+            {{- end }}
+          {{- end }}
+      {{- with .Volumes }}
+      volumes:
+        {{- range . }}
+        - name: {{ .Name }}
+          {{- if .ClaimName }}
+          persistentVolumeClaim:
+            claimName: {{ .ClaimName }}
+          {{- else }}
+          secret:
+            secretName: {{ .SecretName }}
+          {{- end }}
+        {{- end }}
+      {{- end }}
+`
@@ -0,0 +1,320 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backups
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/backup"
+	"claio/internal/kubernetes"
+	"claio/internal/resources"
+	"claio/internal/resources/controlplanes"
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type Restore struct {
+	resources.Resource[*claiov1beta1.ControlPlaneRestore]
+	// JobImage runs the restore command
+	JobImage *controlplanes.JobImage
+}
+
+func NewRestore(ctx context.Context, req ctrl.Request, rClient client.Client, rScheme *runtime.Scheme) (*Restore, error) {
+	res := &claiov1beta1.ControlPlaneRestore{}
+	if err := rClient.Get(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace}, res); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return &Restore{
+		Resource: *resources.NewResource("ControlPlaneRestore", ctx, req, rClient, rScheme, res),
+	}, nil
+}
+
+func (r *Restore) Check() (string, error) {
+	r.LogHeader("check control-plane restore (init) ...")
+	if r.Object.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !r.HasFinalizer() {
+			r.LogInfo("add finalizer")
+			if err := r.AddFinalizer(); err != nil {
+				return r.STATUS_UP, fmt.Errorf("adding finalizer failed")
+			}
+		}
+		return r.STATUS_UP, nil
+	} else {
+		if r.HasFinalizer() {
+			return r.STATUS_WANTDOWN, nil
+		}
+		return r.STATUS_GOINGDOWN, nil
+	}
+}
+
+// Reconcile pauses the control-plane, replaces its keys by the keys of the archive with a job
+// and resumes it. A failed restore keeps the control-plane paused, its keys may be gone
+// already, until the restore is deleted. The finalizer resumes the control-plane.
+func (r *Restore) Reconcile() (ctrl.Result, error) {
+	status, err := r.Check()
+	if err != nil {
+		r.LogError(err, "check failed")
+		return ctrl.Result{}, err
+	}
+	r.LogInfo("status: %s", status)
+
+	if status == r.STATUS_WANTDOWN {
+		r.LogHeader("check control-plane restore (finalize) ...")
+		if err := r.resume(); err != nil {
+			r.LogError(err, "failed to resume control-plane")
+			return ctrl.Result{}, err
+		}
+		r.LogInfo("remove finalizer")
+		if err := r.RemoveFinalizer(); err != nil {
+			r.LogError(err, "failed to remove finalizer")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	if status != r.STATUS_UP {
+		return ctrl.Result{}, nil
+	}
+	phase := r.Object.Status.Phase
+	if phase == claiov1beta1.BackupPhaseCompleted || phase == claiov1beta1.BackupPhaseFailed {
+		r.LogInfo("restore is %s", phase)
+		return ctrl.Result{}, nil
+	}
+
+	name := jobName("restore", r.Name())
+	job, err := r.GetJob(name)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error getting job %s: %s", name, err)
+	}
+	if job == nil {
+		return r.startJob(name)
+	}
+
+	finished, failed := kubernetes.JobFinished(job)
+	if !finished {
+		r.LogInfo("waiting for job %s", name)
+		return ctrl.Result{RequeueAfter: jobInterval}, nil
+	}
+	result, err := controlplanes.JobResult(r.Client, r.Ctx, r.Namespace(), name, backup.CommandRestore)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if failed != "" {
+		if result.Error != "" {
+			failed = result.Error
+		}
+		return ctrl.Result{}, r.fail(fmt.Errorf("job %s failed: %s", name, failed))
+	}
+	if err := r.resume(); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.LogInfo("restore of %d keys completed", result.Keys)
+	now := metav1.Now()
+	r.Object.Status.Phase = claiov1beta1.BackupPhaseCompleted
+	r.Object.Status.Message = fmt.Sprintf("%d keys restored, control-plane resumed", result.Keys)
+	r.Object.Status.Keys = result.Keys
+	r.Object.Status.Revision = result.Revision
+	r.Object.Status.CompletionTime = &now
+	return ctrl.Result{}, r.updateStatus()
+}
+
+// startJob waits for the archive and the control-plane, pauses the control-plane and creates
+// the job once the deployment has no pods anymore
+func (r *Restore) startJob(name string) (ctrl.Result, error) {
+	target, archive, checksum, message, err := r.source()
+	if err != nil {
+		return ctrl.Result{}, r.fail(err)
+	}
+	if message != "" {
+		return r.pending(message)
+	}
+	controlPlane, message, err := r.controlPlane()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if message != "" {
+		return r.pending(message)
+	}
+
+	if paused := controlPlane.Object.Annotations[claiov1beta1.RestoreAnnotation]; paused != r.Name() {
+		if paused != "" {
+			return r.pending(fmt.Sprintf("control-plane %s is restored by %s", controlPlane.Object.Name, paused))
+		}
+		r.LogInfo("pause control-plane %s", controlPlane.Object.Name)
+		if controlPlane.Object.Annotations == nil {
+			controlPlane.Object.Annotations = map[string]string{}
+		}
+		controlPlane.Object.Annotations[claiov1beta1.RestoreAnnotation] = r.Name()
+		if err := r.Client.Update(r.Ctx, controlPlane.Object); err != nil {
+			return ctrl.Result{}, fmt.Errorf("error pausing control-plane %s: %s", controlPlane.Object.Name, err)
+		}
+		now := metav1.Now()
+		r.Object.Status.StartTime = &now
+	}
+	deployment, err := controlPlane.GetClaioDeployment()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if deployment != nil && deployment.Status.Replicas > 0 {
+		r.Object.Status.Phase = claiov1beta1.BackupPhaseRunning
+		r.Object.Status.Message = fmt.Sprintf("waiting for control-plane %s to stop", controlPlane.Object.Name)
+		return ctrl.Result{RequeueAfter: jobInterval}, r.updateStatus()
+	}
+
+	var s3Secret map[string][]byte
+	if target.S3 != nil {
+		if s3Secret, err = r.GetSecret(target.S3.SecretRef.Name); err != nil {
+			return ctrl.Result{}, fmt.Errorf("error getting secret %s: %s", target.S3.SecretRef.Name, err)
+		}
+	}
+	job, err := newArchiveJob(r.JobImage, backup.CommandRestore, name, target, archive, s3Secret)
+	if err != nil {
+		return ctrl.Result{}, r.fail(err)
+	}
+	job.Args = append(job.Args, "--prefix="+controlPlane.EtcdPrefix())
+	if checksum != "" {
+		job.Args = append(job.Args, "--checksum="+checksum)
+	}
+	jobYaml, err := controlPlane.KineJobYaml(job)
+	if err != nil {
+		return ctrl.Result{}, r.fail(err)
+	}
+	r.LogInfo("start job %s", name)
+	if err := r.CreateJob(name, jobYaml); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.Object.Status.Phase = claiov1beta1.BackupPhaseRunning
+	r.Object.Status.Message = fmt.Sprintf("job %s started", name)
+	return ctrl.Result{RequeueAfter: jobInterval}, r.updateStatus()
+}
+
+// source returns the target, the archive and its checksum, or a message why they are not
+// available yet
+func (r *Restore) source() (*claiov1beta1.BackupTarget, string, string, string, error) {
+	source := &r.Object.Spec.Source
+	if source.BackupName == "" {
+		return source.Target, source.Archive, source.Checksum, "", nil
+	}
+	backup := &claiov1beta1.ControlPlaneBackup{}
+	if err := r.Client.Get(r.Ctx, types.NamespacedName{Namespace: r.Namespace(), Name: source.BackupName}, backup); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return nil, "", "", "", fmt.Errorf("error getting backup %s: %s", source.BackupName, err)
+		}
+		return nil, "", "", fmt.Sprintf("backup %s does not exist", source.BackupName), nil
+	}
+	switch backup.Status.Phase {
+	case claiov1beta1.BackupPhaseCompleted:
+		return &backup.Spec.Target, backup.Status.Archive, backup.Status.Checksum, "", nil
+	case claiov1beta1.BackupPhaseFailed:
+		return nil, "", "", "", fmt.Errorf("backup %s failed", source.BackupName)
+	}
+	return nil, "", "", fmt.Sprintf("waiting for backup %s to complete", source.BackupName), nil
+}
+
+// controlPlane returns the control-plane once its datastore is ready, it is created from the
+// spec of the restore if it does not exist
+func (r *Restore) controlPlane() (*controlplanes.ControlPlane, string, error) {
+	name := r.Object.Spec.ControlPlaneName
+	controlPlane, err := controlplanes.NewControlPlane(r.Ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+		Namespace: r.Namespace(),
+		Name:      name,
+	}}, r.Client, r.Scheme)
+	if err != nil {
+		return nil, "", fmt.Errorf("error getting control-plane %s: %s", name, err)
+	}
+	if controlPlane == nil {
+		if r.Object.Spec.ControlPlane == nil {
+			return nil, fmt.Sprintf("control-plane %s does not exist", name), nil
+		}
+		r.LogInfo("create control-plane %s", name)
+		// the control-plane starts paused, the apiserver must not initialize the tenant
+		if err := r.Client.Create(r.Ctx, &claiov1beta1.ControlPlane{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   r.Namespace(),
+				Annotations: map[string]string{claiov1beta1.RestoreAnnotation: r.Name()},
+			},
+			Spec: *r.Object.Spec.ControlPlane,
+		}); err != nil {
+			return nil, "", fmt.Errorf("error creating control-plane %s: %s", name, err)
+		}
+		now := metav1.Now()
+		r.Object.Status.StartTime = &now
+		return nil, fmt.Sprintf("control-plane %s created", name), nil
+	}
+	if !controlPlane.Object.DeletionTimestamp.IsZero() {
+		return nil, fmt.Sprintf("control-plane %s is being deleted", name), nil
+	}
+	if !meta.IsStatusConditionTrue(controlPlane.Object.Status.Conditions, claiov1beta1.ConditionDatastoreReady) {
+		return nil, fmt.Sprintf("waiting for the datastore of control-plane %s", name), nil
+	}
+	return controlPlane, "", nil
+}
+
+// resume removes the annotation of the restore from the control-plane
+func (r *Restore) resume() error {
+	controlPlane := &claiov1beta1.ControlPlane{}
+	err := r.Client.Get(r.Ctx, types.NamespacedName{Namespace: r.Namespace(), Name: r.Object.Spec.ControlPlaneName}, controlPlane)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if controlPlane.Annotations[claiov1beta1.RestoreAnnotation] != r.Name() {
+		return nil
+	}
+	r.LogInfo("resume control-plane %s", controlPlane.Name)
+	delete(controlPlane.Annotations, claiov1beta1.RestoreAnnotation)
+	if err := r.Client.Update(r.Ctx, controlPlane); err != nil {
+		return fmt.Errorf("error resuming control-plane %s: %s", controlPlane.Name, err)
+	}
+	return nil
+}
+
+// pending records why the job cannot start yet
+func (r *Restore) pending(message string) (ctrl.Result, error) {
+	r.LogInfo("pending: %s", message)
+	if r.Object.Status.Phase == "" {
+		r.Object.Status.Phase = claiov1beta1.BackupPhasePending
+	}
+	r.Object.Status.Message = message
+	return ctrl.Result{RequeueAfter: pendingInterval}, r.updateStatus()
+}
+
+// fail ends the restore, it is not retried
+func (r *Restore) fail(err error) error {
+	r.LogError(err, "restore failed")
+	now := metav1.Now()
+	r.Object.Status.Phase = claiov1beta1.BackupPhaseFailed
+	r.Object.Status.Message = err.Error()
+	if r.Object.Status.StartTime != nil {
+		r.Object.Status.Message += ", the control-plane stays paused until the restore is deleted"
+	}
+	r.Object.Status.CompletionTime = &now
+	return r.updateStatus()
+}
+
+func (r *Restore) updateStatus() error {
+	if err := r.Client.Status().Update(r.Ctx, r.Object); err != nil {
+		r.LogError(err, "failed to update status")
+		return err
+	}
+	return nil
+}
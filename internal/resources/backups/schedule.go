@@ -0,0 +1,295 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backups
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/backup"
+	"claio/internal/resources"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// defaultKeepLast is the retention of a schedule without rules
+	defaultKeepLast = 7
+	// maxMissedRuns bounds the search for the latest missed time of a schedule
+	maxMissedRuns = 100000
+
+	// condition reasons of BackupSucceeded
+	reasonBackupCompleted = "BackupCompleted"
+	reasonBackupFailed    = "BackupFailed"
+)
+
+type Schedule struct {
+	resources.Resource[*claiov1beta1.ControlPlaneBackupSchedule]
+}
+
+func NewSchedule(ctx context.Context, req ctrl.Request, rClient client.Client, rScheme *runtime.Scheme) (*Schedule, error) {
+	res := &claiov1beta1.ControlPlaneBackupSchedule{}
+	if err := rClient.Get(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace}, res); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return &Schedule{
+		Resource: *resources.NewResource("ControlPlaneBackupSchedule", ctx, req, rClient, rScheme, res),
+	}, nil
+}
+
+// Reconcile creates the backup of the latest due time of the schedule, only one backup of a
+// schedule runs at a time and missed times are skipped. The completed backups the retention
+// does not keep are deleted with their archives (deletion policy Delete), failed backups once
+// a newer backup has completed. The backups are not owned by the schedule, they stay when it
+// is deleted.
+func (s *Schedule) Reconcile() (ctrl.Result, error) {
+	s.LogHeader("check control-plane backup schedule ...")
+	status := &s.Object.Status
+	schedule, err := backup.ParseSchedule(s.Object.Spec.Schedule)
+	if err != nil {
+		s.LogInfo("invalid schedule: %s", err)
+		status.Message = err.Error()
+		status.NextScheduleTime = nil
+		return ctrl.Result{}, s.updateStatus()
+	}
+	backupList, err := s.backups()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := time.Now()
+	last := s.Object.CreationTimestamp.Time
+	if status.LastScheduleTime != nil {
+		last = status.LastScheduleTime.Time
+	}
+	due, next := time.Time{}, schedule.Next(last)
+	for i := 0; !next.IsZero() && !next.After(now) && i < maxMissedRuns; i++ {
+		due, next = next, schedule.Next(next)
+	}
+	status.Message = ""
+	if !due.IsZero() {
+		created, err := s.createBackup(due, backupList)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if created != nil {
+			backupList = append(backupList, *created)
+		}
+	}
+
+	if err := s.prune(backupList); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := s.reportLastBackup(); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	result := ctrl.Result{}
+	if next.IsZero() {
+		status.NextScheduleTime = nil
+		status.Message = "the schedule has no further times"
+	} else {
+		status.NextScheduleTime = &metav1.Time{Time: next}
+		result.RequeueAfter = time.Until(next) + time.Second
+	}
+	return result, s.updateStatus()
+}
+
+// backups are the ControlPlaneBackups of the schedule, the oldest first
+func (s *Schedule) backups() ([]claiov1beta1.ControlPlaneBackup, error) {
+	backupList := &claiov1beta1.ControlPlaneBackupList{}
+	if err := s.Client.List(s.Ctx, backupList, client.InNamespace(s.Namespace()),
+		client.MatchingLabels{claiov1beta1.BackupScheduleLabel: s.Name()}); err != nil {
+		return nil, fmt.Errorf("error listing backups: %s", err)
+	}
+	sort.SliceStable(backupList.Items, func(i, j int) bool {
+		return backupList.Items[i].CreationTimestamp.Before(&backupList.Items[j].CreationTimestamp)
+	})
+	return backupList.Items, nil
+}
+
+// createBackup creates the backup of the due time unless the schedule is suspended, the
+// control-plane is missing or another backup of the schedule still runs
+func (s *Schedule) createBackup(due time.Time, backupList []claiov1beta1.ControlPlaneBackup) (*claiov1beta1.ControlPlaneBackup, error) {
+	spec := &s.Object.Spec
+	if spec.Suspend {
+		s.LogInfo("schedule is suspended")
+		s.Object.Status.Message = "the schedule is suspended"
+		return nil, nil
+	}
+	controlPlane := &claiov1beta1.ControlPlane{}
+	err := s.Client.Get(s.Ctx, types.NamespacedName{Namespace: s.Namespace(), Name: spec.ControlPlaneName}, controlPlane)
+	if errors.IsNotFound(err) {
+		s.LogInfo("control-plane %s does not exist", spec.ControlPlaneName)
+		s.Object.Status.Message = fmt.Sprintf("control-plane %s does not exist", spec.ControlPlaneName)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting control-plane %s: %s", spec.ControlPlaneName, err)
+	}
+
+	s.Object.Status.LastScheduleTime = &metav1.Time{Time: due}
+	for _, item := range backupList {
+		if item.Status.Phase != claiov1beta1.BackupPhaseCompleted && item.Status.Phase != claiov1beta1.BackupPhaseFailed {
+			s.LogInfo("skip backup of %s, backup %s still runs", due.UTC().Format(time.RFC3339), item.Name)
+			s.Object.Status.Message = fmt.Sprintf("skipped the backup of %s, backup %s still runs",
+				due.UTC().Format(time.RFC3339), item.Name)
+			return nil, nil
+		}
+	}
+
+	created := &claiov1beta1.ControlPlaneBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", s.Name(), due.UTC().Format("200601021504")),
+			Namespace: s.Namespace(),
+			Labels:    map[string]string{claiov1beta1.BackupScheduleLabel: s.Name()},
+		},
+		Spec: claiov1beta1.ControlPlaneBackupSpec{
+			ControlPlaneName: spec.ControlPlaneName,
+			Target:           *spec.Target.DeepCopy(),
+			DeletionPolicy:   claiov1beta1.DeletionPolicyDelete,
+		},
+	}
+	s.LogInfo("create backup %s", created.Name)
+	if err := s.Client.Create(s.Ctx, created); err != nil {
+		if errors.IsAlreadyExists(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error creating backup %s: %s", created.Name, err)
+	}
+	return created, nil
+}
+
+// prune deletes the completed backups the retention does not keep and the failed backups
+// older than the newest completed backup
+func (s *Schedule) prune(backupList []claiov1beta1.ControlPlaneBackup) error {
+	retention := backup.Retention{
+		KeepLast:   int(s.Object.Spec.Retention.KeepLast),
+		KeepDaily:  int(s.Object.Spec.Retention.KeepDaily),
+		KeepWeekly: int(s.Object.Spec.Retention.KeepWeekly),
+	}
+	if retention == (backup.Retention{}) {
+		retention.KeepLast = defaultKeepLast
+	}
+	completed := []*claiov1beta1.ControlPlaneBackup{}
+	times := []time.Time{}
+	var newestCompleted time.Time
+	for i := range backupList {
+		item := &backupList[i]
+		if item.Status.Phase == claiov1beta1.BackupPhaseCompleted && item.DeletionTimestamp.IsZero() {
+			completed = append(completed, item)
+			times = append(times, item.CreationTimestamp.Time)
+			newestCompleted = item.CreationTimestamp.Time
+		}
+	}
+
+	prune := []*claiov1beta1.ControlPlaneBackup{}
+	kept := int32(0)
+	for i, keep := range retention.Retain(times) {
+		if keep {
+			kept++
+		} else {
+			prune = append(prune, completed[i])
+		}
+	}
+	for i := range backupList {
+		item := &backupList[i]
+		if item.Status.Phase == claiov1beta1.BackupPhaseFailed && item.DeletionTimestamp.IsZero() &&
+			item.CreationTimestamp.Time.Before(newestCompleted) {
+			prune = append(prune, item)
+		}
+	}
+	for _, item := range prune {
+		s.LogInfo("prune backup %s", item.Name)
+		if err := s.Client.Delete(s.Ctx, item); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("error deleting backup %s: %s", item.Name, err)
+		}
+	}
+	s.Object.Status.Completed = kept
+	return nil
+}
+
+// reportLastBackup records the newest backup in the status of the schedule and the result of
+// the newest finished backup in the condition BackupSucceeded of the control-plane
+func (s *Schedule) reportLastBackup() error {
+	backupList, err := s.backups()
+	if err != nil {
+		return err
+	}
+	status := &s.Object.Status
+	var finished *claiov1beta1.ControlPlaneBackup
+	for i := range backupList {
+		item := &backupList[i]
+		if !item.DeletionTimestamp.IsZero() {
+			continue
+		}
+		status.LastBackup = item.Name
+		status.LastBackupPhase = item.Status.Phase
+		switch item.Status.Phase {
+		case claiov1beta1.BackupPhaseCompleted:
+			status.LastSuccessfulTime = &metav1.Time{Time: item.CreationTimestamp.Time}
+			finished = item
+		case claiov1beta1.BackupPhaseFailed:
+			finished = item
+		}
+	}
+	if finished == nil {
+		return nil
+	}
+
+	controlPlane := &claiov1beta1.ControlPlane{}
+	err = s.Client.Get(s.Ctx, types.NamespacedName{Namespace: s.Namespace(), Name: s.Object.Spec.ControlPlaneName}, controlPlane)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	condition := metav1.Condition{
+		Type:               claiov1beta1.ConditionBackupSucceeded,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonBackupCompleted,
+		Message:            fmt.Sprintf("backup %s completed", finished.Name),
+		ObservedGeneration: controlPlane.Generation,
+	}
+	if finished.Status.Phase == claiov1beta1.BackupPhaseFailed {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonBackupFailed
+		condition.Message = fmt.Sprintf("backup %s failed: %s", finished.Name, finished.Status.Message)
+	}
+	if !meta.SetStatusCondition(&controlPlane.Status.Conditions, condition) {
+		return nil
+	}
+	s.LogInfo("set condition %s of control-plane %s to %s", condition.Type, controlPlane.Name, condition.Status)
+	if err := s.Client.Status().Update(s.Ctx, controlPlane); err != nil {
+		return fmt.Errorf("error updating status of control-plane %s: %s", controlPlane.Name, err)
+	}
+	return nil
+}
+
+func (s *Schedule) updateStatus() error {
+	if err := s.Client.Status().Update(s.Ctx, s.Object); err != nil {
+		s.LogError(err, "failed to update status")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,641 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/kubernetes"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// AddonLabel marks the objects of an addon in the tenant, only those are removed when the
+	// addon is disabled
+	AddonLabel = "claio.github.com/addon"
+
+	// addonResyncInterval is the interval the addons are applied again to undo changes in the tenant
+	addonResyncInterval = 10 * time.Minute
+
+	defaultCoreDNSVersion = "v1.11.3"
+	// konnectivityVersion has to match the konnectivity-server of the control-plane deployment
+	konnectivityVersion = "v0.0.37"
+)
+
+// coreDNSVersions are the CoreDNS releases kubeadm installs with a kubernetes minor version
+var coreDNSVersions = map[string]string{
+	"1.29": "v1.11.1",
+	"1.30": "v1.11.1",
+	"1.31": "v1.11.3",
+}
+
+// addon is a set of objects installed into the tenant
+type addon struct {
+	name string
+	// spec selects the switch of the addon in the control-plane spec
+	spec func(*claiov1beta1.AddonsSpec) *claiov1beta1.AddonSpec
+	// image returns the default image, nil for addons without workload
+	image    func(*claiov1beta1.ControlPlaneSpec) string
+	template string
+}
+
+// addonValues are the values of the addon templates
+type addonValues struct {
+	Spec       *claiov1beta1.ControlPlaneSpec
+	Image      string
+	Endpoint   string
+	ClusterDNS string
+}
+
+var addons = []addon{
+	{
+		name:     "bootstrap-rbac",
+		spec:     func(a *claiov1beta1.AddonsSpec) *claiov1beta1.AddonSpec { return &a.BootstrapRBAC },
+		template: bootstrapRBACTemplate,
+	},
+	{
+		name: "kube-proxy",
+		spec: func(a *claiov1beta1.AddonsSpec) *claiov1beta1.AddonSpec { return &a.KubeProxy },
+		image: func(spec *claiov1beta1.ControlPlaneSpec) string {
+			return "registry.k8s.io/kube-proxy:v" + spec.Version
+		},
+		template: kubeProxyTemplate,
+	},
+	{
+		name: "coredns",
+		spec: func(a *claiov1beta1.AddonsSpec) *claiov1beta1.AddonSpec { return &a.CoreDNS },
+		image: func(spec *claiov1beta1.ControlPlaneSpec) string {
+			return "registry.k8s.io/coredns/coredns:" + coreDNSVersion(spec.Version)
+		},
+		template: coreDNSTemplate,
+	},
+	{
+		name: "konnectivity-agent",
+		spec: func(a *claiov1beta1.AddonsSpec) *claiov1beta1.AddonSpec { return &a.Konnectivity },
+		image: func(_ *claiov1beta1.ControlPlaneSpec) string {
+			return "registry.k8s.io/kas-network-proxy/proxy-agent:" + konnectivityVersion
+		},
+		template: konnectivityAgentTemplate,
+	},
+}
+
+// coreDNSVersion returns the CoreDNS release for a kubernetes version
+func coreDNSVersion(version string) string {
+	parts := strings.Split(version, ".")
+	if len(parts) >= 2 {
+		if coreDNSVersion, ok := coreDNSVersions[parts[0]+"."+parts[1]]; ok {
+			return coreDNSVersion
+		}
+	}
+	return defaultCoreDNSVersion
+}
+
+// reconcileAddons applies the enabled addons to the tenant and removes the disabled ones
+func (c *ControlPlane) reconcileAddons() error {
+	c.LogHeader("check addons ...")
+	tenantClient, err := c.TenantClient()
+	if err != nil {
+		return err
+	}
+	clusterDNS, err := c.ClusterDNS()
+	if err != nil {
+		return err
+	}
+
+	applied := []string{}
+	for _, a := range addons {
+		spec := a.spec(&c.Object.Spec.Addons)
+		values := &addonValues{
+			Spec:       &c.Object.Spec,
+			Image:      spec.Image,
+			Endpoint:   c.Endpoint(),
+			ClusterDNS: clusterDNS,
+		}
+		if values.Image == "" && a.image != nil {
+			values.Image = a.image(&c.Object.Spec)
+		}
+		yaml, err := c.ToYaml(a.template, values)
+		if err != nil {
+			return fmt.Errorf("error generating yaml of addon %s: %s", a.name, err)
+		}
+		objects, err := kubernetes.DecodeYaml(yaml)
+		if err != nil {
+			return fmt.Errorf("error decoding yaml of addon %s: %s", a.name, err)
+		}
+
+		if !spec.IsEnabled() {
+			if err := c.removeAddon(tenantClient, a.name, objects); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, obj := range objects {
+			labels := obj.GetLabels()
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			labels[AddonLabel] = a.name
+			obj.SetLabels(labels)
+		}
+		if err := kubernetes.ApplyObjects(tenantClient, c.Ctx, objects); err != nil {
+			return fmt.Errorf("error applying addon %s: %s", a.name, err)
+		}
+		if values.Image != "" {
+			applied = append(applied, fmt.Sprintf("%s (%s)", a.name, values.Image))
+		} else {
+			applied = append(applied, a.name)
+		}
+	}
+
+	message := "all addons are disabled"
+	if len(applied) > 0 {
+		message = "applied " + strings.Join(applied, ", ")
+	}
+	c.setConditionTrue(claiov1beta1.ConditionAddonsReady, reasonAvailable, message)
+	return nil
+}
+
+// removeAddon deletes the objects of a disabled addon. Objects without the label of the addon
+// have not been created by claio and are left alone.
+func (c *ControlPlane) removeAddon(tenantClient client.Client, name string, objects []*unstructured.Unstructured) error {
+	for _, obj := range objects {
+		current := &unstructured.Unstructured{}
+		current.SetGroupVersionKind(obj.GroupVersionKind())
+		if err := tenantClient.Get(c.Ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+			if client.IgnoreNotFound(err) == nil {
+				continue
+			}
+			return fmt.Errorf("error getting %s %s of addon %s: %s", obj.GetKind(), obj.GetName(), name, err)
+		}
+		if current.GetLabels()[AddonLabel] != name {
+			continue
+		}
+		c.LogInfo("remove %s %s of disabled addon %s", obj.GetKind(), obj.GetName(), name)
+		if err := tenantClient.Delete(c.Ctx, current); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("error deleting %s %s of addon %s: %s", obj.GetKind(), obj.GetName(), name, err)
+		}
+	}
+	return nil
+}
+
+// bootstrapRBACTemplate allows nodes to join with a bootstrap token (kubeadm join and kubelet
+// TLS bootstrap) and auto-approves the client certificates of the kubelets
+const bootstrapRBACTemplate = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: kubeadm:get-nodes
+rules:
+- apiGroups: [""]
+  resources: ["nodes"]
+  verbs: ["get"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: kubeadm:get-nodes
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: kubeadm:get-nodes
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: ` + bootstrapTokenGroups + `
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: kubeadm:kubelet-bootstrap
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:node-bootstrapper
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: ` + bootstrapTokenGroups + `
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: kubeadm:node-autoapprove-bootstrap
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:certificates.k8s.io:certificatesigningrequests:nodeclient
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: ` + bootstrapTokenGroups + `
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: kubeadm:node-autoapprove-certificate-rotation
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:certificates.k8s.io:certificatesigningrequests:selfnodeclient
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: system:nodes
+`
+
+// kubeProxyTemplate connects kube-proxy to the advertised endpoint, the service network is
+// not routable before kube-proxy runs
+const kubeProxyTemplate = `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: kube-proxy
+  namespace: kube-system
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: kubeadm:node-proxier
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:node-proxier
+subjects:
+- kind: ServiceAccount
+  name: kube-proxy
+  namespace: kube-system
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: kube-proxy
+  namespace: kube-system
+rules:
+- apiGroups: [""]
+  resources: ["configmaps"]
+  resourceNames: ["kube-proxy"]
+  verbs: ["get"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: kube-proxy
+  namespace: kube-system
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: kube-proxy
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: ` + bootstrapTokenGroups + `
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: kube-proxy
+  namespace: kube-system
+  labels:
+    app: kube-proxy
+data:
+  config.conf: |
+    apiVersion: kubeproxy.config.k8s.io/v1alpha1
+    kind: KubeProxyConfiguration
+    bindAddress: 0.0.0.0
+    clientConnection:
+      kubeconfig: /var/lib/kube-proxy/kubeconfig.conf
+    clusterCIDR: {{ .Spec.Network.ClusterCIDR }}
+    mode: ""
+  kubeconfig.conf: |
+    apiVersion: v1
+    kind: Config
+    clusters:
+    - cluster:
+        certificate-authority: /var/run/secrets/kubernetes.io/serviceaccount/ca.crt
+        server: {{ .Endpoint }}
+      name: default
+    contexts:
+    - context:
+        cluster: default
+        namespace: default
+        user: default
+      name: default
+    current-context: default
+    users:
+    - name: default
+      user:
+        tokenFile: /var/run/secrets/kubernetes.io/serviceaccount/token
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: kube-proxy
+  namespace: kube-system
+  labels:
+    k8s-app: kube-proxy
+spec:
+  selector:
+    matchLabels:
+      k8s-app: kube-proxy
+  updateStrategy:
+    type: RollingUpdate
+  template:
+    metadata:
+      labels:
+        k8s-app: kube-proxy
+    spec:
+      containers:
+      - name: kube-proxy
+        image: {{ .Image }}
+        command:
+        - /usr/local/bin/kube-proxy
+        - --config=/var/lib/kube-proxy/config.conf
+        - --hostname-override=$(NODE_NAME)
+        env:
+        - name: NODE_NAME
+          valueFrom:
+            fieldRef:
+              fieldPath: spec.nodeName
+        securityContext:
+          privileged: true
+        volumeMounts:
+        - mountPath: /var/lib/kube-proxy
+          name: kube-proxy
+        - mountPath: /run/xtables.lock
+          name: xtables-lock
+        - mountPath: /lib/modules
+          name: lib-modules
+          readOnly: true
+      hostNetwork: true
+      nodeSelector:
+        kubernetes.io/os: linux
+      priorityClassName: system-node-critical
+      serviceAccountName: kube-proxy
+      tolerations:
+      - operator: Exists
+      volumes:
+      - name: kube-proxy
+        configMap:
+          name: kube-proxy
+      - name: xtables-lock
+        hostPath:
+          path: /run/xtables.lock
+          type: FileOrCreate
+      - name: lib-modules
+        hostPath:
+          path: /lib/modules
+`
+
+const coreDNSTemplate = `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: coredns
+  namespace: kube-system
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: system:coredns
+rules:
+- apiGroups: [""]
+  resources: ["endpoints", "services", "pods", "namespaces"]
+  verbs: ["list", "watch"]
+- apiGroups: ["discovery.k8s.io"]
+  resources: ["endpointslices"]
+  verbs: ["list", "watch"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: system:coredns
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:coredns
+subjects:
+- kind: ServiceAccount
+  name: coredns
+  namespace: kube-system
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: coredns
+  namespace: kube-system
+data:
+  Corefile: |
+    .:53 {
+        errors
+        health {
+           lameduck 5s
+        }
+        ready
+        kubernetes {{ .Spec.Network.DNSDomain }} in-addr.arpa ip6.arpa {
+           pods insecure
+           fallthrough in-addr.arpa ip6.arpa
+           ttl 30
+        }
+        prometheus :9153
+        forward . /etc/resolv.conf {
+           max_concurrent 1000
+        }
+        cache 30
+        loop
+        reload
+        loadbalance
+    }
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: coredns
+  namespace: kube-system
+  labels:
+    k8s-app: kube-dns
+spec:
+  replicas: 2
+  strategy:
+    type: RollingUpdate
+    rollingUpdate:
+      maxUnavailable: 1
+  selector:
+    matchLabels:
+      k8s-app: kube-dns
+  template:
+    metadata:
+      labels:
+        k8s-app: kube-dns
+    spec:
+      priorityClassName: system-cluster-critical
+      serviceAccountName: coredns
+      tolerations:
+      - key: CriticalAddonsOnly
+        operator: Exists
+      - key: node-role.kubernetes.io/control-plane
+        effect: NoSchedule
+      nodeSelector:
+        kubernetes.io/os: linux
+      containers:
+      - name: coredns
+        image: {{ .Image }}
+        args: ["-conf", "/etc/coredns/Corefile"]
+        resources:
+          limits:
+            memory: 170Mi
+          requests:
+            cpu: 100m
+            memory: 70Mi
+        volumeMounts:
+        - name: config-volume
+          mountPath: /etc/coredns
+          readOnly: true
+        ports:
+        - containerPort: 53
+          name: dns
+          protocol: UDP
+        - containerPort: 53
+          name: dns-tcp
+          protocol: TCP
+        - containerPort: 9153
+          name: metrics
+          protocol: TCP
+        securityContext:
+          allowPrivilegeEscalation: false
+          capabilities:
+            add: ["NET_BIND_SERVICE"]
+            drop: ["ALL"]
+          readOnlyRootFilesystem: true
+        livenessProbe:
+          httpGet:
+            path: /health
+            port: 8080
+            scheme: HTTP
+          initialDelaySeconds: 60
+          timeoutSeconds: 5
+          successThreshold: 1
+          failureThreshold: 5
+        readinessProbe:
+          httpGet:
+            path: /ready
+            port: 8181
+            scheme: HTTP
+      dnsPolicy: Default
+      volumes:
+      - name: config-volume
+        configMap:
+          name: coredns
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: kube-dns
+  namespace: kube-system
+  labels:
+    k8s-app: kube-dns
+    kubernetes.io/cluster-service: "true"
+    kubernetes.io/name: CoreDNS
+spec:
+  selector:
+    k8s-app: kube-dns
+  clusterIP: {{ .ClusterDNS }}
+  ports:
+  - name: dns
+    port: 53
+    protocol: UDP
+  - name: dns-tcp
+    port: 53
+    protocol: TCP
+  - name: metrics
+    port: 9153
+    protocol: TCP
+`
+
+// konnectivityAgentTemplate connects the nodes to the konnectivity-server of the control-plane,
+// the agents authenticate with a service account token for the audience of the server
+const konnectivityAgentTemplate = `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: konnectivity-agent
+  namespace: kube-system
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: system:konnectivity-server
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:auth-delegator
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: User
+  name: system:konnectivity-server
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: konnectivity-agent
+  namespace: kube-system
+  labels:
+    k8s-app: konnectivity-agent
+spec:
+  selector:
+    matchLabels:
+      k8s-app: konnectivity-agent
+  template:
+    metadata:
+      labels:
+        k8s-app: konnectivity-agent
+    spec:
+      priorityClassName: system-cluster-critical
+      serviceAccountName: konnectivity-agent
+      tolerations:
+      - key: CriticalAddonsOnly
+        operator: Exists
+      nodeSelector:
+        kubernetes.io/os: linux
+      containers:
+      - name: konnectivity-agent
+        image: {{ .Image }}
+        command: ["/proxy-agent"]
+        args:
+        - --logtostderr=true
+        - --ca-cert=/var/run/secrets/kubernetes.io/serviceaccount/ca.crt
+        - --proxy-server-host={{ .Spec.Endpoint.Host }}
+        - --proxy-server-port=8132
+        - --admin-server-port=8133
+        - --health-server-port=8134
+        - --service-account-token-path=/var/run/secrets/tokens/konnectivity-agent-token
+        livenessProbe:
+          httpGet:
+            port: 8134
+            path: /healthz
+          initialDelaySeconds: 15
+          timeoutSeconds: 15
+        volumeMounts:
+        - mountPath: /var/run/secrets/tokens
+          name: konnectivity-agent-token
+      volumes:
+      - name: konnectivity-agent-token
+        projected:
+          sources:
+          - serviceAccountToken:
+              path: konnectivity-agent-token
+              audience: system:konnectivity-server
+`
@@ -0,0 +1,460 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1alpha1 "claio/api/v1alpha1"
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/certificates"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	caSecretName = "ca"
+	// caNextSecretName is the new CA while the tenant learns to trust it
+	caNextSecretName = "ca-next"
+	// caPreviousSecretName is the old CA while it is still trusted
+	caPreviousSecretName = "ca-previous"
+	// caBundleSecretName holds the CAs the tenant trusts, both CAs during a rotation
+	caBundleSecretName = "ca-bundle"
+	caBundleKey        = "ca-bundle.crt"
+
+	// caRotationInterval is how often a stage which waits for the tenant is checked
+	caRotationInterval = 15 * time.Second
+	// DefaultCARotationGracePeriod is how long the old CA is trusted after the certificates were
+	// reissued from the new one
+	DefaultCARotationGracePeriod = 24 * time.Hour
+
+	// pkiChecksumAnnotation on the pods of the deployment rolls them out when a certificate changes
+	pkiChecksumAnnotation = "claio.github.com/pki-checksum"
+
+	// eventCARotation is the reason of the events of the stages of a CA rotation
+	eventCARotation = "CARotation"
+)
+
+// pkiSecrets are mounted into the control-plane pods (kubernetes-pki)
+var pkiSecrets = []string{
+	caSecretName,
+	caBundleSecretName,
+	"apiserver",
+	"apiserver-kubelet-client",
+	"front-proxy-ca",
+	"front-proxy-client",
+	"sa",
+	"kubeconfig-scheduler",
+	"kubeconfig-controller",
+	"kubeconfig-konnectivity",
+}
+
+// reconcileCARotation advances the staged rotation of the tenant CA before the certificates are
+// checked. A rotation starts when the annotation changes, the CA expires within the renewal
+// window or the CA in the secret of the spec changes:
+//
+//  1. Publishing: a new CA is created (or taken from the secret of the spec) in ca-next, the
+//     bundle of both CAs is rolled out to the control-plane and the kubeconfigs and published in
+//     cluster-info and kube-root-ca.crt
+//  2. Reissuing: the new CA replaces ca (the old one is kept in ca-previous), the certificates
+//     and kubeconfigs are reissued from it and rolled out
+//  3. Retiring: the old CA is trusted for the grace period, then it is removed from the bundle
+//
+// The nodes of Machines keep the CA they joined with, a rotation is Blocked until they are gone.
+// It returns when the stage is checked again.
+func (c *ControlPlane) reconcileCARotation() (time.Duration, error) {
+	c.LogHeader("check ca rotation ...")
+	ca, err := c.getCertificateSecret(caSecretName)
+	if err != nil {
+		return 0, err
+	}
+	rotation := c.Object.Status.CARotation
+	id := c.Object.Annotations[claiov1beta1.CARotationAnnotation]
+	blocked := rotation != nil && rotation.Stage == claiov1beta1.CARotationBlocked
+	requested := id != "" && (rotation == nil || rotation.ID != id || blocked)
+
+	if rotation == nil || rotation.Stage == claiov1beta1.CARotationCompleted || blocked {
+		if ca == nil {
+			// the CA of a new tenant is created with the certificates, there is nothing to rotate
+			if requested {
+				c.Object.Status.CARotation = &claiov1beta1.CARotationStatus{
+					ID: id, Stage: claiov1beta1.CARotationCompleted, Message: "the CA is new",
+				}
+			}
+			return 0, nil
+		}
+		if source := c.Object.Spec.Certificates.CA; source != nil {
+			return c.rotateToExternalCA(ca, source, id, requested)
+		}
+		raw, err := ca.RawCert()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get ca certificate: %s", err)
+		}
+		switch {
+		case requested:
+			return c.startCARotation(id, fmt.Sprintf("requested with %s=%s", claiov1beta1.CARotationAnnotation, id), nil)
+		case time.Until(raw.NotAfter) < c.renewBefore():
+			return c.startCARotation(id, fmt.Sprintf("the CA expires at %s", raw.NotAfter.Format(time.RFC3339)), nil)
+		}
+		c.scheduleRenewal(ca)
+		c.unblockCARotation()
+		return 0, nil
+	}
+
+	switch rotation.Stage {
+	case claiov1beta1.CARotationPublishing:
+		return c.publishCA(ca)
+	case claiov1beta1.CARotationReissuing:
+		return c.reissueFromCA()
+	case claiov1beta1.CARotationRetiring:
+		return c.retireCA()
+	}
+	return 0, fmt.Errorf("unknown stage %s of the ca rotation", rotation.Stage)
+}
+
+// rotateToExternalCA starts a rotation when the CA in the secret of the spec differs from the
+// CA of the tenant, the manager cannot renew such a CA by itself
+func (c *ControlPlane) rotateToExternalCA(ca *certificates.Certificate, source *claiov1beta1.CASource, id string, requested bool) (time.Duration, error) {
+	external, err := c.externalCA(source)
+	if err != nil {
+		return 0, err
+	}
+	if !sameCA(ca, external) {
+		return c.startCARotation(id, fmt.Sprintf("the CA in secret %s changed", source.SecretRef.Name), external)
+	}
+	if requested {
+		c.Object.Status.CARotation = &claiov1beta1.CARotationStatus{
+			ID:      id,
+			Stage:   claiov1beta1.CARotationCompleted,
+			Message: fmt.Sprintf("the CA is taken from secret %s, replace it there to rotate it", source.SecretRef.Name),
+		}
+		return 0, nil
+	}
+	c.unblockCARotation()
+	return 0, nil
+}
+
+// startCARotation creates the new CA or takes the given one, the tenant trusts it with the next
+// rollout
+func (c *ControlPlane) startCARotation(id, reason string, next *certificates.Certificate) (time.Duration, error) {
+	machines, err := c.machineNames()
+	if err != nil {
+		return 0, err
+	}
+	if len(machines) > 0 {
+		return c.blockCARotation(id, fmt.Sprintf("%s, but the nodes of the machines %s only trust the old CA, delete them to rotate it",
+			reason, strings.Join(machines, ", ")))
+	}
+	c.LogInfo("start ca rotation: %s", reason)
+	if err := c.DeleteSecret(caNextSecretName); err != nil {
+		return 0, fmt.Errorf("failed to delete secret %s: %s", caNextSecretName, err)
+	}
+	if next == nil {
+		template, err := caTemplate(&c.Object.Spec)
+		if err != nil {
+			return 0, err
+		}
+		if next, err = createCert(template, nil, c.keyAlgorithm()); err != nil {
+			return 0, fmt.Errorf("failed to create the new ca: %s", err)
+		}
+	}
+	if err := c.CreateSecret(caNextSecretName, certificateSecretData(caSecretName, next)); err != nil {
+		return 0, fmt.Errorf("failed to create secret %s: %s", caNextSecretName, err)
+	}
+	now := metav1.Now()
+	c.Object.Status.CARotation = &claiov1beta1.CARotationStatus{ID: id, StartTime: &now}
+	c.setCARotationStage(claiov1beta1.CARotationPublishing, reason+", the tenant trusts the old and the new CA")
+	return caRotationInterval, nil
+}
+
+// publishCA waits until the bundle reached the tenant and then lets the new CA sign
+func (c *ControlPlane) publishCA(ca *certificates.Certificate) (time.Duration, error) {
+	next, err := c.getCertificateSecretAs(caNextSecretName, caSecretName)
+	if err != nil {
+		return 0, err
+	}
+	if next == nil {
+		previous, err := c.getCertificateSecretAs(caPreviousSecretName, caSecretName)
+		if err != nil {
+			return 0, err
+		}
+		if previous == nil {
+			return 0, fmt.Errorf("the new ca of the rotation is missing, change %s to start again", claiov1beta1.CARotationAnnotation)
+		}
+		// the new CA was moved already
+		c.setCARotationStage(claiov1beta1.CARotationReissuing, "the certificates and kubeconfigs are reissued from the new CA")
+		return caRotationInterval, nil
+	}
+
+	if ca.Cert != next.Cert {
+		published, message, err := c.caPublished(next)
+		if err != nil {
+			return 0, err
+		}
+		if !published {
+			c.Object.Status.CARotation.Message = message
+			return caRotationInterval, nil
+		}
+		c.LogInfo("the new CA signs, the old one is kept in %s", caPreviousSecretName)
+		if err := c.applySecret(caPreviousSecretName, certificateSecretData(caSecretName, ca)); err != nil {
+			return 0, err
+		}
+		if err := c.UpdateSecret(caSecretName, certificateSecretData(caSecretName, next)); err != nil {
+			return 0, fmt.Errorf("failed to update secret %s: %s", caSecretName, err)
+		}
+	}
+	if err := c.DeleteSecret(caNextSecretName); err != nil {
+		return 0, fmt.Errorf("failed to delete secret %s: %s", caNextSecretName, err)
+	}
+	c.setCARotationStage(claiov1beta1.CARotationReissuing, "the certificates and kubeconfigs are reissued from the new CA")
+	return caRotationInterval, nil
+}
+
+// reissueFromCA waits until the certificates are reissued from the new CA, cert-manager issues
+// them asynchronously, and rolled out
+func (c *ControlPlane) reissueFromCA() (time.Duration, error) {
+	ca, err := c.getCertificateSecret(caSecretName)
+	if err != nil {
+		return 0, err
+	}
+	for name, caName := range issuedCertificates {
+		if caName != caSecretName {
+			continue
+		}
+		cert, err := c.getCertificateSecret(name)
+		if err != nil {
+			return 0, err
+		}
+		if cert == nil || !cert.IsValid(ca, nil, 0) {
+			c.Object.Status.CARotation.Message = fmt.Sprintf("waiting for certificate %s from the new CA", name)
+			return caRotationInterval, nil
+		}
+	}
+	rolledOut, err := c.pkiRolledOut()
+	if err != nil {
+		return 0, err
+	}
+	if !rolledOut {
+		c.Object.Status.CARotation.Message = "waiting for the rollout of the reissued certificates"
+		return caRotationInterval, nil
+	}
+	c.setCARotationStage(claiov1beta1.CARotationRetiring,
+		fmt.Sprintf("the old CA is trusted until %s", time.Now().Add(c.CARotationGracePeriod).Format(time.RFC3339)))
+	return max(c.CARotationGracePeriod, time.Second), nil
+}
+
+// retireCA removes the old CA from the bundle after the grace period
+func (c *ControlPlane) retireCA() (time.Duration, error) {
+	rotation := c.Object.Status.CARotation
+	if rotation.LastTransitionTime != nil {
+		if wait := time.Until(rotation.LastTransitionTime.Add(c.CARotationGracePeriod)); wait > 0 {
+			return wait, nil
+		}
+	}
+	c.LogInfo("retire the old CA")
+	if err := c.DeleteSecret(caPreviousSecretName); err != nil {
+		return 0, fmt.Errorf("failed to delete secret %s: %s", caPreviousSecretName, err)
+	}
+	c.setCARotationStage(claiov1beta1.CARotationCompleted, "the old CA was removed from the bundle")
+	return 0, nil
+}
+
+// blockCARotation keeps the rotation from starting, the warning is only recorded when it changes
+func (c *ControlPlane) blockCARotation(id, message string) (time.Duration, error) {
+	rotation := c.Object.Status.CARotation
+	if rotation != nil && rotation.Stage == claiov1beta1.CARotationBlocked && rotation.ID == id && rotation.Message == message {
+		return caRotationInterval, nil
+	}
+	now := metav1.Now()
+	c.Object.Status.CARotation = &claiov1beta1.CARotationStatus{
+		ID: id, Stage: claiov1beta1.CARotationBlocked, Message: message, LastTransitionTime: &now,
+	}
+	c.LogInfo("ca rotation %s: %s", claiov1beta1.CARotationBlocked, message)
+	c.event(corev1.EventTypeWarning, eventCARotation, "%s: %s", claiov1beta1.CARotationBlocked, message)
+	return caRotationInterval, nil
+}
+
+// unblockCARotation completes a blocked rotation which is not needed anymore
+func (c *ControlPlane) unblockCARotation() {
+	if rotation := c.Object.Status.CARotation; rotation != nil && rotation.Stage == claiov1beta1.CARotationBlocked {
+		c.setCARotationStage(claiov1beta1.CARotationCompleted, "the CA does not need to be rotated anymore")
+	}
+}
+
+// machineNames are the Machines which join nodes to the tenant
+func (c *ControlPlane) machineNames() ([]string, error) {
+	machines := &claiov1alpha1.MachineList{}
+	if err := c.Client.List(c.Ctx, machines, client.InNamespace(c.Namespace())); err != nil {
+		return nil, fmt.Errorf("error listing machines: %s", err)
+	}
+	names := []string{}
+	for _, machine := range machines.Items {
+		if machine.Spec.ControlPlaneRef.Name == c.Object.Name {
+			names = append(names, machine.Name)
+		}
+	}
+	return names, nil
+}
+
+// CARotating is true while the tenant CA is replaced, new nodes wait until it is completed
+func (c *ControlPlane) CARotating() bool {
+	rotation := c.Object.Status.CARotation
+	return rotation != nil && rotation.Stage != claiov1beta1.CARotationCompleted && rotation.Stage != claiov1beta1.CARotationBlocked
+}
+
+// setCARotationStage moves the rotation to the stage and records an event
+func (c *ControlPlane) setCARotationStage(stage claiov1beta1.CARotationStage, message string) {
+	now := metav1.Now()
+	rotation := c.Object.Status.CARotation
+	rotation.Stage, rotation.Message, rotation.LastTransitionTime = stage, message, &now
+	c.LogInfo("ca rotation %s: %s", stage, message)
+	c.event(corev1.EventTypeNormal, eventCARotation, "%s: %s", stage, message)
+}
+
+// caPublished checks that the control-plane runs with the bundle of the new CA and the tenant
+// publishes it in cluster-info and kube-root-ca.crt, the message says what is missing
+func (c *ControlPlane) caPublished(next *certificates.Certificate) (bool, string, error) {
+	rolledOut, err := c.pkiRolledOut()
+	if err != nil {
+		return false, "", err
+	}
+	if !rolledOut {
+		return false, "waiting for the rollout of the CA bundle", nil
+	}
+	tenantClient, err := c.TenantClient()
+	if err != nil {
+		return false, "", err
+	}
+	clusterInfo := &corev1.ConfigMap{}
+	if err := tenantClient.Get(c.Ctx, types.NamespacedName{Namespace: metav1.NamespacePublic, Name: "cluster-info"}, clusterInfo); err != nil {
+		return false, "", fmt.Errorf("error getting cluster-info: %s", err)
+	}
+	config, err := clientcmd.Load([]byte(clusterInfo.Data["kubeconfig"]))
+	if err != nil {
+		return false, "", fmt.Errorf("error parsing cluster-info: %s", err)
+	}
+	published := false
+	for _, cluster := range config.Clusters {
+		published = published || strings.Contains(string(cluster.CertificateAuthorityData), next.Cert)
+	}
+	if !published {
+		return false, "waiting for cluster-info to publish the CA bundle", nil
+	}
+	rootCAs := &corev1.ConfigMapList{}
+	if err := tenantClient.List(c.Ctx, rootCAs, client.MatchingFields{"metadata.name": "kube-root-ca.crt"}); err != nil {
+		return false, "", fmt.Errorf("error listing kube-root-ca.crt: %s", err)
+	}
+	for _, rootCA := range rootCAs.Items {
+		if !strings.Contains(rootCA.Data["ca.crt"], next.Cert) {
+			return false, fmt.Sprintf("waiting for kube-root-ca.crt in namespace %s to publish the CA bundle", rootCA.Namespace), nil
+		}
+	}
+	return true, "", nil
+}
+
+// caBundle are the CAs the tenant trusts with the chains of intermediate CAs, the signing CA first
+func (c *ControlPlane) caBundle(ca *certificates.Certificate) (string, error) {
+	bundle := ca.Cert + ca.Chain
+	for _, name := range []string{caNextSecretName, caPreviousSecretName} {
+		other, err := c.getCertificateSecretAs(name, caSecretName)
+		if err != nil {
+			return "", err
+		}
+		if other != nil && other.Cert != ca.Cert {
+			bundle += other.Cert + other.Chain
+		}
+	}
+	return bundle, nil
+}
+
+// reconcileCABundle writes the bundle of the CAs into the secret ca-bundle
+func (c *ControlPlane) reconcileCABundle(ca *certificates.Certificate) error {
+	bundle, err := c.caBundle(ca)
+	if err != nil {
+		return err
+	}
+	return c.applySecret(caBundleSecretName, map[string][]byte{caBundleKey: []byte(bundle)})
+}
+
+// applySecret creates or updates the secret if its data differs
+func (c *ControlPlane) applySecret(name string, data map[string][]byte) error {
+	current, err := c.GetSecret(name)
+	if err != nil {
+		return fmt.Errorf("failed to get secret %s/%s: %s", c.Namespace(), name, err)
+	}
+	if current == nil {
+		c.LogInfo("create secret %s", name)
+		return c.CreateSecret(name, data)
+	}
+	if reflect.DeepEqual(current, data) {
+		return nil
+	}
+	c.LogInfo("update secret %s", name)
+	return c.UpdateSecret(name, data)
+}
+
+// pkiChecksum is the checksum of the secrets mounted into the control-plane pods
+func (c *ControlPlane) pkiChecksum() (string, error) {
+	hash := sha256.New()
+	for _, name := range pkiSecrets {
+		data, err := c.GetSecret(name)
+		if err != nil {
+			return "", fmt.Errorf("failed to get secret %s/%s: %s", c.Namespace(), name, err)
+		}
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		fmt.Fprintf(hash, "%s\n", name)
+		for _, key := range keys {
+			fmt.Fprintf(hash, "%s=%x\n", key, data[key])
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// pkiRolledOut is true if all pods of the deployment run with the current secrets
+func (c *ControlPlane) pkiRolledOut() (bool, error) {
+	deployment, err := c.GetClaioDeployment()
+	if err != nil || deployment == nil {
+		return false, err
+	}
+	checksum, err := c.pkiChecksum()
+	if err != nil {
+		return false, err
+	}
+	if deployment.Spec.Template.Annotations[pkiChecksumAnnotation] != checksum {
+		return false, nil
+	}
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	return replicas > 0 && status.ObservedGeneration >= deployment.Generation && status.UpdatedReplicas == replicas &&
+		status.Replicas == replicas && status.AvailableReplicas == replicas, nil
+}
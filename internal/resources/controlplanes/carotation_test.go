@@ -0,0 +1,333 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1alpha1 "claio/api/v1alpha1"
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/resources"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("CA rotation", func() {
+	var (
+		c        *ControlPlane
+		tenant   client.Client
+		oldCA    string
+		newCA    string
+		requeue  time.Duration
+		rotation = func() *claiov1beta1.CARotationStatus { return c.Object.Status.CARotation }
+	)
+
+	// caCert is the certificate of the CA in the secret, empty if it does not exist
+	caCert := func(name string) string {
+		ca, err := c.getCertificateSecretAs(name, caSecretName)
+		Expect(err).NotTo(HaveOccurred())
+		if ca == nil {
+			return ""
+		}
+		return ca.Cert
+	}
+
+	bundle := func() string {
+		data, err := c.GetSecret(caBundleSecretName)
+		Expect(err).NotTo(HaveOccurred())
+		return string(data[caBundleKey])
+	}
+
+	// reconcile runs the rotation and the certificates like the reconciler
+	reconcile := func() {
+		var err error
+		requeue, err = c.reconcileCARotation()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = c.reconcileCertificates()
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	// rollOut lets the pods of the control-plane run with the current secrets
+	rollOut := func() {
+		checksum, err := c.pkiChecksum()
+		Expect(err).NotTo(HaveOccurred())
+		deployment, err := c.GetClaioDeployment()
+		Expect(err).NotTo(HaveOccurred())
+		deployment.Spec.Template.Annotations = map[string]string{pkiChecksumAnnotation: checksum}
+		Expect(c.Client.Update(c.Ctx, deployment)).To(Succeed())
+		deployment.Status = appsv1.DeploymentStatus{
+			ObservedGeneration: deployment.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1,
+		}
+		Expect(c.Client.Status().Update(c.Ctx, deployment)).To(Succeed())
+	}
+
+	// publish lets the tenant publish the CA bundle in cluster-info and the kube-root-ca.crt of the
+	// namespaces
+	publish := func(clusterInfo bool, namespaces ...string) {
+		if clusterInfo {
+			config := clientcmdapi.NewConfig()
+			config.Clusters[""] = &clientcmdapi.Cluster{Server: c.Endpoint(), CertificateAuthorityData: []byte(bundle())}
+			kubeconfig, err := clientcmd.Write(*config)
+			Expect(err).NotTo(HaveOccurred())
+			configMap := &corev1.ConfigMap{}
+			Expect(tenant.Get(c.Ctx, client.ObjectKey{Namespace: metav1.NamespacePublic, Name: "cluster-info"}, configMap)).To(Succeed())
+			configMap.Data = map[string]string{"kubeconfig": string(kubeconfig)}
+			Expect(tenant.Update(c.Ctx, configMap)).To(Succeed())
+		}
+		for _, namespace := range namespaces {
+			configMap := &corev1.ConfigMap{}
+			Expect(tenant.Get(c.Ctx, client.ObjectKey{Namespace: namespace, Name: "kube-root-ca.crt"}, configMap)).To(Succeed())
+			configMap.Data = map[string]string{"ca.crt": bundle()}
+			Expect(tenant.Update(c.Ctx, configMap)).To(Succeed())
+		}
+	}
+
+	// restart continues with a new control-plane from the saved object like a restarted manager
+	restart := func() {
+		status := c.Object.Status.DeepCopy()
+		Expect(c.Client.Update(c.Ctx, c.Object)).To(Succeed())
+		c.Object.Status = *status
+		Expect(c.Client.Status().Update(c.Ctx, c.Object)).To(Succeed())
+		current := &claiov1beta1.ControlPlane{}
+		Expect(c.Client.Get(c.Ctx, client.ObjectKeyFromObject(c.Object), current)).To(Succeed())
+		c = &ControlPlane{
+			Resource:              *resources.NewResource("ControlPlane", c.Ctx, c.Req, c.Client, c.Scheme, current),
+			CARotationGracePeriod: DefaultCARotationGracePeriod,
+			tenantClient:          tenant,
+		}
+	}
+
+	// moveCA does the work of the publishing stage without saving the status, like a manager which
+	// is stopped in between
+	moveCA := func(removeNext bool) {
+		rollOut()
+		publish(true, metav1.NamespaceSystem, metav1.NamespaceDefault)
+		current, err := c.GetSecret(caSecretName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.applySecret(caPreviousSecretName, current)).To(Succeed())
+		next, err := c.GetSecret(caNextSecretName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.UpdateSecret(caSecretName, next)).To(Succeed())
+		if removeNext {
+			Expect(c.DeleteSecret(caNextSecretName)).To(Succeed())
+		}
+	}
+
+	BeforeEach(func() {
+		c = newTestControlPlane(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "claio", Namespace: "tenant-sample"},
+		})
+		tenant = fake.NewClientBuilder().
+			WithScheme(clientgoscheme.Scheme).
+			WithObjects(
+				&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespacePublic, Name: "cluster-info"}},
+				&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "kube-root-ca.crt"}},
+				&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceSystem, Name: "kube-root-ca.crt"}},
+			).
+			WithIndex(&corev1.ConfigMap{}, "metadata.name", func(obj client.Object) []string {
+				return []string{obj.GetName()}
+			}).
+			Build()
+		c.tenantClient = tenant
+
+		reconcile()
+		Expect(rotation()).To(BeNil())
+		Expect(requeue).To(BeZero())
+		oldCA = caCert(caSecretName)
+		Expect(bundle()).To(Equal(oldCA))
+		rollOut()
+
+		By("requesting a rotation")
+		c.Object.Annotations = map[string]string{claiov1beta1.CARotationAnnotation: "2024-10-01"}
+		reconcile()
+		Expect(rotation().ID).To(Equal("2024-10-01"))
+		Expect(rotation().Stage).To(Equal(claiov1beta1.CARotationPublishing))
+		Expect(requeue).To(Equal(caRotationInterval))
+		newCA = caCert(caNextSecretName)
+		Expect(newCA).NotTo(BeEmpty())
+		Expect(caCert(caSecretName)).To(Equal(oldCA))
+		Expect(bundle()).To(Equal(oldCA + newCA))
+	})
+
+	// step is a reconciliation of the rotation after the tenant was prepared
+	type step struct {
+		prepare  func()
+		stage    claiov1beta1.CARotationStage
+		message  string
+		ca       func() string
+		previous func() string
+		bundle   func() string
+		requeue  time.Duration
+	}
+	none := func() string { return "" }
+	old := func() string { return oldCA }
+	next := func() string { return newCA }
+	both := func() string { return newCA + oldCA }
+
+	DescribeTable("rotates the CA in stages",
+		func(steps ...step) {
+			for _, s := range steps {
+				s.prepare()
+				reconcile()
+				Expect(rotation().Stage).To(Equal(s.stage))
+				Expect(rotation().Message).To(ContainSubstring(s.message))
+				Expect(caCert(caSecretName)).To(Equal(s.ca()))
+				Expect(caCert(caPreviousSecretName)).To(Equal(s.previous()))
+				Expect(bundle()).To(Equal(s.bundle()))
+				Expect(requeue).To(BeNumerically("~", s.requeue, time.Minute))
+			}
+			Expect(caCert(caNextSecretName)).To(BeEmpty())
+		},
+		Entry("from start to end",
+			step{func() {}, claiov1beta1.CARotationPublishing, "waiting for the rollout of the CA bundle",
+				old, none, func() string { return oldCA + newCA }, caRotationInterval},
+			step{rollOut, claiov1beta1.CARotationPublishing, "waiting for cluster-info",
+				old, none, func() string { return oldCA + newCA }, caRotationInterval},
+			step{func() { publish(true, metav1.NamespaceSystem) }, claiov1beta1.CARotationPublishing,
+				"waiting for kube-root-ca.crt in namespace default", old, none, func() string { return oldCA + newCA }, caRotationInterval},
+			step{func() { publish(false, metav1.NamespaceDefault) }, claiov1beta1.CARotationReissuing,
+				"reissued from the new CA", next, old, both, caRotationInterval},
+			step{func() {}, claiov1beta1.CARotationReissuing, "waiting for the rollout of the reissued certificates",
+				next, old, both, caRotationInterval},
+			step{rollOut, claiov1beta1.CARotationRetiring, "the old CA is trusted until",
+				next, old, both, DefaultCARotationGracePeriod},
+			step{func() {}, claiov1beta1.CARotationRetiring, "the old CA is trusted until",
+				next, old, both, DefaultCARotationGracePeriod},
+			step{func() {
+				passed := metav1.NewTime(time.Now().Add(-DefaultCARotationGracePeriod - time.Minute))
+				rotation().LastTransitionTime = &passed
+			}, claiov1beta1.CARotationCompleted, "the old CA was removed", next, none, next, 0},
+		),
+		Entry("after a restart while the bundle is published",
+			step{func() { rollOut(); publish(true, metav1.NamespaceSystem); restart() }, claiov1beta1.CARotationPublishing,
+				"waiting for kube-root-ca.crt in namespace default", old, none, func() string { return oldCA + newCA }, caRotationInterval},
+			step{func() { publish(false, metav1.NamespaceDefault) }, claiov1beta1.CARotationReissuing,
+				"reissued from the new CA", next, old, both, caRotationInterval},
+		),
+		Entry("after a restart when the new CA was moved but the status not saved",
+			step{func() { moveCA(false); restart() }, claiov1beta1.CARotationReissuing,
+				"reissued from the new CA", next, old, both, caRotationInterval},
+		),
+		Entry("after a restart when the new CA was moved and removed from ca-next but the status not saved",
+			step{func() { moveCA(true); restart() }, claiov1beta1.CARotationReissuing,
+				"reissued from the new CA", next, old, both, caRotationInterval},
+		),
+	)
+
+	It("Should wait for the certificates of the new CA", func() {
+		rollOut()
+		publish(true, metav1.NamespaceSystem, metav1.NamespaceDefault)
+		var err error
+		requeue, err = c.reconcileCARotation()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotation().Stage).To(Equal(claiov1beta1.CARotationReissuing))
+
+		requeue, err = c.reconcileCARotation()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotation().Stage).To(Equal(claiov1beta1.CARotationReissuing))
+		Expect(rotation().Message).To(MatchRegexp("waiting for certificate apiserver(-kubelet-client)? from the new CA"))
+		Expect(requeue).To(Equal(caRotationInterval))
+	})
+
+	It("Should keep the remaining grace period of the old CA after a restart", func() {
+		rollOut()
+		publish(true, metav1.NamespaceSystem, metav1.NamespaceDefault)
+		reconcile()
+		rollOut()
+		reconcile()
+		Expect(rotation().Stage).To(Equal(claiov1beta1.CARotationRetiring))
+		passed := metav1.NewTime(time.Now().Add(-time.Hour))
+		rotation().LastTransitionTime = &passed
+
+		restart()
+		reconcile()
+		Expect(rotation().Stage).To(Equal(claiov1beta1.CARotationRetiring))
+		Expect(requeue).To(BeNumerically("~", DefaultCARotationGracePeriod-time.Hour, time.Minute))
+		Expect(caCert(caPreviousSecretName)).To(Equal(oldCA))
+	})
+})
+
+var _ = Describe("CA rotation of a tenant with machines", func() {
+	var (
+		c       *ControlPlane
+		machine *claiov1alpha1.Machine
+	)
+
+	BeforeEach(func() {
+		machine = &claiov1alpha1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Namespace: "tenant-sample"},
+			Spec:       claiov1alpha1.MachineSpec{ControlPlaneRef: claiov1alpha1.ControlPlaneReference{Name: "sample"}},
+		}
+		c = newTestControlPlane(machine, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "claio", Namespace: "tenant-sample"},
+		})
+		_, err := c.reconcileCertificates()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	reconcile := func() time.Duration {
+		requeue, err := c.reconcileCARotation()
+		Expect(err).NotTo(HaveOccurred())
+		return requeue
+	}
+
+	It("Should block the rotation until the machines are gone", func() {
+		c.Object.Annotations = map[string]string{claiov1beta1.CARotationAnnotation: "2024-10-01"}
+		for range 2 {
+			Expect(reconcile()).To(Equal(caRotationInterval))
+			Expect(c.Object.Status.CARotation.ID).To(Equal("2024-10-01"))
+			Expect(c.Object.Status.CARotation.Stage).To(Equal(claiov1beta1.CARotationBlocked))
+			Expect(c.Object.Status.CARotation.Message).To(ContainSubstring("machines worker-1 only trust the old CA"))
+			Expect(c.CARotating()).To(BeFalse())
+			next, err := c.GetSecret(caNextSecretName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next).To(BeNil())
+		}
+
+		Expect(c.Client.Delete(c.Ctx, machine)).To(Succeed())
+		Expect(reconcile()).To(Equal(caRotationInterval))
+		Expect(c.Object.Status.CARotation.ID).To(Equal("2024-10-01"))
+		Expect(c.Object.Status.CARotation.Stage).To(Equal(claiov1beta1.CARotationPublishing))
+		Expect(c.CARotating()).To(BeTrue())
+	})
+
+	It("Should ignore the machines of other control-planes", func() {
+		machine.Spec.ControlPlaneRef.Name = "other"
+		Expect(c.Client.Update(c.Ctx, machine)).To(Succeed())
+		c.Object.Annotations = map[string]string{claiov1beta1.CARotationAnnotation: "2024-10-01"}
+		reconcile()
+		Expect(c.Object.Status.CARotation.Stage).To(Equal(claiov1beta1.CARotationPublishing))
+	})
+
+	It("Should complete a blocked rotation which is not requested anymore", func() {
+		c.Object.Annotations = map[string]string{claiov1beta1.CARotationAnnotation: "2024-10-01"}
+		reconcile()
+		Expect(c.Object.Status.CARotation.Stage).To(Equal(claiov1beta1.CARotationBlocked))
+
+		c.Object.Annotations = nil
+		Expect(reconcile()).To(BeZero())
+		Expect(c.Object.Status.CARotation.Stage).To(Equal(claiov1beta1.CARotationCompleted))
+	})
+})
@@ -17,84 +17,27 @@ limitations under the License.
 package controlplanes
 
 import (
-	"bytes"
+	claiov1beta1 "claio/api/v1beta1"
 	"claio/internal/certificates"
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/pem"
 	"fmt"
 	"math/big"
 	"net"
 	"time"
-)
-
-func getSerial() *big.Int {
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 62)
-	serial, err := rand.Int(rand.Reader, serialNumberLimit)
-	if err != nil {
-		return big.NewInt(1)
-	}
-	return serial
-}
-
-func createCert(cert *x509.Certificate, ca *certificates.Certificate) (*certificates.Certificate, error) {
-	// create private key
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate private key: %s", err)
-	}
 
-	// private PEM
-	privateKeyPEM := new(bytes.Buffer)
-	pem.Encode(privateKeyPEM, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
-	})
+	corev1 "k8s.io/api/core/v1"
+)
 
-	// public PEM
-	der, err := x509.MarshalPKIXPublicKey(privateKey.Public())
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal public key: %s", err)
-	}
-	publicKeyPEM := new(bytes.Buffer)
-	pem.Encode(publicKeyPEM, &pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: der,
-	})
-
-	// certificate
-	caCert := cert
-	caKey := privateKey
-	if ca != nil {
-		caCert, err = ca.RawCert()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get ca certificate: %s", err)
-		}
-		caKey, err = ca.RawKey()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get ca private key: %s", err)
-		}
-	}
-	certBytes, err := x509.CreateCertificate(rand.Reader, cert, caCert, &privateKey.PublicKey, caKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create certificate: %s", err)
-	}
-	certPEM := new(bytes.Buffer)
-	pem.Encode(certPEM, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: certBytes,
-	})
+const (
+	// defaultRenewBefore is the renewal window of control-planes without certificates.renewBefore
+	defaultRenewBefore = 30 * 24 * time.Hour
 
-	return &certificates.Certificate{
-		Key:  privateKeyPEM.String(),
-		Pub:  publicKeyPEM.String(),
-		Cert: certPEM.String(),
-	}, nil
-}
+	// externalCARootKey is the root of an intermediate CA in the secret of the spec
+	externalCARootKey = "ca.crt"
+)
 
-func newCaCert(ca *certificates.Certificate, advertisedName *string, advertisedIp *string) (*certificates.Certificate, error) {
+func caTemplate(spec *claiov1beta1.ControlPlaneSpec) (*x509.Certificate, error) {
 	cert := &x509.Certificate{
 		SerialNumber:          big.NewInt(0),
 		NotBefore:             time.Now(),
@@ -103,37 +46,67 @@ func newCaCert(ca *certificates.Certificate, advertisedName *string, advertisedI
 		Subject:               pkix.Name{CommonName: "kubernetes"},
 		IsCA:                  true,
 		BasicConstraintsValid: true,
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
 	}
-	return createCert(cert, nil)
+	return cert, nil
 }
 
-func newApiserverCert(ca *certificates.Certificate, advertisedName *string, advertisedIp *string) (*certificates.Certificate, error) {
-	ip := net.ParseIP(*advertisedIp)
+func apiserverTemplate(spec *claiov1beta1.ControlPlaneSpec) (*x509.Certificate, error) {
+	ip := net.ParseIP(spec.Endpoint.Address)
 	if ip == nil {
-		return nil, fmt.Errorf("invalid IP address: %s", *advertisedIp)
+		return nil, fmt.Errorf("invalid IP address: %s", spec.Endpoint.Address)
+	}
+	serviceIp, err := firstServiceIP(spec.Network.ServiceCIDR)
+	if err != nil {
+		return nil, err
 	}
 	cert := &x509.Certificate{
-		SerialNumber: getSerial(),
+		SerialNumber: certificates.Serial(),
 		Subject:      pkix.Name{CommonName: "kube-apiserver"},
 		NotBefore:    time.Now(),
 		NotAfter:     time.Now().AddDate(1, 0, 0),
-		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		IPAddresses:  []net.IP{net.IPv4(192, 168, 128, 1), net.IPv4(127, 0, 0, 1), ip},
+		IPAddresses:  []net.IP{serviceIp, net.IPv4(127, 0, 0, 1), ip},
 		DNSNames: []string{
 			"kubernetes",
 			"kubernetes.default",
 			"kubernetes.default.svc",
-			"kubernetes.default.svc.cluster.local",
+			"kubernetes.default.svc." + spec.Network.DNSDomain,
 			"localhost",
-			*advertisedName},
+			// the service machines and the manager reach the apiserver through
+			"claio-apiserver.tenant-" + spec.Name + ".svc",
+			spec.Endpoint.Host},
+	}
+	for _, san := range spec.Certificates.ExtraSANs {
+		if sanIp := net.ParseIP(san); sanIp != nil {
+			cert.IPAddresses = append(cert.IPAddresses, sanIp)
+		} else {
+			cert.DNSNames = append(cert.DNSNames, san)
+		}
 	}
 
-	return createCert(cert, ca)
+	return cert, nil
 }
 
-func newFrontProxyCaCert(ca *certificates.Certificate, advertisedName *string, advertisedIp *string) (*certificates.Certificate, error) {
+// firstServiceIP returns the first address of the service network (the kubernetes service)
+func firstServiceIP(serviceCIDR string) (net.IP, error) {
+	return serviceIP(serviceCIDR, 1)
+}
+
+// serviceIP returns the n-th address of the service network (n < 256)
+func serviceIP(serviceCIDR string, n byte) (net.IP, error) {
+	_, ipNet, err := net.ParseCIDR(serviceCIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service CIDR: %s", serviceCIDR)
+	}
+	ip := make(net.IP, len(ipNet.IP))
+	copy(ip, ipNet.IP)
+	ip[len(ip)-1] += n
+	return ip, nil
+}
+
+func frontProxyCaTemplate(spec *claiov1beta1.ControlPlaneSpec) (*x509.Certificate, error) {
 	cert := &x509.Certificate{
 		SerialNumber:          big.NewInt(0),
 		NotBefore:             time.Now(),
@@ -142,60 +115,66 @@ func newFrontProxyCaCert(ca *certificates.Certificate, advertisedName *string, a
 		Subject:               pkix.Name{CommonName: "front-proxy-ca"},
 		IsCA:                  true,
 		BasicConstraintsValid: true,
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
 	}
-	return createCert(cert, nil)
+	return cert, nil
 }
 
-func newFrontProxyClientCert(ca *certificates.Certificate, advertisedName *string, advertisedIp *string) (*certificates.Certificate, error) {
+func frontProxyClientTemplate(spec *claiov1beta1.ControlPlaneSpec) (*x509.Certificate, error) {
 	cert := &x509.Certificate{
-		SerialNumber: getSerial(),
+		SerialNumber: certificates.Serial(),
 		NotBefore:    time.Now(),
 		NotAfter:     time.Now().AddDate(1, 0, 0),
 		Subject:      pkix.Name{CommonName: "front-proxy-client"},
-		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 	}
-	return createCert(cert, ca)
+	return cert, nil
 }
 
-func newApiserverKubeletClientCert(ca *certificates.Certificate, advertisedName *string, advertisedIp *string) (*certificates.Certificate, error) {
+func apiserverKubeletClientTemplate(spec *claiov1beta1.ControlPlaneSpec) (*x509.Certificate, error) {
 	cert := &x509.Certificate{
-		SerialNumber: getSerial(),
+		SerialNumber: certificates.Serial(),
 		NotBefore:    time.Now(),
 		NotAfter:     time.Now().AddDate(1, 0, 0),
 		Subject:      pkix.Name{CommonName: "kube-apiserver-kubelet-client", Organization: []string{"system:masters"}},
-		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
 	}
-	return createCert(cert, ca)
+	return cert, nil
 }
 
-func newKubernetesAdminCert(ca *certificates.Certificate, _, _ *string) (*certificates.Certificate, error) {
+func kubernetesAdminTemplate(spec *claiov1beta1.ControlPlaneSpec) (*x509.Certificate, error) {
 	cert := &x509.Certificate{
-		SerialNumber: getSerial(),
+		SerialNumber: certificates.Serial(),
 		NotBefore:    time.Now(),
 		NotAfter:     time.Now().AddDate(1, 0, 0),
 		Subject:      pkix.Name{CommonName: "kubernetes-admin", Organization: []string{"system:masters"}},
-		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
 	}
-	return createCert(cert, ca)
+	return cert, nil
 }
 
-func newSaCert(ca *certificates.Certificate, advertisedName *string, advertisedIp *string) (*certificates.Certificate, error) {
+func saTemplate(spec *claiov1beta1.ControlPlaneSpec) (*x509.Certificate, error) {
 	cert := &x509.Certificate{
-		SerialNumber: getSerial(),
+		SerialNumber: certificates.Serial(),
 		NotBefore:    time.Now(),
 		NotAfter:     time.Now().AddDate(1, 0, 0),
 		Subject:      pkix.Name{CommonName: "SA"},
-		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
 	}
-	return createCert(cert, ca)
+	return cert, nil
 }
 
 func (c *ControlPlane) getCertificateSecret(name string) (*certificates.Certificate, error) {
+	return c.getCertificateSecretAs(name, name)
+}
+
+// getCertificateSecretAs reads a certificate whose keys in the secret are named differently,
+// e.g. the next CA of a rotation is stored as ca.crt in the secret ca-next
+func (c *ControlPlane) getCertificateSecretAs(name, keyName string) (*certificates.Certificate, error) {
 	secretData, err := c.GetSecret(name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get secret %s/%s: %v", c.Namespace(), name, err)
@@ -203,7 +182,7 @@ func (c *ControlPlane) getCertificateSecret(name string) (*certificates.Certific
 	if secretData == nil {
 		return nil, nil
 	}
-	cert, err := certificates.NewCertificateFromSecretData(name, secretData)
+	cert, err := certificates.NewCertificateFromSecretData(keyName, secretData)
 	if err != nil {
 		return nil, err
 	}
@@ -211,24 +190,113 @@ func (c *ControlPlane) getCertificateSecret(name string) (*certificates.Certific
 }
 
 func (c *ControlPlane) createCertificateSecret(name string, cert *certificates.Certificate) error {
-	data := make(map[string][]byte)
-	data[name+".key"] = []byte(cert.Key)
-	data[name+".crt"] = []byte(cert.Cert)
-	data[name+".pub"] = []byte(cert.Pub)
-	if err := c.CreateSecret(name, data); err != nil {
+	if err := c.CreateSecret(name, certificateSecretData(name, cert)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (c *ControlPlane) getCertificate(name, caName string, fn CertificateCreator, forceCreate bool) (*certificates.Certificate, bool, error) {
+// certificateSecretData are the keys of a certificate in a secret, the chain of an intermediate
+// CA is kept next to its certificate
+func certificateSecretData(keyName string, cert *certificates.Certificate) map[string][]byte {
+	data := make(map[string][]byte)
+	data[keyName+".key"] = []byte(cert.Key)
+	data[keyName+".crt"] = []byte(cert.Cert)
+	data[keyName+".pub"] = []byte(cert.Pub)
+	if cert.Chain != "" {
+		data[keyName+"-chain.crt"] = []byte(cert.Chain)
+	}
+	return data
+}
+
+// externalCA reads the CA of the secret of the spec and checks that it can sign
+func (c *ControlPlane) externalCA(source *claiov1beta1.CASource) (*certificates.Certificate, error) {
+	name := source.SecretRef.Name
+	data, err := c.GetSecret(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %s", c.Namespace(), name, err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("secret %s of the CA does not exist", name)
+	}
+	if len(data[corev1.TLSCertKey]) == 0 || len(data[corev1.TLSPrivateKeyKey]) == 0 {
+		return nil, fmt.Errorf("secret %s of the CA needs %s and %s", name, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+	}
+	ca, err := certificates.FromChain(data[corev1.TLSPrivateKeyKey], data[corev1.TLSCertKey], data[externalCARootKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA in secret %s: %s", name, err)
+	}
+	if err := ca.CheckCA(); err != nil {
+		return nil, fmt.Errorf("invalid CA in secret %s: %s", name, err)
+	}
+	return ca, nil
+}
+
+// getExternalCA copies the CA of the secret of the spec into the secret name. A changed CA
+// replaces the copy if replace is set, the tenant CA is replaced by a rotation instead.
+func (c *ControlPlane) getExternalCA(name string, source *claiov1beta1.CASource, replace, forceCreate bool) (*certificates.Certificate, bool, error) {
+	external, err := c.externalCA(source)
+	if err != nil {
+		return nil, false, err
+	}
+	current, err := c.getCertificateSecret(name)
+	if err != nil {
+		return nil, false, err
+	}
+	if current != nil && !forceCreate && (!replace || sameCA(current, external)) {
+		return current, false, nil
+	}
+	c.LogInfo("copy the CA of secret %s into %s", source.SecretRef.Name, name)
+	if err := c.applySecret(name, certificateSecretData(name, external)); err != nil {
+		return nil, false, fmt.Errorf("failed to create secret %s: %s", name, err)
+	}
+	return external, true, nil
+}
+
+// sameCA is true if both are the same key pair with the same chain
+func sameCA(a, b *certificates.Certificate) bool {
+	return a.Cert == b.Cert && a.Key == b.Key && a.Chain == b.Chain
+}
+
+// getCertificate returns the certificate of the secret, it is created with a key of the algorithm
+// if it does not exist and renewed if it no longer matches the CA, the spec or the algorithm or
+// expires within the renewal window. Certificates which are not renewed only need a matching key.
+func (c *ControlPlane) getCertificate(name, caName string, fn CertificateTemplate, algorithm certificates.KeyAlgorithm, renew, forceCreate bool) (*certificates.Certificate, bool, error) {
+	template, err := fn(&c.Object.Spec)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create certificate %s: %s", name, err)
+	}
+	// CAs and the service-account key are self-signed
+	var ca *certificates.Certificate
+	if caName != "" {
+		ca, err = c.getCertificateSecret(caName)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get CA (as secret) %s: %s", caName, err)
+		}
+		if ca == nil {
+			return nil, false, fmt.Errorf("CA %s does not exist", caName)
+		}
+	}
 	cert, err := c.getCertificateSecret(name)
 	if err != nil {
 		return nil, false, err
 	}
 	if cert != nil {
-		if !forceCreate && cert.IsValid() {
-			return cert, false, nil
+		if !forceCreate {
+			err := cert.CheckKey()
+			if renew && err == nil {
+				err = cert.Validate(ca, template, c.renewBefore())
+			}
+			if renew && err == nil {
+				err = checkKeyAlgorithm(cert, algorithm)
+			}
+			if err == nil {
+				if renew {
+					c.scheduleRenewal(cert)
+				}
+				return cert, false, nil
+			}
+			c.LogInfo("renew certificate %s: %s", name, err)
 		}
 		c.LogInfo("delete old/invalid secret: %s", name)
 		if err := c.DeleteSecret(name); err != nil {
@@ -236,91 +304,190 @@ func (c *ControlPlane) getCertificate(name, caName string, fn CertificateCreator
 		}
 	}
 	c.LogInfo("create certificate: %s", name)
-	if caName == "" {
-		// a CA
-		cert, err = fn(nil, nil, nil)
-	} else {
-		host := c.Object.Spec.AdvertiseHost
-		ip := c.Object.Spec.AdvertiseAddress
-		ca, err1 := c.getCertificateSecret(caName)
-		if err1 != nil {
-			return nil, true, fmt.Errorf("failed to get CA (as secret) %s: %s", caName, err1)
-		}
-		cert, err = fn(ca, &host, &ip)
-	}
+	cert, err = createCert(template, ca, algorithm)
 	if err != nil {
 		return nil, true, fmt.Errorf("failed to create certificate %s: %s", name, err)
 	}
 	if err := c.createCertificateSecret(name, cert); err != nil {
 		return nil, true, fmt.Errorf("failed to create secret %s: %s", name, err)
 	}
+	if renew {
+		c.scheduleRenewal(cert)
+	}
 	return cert, true, nil
 }
 
-type CertificateCreator func(ca *certificates.Certificate, advertisedName, advertisedIp *string) (*certificates.Certificate, error)
+// getLeafCertificate returns a certificate of the control-plane from the backend of the spec
+func (c *ControlPlane) getLeafCertificate(name, caName string, fn CertificateTemplate, forceCreate bool) (*certificates.Certificate, bool, error) {
+	if c.certManager() {
+		return c.getIssuedCertificate(name, caName, fn)
+	}
+	return c.getCertificate(name, caName, fn, c.keyAlgorithm(), true, forceCreate)
+}
+
+// createCert signs the template with the CA or self-signed with a new key of the algorithm
+func createCert(template *x509.Certificate, ca *certificates.Certificate, algorithm certificates.KeyAlgorithm) (*certificates.Certificate, error) {
+	withKeyUsage(template, algorithm)
+	return certificates.CreateWithAlgorithm(template, ca, algorithm)
+}
+
+// withKeyUsage adds the key usages which depend on the algorithm to the template, only RSA keys
+// encipher keys
+func withKeyUsage(template *x509.Certificate, algorithm certificates.KeyAlgorithm) {
+	if algorithm == certificates.RSA {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+}
+
+// CertificateTemplate returns the certificate the spec asks for, it is signed by the CA of the
+// secret or self-signed
+type CertificateTemplate func(spec *claiov1beta1.ControlPlaneSpec) (*x509.Certificate, error)
+
+// keyAlgorithm is the algorithm of the keys of the tenant
+func (c *ControlPlane) keyAlgorithm() certificates.KeyAlgorithm {
+	if algorithm := c.Object.Spec.Certificates.KeyAlgorithm; algorithm != "" {
+		return certificates.KeyAlgorithm(algorithm)
+	}
+	return certificates.RSA
+}
+
+// checkKeyAlgorithm returns an error if the key of the certificate is not of the algorithm
+func checkKeyAlgorithm(cert *certificates.Certificate, algorithm certificates.KeyAlgorithm) error {
+	current, err := cert.KeyAlgorithm()
+	if err != nil {
+		return err
+	}
+	if current != algorithm {
+		return fmt.Errorf("the key is %s instead of %s", current, algorithm)
+	}
+	return nil
+}
+
+// renewBefore is the window before the expiry in which certificates are renewed
+func (c *ControlPlane) renewBefore() time.Duration {
+	if renewBefore := c.Object.Spec.Certificates.RenewBefore; renewBefore != nil && renewBefore.Duration > 0 {
+		return renewBefore.Duration
+	}
+	return defaultRenewBefore
+}
+
+// scheduleRenewal remembers when the certificate is due for renewal, the reconciler requeues
+// the control-plane for the first certificate
+func (c *ControlPlane) scheduleRenewal(cert *certificates.Certificate) {
+	raw, err := cert.RawCert()
+	if err != nil {
+		return
+	}
+	renewAt := raw.NotAfter.Add(-c.renewBefore())
+	if c.renewAt.IsZero() || renewAt.Before(c.renewAt) {
+		c.renewAt = renewAt
+	}
+}
+
+// certificatesMessage describes the next renewal, the stage of a running or blocked CA rotation
+// or the certificates cert-manager has not issued yet
+func (c *ControlPlane) certificatesMessage() string {
+	if rotation := c.Object.Status.CARotation; c.CARotating() {
+		return fmt.Sprintf("the CA is rotated, stage %s: %s", rotation.Stage, rotation.Message)
+	} else if rotation != nil && rotation.Stage == claiov1beta1.CARotationBlocked {
+		return fmt.Sprintf("the CA rotation is blocked: %s", rotation.Message)
+	}
+	if len(c.pendingCertificates) > 0 {
+		return c.certificatesPendingMessage()
+	}
+	return fmt.Sprintf("all certificates are valid, the next renewal is at %s", c.renewAt.UTC().Format(time.RFC3339))
+}
+
+// untilRenewal is the time until the first certificate is renewed, zero if no certificate was
+// checked. Certificates cert-manager has not issued yet are checked again shortly.
+func (c *ControlPlane) untilRenewal() time.Duration {
+	if len(c.pendingCertificates) > 0 {
+		return certManagerInterval
+	}
+	if c.renewAt.IsZero() {
+		return 0
+	}
+	return max(time.Until(c.renewAt), time.Second)
+}
 
 // ----------------------------------------------------------------
 
+// GetCaCert returns the CA of the tenant, it is replaced by a staged rotation before it expires
+// or when the CA of the spec changes (reconcileCARotation)
 func (c *ControlPlane) GetCaCert(forceCreate bool) (*certificates.Certificate, bool, error) {
-	return c.getCertificate("ca", "", newCaCert, forceCreate)
+	if source := c.Object.Spec.Certificates.CA; source != nil {
+		return c.getExternalCA(caSecretName, source, false, forceCreate)
+	}
+	return c.getCertificate(caSecretName, "", caTemplate, c.keyAlgorithm(), false, forceCreate)
 }
 
 func (c *ControlPlane) GetApiserverCert(forceCreate bool) (*certificates.Certificate, bool, error) {
-	return c.getCertificate("apiserver", "ca", newApiserverCert, forceCreate)
+	return c.getLeafCertificate("apiserver", "ca", apiserverTemplate, forceCreate)
 }
 
 func (c *ControlPlane) GetApiserverKubeletClientCert(forceCreate bool) (*certificates.Certificate, bool, error) {
-	return c.getCertificate("apiserver-kubelet-client", "ca", newApiserverKubeletClientCert, forceCreate)
+	return c.getLeafCertificate("apiserver-kubelet-client", "ca", apiserverKubeletClientTemplate, forceCreate)
 }
 
 func (c *ControlPlane) GetFrontProxyCaCert(forceCreate bool) (*certificates.Certificate, bool, error) {
-	return c.getCertificate("front-proxy-ca", "", newFrontProxyCaCert, forceCreate)
+	if source := c.Object.Spec.Certificates.FrontProxyCA; source != nil {
+		return c.getExternalCA("front-proxy-ca", source, true, forceCreate)
+	}
+	return c.getCertificate("front-proxy-ca", "", frontProxyCaTemplate, c.keyAlgorithm(), true, forceCreate)
 }
 
 func (c *ControlPlane) GetFrontProxyClientCert(forceCreate bool) (*certificates.Certificate, bool, error) {
-	return c.getCertificate("front-proxy-client", "front-proxy-ca", newFrontProxyClientCert, forceCreate)
+	return c.getLeafCertificate("front-proxy-client", "front-proxy-ca", frontProxyClientTemplate, forceCreate)
 }
 
+// GetSaCert returns the key pair which signs the service-account tokens, only the key is used.
+// It is not renewed, a new key would invalidate the tokens of the tenant. Kubernetes signs tokens
+// with RSA and ECDSA keys only, an Ed25519 tenant gets an ECDSA P-256 key.
 func (c *ControlPlane) GetSaCert(forceCreate bool) (*certificates.Certificate, bool, error) {
-	return c.getCertificate("sa", "", newSaCert, forceCreate)
+	algorithm := c.keyAlgorithm()
+	if algorithm == certificates.Ed25519 {
+		algorithm = certificates.ECDSAP256
+	}
+	return c.getCertificate("sa", "", saTemplate, algorithm, false, forceCreate)
 }
 
-func (c *ControlPlane) reconcileCertificates() (bool, bool, error) {
+// reconcileCertificates creates and renews the certificates of the tenant and the bundle of the
+// CAs it trusts, it returns true if the CA was created
+func (c *ControlPlane) reconcileCertificates() (bool, error) {
 	c.LogHeader("check secrets ...")
 	// ca
-	_, caChanged, err := c.GetCaCert(false)
+	ca, caChanged, err := c.GetCaCert(false)
 	if err != nil {
-		return false, false, fmt.Errorf("failed to get ca")
+		return false, fmt.Errorf("failed to get ca: %s", err)
+	}
+	if err := c.reconcileCABundle(ca); err != nil {
+		return caChanged, fmt.Errorf("failed to get ca-bundle: %s", err)
 	}
 	// apiserver (force renew if CA has changed)
-	_, certChanged, err := c.GetApiserverCert(caChanged)
-	if err != nil {
-		return caChanged, false, fmt.Errorf("failed to get apiserver")
+	if _, _, err := c.GetApiserverCert(caChanged); err != nil {
+		return caChanged, fmt.Errorf("failed to get apiserver: %s", err)
 	}
 	// apiserver-kubelet-client (force renew if CA has changed)
-	_, changed, err := c.GetApiserverKubeletClientCert(caChanged)
-	if err != nil {
-		return caChanged, false, fmt.Errorf("failed to get apiserver-kubelet-client")
+	if _, _, err := c.GetApiserverKubeletClientCert(caChanged); err != nil {
+		return caChanged, fmt.Errorf("failed to get apiserver-kubelet-client: %s", err)
 	}
-	certChanged = changed || certChanged
 	// front-proxy-ca
 	_, frontProxyCaChanged, err := c.GetFrontProxyCaCert(false)
 	if err != nil {
-		return caChanged, false, fmt.Errorf("failed to get front-proxy-ca")
+		return caChanged, fmt.Errorf("failed to get front-proxy-ca: %s", err)
 	}
-	certChanged = frontProxyCaChanged || certChanged
 	// front-proxy-client
-	_, changed, err = c.GetFrontProxyClientCert(frontProxyCaChanged)
-	if err != nil {
-		return caChanged, false, fmt.Errorf("failed to get front-proxy-client")
+	if _, _, err := c.GetFrontProxyClientCert(frontProxyCaChanged); err != nil {
+		return caChanged, fmt.Errorf("failed to get front-proxy-client: %s", err)
 	}
-	certChanged = changed || certChanged
 	// sa
-	_, changed, err = c.GetSaCert(false)
-	if err != nil {
-		return caChanged, false, fmt.Errorf("failed to get sa")
+	if _, _, err := c.GetSaCert(false); err != nil {
+		return caChanged, fmt.Errorf("failed to get sa: %s", err)
+	}
+	// cert-manager objects of a former backend
+	if err := c.reconcileCertManager(); err != nil {
+		return caChanged, err
 	}
-	certChanged = changed || certChanged
 
-	return caChanged, certChanged, nil
+	return caChanged, nil
 }
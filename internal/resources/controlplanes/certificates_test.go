@@ -0,0 +1,207 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	"claio/internal/certificates"
+	"crypto/x509"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Certificates", func() {
+	var c *ControlPlane
+
+	BeforeEach(func() {
+		c = newTestControlPlane()
+		_, err := c.reconcileCertificates()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	// replaceApiserver stores a certificate of the apiserver template changed by prepare, it is
+	// signed by the CA and gets the key of another certificate if otherKey is set
+	replaceApiserver := func(ca *certificates.Certificate, prepare func(template *x509.Certificate), otherKey bool) string {
+		template, err := apiserverTemplate(&c.Object.Spec)
+		Expect(err).NotTo(HaveOccurred())
+		prepare(template)
+		cert, err := certificates.Create(template, ca)
+		Expect(err).NotTo(HaveOccurred())
+		if otherKey {
+			other, err := certificates.Create(template, ca)
+			Expect(err).NotTo(HaveOccurred())
+			cert.Key = other.Key
+		}
+		Expect(c.DeleteSecret("apiserver")).To(Succeed())
+		Expect(c.createCertificateSecret("apiserver", cert)).To(Succeed())
+		return cert.Cert
+	}
+
+	secretCert := func(name string) *certificates.Certificate {
+		cert, err := c.getCertificateSecret(name)
+		Expect(err).NotTo(HaveOccurred())
+		return cert
+	}
+
+	caOfTenant := func() *certificates.Certificate {
+		ca, err := c.getCertificateSecret(caSecretName)
+		Expect(err).NotTo(HaveOccurred())
+		return ca
+	}
+
+	Context("When the certificates are valid", func() {
+		It("Should requeue at the renewal window of the first certificate", func() {
+			expected := time.Until(time.Now().AddDate(1, 0, 0).Add(-defaultRenewBefore))
+			Expect(c.untilRenewal()).To(BeNumerically("~", expected, time.Minute))
+			Expect(c.certificatesMessage()).To(ContainSubstring("the next renewal is at"))
+		})
+
+		It("Should requeue earlier with the renewal window of the spec", func() {
+			c.Object.Spec.Certificates.RenewBefore = &metav1.Duration{Duration: 90 * 24 * time.Hour}
+			c.renewAt = time.Time{}
+			_, err := c.reconcileCertificates()
+			Expect(err).NotTo(HaveOccurred())
+			expected := time.Until(time.Now().AddDate(1, 0, 0).Add(-90 * 24 * time.Hour))
+			Expect(c.untilRenewal()).To(BeNumerically("~", expected, time.Minute))
+		})
+
+		It("Should keep them", func() {
+			before, err := c.GetSecret("apiserver")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.reconcileCertificates()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.GetSecret("apiserver")).To(Equal(before))
+		})
+	})
+
+	DescribeTable("renews a certificate of the apiserver",
+		func(prepare func(template *x509.Certificate), otherCA, otherKey bool) {
+			ca := caOfTenant()
+			if otherCA {
+				template, err := caTemplate(&c.Object.Spec)
+				Expect(err).NotTo(HaveOccurred())
+				ca, err = certificates.Create(template, nil)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			old := replaceApiserver(ca, prepare, otherKey)
+
+			c.renewAt = time.Time{}
+			_, err := c.reconcileCertificates()
+			Expect(err).NotTo(HaveOccurred())
+			renewed, err := c.getCertificateSecret("apiserver")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(renewed.Cert).NotTo(Equal(old))
+			Expect(renewed.Validate(caOfTenant(), nil, defaultRenewBefore)).To(Succeed())
+			// the renewed certificate moves the next renewal by a year
+			expected := time.Until(time.Now().AddDate(1, 0, 0).Add(-defaultRenewBefore))
+			Expect(c.untilRenewal()).To(BeNumerically("~", expected, time.Minute))
+		},
+		Entry("which has expired", func(template *x509.Certificate) {
+			template.NotBefore = time.Now().AddDate(-1, 0, 0)
+			template.NotAfter = time.Now().Add(-time.Hour)
+		}, false, false),
+		Entry("which expires within the renewal window", func(template *x509.Certificate) {
+			template.NotAfter = time.Now().Add(24 * time.Hour)
+		}, false, false),
+		Entry("which is issued by another CA", func(template *x509.Certificate) {}, true, false),
+		Entry("whose key does not belong to it", func(template *x509.Certificate) {}, false, true),
+	)
+
+	It("Should renew the apiserver certificate when the SANs of the spec change", func() {
+		before, err := c.getCertificateSecret("apiserver")
+		Expect(err).NotTo(HaveOccurred())
+		c.Object.Spec.Certificates.ExtraSANs = []string{"api.example.com"}
+		_, err = c.reconcileCertificates()
+		Expect(err).NotTo(HaveOccurred())
+		after, err := c.getCertificateSecret("apiserver")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after.Cert).NotTo(Equal(before.Cert))
+		raw, err := after.RawCert()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(raw.DNSNames).To(ContainElement("api.example.com"))
+	})
+
+	It("Should requeue shortly if the renewal is due", func() {
+		c.renewAt = time.Now().Add(-time.Minute)
+		Expect(c.untilRenewal()).To(Equal(time.Second))
+	})
+
+	It("Should not requeue without certificates", func() {
+		c.renewAt = time.Time{}
+		Expect(c.untilRenewal()).To(BeZero())
+	})
+
+	It("Should requeue at the interval of cert-manager while certificates are pending", func() {
+		c.pendingCertificates = []string{"apiserver"}
+		Expect(c.untilRenewal()).To(Equal(certManagerInterval))
+	})
+
+	DescribeTable("sets the key usages of the key algorithm",
+		func(algorithm certificates.KeyAlgorithm, keyEncipherment bool) {
+			c = newTestControlPlane()
+			c.Object.Spec.Certificates.KeyAlgorithm = string(algorithm)
+			_, err := c.reconcileCertificates()
+			Expect(err).NotTo(HaveOccurred())
+			data, _, err := c.GetAdminKubeconfig(false)
+			Expect(err).NotTo(HaveOccurred())
+			bundle, err := c.CACertificate()
+			Expect(err).NotTo(HaveOccurred())
+			kubeconfig, err := kubeconfigClientCert(data, c.Namespace(), "kubernetes-admin", c.Endpoint(), bundle)
+			Expect(err).NotTo(HaveOccurred())
+
+			for name, cert := range map[string]*certificates.Certificate{
+				caSecretName:       caOfTenant(),
+				"apiserver":        secretCert("apiserver"),
+				"front-proxy-ca":   secretCert("front-proxy-ca"),
+				"kubeconfig-admin": kubeconfig,
+			} {
+				raw, err := cert.RawCert()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cert.KeyAlgorithm()).To(Equal(algorithm), name)
+				Expect(raw.KeyUsage&x509.KeyUsageDigitalSignature).NotTo(BeZero(), name)
+				Expect(raw.KeyUsage&x509.KeyUsageKeyEncipherment != 0).To(Equal(keyEncipherment), name)
+			}
+		},
+		Entry("RSA keys encipher keys", certificates.RSA, true),
+		Entry("ECDSA P-256 keys do not", certificates.ECDSAP256, false),
+		Entry("ECDSA P-384 keys do not", certificates.ECDSAP384, false),
+		Entry("Ed25519 keys do not", certificates.Ed25519, false),
+	)
+
+	// the apiserver cannot sign service-account tokens with Ed25519 keys
+	DescribeTable("creates the service-account key of the key algorithm",
+		func(algorithm, expected certificates.KeyAlgorithm) {
+			c = newTestControlPlane()
+			c.Object.Spec.Certificates.KeyAlgorithm = string(algorithm)
+			_, err := c.reconcileCertificates()
+			Expect(err).NotTo(HaveOccurred())
+			sa := secretCert("sa")
+			Expect(sa.KeyAlgorithm()).To(Equal(expected))
+			Expect(sa.CheckKey()).To(Succeed())
+
+			cert, changed, err := c.GetSaCert(false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed).To(BeFalse())
+			Expect(cert.Key).To(Equal(sa.Key))
+		},
+		Entry("RSA", certificates.RSA, certificates.RSA),
+		Entry("ECDSA P-384", certificates.ECDSAP384, certificates.ECDSAP384),
+		Entry("ECDSA P-256 instead of Ed25519", certificates.Ed25519, certificates.ECDSAP256),
+	)
+})
@@ -0,0 +1,375 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/certificates"
+	"claio/internal/kubernetes"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// CertManagerLabel on the secrets cert-manager issues names the control-plane they belong to
+	CertManagerLabel = "claio.github.com/control-plane"
+
+	// certManagerInterval is how often certificates cert-manager has not issued yet are checked
+	certManagerInterval = 15 * time.Second
+	// issuedSecretSuffix names the kubernetes.io/tls secret cert-manager issues a certificate into,
+	// the manager copies it into the secret of the certificate
+	issuedSecretSuffix = "-tls"
+	// issuerSecretSuffix names the secret of the key pair of the Issuer of a CA
+	issuerSecretSuffix = "-issuer"
+)
+
+// issuedCertificates are the certificates cert-manager issues with the CAs which sign them
+var issuedCertificates = map[string]string{
+	"apiserver":                caSecretName,
+	"apiserver-kubelet-client": caSecretName,
+	"front-proxy-client":       "front-proxy-ca",
+}
+
+// certManagerValues are the values of the cert-manager templates
+type certManagerValues struct {
+	Name          string
+	Namespace     string
+	ControlPlane  string
+	SecretName    string
+	IssuerName    string
+	CommonName    string
+	Organizations []string
+	DNSNames      []string
+	IPAddresses   []string
+	Usages        []string
+	Duration      string
+	RenewBefore   string
+	PrivateKey    certManagerPrivateKey
+}
+
+// certManagerPrivateKey is the privateKey of a cert-manager Certificate
+type certManagerPrivateKey struct {
+	Algorithm string
+	Encoding  string
+	Size      int
+}
+
+// certManagerPrivateKeys are the keys of the key algorithms, encoded like the keys of the manager
+var certManagerPrivateKeys = map[certificates.KeyAlgorithm]certManagerPrivateKey{
+	certificates.RSA:       {Algorithm: "RSA", Encoding: "PKCS1", Size: 2048},
+	certificates.ECDSAP256: {Algorithm: "ECDSA", Encoding: "PKCS8", Size: 256},
+	certificates.ECDSAP384: {Algorithm: "ECDSA", Encoding: "PKCS8", Size: 384},
+	certificates.Ed25519:   {Algorithm: "Ed25519", Encoding: "PKCS8"},
+}
+
+// certManager is true if cert-manager issues the certificates of the tenant
+func (c *ControlPlane) certManager() bool {
+	return c.Object.Spec.Certificates.Backend == claiov1beta1.CertificateBackendCertManager
+}
+
+// getIssuedCertificate requests the certificate from cert-manager with the Issuer of the CA and
+// copies the issued certificate into the secret name once it matches the CA and the spec.
+// cert-manager renews the certificate, a certificate of an old CA is issued again by deleting
+// its secret.
+func (c *ControlPlane) getIssuedCertificate(name, caName string, fn CertificateTemplate) (*certificates.Certificate, bool, error) {
+	template, err := fn(&c.Object.Spec)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create certificate %s: %s", name, err)
+	}
+	withKeyUsage(template, c.keyAlgorithm())
+	ca, err := c.getCertificateSecret(caName)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get CA (as secret) %s: %s", caName, err)
+	}
+	if ca == nil {
+		return nil, false, fmt.Errorf("CA %s does not exist", caName)
+	}
+	if err := c.applyIssuer(caName, ca); err != nil {
+		return nil, false, err
+	}
+	if err := c.applyCertificateRequest(name, caName, template); err != nil {
+		return nil, false, err
+	}
+
+	current, err := c.getCertificateSecret(name)
+	if err != nil {
+		return nil, false, err
+	}
+	issued, err := c.issuedCertificate(name)
+	if err != nil {
+		return nil, false, err
+	}
+	if issued != nil {
+		if err := issued.Validate(ca, nil, 0); err != nil {
+			c.LogInfo("issue certificate %s again: %s", name, err)
+			if err := c.DeleteSecret(name + issuedSecretSuffix); err != nil {
+				return nil, false, fmt.Errorf("failed to delete secret %s: %s", name+issuedSecretSuffix, err)
+			}
+			issued = nil
+		} else {
+			err := issued.Validate(ca, template, 0)
+			if err == nil {
+				err = checkKeyAlgorithm(issued, c.keyAlgorithm())
+			}
+			if err != nil {
+				// cert-manager has not seen the change of the Certificate yet
+				c.LogInfo("wait for certificate %s: %s", name, err)
+				issued = nil
+			}
+		}
+	}
+	if issued == nil {
+		if current == nil {
+			return nil, false, fmt.Errorf("certificate %s is not issued by cert-manager yet", name)
+		}
+		c.pendingCertificates = append(c.pendingCertificates, name)
+		return current, false, nil
+	}
+	c.scheduleRenewal(issued)
+	if current != nil && current.Cert == issued.Cert && current.Key == issued.Key {
+		return current, false, nil
+	}
+	c.LogInfo("copy certificate %s issued by cert-manager", name)
+	if err := c.applySecret(name, certificateSecretData(name, issued)); err != nil {
+		return nil, false, fmt.Errorf("failed to create secret %s: %s", name, err)
+	}
+	return issued, true, nil
+}
+
+// issuedCertificate reads the kubernetes.io/tls secret cert-manager issued, it is nil if the
+// secret does not exist (yet)
+func (c *ControlPlane) issuedCertificate(name string) (*certificates.Certificate, error) {
+	secretName := name + issuedSecretSuffix
+	data, err := c.GetSecret(secretName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %s", c.Namespace(), secretName, err)
+	}
+	if len(data[corev1.TLSCertKey]) == 0 || len(data[corev1.TLSPrivateKeyKey]) == 0 {
+		return nil, nil
+	}
+	cert, err := certificates.FromKeyPair(data[corev1.TLSPrivateKeyKey], data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate in secret %s: %s", secretName, err)
+	}
+	return cert, nil
+}
+
+// applyIssuer applies the CA Issuer of cert-manager with the key pair of the CA
+func (c *ControlPlane) applyIssuer(caName string, ca *certificates.Certificate) error {
+	data := map[string][]byte{
+		corev1.TLSCertKey:       []byte(ca.Cert + ca.Chain),
+		corev1.TLSPrivateKeyKey: []byte(ca.Key),
+	}
+	if err := c.applySecret(caName+issuerSecretSuffix, data); err != nil {
+		return fmt.Errorf("failed to apply secret %s: %s", caName+issuerSecretSuffix, err)
+	}
+	return c.applyCertManagerObjects(issuerTemplate, &certManagerValues{
+		Name:       caName,
+		Namespace:  c.Namespace(),
+		SecretName: caName + issuerSecretSuffix,
+	})
+}
+
+// applyCertificateRequest applies the cert-manager Certificate of the template
+func (c *ControlPlane) applyCertificateRequest(name, caName string, template *x509.Certificate) error {
+	values := &certManagerValues{
+		Name:          name,
+		Namespace:     c.Namespace(),
+		ControlPlane:  c.Object.Name,
+		SecretName:    name + issuedSecretSuffix,
+		IssuerName:    caName,
+		CommonName:    template.Subject.CommonName,
+		Organizations: template.Subject.Organization,
+		DNSNames:      template.DNSNames,
+		Usages:        certManagerUsages(template),
+		Duration:      template.NotAfter.Sub(template.NotBefore).Round(time.Hour).String(),
+		RenewBefore:   c.renewBefore().String(),
+		PrivateKey:    certManagerPrivateKeys[c.keyAlgorithm()],
+	}
+	for _, ip := range template.IPAddresses {
+		values.IPAddresses = append(values.IPAddresses, ip.String())
+	}
+	return c.applyCertManagerObjects(certificateTemplate, values)
+}
+
+// certManagerUsages are the key usages of the template as cert-manager names them
+func certManagerUsages(template *x509.Certificate) []string {
+	usages := []string{}
+	if template.KeyUsage&x509.KeyUsageDigitalSignature != 0 {
+		usages = append(usages, "digital signature")
+	}
+	if template.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+		usages = append(usages, "key encipherment")
+	}
+	for _, usage := range template.ExtKeyUsage {
+		switch usage {
+		case x509.ExtKeyUsageServerAuth:
+			usages = append(usages, "server auth")
+		case x509.ExtKeyUsageClientAuth:
+			usages = append(usages, "client auth")
+		}
+	}
+	return usages
+}
+
+// applyCertManagerObjects applies the objects of the template, they are owned by the
+// control-plane
+func (c *ControlPlane) applyCertManagerObjects(tmpl string, values *certManagerValues) error {
+	objects, err := c.certManagerObjects(tmpl, values)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := ctrl.SetControllerReference(c.Object, obj, c.Scheme); err != nil {
+			return fmt.Errorf("cannot set owner-reference on %s %s: %s", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+	return kubernetes.ApplyObjects(c.Client, c.Ctx, objects)
+}
+
+func (c *ControlPlane) certManagerObjects(tmpl string, values *certManagerValues) ([]*unstructured.Unstructured, error) {
+	yaml, err := c.ToYaml(tmpl, values)
+	if err != nil {
+		return nil, fmt.Errorf("error generating yaml of %s: %s", values.Name, err)
+	}
+	objects, err := kubernetes.DecodeYaml(yaml)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding yaml of %s: %s", values.Name, err)
+	}
+	return objects, nil
+}
+
+// reconcileCertManager removes the Certificates, Issuers and their secrets when the tenant no
+// longer uses cert-manager, the manager issues the certificates again once they expire
+func (c *ControlPlane) reconcileCertManager() error {
+	if c.certManager() || c.Object.Status.TargetSpec.Certificates.Backend != claiov1beta1.CertificateBackendCertManager {
+		return nil
+	}
+	return c.removeCertManagerObjects()
+}
+
+// removeCertManagerObjects deletes the Certificates and Issuers of the tenant and the secrets
+// cert-manager issued, they are not owned by the control-plane
+func (c *ControlPlane) removeCertManagerObjects() error {
+	c.LogInfo("remove the cert-manager certificates")
+	objects := []*unstructured.Unstructured{}
+	for _, name := range []string{caSecretName, "front-proxy-ca"} {
+		list, err := c.certManagerObjects(issuerTemplate, &certManagerValues{Name: name, Namespace: c.Namespace()})
+		if err != nil {
+			return err
+		}
+		objects = append(objects, list...)
+	}
+	for name := range issuedCertificates {
+		list, err := c.certManagerObjects(certificateTemplate, &certManagerValues{Name: name, Namespace: c.Namespace()})
+		if err != nil {
+			return err
+		}
+		objects = append(objects, list...)
+	}
+	for _, obj := range objects {
+		if err := c.Client.Delete(c.Ctx, obj); client.IgnoreNotFound(err) != nil && !meta.IsNoMatchError(err) {
+			return fmt.Errorf("failed to delete %s %s: %s", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+	for _, secretName := range []string{
+		"apiserver" + issuedSecretSuffix,
+		"apiserver-kubelet-client" + issuedSecretSuffix,
+		"front-proxy-client" + issuedSecretSuffix,
+		caSecretName + issuerSecretSuffix,
+		"front-proxy-ca" + issuerSecretSuffix,
+	} {
+		if err := c.DeleteSecret(secretName); err != nil {
+			return fmt.Errorf("failed to delete secret %s: %s", secretName, err)
+		}
+	}
+	return nil
+}
+
+// certificatesPendingMessage names the certificates cert-manager has not issued yet
+func (c *ControlPlane) certificatesPendingMessage() string {
+	return fmt.Sprintf("waiting for cert-manager to issue %s", strings.Join(c.pendingCertificates, ", "))
+}
+
+// issuerTemplate is the Issuer of a CA of the tenant
+const issuerTemplate = `apiVersion: cert-manager.io/v1
+kind: Issuer
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+spec:
+  ca:
+    secretName: {{ .SecretName }}
+`
+
+// certificateTemplate is a certificate of the control-plane
+const certificateTemplate = `apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+spec:
+  secretName: {{ .SecretName }}
+  secretTemplate:
+    labels:
+      claio.github.com/control-plane: {{ .ControlPlane }}
+  issuerRef:
+    group: cert-manager.io
+    kind: Issuer
+    name: {{ .IssuerName }}
+  commonName: {{ printf "%q" .CommonName }}
+  {{- if .Organizations }}
+  subject:
+    organizations:
+    {{- range .Organizations }}
+    - {{ printf "%q" . }}
+    {{- end }}
+  {{- end }}
+  {{- if .DNSNames }}
+  dnsNames:
+  {{- range .DNSNames }}
+  - {{ printf "%q" . }}
+  {{- end }}
+  {{- end }}
+  {{- if .IPAddresses }}
+  ipAddresses:
+  {{- range .IPAddresses }}
+  - {{ printf "%q" . }}
+  {{- end }}
+  {{- end }}
+  usages:
+  {{- range .Usages }}
+  - {{ printf "%q" . }}
+  {{- end }}
+  duration: {{ .Duration }}
+  renewBefore: {{ .RenewBefore }}
+  privateKey:
+    algorithm: {{ .PrivateKey.Algorithm }}
+    encoding: {{ .PrivateKey.Encoding }}
+    {{- if .PrivateKey.Size }}
+    size: {{ .PrivateKey.Size }}
+    {{- end }}
+    rotationPolicy: Always
+`
@@ -0,0 +1,305 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/certificates"
+	"context"
+	"crypto"
+	"slices"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+var _ = Describe("cert-manager", func() {
+	var (
+		c       *ControlPlane
+		applied map[string]*unstructured.Unstructured
+		deleted []string
+	)
+
+	// the fake client cannot apply, the applied and deleted cert-manager objects are recorded
+	BeforeEach(func() {
+		c = newTestControlPlane()
+		c.Object.Spec.Certificates.Backend = claiov1beta1.CertificateBackendCertManager
+		applied = map[string]*unstructured.Unstructured{}
+		deleted = nil
+		c.Client = interceptor.NewClient(c.Client.(client.WithWatch), interceptor.Funcs{
+			Patch: func(ctx context.Context, cl client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				if patch.Type() != types.ApplyPatchType {
+					return cl.Patch(ctx, obj, patch, opts...)
+				}
+				u := obj.(*unstructured.Unstructured)
+				applied[u.GetKind()+"/"+u.GetName()] = u.DeepCopy()
+				return nil
+			},
+			Delete: func(ctx context.Context, cl client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+				if u, ok := obj.(*unstructured.Unstructured); ok && u.GroupVersionKind().Group == "cert-manager.io" {
+					deleted = append(deleted, u.GetKind()+"/"+u.GetName())
+					return nil
+				}
+				return cl.Delete(ctx, obj, opts...)
+			},
+		})
+	})
+
+	reconcile := func() error {
+		c.pendingCertificates = nil
+		_, err := c.reconcileCertificates()
+		return err
+	}
+
+	certificateSpec := func(name string) map[string]any {
+		obj, ok := applied["Certificate/"+name]
+		Expect(ok).To(BeTrue(), "Certificate %s is not applied", name)
+		spec, _, err := unstructured.NestedMap(obj.Object, "spec")
+		Expect(err).NotTo(HaveOccurred())
+		return spec
+	}
+
+	// issue does what cert-manager does: it signs the certificate of the template with the key
+	// pair of the Issuer and stores it in the kubernetes.io/tls secret of the Certificate
+	issue := func(name string, fn CertificateTemplate, ca *certificates.Certificate) *certificates.Certificate {
+		if ca == nil {
+			issuerName := certificateSpec(name)["issuerRef"].(map[string]any)["name"].(string)
+			data, err := c.GetSecret(issuerName + issuerSecretSuffix)
+			Expect(err).NotTo(HaveOccurred())
+			ca, err = certificates.FromChain(data[corev1.TLSPrivateKeyKey], data[corev1.TLSCertKey], nil)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		template, err := fn(&c.Object.Spec)
+		Expect(err).NotTo(HaveOccurred())
+		cert, err := certificates.CreateWithAlgorithm(template, ca, c.keyAlgorithm())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.DeleteSecret(name + issuedSecretSuffix)).To(Succeed())
+		Expect(c.Client.Create(c.Ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name + issuedSecretSuffix, Namespace: c.Namespace(),
+				Labels: map[string]string{CertManagerLabel: c.Object.Name},
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       []byte(cert.Cert),
+				corev1.TLSPrivateKeyKey: []byte(cert.Key),
+				"ca.crt":                []byte(ca.Cert),
+			},
+		})).To(Succeed())
+		return cert
+	}
+
+	issueAll := func() {
+		Expect(reconcile()).To(MatchError(ContainSubstring("is not issued by cert-manager yet")))
+		issue("apiserver", apiserverTemplate, nil)
+		Expect(reconcile()).To(MatchError(ContainSubstring("is not issued by cert-manager yet")))
+		issue("apiserver-kubelet-client", apiserverKubeletClientTemplate, nil)
+		Expect(reconcile()).To(MatchError(ContainSubstring("is not issued by cert-manager yet")))
+		issue("front-proxy-client", frontProxyClientTemplate, nil)
+		Expect(reconcile()).To(Succeed())
+		Expect(c.pendingCertificates).To(BeEmpty())
+	}
+
+	It("Should apply an Issuer of each CA with its key pair", func() {
+		issueAll()
+		for _, caName := range []string{caSecretName, "front-proxy-ca"} {
+			issuer, ok := applied["Issuer/"+caName]
+			Expect(ok).To(BeTrue(), "Issuer %s is not applied", caName)
+			Expect(issuer.GetNamespace()).To(Equal("tenant-sample"))
+			secretName, _, err := unstructured.NestedString(issuer.Object, "spec", "ca", "secretName")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secretName).To(Equal(caName + issuerSecretSuffix))
+			Expect(issuer.GetOwnerReferences()).To(ConsistOf(HaveField("Name", "sample")))
+
+			ca, err := c.getCertificateSecret(caName)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := c.GetSecret(caName + issuerSecretSuffix)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data[corev1.TLSCertKey])).To(Equal(ca.Cert))
+			Expect(string(data[corev1.TLSPrivateKeyKey])).To(Equal(ca.Key))
+		}
+	})
+
+	It("Should request the certificates with the subject, the SANs and the lifetime of the spec", func() {
+		c.Object.Spec.Certificates.ExtraSANs = []string{"api.example.com", "192.168.1.11"}
+		c.Object.Spec.Certificates.RenewBefore = &metav1.Duration{Duration: 10 * 24 * time.Hour}
+		issueAll()
+
+		spec := certificateSpec("apiserver")
+		Expect(spec["secretName"]).To(Equal("apiserver-tls"))
+		Expect(spec["issuerRef"]).To(Equal(map[string]any{"group": "cert-manager.io", "kind": "Issuer", "name": "ca"}))
+		Expect(spec["secretTemplate"]).To(Equal(map[string]any{"labels": map[string]any{CertManagerLabel: "sample"}}))
+		Expect(spec["commonName"]).To(Equal("kube-apiserver"))
+		Expect(spec).NotTo(HaveKey("subject"))
+		Expect(spec["dnsNames"]).To(ConsistOf("kubernetes", "kubernetes.default", "kubernetes.default.svc",
+			"kubernetes.default.svc.cluster.local", "localhost", "claio-apiserver.tenant-sample.svc",
+			"sample.example.com", "api.example.com"))
+		Expect(spec["ipAddresses"]).To(ConsistOf("10.96.0.1", "127.0.0.1", "192.168.1.10", "192.168.1.11"))
+		Expect(spec["duration"]).To(Equal("8760h0m0s"))
+		Expect(spec["renewBefore"]).To(Equal("240h0m0s"))
+		Expect(applied["Certificate/apiserver"].GetOwnerReferences()).To(ConsistOf(HaveField("Name", "sample")))
+
+		spec = certificateSpec("apiserver-kubelet-client")
+		Expect(spec["issuerRef"]).To(HaveKeyWithValue("name", "ca"))
+		Expect(spec["subject"]).To(Equal(map[string]any{"organizations": []any{"system:masters"}}))
+		Expect(spec).NotTo(HaveKey("dnsNames"))
+		Expect(spec).NotTo(HaveKey("ipAddresses"))
+
+		spec = certificateSpec("front-proxy-client")
+		Expect(spec["issuerRef"]).To(HaveKeyWithValue("name", "front-proxy-ca"))
+		Expect(spec["commonName"]).To(Equal("front-proxy-client"))
+	})
+
+	// serving and client certificates, the front-proxy-client is a serving certificate like the
+	// one of the manager
+	usages := func(keyEncipherment bool) map[string][]any {
+		result := map[string][]any{}
+		for name, extUsage := range map[string]string{
+			"apiserver": "server auth", "apiserver-kubelet-client": "client auth", "front-proxy-client": "server auth",
+		} {
+			result[name] = []any{"digital signature"}
+			if keyEncipherment {
+				result[name] = append(result[name], "key encipherment")
+			}
+			result[name] = append(result[name], extUsage)
+		}
+		return result
+	}
+
+	DescribeTable("requests the key algorithm and the usages of the spec",
+		func(algorithm certificates.KeyAlgorithm, privateKey map[string]any, expectedUsages map[string][]any) {
+			c.Object.Spec.Certificates.KeyAlgorithm = string(algorithm)
+			issueAll()
+			for _, name := range sortedKeys(issuedCertificates) {
+				spec := certificateSpec(name)
+				Expect(spec["privateKey"]).To(Equal(privateKey), name)
+				Expect(spec["usages"]).To(Equal(expectedUsages[name]), name)
+			}
+			cert, err := c.getCertificateSecret("apiserver")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cert.KeyAlgorithm()).To(Equal(algorithm))
+		},
+		Entry("RSA", certificates.RSA,
+			map[string]any{"algorithm": "RSA", "encoding": "PKCS1", "size": float64(2048), "rotationPolicy": "Always"},
+			usages(true)),
+		Entry("ECDSA P-256", certificates.ECDSAP256,
+			map[string]any{"algorithm": "ECDSA", "encoding": "PKCS8", "size": float64(256), "rotationPolicy": "Always"},
+			usages(false)),
+		Entry("ECDSA P-384", certificates.ECDSAP384,
+			map[string]any{"algorithm": "ECDSA", "encoding": "PKCS8", "size": float64(384), "rotationPolicy": "Always"},
+			usages(false)),
+		Entry("Ed25519", certificates.Ed25519,
+			map[string]any{"algorithm": "Ed25519", "encoding": "PKCS8", "rotationPolicy": "Always"},
+			usages(false)),
+	)
+
+	It("Should copy the issued certificates into secrets like the ones the manager writes", func() {
+		issueAll()
+		builtIn := newTestControlPlane()
+		_, err := builtIn.reconcileCertificates()
+		Expect(err).NotTo(HaveOccurred())
+
+		for name, caName := range issuedCertificates {
+			issued, err := c.GetSecret(name + issuedSecretSuffix)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := c.GetSecret(name)
+			Expect(err).NotTo(HaveOccurred())
+			expected, err := builtIn.GetSecret(name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sortedKeys(data)).To(Equal(sortedKeys(expected)), name)
+			Expect(data[name+".crt"]).To(Equal(issued[corev1.TLSCertKey]))
+			Expect(data[name+".key"]).To(Equal(issued[corev1.TLSPrivateKeyKey]))
+
+			cert, err := certificates.NewCertificateFromSecretData(name, data)
+			Expect(err).NotTo(HaveOccurred())
+			key, err := cert.RawKey()
+			Expect(err).NotTo(HaveOccurred())
+			pub, err := cert.RawPub()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub)).To(BeTrue())
+			ca, err := c.getCertificateSecret(caName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cert.Validate(ca, nil, 0)).To(Succeed())
+		}
+		Expect(c.untilRenewal()).To(BeNumerically("~", time.Until(time.Now().AddDate(1, 0, 0).Add(-defaultRenewBefore)), time.Minute))
+	})
+
+	It("Should keep the current certificate until cert-manager issued a changed spec", func() {
+		issueAll()
+		before, err := c.GetSecret("apiserver")
+		Expect(err).NotTo(HaveOccurred())
+
+		c.Object.Spec.Certificates.ExtraSANs = []string{"api.example.com"}
+		Expect(reconcile()).To(Succeed())
+		Expect(certificateSpec("apiserver")["dnsNames"]).To(ContainElement("api.example.com"))
+		Expect(c.pendingCertificates).To(Equal([]string{"apiserver"}))
+		Expect(c.untilRenewal()).To(Equal(certManagerInterval))
+		Expect(c.certificatesMessage()).To(Equal("waiting for cert-manager to issue apiserver"))
+		Expect(c.GetSecret("apiserver")).To(Equal(before))
+
+		issued := issue("apiserver", apiserverTemplate, nil)
+		Expect(reconcile()).To(Succeed())
+		Expect(c.pendingCertificates).To(BeEmpty())
+		after, err := c.getCertificateSecret("apiserver")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after.Cert).To(Equal(issued.Cert))
+	})
+
+	It("Should issue a certificate of another CA again", func() {
+		issueAll()
+		template, err := caTemplate(&c.Object.Spec)
+		Expect(err).NotTo(HaveOccurred())
+		other, err := certificates.Create(template, nil)
+		Expect(err).NotTo(HaveOccurred())
+		issue("apiserver", apiserverTemplate, other)
+
+		Expect(reconcile()).To(Succeed())
+		Expect(c.GetSecret("apiserver" + issuedSecretSuffix)).To(BeNil())
+		Expect(c.pendingCertificates).To(Equal([]string{"apiserver"}))
+	})
+
+	It("Should remove the cert-manager objects when the manager issues the certificates again", func() {
+		issueAll()
+		c.Object.Status.TargetSpec.Certificates.Backend = claiov1beta1.CertificateBackendCertManager
+		c.Object.Spec.Certificates.Backend = claiov1beta1.CertificateBackendManager
+		Expect(reconcile()).To(Succeed())
+		Expect(deleted).To(ConsistOf("Issuer/ca", "Issuer/front-proxy-ca", "Certificate/apiserver",
+			"Certificate/apiserver-kubelet-client", "Certificate/front-proxy-client"))
+		for _, name := range []string{"apiserver-tls", "apiserver-kubelet-client-tls", "front-proxy-client-tls", "ca-issuer", "front-proxy-ca-issuer"} {
+			Expect(c.GetSecret(name)).To(BeNil(), name)
+		}
+		// the copies are kept until they expire
+		Expect(c.GetSecret("apiserver")).NotTo(BeNil())
+	})
+})
+
+// sortedKeys are the keys of the map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -17,23 +17,45 @@ limitations under the License.
 package controlplanes
 
 import (
-	claiov1alpha1 "claio/api/v1alpha1"
+	claiov1beta1 "claio/api/v1beta1"
 	"claio/internal/resources"
 	"context"
 	"fmt"
+	"reflect"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type ControlPlane struct {
-	resources.Resource[*claiov1alpha1.ControlPlane]
+	resources.Resource[*claiov1beta1.ControlPlane]
+	// JobImage runs the migrate command of the manager when the datastore changes
+	JobImage *JobImage
+	// Gateway is the kine gateway of the gateway driver, nil if the manager has none
+	Gateway *Gateway
+	// Recorder records the events of the datastore quota and the CA rotation
+	Recorder record.EventRecorder
+	// CARotationGracePeriod is how long the old CA is trusted after a rotation reissued the
+	// certificates
+	CARotationGracePeriod time.Duration
+
+	// renewAt is when the first certificate of the tenant has to be renewed
+	renewAt time.Time
+	// pendingCertificates are the certificates cert-manager has not issued yet, the current ones
+	// are kept until then
+	pendingCertificates []string
+	// tenantClient replaces the client of the apiserver of the tenant which is built from the admin
+	// kubeconfig, e.g. by a fake client
+	tenantClient client.Client
 }
 
 func NewControlPlane(ctx context.Context, req ctrl.Request, rClient client.Client, rScheme *runtime.Scheme) (*ControlPlane, error) {
-	res := &claiov1alpha1.ControlPlane{}
+	res := &claiov1beta1.ControlPlane{}
 	if err := rClient.Get(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace}, res); err != nil {
 		return nil, client.IgnoreNotFound(err)
 	}
@@ -60,59 +82,191 @@ func (r *ControlPlane) Check() (string, error) {
 	}
 }
 
-func (r *ControlPlane) Reconcile() error {
+// Reconcile converges the control-plane, the result requeues it for the next token rotation or
+// certificate renewal
+func (r *ControlPlane) Reconcile() (ctrl.Result, error) {
 	status, err := r.Check()
 	if err != nil {
 		r.LogError(err, "check failed")
-		return err
+		return ctrl.Result{}, err
 	}
 	r.LogInfo("status: %s", status)
+	if r.Object.Status.Phase == "" {
+		r.Object.Status.Phase = claiov1beta1.ControlPlanePhasePending
+	}
 
 	apiDirty := false
+	var migrationRequeue, caRotationRequeue time.Duration
 	if status == r.STATUS_UP {
-		// check certificates
-		caChanged, localApiDirty, err := r.reconcileCertificates()
+		// rotate the CA in stages, the certificates are reissued once the new CA signs
+		caRotationRequeue, err = r.reconcileCARotation()
+		if err != nil {
+			r.LogError(err, "failed to rotate the ca")
+			return ctrl.Result{}, r.abort(status, r.setFailed(claiov1beta1.ConditionCertificatesReady, err))
+		}
+		// check certificates, renewed certificates are rolled out with the deployment, a new CA
+		// restarts it
+		caChanged, err := r.reconcileCertificates()
 		if err != nil {
 			r.LogError(err, "failed to reconcile secrets")
-			return err
+			return ctrl.Result{}, r.abort(status, r.setFailed(claiov1beta1.ConditionCertificatesReady, err))
 		}
-		apiDirty = apiDirty || localApiDirty
+		apiDirty = apiDirty || caChanged
 
 		if err := r.kubeconfigReconcile(caChanged); err != nil {
 			r.LogError(err, "failed to reconcile kubeconfig")
-			return err
+			return ctrl.Result{}, r.abort(status, r.setFailed(claiov1beta1.ConditionKubeconfigsReady, err))
+		}
+		r.setConditionTrue(claiov1beta1.ConditionCertificatesReady, reasonIssued, r.certificatesMessage())
+		r.setConditionTrue(claiov1beta1.ConditionKubeconfigsReady, reasonCreated, "all kubeconfigs exist")
+
+		localApiDirty, err := r.reconcileDatastore()
+		if err != nil {
+			r.LogError(err, "failed to reconcile datastore")
+			return ctrl.Result{}, r.abort(status, r.setFailed(claiov1beta1.ConditionDatastoreReady, err))
+		}
+		apiDirty = apiDirty || localApiDirty
+		if migrationRequeue, err = r.reconcileMigration(); err != nil {
+			r.LogError(err, "failed to migrate datastore")
+			return ctrl.Result{}, r.abort(status, r.setFailed(claiov1beta1.ConditionDatastoreReady, err))
 		}
+		r.setConditionTrue(claiov1beta1.ConditionDatastoreReady, reasonAvailable, r.datastoreMessage())
 	}
 
 	// check deployment and service
 	if status == r.STATUS_UP || status == r.STATUS_WANTDOWN {
 		if err := r.ReconcileDeployment(apiDirty, status); err != nil {
 			r.LogError(err, "failed to check deployment")
-			return err
+			return ctrl.Result{}, r.abort(status, r.setFailed(claiov1beta1.ConditionDeploymentAvailable, err))
 		}
 		if err := r.ReconcileService(apiDirty, status); err != nil {
 			r.LogError(err, "failed to check service")
-			return err
+			return ctrl.Result{}, r.abort(status, r.setFailed(claiov1beta1.ConditionServiceReady, err))
+		}
+	}
+
+	// check bootstrap token, join configuration and addons, they need a running apiserver
+	var requeueAfter time.Duration
+	if status == r.STATUS_UP {
+		if meta.IsStatusConditionTrue(r.Object.Status.Conditions, claiov1beta1.ConditionDeploymentAvailable) {
+			requeueAfter, err = r.reconcileJoinConfiguration()
+			if err != nil {
+				r.LogError(err, "failed to reconcile join configuration")
+				return ctrl.Result{}, r.abort(status, r.setFailed(claiov1beta1.ConditionJoinConfigurationReady, err))
+			}
+			if err := r.reconcileAddons(); err != nil {
+				r.LogError(err, "failed to reconcile addons")
+				return ctrl.Result{}, r.abort(status, r.setFailed(claiov1beta1.ConditionAddonsReady, err))
+			}
+			// apply the addons again from time to time, they are not watched in the tenant
+			if requeueAfter <= 0 || requeueAfter > addonResyncInterval {
+				requeueAfter = addonResyncInterval
+			}
+			quotaRequeue, err := r.reconcileQuota()
+			if err != nil {
+				r.LogError(err, "failed to enforce the datastore quota")
+				return ctrl.Result{}, r.abort(status, r.setFailed(claiov1beta1.ConditionDatastoreReady, err))
+			}
+			if quotaRequeue < requeueAfter {
+				requeueAfter = quotaRequeue
+			}
+		} else {
+			r.setConditionFalse(claiov1beta1.ConditionJoinConfigurationReady, reasonProgressing, "waiting for the apiserver")
+			r.setConditionFalse(claiov1beta1.ConditionAddonsReady, reasonProgressing, "waiting for the apiserver")
 		}
 	}
 
 	// handle finalizer
 	r.LogHeader("check control-plane (finalize) ...")
 	if status == r.STATUS_WANTDOWN {
+		done, err := r.reconcileDeletionPolicy()
+		if err != nil {
+			r.LogError(err, "failed to apply the datastore deletion policy")
+			return ctrl.Result{}, r.abort(status, r.setFailed(claiov1beta1.ConditionDatastoreReady, err))
+		}
+		if !done {
+			return ctrl.Result{RequeueAfter: deletionPolicyInterval}, r.updateStatus(status)
+		}
+		if err := r.releaseDataStore(); err != nil {
+			r.LogError(err, "failed to release datastore")
+			return ctrl.Result{}, err
+		}
+		if r.certManager() {
+			if err := r.removeCertManagerObjects(); err != nil {
+				r.LogError(err, "failed to remove the cert-manager certificates")
+				return ctrl.Result{}, err
+			}
+		}
+		if err := r.updateStatus(status); err != nil {
+			return ctrl.Result{}, err
+		}
 		r.LogInfo("remove finalizer")
 		if err := r.RemoveFinalizer(); err != nil {
 			r.LogError(err, "failed to remove finalizer")
-			return err
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// a running migration is checked until the job has finished
+	if migrationRequeue > 0 && (requeueAfter <= 0 || migrationRequeue < requeueAfter) {
+		requeueAfter = migrationRequeue
+	}
+	// certificates are renewed without a change of the spec, the stages of a CA rotation wait
+	// for the tenant
+	for _, requeue := range []time.Duration{r.untilRenewal(), caRotationRequeue} {
+		if requeue > 0 && (requeueAfter <= 0 || requeue < requeueAfter) {
+			requeueAfter = requeue
 		}
-		return nil
 	}
 
-	// update status
-	r.Object.Status = claiov1alpha1.ControlPlaneStatus{TargetSpec: r.Object.Spec}
-	if err := r.Client.Status().Update(r.Ctx, r.Object); err != nil {
-		r.LogError(err, "failed to update status")
-		return err
+	r.observeSpec()
+	return ctrl.Result{RequeueAfter: requeueAfter}, r.updateStatus(status)
+}
+
+// observeSpec records the converged spec and its generation in the status, the deployment stays
+// on the old datastore until a migration has completed
+func (r *ControlPlane) observeSpec() {
+	datastore := *r.activeDatastore()
+	r.Object.Status.TargetSpec = r.Object.Spec
+	r.Object.Status.TargetSpec.Datastore = datastore
+	r.Object.Status.ObservedGeneration = r.Object.Generation
+	r.Object.Status.LastError = ""
+}
+
+// structuralChanges is true if the spec changed since the last reconciliation in a way the
+// deployment and the service have to be recreated for. Addons are applied to the running tenant,
+// the datastore is switched by the migration.
+func (r *ControlPlane) structuralChanges() bool {
+	spec := r.Object.Spec.DeepCopy()
+	target := r.Object.Status.TargetSpec.DeepCopy()
+	spec.Addons = claiov1beta1.AddonsSpec{}
+	target.Addons = claiov1beta1.AddonsSpec{}
+	// renewed certificates, also of another backend or key algorithm, roll the deployment by
+	// themselves
+	spec.Certificates.RenewBefore = nil
+	target.Certificates.RenewBefore = nil
+	spec.Certificates.Backend = ""
+	target.Certificates.Backend = ""
+	spec.Certificates.KeyAlgorithm = ""
+	target.Certificates.KeyAlgorithm = ""
+	// a new datastore is applied by the migration
+	if r.migrationSource() != nil {
+		spec.Datastore = target.Datastore
 	}
+	// the quota is enforced on the running tenant, only the revision history is passed to kine
+	spec.Datastore.Quota = revisionHistoryOf(spec.Datastore.Quota)
+	target.Datastore.Quota = revisionHistoryOf(target.Datastore.Quota)
+	return !reflect.DeepEqual(spec, target)
+}
+
+// abort records the failure in the status (best effort) and returns the original error
+func (r *ControlPlane) abort(mode string, err error) error {
+	_ = r.updateStatus(mode)
+	return err
+}
 
-	return nil
+// Endpoint is the advertised url of the apiserver of the tenant
+func (r *ControlPlane) Endpoint() string {
+	return fmt.Sprintf("https://%s:%d", r.Object.Spec.Endpoint.Host, r.Object.Spec.Endpoint.Port)
 }
@@ -0,0 +1,97 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1alpha1 "claio/api/v1alpha1"
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/resources"
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// These tests use Ginkgo (BDD-style Go testing framework). Refer to
+// http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
+// The control-plane works on a fake client of the management cluster.
+
+func TestControlPlanes(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "ControlPlanes Suite")
+}
+
+var errTest = errors.New("test error")
+
+// testScheme knows the objects of the management cluster
+func testScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(claiov1alpha1.AddToScheme(scheme)).To(Succeed())
+	Expect(claiov1beta1.AddToScheme(scheme)).To(Succeed())
+	return scheme
+}
+
+// newTestControlPlane returns the control-plane sample in the namespace tenant-sample of a fake
+// client with the objects
+func newTestControlPlane(objects ...client.Object) *ControlPlane {
+	obj := &claiov1beta1.ControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: "tenant-sample", Generation: 1},
+		Spec: claiov1beta1.ControlPlaneSpec{
+			Name:    "sample",
+			Version: "1.31.1",
+			Endpoint: claiov1beta1.EndpointSpec{
+				Host:    "sample.example.com",
+				Address: "192.168.1.10",
+				Port:    6443,
+			},
+			Network: claiov1beta1.NetworkSpec{
+				ClusterCIDR: "10.244.0.0/16",
+				ServiceCIDR: "10.96.0.0/12",
+				DNSDomain:   "cluster.local",
+			},
+		},
+	}
+	return newTestControlPlaneOf(obj, objects...)
+}
+
+// newTestControlPlaneOf returns the control-plane of a fake client with the objects
+func newTestControlPlaneOf(obj *claiov1beta1.ControlPlane, objects ...client.Object) *ControlPlane {
+	scheme := testScheme()
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(append(objects, obj)...).
+		WithStatusSubresource(&claiov1beta1.ControlPlane{}).
+		Build()
+	current := &claiov1beta1.ControlPlane{}
+	Expect(fakeClient.Get(context.Background(), client.ObjectKeyFromObject(obj), current)).To(Succeed())
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: obj.Name, Namespace: obj.Namespace}}
+	return &ControlPlane{
+		Resource:              *resources.NewResource("ControlPlane", context.Background(), req, fakeClient, scheme, current),
+		CARotationGracePeriod: DefaultCARotationGracePeriod,
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// sqliteEndpoint is the database on the volume of the sqlite datastore
+	sqliteEndpoint = "sqlite://" + kineDataPath + "/state.db?_journal=WAL&cache=shared&_busy_timeout=30000"
+
+	kineDataClaim = "kine-data"
+	kineDataPath  = "/var/lib/kine"
+	kineTLSPath   = "/etc/kine/datastore"
+
+	defaultSQLiteSize = "1Gi"
+)
+
+// kineValues configure the kine container of the deployment
+type kineValues struct {
+	// Endpoint is passed to kine as is if the datastore has no secret
+	Endpoint string
+	// SecretName is the datastore secret, kine reads the endpoint from it
+	SecretName string
+	// CACert and ClientCert are set if the secret has the corresponding TLS files
+	CACert     bool
+	ClientCert bool
+	// NATSCredentials is set if the secret has the nkey and the context file of kine
+	NATSCredentials bool
+	// DataClaim is the volume of the sqlite datastore
+	DataClaim string
+	TLSPath   string
+	DataPath  string
+	NATSPath  string
+	// Suffix is appended to the names of the container and its volumes, a second kine next
+	// to the first one listens on ListenAddress and serves its metrics on MetricsAddress
+	Suffix         string
+	ListenAddress  string
+	MetricsAddress string
+	// CompactMinRetain is the revision history of the quota for the sql drivers
+	CompactMinRetain int32
+	// Gateway is set for the gateway driver, the apiserver connects to the kine gateway at
+	// Endpoint with the client certificate of the secret instead of a kine sidecar
+	Gateway bool
+}
+
+// kineValues derives the kine configuration of the deployment, it keeps running on the old
+// datastore until a migration to the datastore of the spec has completed
+func (c *ControlPlane) kineValues() (*kineValues, error) {
+	return c.kineValuesOf(c.activeDatastore(), DataStoreSecretName)
+}
+
+// kineValuesOf derives the kine configuration from a datastore spec and its secret,
+// dataStoreSecret is the secret of a tenant on a DataStore
+func (c *ControlPlane) kineValuesOf(datastore *claiov1beta1.DatastoreSpec, dataStoreSecret string) (*kineValues, error) {
+	values := &kineValues{TLSPath: kineTLSPath, DataPath: kineDataPath, NATSPath: natsCredentialsPath}
+	switch datastore.Driver {
+	case claiov1beta1.DatastoreDriverPostgres, claiov1beta1.DatastoreDriverMySQL, claiov1beta1.DatastoreDriverSQLite:
+		if datastore.Quota != nil && datastore.Quota.MaxRevisionHistory != nil {
+			values.CompactMinRetain = *datastore.Quota.MaxRevisionHistory
+		}
+	}
+
+	if datastore.Driver == claiov1beta1.DatastoreDriverSQLite {
+		values.Endpoint = sqliteEndpoint
+		values.DataClaim = kineDataClaim
+		return values, nil
+	}
+	if datastore.Driver == claiov1beta1.DatastoreDriverGateway {
+		// the secret is generated by reconcileGatewaySecret, the sidecars of the jobs reach the
+		// gateway like an etcd
+		if c.Gateway == nil || c.Gateway.Endpoint == "" {
+			return nil, fmt.Errorf("the manager has no kine gateway")
+		}
+		values.Gateway = true
+		values.Endpoint = c.Gateway.Endpoint
+		values.SecretName = gatewaySecretName
+		values.CACert = true
+		values.ClientCert = true
+		return values, nil
+	}
+	// the secret of a tenant on a DataStore is generated by reconcileDataStoreTenant
+	secretName := dataStoreSecret
+	if datastore.DataStoreName == "" {
+		if datastore.SecretRef == nil {
+			return nil, fmt.Errorf("the %s datastore needs a secret with the endpoint or a datastore", datastore.Driver)
+		}
+		secretName = datastore.SecretRef.Name
+	}
+
+	secretData, err := c.GetSecret(secretName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting datastore secret %s in ns %s: %s", secretName, c.Namespace(), err)
+	}
+	if secretData == nil {
+		return nil, fmt.Errorf("datastore secret %s in ns %s does not exist", secretName, c.Namespace())
+	}
+	if len(secretData[claiov1beta1.DatastoreSecretKeyEndpoint]) == 0 {
+		return nil, fmt.Errorf("datastore secret %s in ns %s has no %s", secretName, c.Namespace(), claiov1beta1.DatastoreSecretKeyEndpoint)
+	}
+	values.SecretName = secretName
+	values.CACert = len(secretData[claiov1beta1.DatastoreSecretKeyCACert]) > 0
+	values.ClientCert = len(secretData[claiov1beta1.DatastoreSecretKeyTLSCert]) > 0 &&
+		len(secretData[claiov1beta1.DatastoreSecretKeyTLSKey]) > 0
+	values.NATSCredentials = len(secretData[DataStoreSecretKeyNATSSeed]) > 0
+	return values, nil
+}
+
+// datastoreMessage describes the datastore of the tenant in the DatastoreReady condition
+func (c *ControlPlane) datastoreMessage() string {
+	if migration := c.Object.Status.Migration; c.migrationSource() != nil && migration != nil {
+		return fmt.Sprintf("migration from %s to %s: %s", migration.Source, migration.Target, migration.Message)
+	}
+	if name := c.Object.Spec.Datastore.DataStoreName; name != "" {
+		return fmt.Sprintf("%s datastore is placed on %s", c.Object.Spec.Datastore.Driver, name)
+	}
+	return c.Object.Spec.Datastore.Driver + " datastore is configured"
+}
+
+// reconcileDatastore places the tenant on the DataStore of the spec, checks the datastore
+// secret and creates the volume of a sqlite datastore. It returns true if the apiserver has to
+// be restarted for a renewed client certificate of the kine gateway.
+func (c *ControlPlane) reconcileDatastore() (bool, error) {
+	c.LogHeader("check datastore (%s) ...", c.Object.Spec.Datastore.Driver)
+	if c.Object.Spec.Datastore.Driver == claiov1beta1.DatastoreDriverGateway ||
+		c.activeDatastore().Driver == claiov1beta1.DatastoreDriverGateway {
+		renewed, err := c.reconcileGatewaySecret()
+		if err != nil {
+			return false, err
+		}
+		// a migration starts the apiserver anew anyway
+		if renewed && c.activeDatastore().Driver == claiov1beta1.DatastoreDriverGateway {
+			return true, nil
+		}
+	}
+	dataStore, err := c.getDataStore()
+	if err != nil {
+		return false, err
+	}
+	if dataStore != nil {
+		if err := c.checkPlacement(dataStore); err != nil {
+			return false, err
+		}
+		if err := c.reconcileDataStoreTenant(dataStore); err != nil {
+			return false, err
+		}
+	}
+	kine, err := c.kineValuesOf(&c.Object.Spec.Datastore, c.dataStoreSecretName())
+	if err != nil {
+		return false, err
+	}
+	if kine.DataClaim == "" {
+		return false, nil
+	}
+
+	pvc, err := c.GetPersistentVolumeClaim(kine.DataClaim)
+	if err != nil {
+		return false, fmt.Errorf("error getting persistent volume claim %s in ns %s: %s", kine.DataClaim, c.Namespace(), err)
+	}
+	if pvc != nil {
+		return false, nil
+	}
+	size := resource.MustParse(defaultSQLiteSize)
+	storageClassName := ""
+	if storage := c.Object.Spec.Datastore.Storage; storage != nil {
+		if storage.Size != nil {
+			size = *storage.Size
+		}
+		if storage.StorageClassName != nil {
+			storageClassName = *storage.StorageClassName
+		}
+	}
+	pvcYaml, err := c.ToYaml(kineDataClaimTemplate, map[string]any{
+		"Name":             kine.DataClaim,
+		"Namespace":        c.Namespace(),
+		"Size":             size.String(),
+		"StorageClassName": storageClassName,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error generating yaml: %s", err)
+	}
+	c.LogInfo("create persistent volume claim %s", kine.DataClaim)
+	return false, c.CreatePersistentVolumeClaim(kine.DataClaim, pvcYaml)
+}
+
+const kineDataClaimTemplate = `apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+  labels:
+    app: claio
+spec:
+  accessModes:
+    - ReadWriteOnce
+  {{- with .StorageClassName }}
+  storageClassName: {{ . }}
+  {{- end }}
+  resources:
+    requests:
+      storage: {{ .Size }}
+`
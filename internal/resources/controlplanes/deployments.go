@@ -17,34 +17,69 @@ limitations under the License.
 package controlplanes
 
 import (
+	claiov1beta1 "claio/api/v1beta1"
 	"fmt"
-	"reflect"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/apps/v1"
 )
 
+// deploymentValues are the values of the deployment template, the spec fields are promoted
+type deploymentValues struct {
+	claiov1beta1.ControlPlaneSpec
+	Kine *kineValues
+	// PKISecrets are mounted into the pods, a new PKIChecksum rolls them out
+	PKISecrets  []string
+	PKIChecksum string
+}
+
+func (c *ControlPlane) deploymentYaml(kine *kineValues) ([]byte, error) {
+	checksum, err := c.pkiChecksum()
+	if err != nil {
+		return nil, err
+	}
+	deploymentYaml, err := c.ToYaml(controlplaneTemplate, &deploymentValues{
+		ControlPlaneSpec: c.Object.Spec,
+		Kine:             kine,
+		PKISecrets:       pkiSecrets,
+		PKIChecksum:      checksum,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error generating yaml: %s", err)
+	}
+	return deploymentYaml, nil
+}
+
 func (c *ControlPlane) CreateClaioDeployment() error {
-	deploymentYaml, err := c.ToYaml(controlplaneTemplate, c.Object.Spec)
+	kine, err := c.kineValues()
 	if err != nil {
-		return fmt.Errorf("error generating yaml: %s", err)
+		return err
+	}
+	deploymentYaml, err := c.deploymentYaml(kine)
+	if err != nil {
+		return err
 	}
 	return c.CreateDeployment("claio", deploymentYaml)
 }
 
 func (c *ControlPlane) UpdateClaioDeployment() error {
-	deploymentYaml, err := c.ToYaml(controlplaneTemplate, c.Object.Spec)
+	kine, err := c.kineValues()
+	if err != nil {
+		return err
+	}
+	deploymentYaml, err := c.deploymentYaml(kine)
 	if err != nil {
-		return fmt.Errorf("error generating yaml: %s", err)
+		return err
 	}
 	return c.UpdateDeployment("claio", deploymentYaml)
 }
 
 func (c *ControlPlane) DeleteClaioDeployment() error {
-	deploymentYaml, err := c.ToYaml(controlplaneTemplate, c.Object.Spec)
+	// only name and namespace matter, the datastore may be gone already
+	deploymentYaml, err := c.deploymentYaml(&kineValues{})
 	if err != nil {
-		return fmt.Errorf("error generating yaml: %s", err)
+		return err
 	}
 	return c.DeleteDeployment("claio", deploymentYaml)
 }
@@ -74,6 +109,30 @@ func (c *ControlPlane) ReconcileDeployment(apiDirty bool, mode string) error {
 				return err
 			}
 		}
+		c.setConditionFalse(claiov1beta1.ConditionDeploymentAvailable, reasonDeleting, "deployment is scaled down")
+		return nil
+	}
+
+	// stop deployment, a ControlPlaneRestore replaces the keys of the tenant
+	if restore := c.Object.Annotations[claiov1beta1.RestoreAnnotation]; restore != "" {
+		if deployment != nil {
+			if err := c.stopPods(deployment); err != nil {
+				return err
+			}
+		}
+		c.setConditionFalse(claiov1beta1.ConditionDeploymentAvailable, reasonRestoring, fmt.Sprintf("deployment is scaled down for restore %s", restore))
+		return nil
+	}
+
+	// stop deployment, the keys of the tenant are copied to another datastore
+	if c.migrationRunning() {
+		if deployment != nil {
+			if err := c.stopPods(deployment); err != nil {
+				return err
+			}
+		}
+		c.setConditionFalse(claiov1beta1.ConditionDeploymentAvailable, reasonMigrating,
+			fmt.Sprintf("deployment is scaled down for the migration to %s", c.Object.Status.Migration.Target))
 		return nil
 	}
 
@@ -83,19 +142,50 @@ func (c *ControlPlane) ReconcileDeployment(apiDirty bool, mode string) error {
 			c.LogError(err, "failed to create deployment")
 			return err
 		}
+		c.setConditionFalse(claiov1beta1.ConditionDeploymentAvailable, reasonCreated, "deployment created, waiting for available replicas")
 		return nil
 	}
 
-	if apiDirty || !reflect.DeepEqual(c.Object.Spec, c.Object.Status.TargetSpec) {
+	if apiDirty || c.structuralChanges() {
 		c.LogInfo("structural changes detected - need to stop control-plane")
 		if err := c.stopDeployment(); err != nil {
 			c.LogError(err, "failed to stop deployment")
 			return err
 		}
+		c.setConditionFalse(claiov1beta1.ConditionDeploymentAvailable, reasonRestarting, "deployment stopped to apply structural changes")
 
 		// the deployment will be startet with the next reconcilation run
 		return nil
 	}
+
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+		c.LogInfo("scale replicas up")
+		if err := c.UpdateClaioDeployment(); err != nil {
+			c.LogError(err, "failed to scale deployment up")
+			return err
+		}
+		c.setConditionFalse(claiov1beta1.ConditionDeploymentAvailable, reasonProgressing, "deployment scaled up, waiting for available replicas")
+		return nil
+	}
+
+	// renewed certificates are rolled out, the old pod serves until the new one is ready
+	checksum, err := c.pkiChecksum()
+	if err != nil {
+		return err
+	}
+	if deployment.Spec.Template.Annotations[pkiChecksumAnnotation] != checksum {
+		c.LogInfo("roll out the renewed certificates")
+		if err := c.UpdateClaioDeployment(); err != nil {
+			c.LogError(err, "failed to roll out the certificates")
+			return err
+		}
+	}
+
+	if deployment.Status.AvailableReplicas > 0 {
+		c.setConditionTrue(claiov1beta1.ConditionDeploymentAvailable, reasonAvailable, "deployment has available replicas")
+	} else {
+		c.setConditionFalse(claiov1beta1.ConditionDeploymentAvailable, reasonProgressing, "deployment has no available replicas yet")
+	}
 	return nil
 }
 
@@ -122,10 +212,14 @@ func (c *ControlPlane) stopDeployment() error {
 }
 
 func (c *ControlPlane) stopPods(deployment *appsv1.Deployment) error {
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+		return nil
+	}
 	c.LogInfo("scale replicas down to 0")
-	*deployment.Spec.Replicas = 0
-	if err := c.UpdateClaioDeployment(); err != nil {
-		return fmt.Errorf("failed to set deployments replicas to 0")
+	replicas := int32(0)
+	deployment.Spec.Replicas = &replicas
+	if err := c.Client.Update(c.Ctx, deployment); err != nil {
+		return fmt.Errorf("failed to set deployments replicas to 0: %s", err)
 	}
 	return nil
 }
@@ -139,6 +233,11 @@ metadata:
   namespace: tenant-{{ .Name }}
 spec:
   replicas: 1
+  {{- if .Kine.DataClaim }}
+  # the volume of the sqlite datastore can only be mounted once
+  strategy:
+    type: Recreate
+  {{- end }}
   selector:
     matchLabels:
       app: claio
@@ -146,21 +245,32 @@ spec:
     metadata:
       labels:
         app: claio
+      annotations:
+        claio.github.com/pki-checksum: "{{ .PKIChecksum }}"
     spec:
       containers:
         - name: kube-apiserver
-          image: registry.k8s.io/kube-apiserver:v{{ .Version }}
+          image: {{ with .Components.APIServer.Image }}{{ printf "%q" . }}{{ else }}registry.k8s.io/kube-apiserver:v{{ .Version }}{{ end }}
           command:
             - kube-apiserver
           args:      
             #- --advertise-address=127.0.0.1
             - --allow-privileged=true
             - --authorization-mode=Node,RBAC
-            - --client-ca-file=/etc/kubernetes/pki/ca.crt
+            - --client-ca-file=/etc/kubernetes/pki/ca-bundle.crt
             - --enable-bootstrap-token-auth=true
             - --etcd-prefix=/tenant-{{ .Name }}
+            {{- if .Kine.Gateway }}
+            # the kine gateway is shared, it does not allow compactions of a tenant
+            - --etcd-servers={{ .Kine.Endpoint }}
+            - --etcd-cafile={{ .Kine.TLSPath }}/ca.crt
+            - --etcd-certfile={{ .Kine.TLSPath }}/tls.crt
+            - --etcd-keyfile={{ .Kine.TLSPath }}/tls.key
+            - --etcd-compaction-interval=0
+            {{- else }}
             - --etcd-servers=http://localhost:2379
-            - --external-hostname={{ .AdvertiseHost }}
+            {{- end }}
+            - --external-hostname={{ .Endpoint.Host }}
             - --kubelet-client-certificate=/etc/kubernetes/pki/apiserver-kubelet-client.crt
             - --kubelet-client-key=/etc/kubernetes/pki/apiserver-kubelet-client.key
             - --kubelet-preferred-address-types=InternalIP,ExternalIP,Hostname
@@ -171,18 +281,21 @@ spec:
             - --requestheader-extra-headers-prefix=X-Remote-Extra-
             - --requestheader-group-headers=X-Remote-Group
             - --requestheader-username-headers=X-Remote-User
-            - --secure-port={{ .Port }}
-            - --service-account-issuer=https://kubernetes.default.svc.cluster.local
+            - --secure-port={{ .Endpoint.Port }}
+            - --service-account-issuer=https://kubernetes.default.svc.{{ .Network.DNSDomain }}
             - --service-account-key-file=/etc/kubernetes/pki/sa.pub
             - --service-account-signing-key-file=/etc/kubernetes/pki/sa.key
-            - --service-cluster-ip-range={{ .ServiceCIDR }}
+            - --service-cluster-ip-range={{ .Network.ServiceCIDR }}
             - --tls-cert-file=/etc/kubernetes/pki/apiserver.crt
-            - --tls-private-key-file=/etc/kubernetes/pki/apiserver.key              
+            - --tls-private-key-file=/etc/kubernetes/pki/apiserver.key
+            {{- range .Components.APIServer.ExtraArgs }}
+            - {{ printf "%q" . }}
+            {{- end }}
           livenessProbe:
             failureThreshold: 3
             httpGet:
               path: /livez
-              port: {{ .Port }}
+              port: {{ .Endpoint.Port }}
               scheme: HTTPS
             periodSeconds: 10
             successThreshold: 1
@@ -191,7 +304,7 @@ spec:
             failureThreshold: 3
             httpGet:
               path: /readyz
-              port: {{ .Port }}
+              port: {{ .Endpoint.Port }}
               scheme: HTTPS
             periodSeconds: 10
             successThreshold: 1
@@ -204,7 +317,7 @@ spec:
             failureThreshold: 3
             httpGet:
               path: /livez
-              port: {{ .Port }}
+              port: {{ .Endpoint.Port }}
               scheme: HTTPS
             periodSeconds: 10
             successThreshold: 1
@@ -213,8 +326,13 @@ spec:
           - mountPath: /etc/kubernetes/pki
             name: kubernetes-pki
             readOnly: true
+          {{- if .Kine.Gateway }}
+          - mountPath: {{ .Kine.TLSPath }}
+            name: datastore-tls
+            readOnly: true
+          {{- end }}
         - name: kube-scheduler
-          image: registry.k8s.io/kube-scheduler:v{{ .Version }}
+          image: {{ with .Components.Scheduler.Image }}{{ printf "%q" . }}{{ else }}registry.k8s.io/kube-scheduler:v{{ .Version }}{{ end }}
           command:
             - kube-scheduler
           args:
@@ -223,6 +341,9 @@ spec:
             - --bind-address=0.0.0.0
             - --kubeconfig=/etc/kubernetes/pki/scheduler.conf
             - --leader-elect=true
+            {{- range .Components.Scheduler.ExtraArgs }}
+            - {{ printf "%q" . }}
+            {{- end }}
           livenessProbe:
             failureThreshold: 3
             httpGet:
@@ -250,7 +371,7 @@ spec:
               name: kubernetes-pki
               readOnly: true
         - name: kube-controller-manager
-          image: registry.k8s.io/kube-controller-manager:v{{ .Version }}
+          image: {{ with .Components.ControllerManager.Image }}{{ printf "%q" . }}{{ else }}registry.k8s.io/kube-controller-manager:v{{ .Version }}{{ end }}
           command:
             - kube-controller-manager
           args:
@@ -258,8 +379,8 @@ spec:
             - --authentication-kubeconfig=/etc/kubernetes/pki/controller-manager.conf
             - --authorization-kubeconfig=/etc/kubernetes/pki/controller-manager.conf
             - --bind-address=0.0.0.0
-            - --client-ca-file=/etc/kubernetes/pki/ca.crt
-            - --cluster-cidr={{ .ClusterCIDR }}
+            - --client-ca-file=/etc/kubernetes/pki/ca-bundle.crt
+            - --cluster-cidr={{ .Network.ClusterCIDR }}
             - --cluster-name=dev
             - --cluster-signing-cert-file=/etc/kubernetes/pki/ca.crt
             - --cluster-signing-key-file=/etc/kubernetes/pki/ca.key
@@ -267,10 +388,13 @@ spec:
             - --kubeconfig=/etc/kubernetes/pki/controller-manager.conf
             - --leader-elect=true
             - --requestheader-client-ca-file=/etc/kubernetes/pki/front-proxy-ca.crt
-            - --root-ca-file=/etc/kubernetes/pki/ca.crt
+            - --root-ca-file=/etc/kubernetes/pki/ca-bundle.crt
             - --service-account-private-key-file=/etc/kubernetes/pki/sa.key
-            - --service-cluster-ip-range={{ .ServiceCIDR }}
+            - --service-cluster-ip-range={{ .Network.ServiceCIDR }}
             - --use-service-account-credentials=true
+            {{- range .Components.ControllerManager.ExtraArgs }}
+            - {{ printf "%q" . }}
+            {{- end }}
           livenessProbe:
             failureThreshold: 3
             httpGet:
@@ -308,7 +432,7 @@ spec:
             - --mode=grpc
             - --server-count=1
             - --server-port=0
-            - --agent-namespace=tenant-dev
+            - --agent-namespace=kube-system
             - --agent-service-account=konnectivity-agent
             - --authentication-audience=system:konnectivity-server
             - --cluster-cert=/etc/kubernetes/pki/apiserver.crt
@@ -321,34 +445,104 @@ spec:
               readOnly: true
             - mountPath: /run/konnectivity
               name: konnectivity-uds     
-        - name: kine
-          image: rancher/kine:v0.13.2
-          args:
-            - --endpoint 
-            - "nats://nats.claio-system.svc?noEmbed&bucket=tenant-sample"
+{{- if not .Kine.Gateway }}
+` + kineContainerTemplate + `{{- end }}
       volumes:
         - name: kubernetes-pki
           projected:
             sources:
+              {{- range .PKISecrets }}
               - secret:
-                  name: ca
-              - secret:
-                  name: apiserver
-              - secret:
-                  name: apiserver-kubelet-client
-              - secret:
-                  name: front-proxy-ca
-              - secret:
-                  name: front-proxy-client
-              - secret:
-                  name: sa
-              - secret:
-                  name: kubeconfig-scheduler
-              - secret:
-                  name: kubeconfig-controller
-              - secret:
-                  name: kubeconfig-konnectivity
+                  name: {{ . }}
+              {{- end }}
         - name: konnectivity-uds
           emptyDir:
             medium: Memory
+` + kineVolumesTemplate
+
+// kineContainerTemplate is the kine container of the deployment and the sidecar of the jobs
+// which reach the datastore of the tenant, it expects the kineValues in .Kine
+const kineContainerTemplate = `        - name: kine{{ .Kine.Suffix }}
+          image: rancher/kine:v0.13.2
+          args:
+            {{- with .Kine.ListenAddress }}
+            - --listen-address={{ . }}
+            {{- end }}
+            {{- with .Kine.MetricsAddress }}
+            - --metrics-bind-address={{ . }}
+            {{- end }}
+            {{- with .Kine.CompactMinRetain }}
+            - --compact-min-retain={{ . }}
+            {{- end }}
+            {{- if .Kine.SecretName }}
+            - --endpoint=$(KINE_ENDPOINT)
+            {{- else }}
+            - --endpoint={{ .Kine.Endpoint }}
+            {{- end }}
+            {{- if .Kine.CACert }}
+            - --ca-file={{ .Kine.TLSPath }}/ca.crt
+            {{- end }}
+            {{- if .Kine.ClientCert }}
+            - --cert-file={{ .Kine.TLSPath }}/tls.crt
+            - --key-file={{ .Kine.TLSPath }}/tls.key
+            {{- end }}
+          {{- if .Kine.SecretName }}
+          env:
+            - name: KINE_ENDPOINT
+              valueFrom:
+                secretKeyRef:
+                  name: {{ .Kine.SecretName }}
+                  key: endpoint
+          {{- end }}
+          {{- if or .Kine.CACert .Kine.ClientCert .Kine.DataClaim .Kine.NATSCredentials }}
+          volumeMounts:
+            {{- if or .Kine.CACert .Kine.ClientCert }}
+            - mountPath: {{ .Kine.TLSPath }}
+              name: datastore-tls{{ .Kine.Suffix }}
+              readOnly: true
+            {{- end }}
+            {{- if .Kine.DataClaim }}
+            - mountPath: {{ .Kine.DataPath }}
+              name: kine-data{{ .Kine.Suffix }}
+            {{- end }}
+            {{- if .Kine.NATSCredentials }}
+            - mountPath: {{ .Kine.NATSPath }}
+              name: nats-credentials{{ .Kine.Suffix }}
+              readOnly: true
+            {{- end }}
+          {{- end }}
+`
+
+// kineVolumesTemplate are the volumes of kineContainerTemplate
+const kineVolumesTemplate = `        {{- if or .Kine.CACert .Kine.ClientCert }}
+        - name: datastore-tls{{ .Kine.Suffix }}
+          secret:
+            secretName: {{ .Kine.SecretName }}
+            items:
+              {{- if .Kine.CACert }}
+              - key: ca.crt
+                path: ca.crt
+              {{- end }}
+              {{- if .Kine.ClientCert }}
+              - key: tls.crt
+                path: tls.crt
+              - key: tls.key
+                path: tls.key
+              {{- end }}
+        {{- end }}
+        {{- if .Kine.DataClaim }}
+        - name: kine-data{{ .Kine.Suffix }}
+          persistentVolumeClaim:
+            claimName: {{ .Kine.DataClaim }}
+        {{- end }}
+        {{- if .Kine.NATSCredentials }}
+        - name: nats-credentials{{ .Kine.Suffix }}
+          secret:
+            secretName: {{ .Kine.SecretName }}
+            items:
+              - key: nats.json
+                path: nats.json
+              - key: nats.nk
+                path: nats.nk
+        {{- end }}
 `
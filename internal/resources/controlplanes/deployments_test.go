@@ -0,0 +1,74 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+var _ = Describe("deployment", func() {
+	var c *ControlPlane
+
+	BeforeEach(func() {
+		c = newTestControlPlane()
+	})
+
+	render := func() *appsv1.Deployment {
+		deploymentYaml, err := c.deploymentYaml(&kineValues{Endpoint: "postgres://db:5432/sample"})
+		Expect(err).NotTo(HaveOccurred())
+		deployment := &appsv1.Deployment{}
+		Expect(yaml.UnmarshalStrict(deploymentYaml, deployment)).To(Succeed())
+		return deployment
+	}
+
+	container := func(deployment *appsv1.Deployment, name string) corev1.Container {
+		for _, container := range deployment.Spec.Template.Spec.Containers {
+			if container.Name == name {
+				return container
+			}
+		}
+		Fail("no container " + name)
+		return corev1.Container{}
+	}
+
+	It("defaults the images to the version", func() {
+		deployment := render()
+		Expect(container(deployment, "kube-apiserver").Image).To(Equal("registry.k8s.io/kube-apiserver:v1.31.1"))
+		Expect(container(deployment, "kube-scheduler").Image).To(Equal("registry.k8s.io/kube-scheduler:v1.31.1"))
+	})
+
+	It("keeps images and extra args that are special to yaml as they are", func() {
+		args := []string{"--feature-gates=A=true, B: false", "--audit-policy-file=/etc/audit # policy", `--token="{x}"`}
+		c.Object.Spec.Components = claiov1beta1.ComponentsSpec{
+			APIServer:         claiov1beta1.ComponentSpec{Image: "registry.example.com:5000/kube-apiserver:v1.31.1", ExtraArgs: args},
+			Scheduler:         claiov1beta1.ComponentSpec{ExtraArgs: args},
+			ControllerManager: claiov1beta1.ComponentSpec{ExtraArgs: args},
+		}
+		deployment := render()
+		Expect(container(deployment, "kube-apiserver").Image).To(Equal("registry.example.com:5000/kube-apiserver:v1.31.1"))
+		for _, name := range []string{"kube-apiserver", "kube-scheduler", "kube-controller-manager"} {
+			containerArgs := container(deployment, name).Args
+			Expect(containerArgs[len(containerArgs)-len(args):]).To(Equal(args), name)
+		}
+	})
+})
@@ -0,0 +1,173 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/certificates"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("External CAs", func() {
+	var (
+		c                  *ControlPlane
+		root, intermediate *certificates.Certificate
+	)
+
+	newCA := func(name string, issuer *certificates.Certificate) *certificates.Certificate {
+		template := &x509.Certificate{
+			SerialNumber:          certificates.Serial(),
+			NotBefore:             time.Now().Add(-time.Minute),
+			NotAfter:              time.Now().AddDate(5, 0, 0),
+			Subject:               pkix.Name{CommonName: name},
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		}
+		ca, err := certificates.Create(template, issuer)
+		Expect(err).NotTo(HaveOccurred())
+		return ca
+	}
+
+	// caSecret is the secret of a CA of the spec
+	caSecret := func(name string, data map[string]string) *corev1.Secret {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "tenant-sample"},
+			Data:       map[string][]byte{},
+		}
+		for key, value := range data {
+			secret.Data[key] = []byte(value)
+		}
+		return secret
+	}
+
+	useCA := func(name string) {
+		c.Object.Spec.Certificates.CA = &claiov1beta1.CASource{SecretRef: corev1.LocalObjectReference{Name: name}}
+	}
+
+	BeforeEach(func() {
+		root = newCA("root", nil)
+		intermediate = newCA("intermediate", root)
+		other := newCA("root", nil)
+		c = newTestControlPlane(
+			caSecret("own-root", map[string]string{"tls.crt": root.Cert, "tls.key": root.Key}),
+			caSecret("intermediate-chain", map[string]string{"tls.crt": intermediate.Cert + root.Cert, "tls.key": intermediate.Key}),
+			caSecret("intermediate-root", map[string]string{"tls.crt": intermediate.Cert, "tls.key": intermediate.Key, "ca.crt": root.Cert}),
+			caSecret("mismatched", map[string]string{"tls.crt": intermediate.Cert, "tls.key": other.Key, "ca.crt": root.Cert}),
+			caSecret("root-only", map[string]string{"tls.crt": root.Cert}),
+			caSecret("other-root", map[string]string{"tls.crt": intermediate.Cert, "tls.key": intermediate.Key, "ca.crt": other.Cert}),
+		)
+	})
+
+	DescribeTable("issues the certificates of the tenant from the CA of a secret",
+		func(secret string, chain func() string, bundle func() string) {
+			useCA(secret)
+			created, err := c.reconcileCertificates()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeTrue())
+
+			ca, err := c.getCertificateSecret(caSecretName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ca.Chain).To(Equal(chain()))
+			data, err := c.GetSecret(caBundleSecretName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data[caBundleKey])).To(Equal(bundle()))
+
+			apiserver, err := c.getCertificateSecret("apiserver")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(apiserver.Validate(ca, nil, 0)).To(Succeed())
+			raw, err := apiserver.RawCert()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = raw.Verify(x509.VerifyOptions{Roots: rootPool(root), Intermediates: rootPool(intermediate)})
+			Expect(err).NotTo(HaveOccurred())
+
+			// the copy is kept
+			_, created, err = c.GetCaCert(false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeFalse())
+		},
+		Entry("an own root CA", "own-root",
+			func() string { return "" }, func() string { return root.Cert }),
+		Entry("an intermediate CA with the chain in tls.crt", "intermediate-chain",
+			func() string { return root.Cert }, func() string { return intermediate.Cert + root.Cert }),
+		Entry("an intermediate CA of the external root in ca.crt", "intermediate-root",
+			func() string { return root.Cert }, func() string { return intermediate.Cert + root.Cert }),
+	)
+
+	DescribeTable("denies an invalid CA",
+		func(secret, message string) {
+			useCA(secret)
+			Expect(func() {
+				_, err := c.reconcileCertificates()
+				Expect(err).To(MatchError(ContainSubstring(message)))
+			}).NotTo(Panic())
+			Expect(c.GetSecret(caSecretName)).To(BeNil())
+		},
+		Entry("whose key does not belong to the certificate", "mismatched", "does not belong to the certificate"),
+		Entry("without key", "root-only", "needs tls.crt and tls.key"),
+		Entry("which does not chain up to its root", "other-root", "does not chain up to its root"),
+		Entry("whose secret does not exist", "missing", "secret missing of the CA does not exist"),
+	)
+
+	It("Should replace the front-proxy-ca when its secret changes", func() {
+		c.Object.Spec.Certificates.FrontProxyCA = &claiov1beta1.CASource{SecretRef: corev1.LocalObjectReference{Name: "own-root"}}
+		_, err := c.reconcileCertificates()
+		Expect(err).NotTo(HaveOccurred())
+		frontProxyCA, err := c.getCertificateSecret("front-proxy-ca")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(frontProxyCA.Cert).To(Equal(root.Cert))
+
+		c.Object.Spec.Certificates.FrontProxyCA.SecretRef.Name = "intermediate-root"
+		_, err = c.reconcileCertificates()
+		Expect(err).NotTo(HaveOccurred())
+		frontProxyCA, err = c.getCertificateSecret("front-proxy-ca")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(frontProxyCA.Cert).To(Equal(intermediate.Cert))
+		client, err := c.getCertificateSecret("front-proxy-client")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.Validate(frontProxyCA, nil, 0)).To(Succeed())
+	})
+
+	It("Should not replace the tenant CA when its secret changes, a rotation does", func() {
+		useCA("own-root")
+		_, err := c.reconcileCertificates()
+		Expect(err).NotTo(HaveOccurred())
+
+		useCA("intermediate-root")
+		_, err = c.reconcileCertificates()
+		Expect(err).NotTo(HaveOccurred())
+		ca, err := c.getCertificateSecret(caSecretName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ca.Cert).To(Equal(root.Cert))
+	})
+})
+
+// rootPool is a pool of the certificate of the CA
+func rootPool(ca *certificates.Certificate) *x509.CertPool {
+	raw, err := ca.RawCert()
+	Expect(err).NotTo(HaveOccurred())
+	pool := x509.NewCertPool()
+	pool.AddCert(raw)
+	return pool
+}
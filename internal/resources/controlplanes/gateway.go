@@ -0,0 +1,84 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/certificates"
+	"claio/internal/gateway"
+	"fmt"
+)
+
+// gatewaySecretName holds the endpoint of the kine gateway and the client certificate of the tenant
+const gatewaySecretName = "kine-gateway"
+
+// Gateway is the kine gateway of the manager, the apiservers of tenants with the gateway driver
+// connect to it instead of a kine sidecar
+type Gateway struct {
+	// Endpoint is the URL of the gateway, reachable from the tenant namespaces
+	Endpoint string
+	// CANamespace and CAName are the secret of the CA of the gateway
+	CANamespace string
+	CAName      string
+}
+
+// reconcileGatewaySecret issues the client certificate of the tenant for the gateway and renews
+// it before it expires, it returns true if the apiserver has to be restarted for a new certificate
+func (c *ControlPlane) reconcileGatewaySecret() (bool, error) {
+	if c.Gateway == nil || c.Gateway.Endpoint == "" {
+		return false, fmt.Errorf("the manager has no kine gateway")
+	}
+	ca, err := gateway.LoadCA(c.Ctx, c.Client, c.Gateway.CANamespace, c.Gateway.CAName)
+	if err != nil {
+		return false, err
+	}
+	secretData, err := c.GetSecret(gatewaySecretName)
+	if err != nil {
+		return false, fmt.Errorf("error getting secret %s in ns %s: %s", gatewaySecretName, c.Namespace(), err)
+	}
+	if secretData != nil && string(secretData[claiov1beta1.DatastoreSecretKeyEndpoint]) == c.Gateway.Endpoint &&
+		string(secretData[claiov1beta1.DatastoreSecretKeyCACert]) == ca.Cert {
+		cert := &certificates.Certificate{
+			Cert: string(secretData[claiov1beta1.DatastoreSecretKeyTLSCert]),
+			Key:  string(secretData[claiov1beta1.DatastoreSecretKeyTLSKey]),
+		}
+		if !gateway.NeedsRenewal(cert, ca) {
+			return false, nil
+		}
+	}
+
+	cert, err := gateway.NewClientCert(ca, c.Object.Spec.Name)
+	if err != nil {
+		return false, fmt.Errorf("failed to create the gateway client certificate: %s", err)
+	}
+	data := map[string][]byte{
+		claiov1beta1.DatastoreSecretKeyEndpoint: []byte(c.Gateway.Endpoint),
+		claiov1beta1.DatastoreSecretKeyCACert:   []byte(ca.Cert),
+		claiov1beta1.DatastoreSecretKeyTLSCert:  []byte(cert.Cert),
+		claiov1beta1.DatastoreSecretKeyTLSKey:   []byte(cert.Key),
+	}
+	if secretData == nil {
+		c.LogInfo("create secret %s", gatewaySecretName)
+		return false, c.CreateSecret(gatewaySecretName, data)
+	}
+	c.LogInfo("renew the client certificate in secret %s", gatewaySecretName)
+	if err := c.UpdateSecret(gatewaySecretName, data); err != nil {
+		return false, err
+	}
+	// the apiserver only reads the certificate when it starts
+	return true, nil
+}
@@ -0,0 +1,394 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/kubernetes"
+	"crypto/sha256"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"reflect"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// JoinSecretName is the secret next to the control-plane with everything a host needs to join
+	JoinSecretName = "join-configuration"
+
+	JoinSecretKeyToken             = "token"
+	JoinSecretKeyTokenExpiration   = "token-expiration"
+	JoinSecretKeyCACert            = "ca.crt"
+	JoinSecretKeyJoinConfiguration = "join-configuration.yaml"
+	JoinSecretKeyKubeletConfig     = "kubelet-config.yaml"
+
+	// clusterInfoSignatureWait is the requeue interval while the tenant signs cluster-info
+	clusterInfoSignatureWait = 10 * time.Second
+)
+
+// JoinConfiguration is the content of the join-configuration secret
+type JoinConfiguration struct {
+	Token *BootstrapToken
+	// CACert is the PEM encoded CA of the tenant
+	CACert []byte
+	// JoinConfiguration is a kubeadm JoinConfiguration (kubeadm join --config)
+	JoinConfiguration []byte
+	// KubeletConfig is the KubeletConfiguration of the nodes
+	KubeletConfig []byte
+}
+
+// joinValues are the values of the join templates
+type joinValues struct {
+	Spec              *claiov1beta1.ControlPlaneSpec
+	Endpoint          string
+	APIServerEndpoint string
+	CACertData        string
+	CACertHashes      []string
+	Token             string
+	ClusterDNS        string
+	KubeletConfig     string
+}
+
+// GetJoinConfiguration returns the join configuration of the tenant
+func (c *ControlPlane) GetJoinConfiguration() (*JoinConfiguration, error) {
+	secretData, err := c.GetSecret(JoinSecretName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s in ns %s: %s", JoinSecretName, c.Namespace(), err)
+	}
+	if secretData == nil {
+		return nil, fmt.Errorf("join configuration of tenant %s does not exist (yet)", c.Object.Spec.Name)
+	}
+	token, err := parseBootstrapToken(string(secretData[JoinSecretKeyToken]), string(secretData[JoinSecretKeyTokenExpiration]))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s in ns %s: %s", JoinSecretName, c.Namespace(), err)
+	}
+	return &JoinConfiguration{
+		Token:             token,
+		CACert:            secretData[JoinSecretKeyCACert],
+		JoinConfiguration: secretData[JoinSecretKeyJoinConfiguration],
+		KubeletConfig:     secretData[JoinSecretKeyKubeletConfig],
+	}, nil
+}
+
+// reconcileJoinConfiguration keeps a valid bootstrap token in the tenant, publishes cluster-info
+// and the configmaps kubeadm join reads and writes the join-configuration secret. The token is
+// rotated bootstrapTokenRenewBefore its expiration, the returned duration is the time until then.
+func (c *ControlPlane) reconcileJoinConfiguration() (time.Duration, error) {
+	c.LogHeader("check join configuration ...")
+	tenantClient, err := c.TenantClient()
+	if err != nil {
+		return 0, err
+	}
+	caCert, err := c.CACertificate()
+	if err != nil {
+		return 0, err
+	}
+	caCertHashes, err := caCertHashes(caCert)
+	if err != nil {
+		return 0, err
+	}
+	clusterDNS, err := c.ClusterDNS()
+	if err != nil {
+		return 0, err
+	}
+
+	current, err := c.GetSecret(JoinSecretName)
+	if err != nil {
+		return 0, fmt.Errorf("error getting %s in ns %s: %s", JoinSecretName, c.Namespace(), err)
+	}
+	token, err := c.currentBootstrapToken(tenantClient, current)
+	if err != nil {
+		return 0, err
+	}
+	if token == nil {
+		if token, err = newBootstrapToken(); err != nil {
+			return 0, err
+		}
+		if err := c.createBootstrapToken(tenantClient, token); err != nil {
+			return 0, err
+		}
+	}
+
+	values := &joinValues{
+		Spec:              &c.Object.Spec,
+		Endpoint:          c.Endpoint(),
+		APIServerEndpoint: fmt.Sprintf("%s:%d", c.Object.Spec.Endpoint.Host, c.Object.Spec.Endpoint.Port),
+		CACertData:        b64.StdEncoding.EncodeToString(caCert),
+		CACertHashes:      caCertHashes,
+		Token:             token.String(),
+		ClusterDNS:        clusterDNS,
+	}
+	kubeletConfig, err := c.ToYaml(kubeletConfigTemplate, values)
+	if err != nil {
+		return 0, fmt.Errorf("error generating kubelet config: %s", err)
+	}
+	values.KubeletConfig = string(kubeletConfig)
+	joinConfiguration, err := c.ToYaml(joinConfigurationTemplate, values)
+	if err != nil {
+		return 0, fmt.Errorf("error generating join configuration: %s", err)
+	}
+	tenantYaml, err := c.ToYaml(joinTenantTemplate, values)
+	if err != nil {
+		return 0, fmt.Errorf("error generating yaml: %s", err)
+	}
+	if err := kubernetes.ApplyYaml(tenantClient, c.Ctx, tenantYaml); err != nil {
+		return 0, fmt.Errorf("error applying cluster-info to tenant %s: %s", c.Object.Spec.Name, err)
+	}
+
+	data := map[string][]byte{
+		JoinSecretKeyToken:             []byte(token.String()),
+		JoinSecretKeyTokenExpiration:   []byte(token.Expiration.Format(time.RFC3339)),
+		JoinSecretKeyCACert:            caCert,
+		JoinSecretKeyJoinConfiguration: joinConfiguration,
+		JoinSecretKeyKubeletConfig:     kubeletConfig,
+	}
+	if current == nil {
+		c.LogInfo("create %s", JoinSecretName)
+		if err := c.CreateSecret(JoinSecretName, data); err != nil {
+			return 0, fmt.Errorf("error creating %s in ns %s: %s", JoinSecretName, c.Namespace(), err)
+		}
+	} else if !reflect.DeepEqual(current, data) {
+		c.LogInfo("update %s", JoinSecretName)
+		if err := c.UpdateSecret(JoinSecretName, data); err != nil {
+			return 0, fmt.Errorf("error updating %s in ns %s: %s", JoinSecretName, c.Namespace(), err)
+		}
+	}
+
+	// the bootstrapsigner of the tenant signs cluster-info with the token, kubeadm join
+	// verifies the signature before it trusts the CA
+	signed, err := c.clusterInfoSigned(tenantClient, token)
+	if err != nil {
+		return 0, err
+	}
+	if !signed {
+		c.LogInfo("waiting for cluster-info to be signed with token %s", token.ID)
+		c.setConditionFalse(claiov1beta1.ConditionJoinConfigurationReady, reasonProgressing, "waiting for cluster-info to be signed")
+		return clusterInfoSignatureWait, nil
+	}
+	c.setConditionTrue(claiov1beta1.ConditionJoinConfigurationReady, reasonIssued,
+		fmt.Sprintf("bootstrap token %s expires at %s", token.ID, token.Expiration.Format(time.RFC3339)))
+	return token.rotateIn(), nil
+}
+
+// currentBootstrapToken returns the token of the join-configuration secret, nil if there is none,
+// it is due for rotation or it was removed from the tenant
+func (c *ControlPlane) currentBootstrapToken(tenantClient client.Client, secretData map[string][]byte) (*BootstrapToken, error) {
+	if secretData == nil {
+		return nil, nil
+	}
+	token, err := parseBootstrapToken(string(secretData[JoinSecretKeyToken]), string(secretData[JoinSecretKeyTokenExpiration]))
+	if err != nil {
+		c.LogInfo("replace invalid bootstrap token: %s", err)
+		return nil, nil
+	}
+	if token.needsRotation() {
+		c.LogInfo("rotate bootstrap token %s, it expires at %s", token.ID, token.Expiration.Format(time.RFC3339))
+		return nil, nil
+	}
+	exists, err := c.bootstrapTokenExists(tenantClient, token)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		c.LogInfo("bootstrap token %s is missing in the tenant", token.ID)
+		return nil, nil
+	}
+	return token, nil
+}
+
+// clusterInfoSigned checks whether cluster-info carries the signature of the token
+func (c *ControlPlane) clusterInfoSigned(tenantClient client.Client, token *BootstrapToken) (bool, error) {
+	clusterInfo := &corev1.ConfigMap{}
+	if err := tenantClient.Get(c.Ctx, types.NamespacedName{Namespace: metav1.NamespacePublic, Name: "cluster-info"}, clusterInfo); err != nil {
+		return false, fmt.Errorf("error getting cluster-info: %s", err)
+	}
+	_, ok := clusterInfo.Data["jws-kubeconfig-"+token.ID]
+	return ok, nil
+}
+
+// caCertHashes are the hashes of the public keys of the CAs in the format of kubeadm's
+// caCertHashes, kubeadm join accepts any of them
+func caCertHashes(caCert []byte) ([]string, error) {
+	var hashes []string
+	for block, rest := pem.Decode(caCert); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing ca certificate: %s", err)
+		}
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		hashes = append(hashes, hex.EncodeToString(hash[:]))
+	}
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("ca certificate is not PEM encoded")
+	}
+	return hashes, nil
+}
+
+const kubeletConfigTemplate = `apiVersion: kubelet.config.k8s.io/v1beta1
+kind: KubeletConfiguration
+authentication:
+  anonymous:
+    enabled: false
+  webhook:
+    enabled: true
+  x509:
+    clientCAFile: /etc/kubernetes/pki/ca.crt
+authorization:
+  mode: Webhook
+cgroupDriver: systemd
+clusterDNS:
+- {{ .ClusterDNS }}
+clusterDomain: {{ .Spec.Network.DNSDomain }}
+evictionHard:
+  imagefs.available: 0%
+  nodefs.available: 0%
+  nodefs.inodesFree: 0%
+failSwapOn: false
+healthzBindAddress: 127.0.0.1
+healthzPort: 10248
+rotateCertificates: true
+staticPodPath: /etc/kubernetes/manifests
+`
+
+const joinConfigurationTemplate = `apiVersion: kubeadm.k8s.io/v1beta3
+kind: JoinConfiguration
+discovery:
+  bootstrapToken:
+    apiServerEndpoint: {{ .APIServerEndpoint }}
+    token: {{ .Token }}
+    caCertHashes:
+    {{- range .CACertHashes }}
+    - sha256:{{ . }}
+    {{- end }}
+  tlsBootstrapToken: {{ .Token }}
+`
+
+// joinTenantTemplate are the objects in the tenant kubeadm join discovers the tenant with
+const joinTenantTemplate = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cluster-info
+  namespace: kube-public
+data:
+  kubeconfig: |
+    apiVersion: v1
+    clusters:
+    - cluster:
+        certificate-authority-data: {{ .CACertData }}
+        server: {{ .Endpoint }}
+      name: ""
+    contexts: null
+    current-context: ""
+    kind: Config
+    preferences: {}
+    users: null
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: kubeadm:cluster-info
+  namespace: kube-public
+rules:
+- apiGroups: [""]
+  resources: ["configmaps"]
+  resourceNames: ["cluster-info"]
+  verbs: ["get"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: kubeadm:cluster-info
+  namespace: kube-public
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: kubeadm:cluster-info
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: User
+  name: system:anonymous
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: kubeadm-config
+  namespace: kube-system
+data:
+  ClusterConfiguration: |
+    apiVersion: kubeadm.k8s.io/v1beta3
+    kind: ClusterConfiguration
+    apiServer:
+      extraArgs:
+        authorization-mode: Node,RBAC
+    certificatesDir: /etc/kubernetes/pki
+    clusterName: {{ .Spec.Name }}
+    controlPlaneEndpoint: {{ .APIServerEndpoint }}
+    controllerManager: {}
+    dns: {}
+    imageRepository: registry.k8s.io
+    kubernetesVersion: v{{ .Spec.Version }}
+    networking:
+      dnsDomain: {{ .Spec.Network.DNSDomain }}
+      podSubnet: {{ .Spec.Network.ClusterCIDR }}
+      serviceSubnet: {{ .Spec.Network.ServiceCIDR }}
+    scheduler: {}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: kubelet-config
+  namespace: kube-system
+data:
+  # rendered kubelet config, quoted to keep the indentation of the template
+  kubelet: {{ printf "%q" .KubeletConfig }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: kubeadm:nodes-kubeadm-config
+  namespace: kube-system
+rules:
+- apiGroups: [""]
+  resources: ["configmaps"]
+  resourceNames: ["kubeadm-config", "kubelet-config"]
+  verbs: ["get"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: kubeadm:nodes-kubeadm-config
+  namespace: kube-system
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: kubeadm:nodes-kubeadm-config
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: ` + bootstrapTokenGroups + `
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: system:nodes
+`
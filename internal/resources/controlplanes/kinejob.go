@@ -0,0 +1,262 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	"claio/internal/backup"
+	"claio/internal/kubernetes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// managerGroup is the group of the non-root user of the manager image
+const managerGroup = 65532
+
+// KineJob is a job of another resource which reaches the datastore of the tenant through a
+// kine sidecar, the sidecar is configured like the kine container of the deployment and
+// serves the etcd API on 127.0.0.1:2379
+type KineJob struct {
+	Name string
+	// Container is the name of the container next to the sidecar
+	Container string
+	Labels    map[string]string
+	Image     string
+	Command   []string
+	Args      []string
+	// Env are variables of the container from keys of secrets
+	Env []KineJobEnv
+	// Volumes are claims and secrets mounted into the container
+	Volumes []KineJobVolume
+	// FSGroup owns the volumes of the pod, e.g. for a container which runs as non-root user
+	FSGroup int64
+	// SameNode schedules the job next to the deployment, the volume of a sqlite datastore can
+	// only be shared on the node it is attached to
+	SameNode bool
+}
+
+// KineJobEnv is a variable from a key of a secret
+type KineJobEnv struct {
+	Name       string
+	SecretName string
+	Key        string
+}
+
+// KineJobVolume is a PersistentVolumeClaim or a Secret mounted into the container
+type KineJobVolume struct {
+	Name       string
+	ClaimName  string
+	SecretName string
+	MountPath  string
+}
+
+// JobImage is the image of the jobs which run a command of the manager, e.g. backup, restore
+// and migrate
+type JobImage struct {
+	Image string
+	// Command is the manager binary, the entrypoint of the image if not set
+	Command []string
+}
+
+// ResolveJobImage returns the image if one is given. Otherwise the jobs use the image of the
+// manager pod (POD_NAME and POD_NAMESPACE) and the path of the running binary. The image is
+// empty if the manager does not run in a pod.
+func ResolveJobImage(ctx context.Context, reader client.Reader, image string) (*JobImage, error) {
+	if image != "" {
+		return &JobImage{Image: image}, nil
+	}
+	name, namespace := os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE")
+	if name == "" || namespace == "" {
+		return &JobImage{}, nil
+	}
+	pod := &corev1.Pod{}
+	if err := reader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, pod); err != nil {
+		return nil, fmt.Errorf("error getting pod %s in ns %s: %s", name, namespace, err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("error getting path of the manager: %s", err)
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == "manager" {
+			return &JobImage{Image: container.Image, Command: []string{executable}}, nil
+		}
+	}
+	return nil, fmt.Errorf("pod %s in ns %s has no manager container", name, namespace)
+}
+
+// NewKineJob is a job which runs the command of the manager, the container is named after
+// the command
+func (i *JobImage) NewKineJob(name, command string) (*KineJob, error) {
+	if i == nil || i.Image == "" {
+		return nil, fmt.Errorf("the manager does not run in a pod, the jobs need --backup-image")
+	}
+	job := &KineJob{
+		Name:      name,
+		Container: command,
+		Labels:    map[string]string{"app": "claio-" + command},
+		Image:     i.Image,
+		Args:      append(append([]string{}, i.Command...), command),
+		FSGroup:   managerGroup,
+	}
+	if len(i.Command) > 0 {
+		job.Command, job.Args = job.Args[:1], job.Args[1:]
+	}
+	return job, nil
+}
+
+// JobResult reads the result the command of the manager has written as termination message
+func JobResult(c client.Client, ctx context.Context, namespace, name, container string) (*backup.Result, error) {
+	message, err := kubernetes.JobTerminationMessage(c, ctx, namespace, name, container)
+	if err != nil {
+		return nil, fmt.Errorf("error getting pods of job %s: %s", name, err)
+	}
+	result := &backup.Result{}
+	if message == "" {
+		return result, nil
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(message)), result); err != nil {
+		return nil, fmt.Errorf("invalid result of job %s: %s", name, err)
+	}
+	return result, nil
+}
+
+// kineJobValues are the values of the job template
+type kineJobValues struct {
+	*KineJob
+	Namespace string
+	Kine      *kineValues
+	// Target is a second kine sidecar, e.g. on the new datastore of a migration
+	Target *kineJobTarget
+}
+
+// kineJobTarget are the values of the second sidecar, kineContainerTemplate expects them in .Kine
+type kineJobTarget struct {
+	Kine *kineValues
+}
+
+// EtcdPrefix is the --etcd-prefix of the apiserver, the keys of the tenant in the datastore
+func (c *ControlPlane) EtcdPrefix() string {
+	return "/tenant-" + c.Object.Spec.Name
+}
+
+// KineJobYaml renders the job in the namespace of the control-plane
+func (c *ControlPlane) KineJobYaml(job *KineJob) ([]byte, error) {
+	kine, err := c.kineValues()
+	if err != nil {
+		return nil, err
+	}
+	job.SameNode = job.SameNode && kine.DataClaim != ""
+	jobYaml, err := c.ToYaml(kineJobTemplate, &kineJobValues{KineJob: job, Namespace: c.Namespace(), Kine: kine})
+	if err != nil {
+		return nil, fmt.Errorf("error generating yaml: %s", err)
+	}
+	return jobYaml, nil
+}
+
+// kineJobTemplate runs kine as native sidecar, it is stopped once the container has exited
+const kineJobTemplate = `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+  labels:
+    {{- range $key, $value := .Labels }}
+    {{ $key }}: {{ $value }}
+    {{- end }}
+spec:
+  backoffLimit: 0
+  template:
+    metadata:
+      labels:
+        {{- range $key, $value := .Labels }}
+        {{ $key }}: {{ $value }}
+        {{- end }}
+    spec:
+      restartPolicy: Never
+      {{- with .FSGroup }}
+      securityContext:
+        fsGroup: {{ . }}
+      {{- end }}
+      {{- if .SameNode }}
+      affinity:
+        podAffinity:
+          requiredDuringSchedulingIgnoredDuringExecution:
+            - labelSelector:
+                matchLabels:
+                  app: claio
+              topologyKey: kubernetes.io/hostname
+      {{- end }}
+      initContainers:
+` + kineContainerTemplate + `          restartPolicy: Always
+      {{- with .Target }}
+` + kineContainerTemplate + `          restartPolicy: Always
+      {{- end }}
+      containers:
+        - name: {{ .Container }}
+          image: {{ .Image }}
+          {{- with .Command }}
+          command:
+            {{- range . }}
+            - {{ . }}
+            {{- end }}
+          {{- end }}
+          args:
+            {{- range .Args }}
+            - {{ printf "%q" . }}
+            {{- end }}
+          {{- with .Env }}
+          env:
+            {{- range . }}
+            - name: {{ .Name }}
+              valueFrom:
+                secretKeyRef:
+                  name: {{ .SecretName }}
+                  key: {{ .Key }}
+            {{- end }}
+          {{- end }}
+          volumeMounts:
+            - name: work
+              mountPath: /tmp
+            {{- range .Volumes }}
+            - name: {{ .Name }}
+              mountPath: {{ .MountPath }}
+              {{- if .SecretName }}
+              readOnly: true
+              {{- end }}
+            {{- end }}
+      volumes:
+        - name: work
+          emptyDir: {}
+        {{- range .Volumes }}
+        - name: {{ .Name }}
+          {{- if .ClaimName }}
+          persistentVolumeClaim:
+            claimName: {{ .ClaimName }}
+          {{- else }}
+          secret:
+            secretName: {{ .SecretName }}
+          {{- end }}
+        {{- end }}
+` + kineVolumesTemplate + `        {{- with .Target }}
+` + kineVolumesTemplate + `        {{- end }}
+`
@@ -17,8 +17,11 @@ limitations under the License.
 package controlplanes
 
 import (
+	"claio/internal/certificates"
 	b64 "encoding/base64"
 	"fmt"
+
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 type Kubeconfig struct {
@@ -47,9 +50,35 @@ func (c *ControlPlane) getKubeconfig(secretName, secretKey, clusterName, usernam
 	if err != nil {
 		return nil, false, fmt.Errorf("error getting kubeconfig secret %s/%s: %s", c.Namespace(), secretName, err)
 	}
+	ca, err := c.getCertificateSecret("ca")
+	if err != nil {
+		return nil, false, fmt.Errorf("error getting ca cert in ns %s: %s", c.Namespace(), err)
+	}
+	if ca == nil {
+		return nil, false, fmt.Errorf("ca cert does not exist in ns %s", c.Namespace())
+	}
+	bundle, err := c.CACertificate()
+	if err != nil {
+		return nil, false, err
+	}
+	template, err := kubernetesAdminTemplate(&c.Object.Spec)
+	if err != nil {
+		return nil, false, fmt.Errorf("error creating %s certs in ns %s: %s", secretName, c.Namespace(), err)
+	}
 	if secretData != nil {
 		if !forceCreate {
-			return secretData[secretKey], false, nil
+			clientCert, err := kubeconfigClientCert(secretData[secretKey], clusterName, username, c.Endpoint(), bundle)
+			if err == nil {
+				err = clientCert.Validate(ca, template, c.renewBefore())
+			}
+			if err == nil {
+				err = checkKeyAlgorithm(clientCert, c.keyAlgorithm())
+			}
+			if err == nil {
+				c.scheduleRenewal(clientCert)
+				return secretData[secretKey], false, nil
+			}
+			c.LogInfo("renew %s: %s", secretName, err)
 		}
 		c.LogInfo("delete old/invalid secret: %s", secretName)
 		if err := c.DeleteSecret(secretName); err != nil {
@@ -57,19 +86,15 @@ func (c *ControlPlane) getKubeconfig(secretName, secretKey, clusterName, usernam
 		}
 	}
 	c.LogInfo("create %s", secretName)
-	ca, err := c.getCertificateSecret("ca")
-	if err != nil {
-		return nil, true, fmt.Errorf("error getting ca cert in ns %s: %s", c.Namespace(), err)
-	}
-	clientCert, err := newKubernetesAdminCert(ca, nil, nil)
+	clientCert, err := createCert(template, ca, c.keyAlgorithm())
 	if err != nil {
 		return nil, true, fmt.Errorf("error creating %s certs in ns %s: %s", secretName, c.Namespace(), err)
 	}
 	kubeconfig := NewKubeconfig(
 		clusterName,
-		c.Object.Spec.AdvertiseHost,
+		c.Endpoint(),
 		username,
-		ca.Cert,
+		string(bundle),
 		clientCert.Cert,
 		clientCert.Key,
 	)
@@ -80,9 +105,37 @@ func (c *ControlPlane) getKubeconfig(secretName, secretKey, clusterName, usernam
 	if err := c.CreateSecret(secretName, map[string][]byte{secretKey: []byte(yaml)}); err != nil {
 		return nil, true, fmt.Errorf("error creating %s secret in ns %s: %s", secretName, c.Namespace(), err)
 	}
+	c.scheduleRenewal(clientCert)
 	return yaml, true, nil
 }
 
+// kubeconfigClientCert returns the client certificate of the user in the kubeconfig, the
+// kubeconfig has to point to the server and trust the CA bundle of the tenant
+func kubeconfigClientCert(data []byte, clusterName, username, server string, bundle []byte) (*certificates.Certificate, error) {
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing kubeconfig: %s", err)
+	}
+	cluster, ok := config.Clusters[clusterName]
+	if !ok {
+		return nil, fmt.Errorf("the kubeconfig has no cluster %s", clusterName)
+	}
+	if cluster.Server != server {
+		return nil, fmt.Errorf("the kubeconfig points to %s instead of %s", cluster.Server, server)
+	}
+	if string(cluster.CertificateAuthorityData) != string(bundle) {
+		return nil, fmt.Errorf("the kubeconfig does not trust the CA bundle")
+	}
+	user, ok := config.AuthInfos[username]
+	if !ok {
+		return nil, fmt.Errorf("the kubeconfig has no user %s", username)
+	}
+	return &certificates.Certificate{
+		Cert: string(user.ClientCertificateData),
+		Key:  string(user.ClientKeyData),
+	}, nil
+}
+
 // ----------------------------------------------------------------------------
 
 func (c *ControlPlane) GetAdminKubeconfig(forceCreate bool) ([]byte, bool, error) {
@@ -106,22 +159,22 @@ func (c *ControlPlane) kubeconfigReconcile(caChanged bool) error {
 	// kubeconfig-admin
 	_, _, err := c.GetAdminKubeconfig(caChanged)
 	if err != nil {
-		return fmt.Errorf("failed to get kubeconfig-admin")
+		return fmt.Errorf("failed to get kubeconfig-admin: %s", err)
 	}
 	// kubeconfig-scheduler
 	_, _, err = c.GetSchedulerKubeconfig(caChanged)
 	if err != nil {
-		return fmt.Errorf("failed to get kubeconfig-scheduler")
+		return fmt.Errorf("failed to get kubeconfig-scheduler: %s", err)
 	}
 	// kubeconfig-controller
 	_, _, err = c.GetControllerKubeconfig(caChanged)
 	if err != nil {
-		return fmt.Errorf("failed to get kubeconfig-controller")
+		return fmt.Errorf("failed to get kubeconfig-controller: %s", err)
 	}
 	// kubeconfig-konnectivity
 	_, _, err = c.GetKonnectivityKubeconfig(caChanged)
 	if err != nil {
-		return fmt.Errorf("failed to get kubeconfig-konnectivity")
+		return fmt.Errorf("failed to get kubeconfig-konnectivity: %s", err)
 	}
 
 	return nil
@@ -134,7 +187,7 @@ clusters:
   - name: {{ .ClusterName }}
     cluster:
       certificate-authority-data: {{ .CACertData }}
-      server: "https://{{ .Server }}:6543"
+      server: "{{ .Server }}"
 contexts:
   - name: {{ .User }}@{{ .ClusterName }}
     context:		
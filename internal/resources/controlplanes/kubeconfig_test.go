@@ -0,0 +1,62 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var _ = Describe("Kubeconfig", func() {
+	var c *ControlPlane
+
+	BeforeEach(func() {
+		c = newTestControlPlane()
+		c.Object.Spec.Endpoint.Port = 7443
+		_, err := c.reconcileCertificates()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	server := func(data []byte) string {
+		config, err := clientcmd.Load(data)
+		Expect(err).NotTo(HaveOccurred())
+		return config.Clusters[c.Namespace()].Server
+	}
+
+	It("Should point to the port of the endpoint", func() {
+		data, created, err := c.GetAdminKubeconfig(false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created).To(BeTrue())
+		Expect(server(data)).To(Equal("https://sample.example.com:7443"))
+
+		_, created, err = c.GetAdminKubeconfig(false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created).To(BeFalse())
+	})
+
+	It("Should reissue a kubeconfig when the port changes", func() {
+		_, _, err := c.GetAdminKubeconfig(false)
+		Expect(err).NotTo(HaveOccurred())
+
+		c.Object.Spec.Endpoint.Port = 8443
+		data, created, err := c.GetAdminKubeconfig(false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created).To(BeTrue())
+		Expect(server(data)).To(Equal("https://sample.example.com:8443"))
+	})
+})
@@ -0,0 +1,307 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/backup"
+	"claio/internal/kubernetes"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// migrationJobName copies the keys of the tenant between the kine sidecars of the job
+	migrationJobName = "kine-migrate"
+	// migrationSecretName is the datastore secret of a tenant moving onto a DataStore, it
+	// replaces kine-datastore once the migration has completed
+	migrationSecretName = "kine-datastore-migration"
+
+	// the kine of the new datastore runs next to the kine of the old one
+	migrationTargetSuffix  = "-target"
+	migrationTargetAddress = "127.0.0.1:2380"
+	migrationTargetMetrics = "127.0.0.1:8081"
+
+	// migrationInterval is how often a running migration is checked
+	migrationInterval = 10 * time.Second
+)
+
+// sameDatastore is true if both specs reach the same keys, the deployment is restarted for
+// other changes of the datastore. Tenants from before DataStores are placed on the default one.
+func sameDatastore(a, b *claiov1beta1.DatastoreSpec) bool {
+	if a.Driver != b.Driver {
+		return false
+	}
+	if a.Driver == claiov1beta1.DatastoreDriverSQLite {
+		return true
+	}
+	legacy := func(d *claiov1beta1.DatastoreSpec) bool { return d.DataStoreName == "" && d.SecretRef == nil }
+	if legacy(a) || legacy(b) {
+		return true
+	}
+	if a.DataStoreName != "" || b.DataStoreName != "" {
+		return a.DataStoreName == b.DataStoreName
+	}
+	return a.SecretRef.Name == b.SecretRef.Name
+}
+
+// describeDatastore is the datastore in the migration status
+func describeDatastore(datastore *claiov1beta1.DatastoreSpec) string {
+	switch {
+	case datastore.DataStoreName != "":
+		return fmt.Sprintf("%s on DataStore %s", datastore.Driver, datastore.DataStoreName)
+	case datastore.SecretRef != nil:
+		return fmt.Sprintf("%s from secret %s", datastore.Driver, datastore.SecretRef.Name)
+	}
+	return datastore.Driver
+}
+
+// migrationSource is the datastore the deployment runs on if the spec moves the tenant to
+// another one, nil otherwise. The target spec keeps the old datastore until the migration has
+// completed.
+func (c *ControlPlane) migrationSource() *claiov1beta1.DatastoreSpec {
+	target := &c.Object.Status.TargetSpec
+	if target.Name == "" || sameDatastore(&target.Datastore, &c.Object.Spec.Datastore) {
+		return nil
+	}
+	return &target.Datastore
+}
+
+// activeDatastore is the datastore of the deployment
+func (c *ControlPlane) activeDatastore() *claiov1beta1.DatastoreSpec {
+	if source := c.migrationSource(); source != nil {
+		return source
+	}
+	return &c.Object.Spec.Datastore
+}
+
+// dataStoreSecretName is the secret of the tenant on the DataStore of the spec
+func (c *ControlPlane) dataStoreSecretName() string {
+	if c.migrationSource() != nil {
+		return migrationSecretName
+	}
+	return DataStoreSecretName
+}
+
+// migrationRunning is true while the deployment has to stay scaled down for the copy
+func (c *ControlPlane) migrationRunning() bool {
+	migration := c.Object.Status.Migration
+	return c.migrationSource() != nil && migration != nil && migration.Phase == claiov1beta1.DatastoreMigrationRunning
+}
+
+// reconcileMigration moves the keys of the tenant to the datastore of the spec. The apiserver
+// is stopped, the migrate command of the manager copies and verifies the keys and kine is
+// switched to the new datastore. A failed copy is rolled back by the command, the tenant runs
+// on the old datastore again until the job is deleted (retry) or the spec is reverted.
+func (c *ControlPlane) reconcileMigration() (time.Duration, error) {
+	source := c.migrationSource()
+	migration := c.Object.Status.Migration
+	if source == nil {
+		if migration != nil && migration.Phase != claiov1beta1.DatastoreMigrationCompleted {
+			c.LogInfo("migration to %s abandoned", migration.Target)
+			if err := c.cleanupMigration(migration); err != nil {
+				return 0, err
+			}
+			c.Object.Status.Migration = nil
+		}
+		return 0, nil
+	}
+
+	c.LogHeader("check datastore migration ...")
+	target := describeDatastore(&c.Object.Spec.Datastore)
+	if migration == nil || migration.Target != target || migration.Phase == claiov1beta1.DatastoreMigrationCompleted {
+		if migration != nil && migration.Phase != claiov1beta1.DatastoreMigrationCompleted {
+			if err := c.cleanupMigration(migration); err != nil {
+				return 0, err
+			}
+		}
+		c.LogInfo("migrate datastore from %s to %s", describeDatastore(source), target)
+		now := metav1.Now()
+		migration = &claiov1beta1.DatastoreMigrationStatus{
+			Phase:         claiov1beta1.DatastoreMigrationRunning,
+			Source:        describeDatastore(source),
+			Target:        target,
+			DataStoreName: c.Object.Spec.Datastore.DataStoreName,
+			Message:       "stopping the apiserver",
+			StartTime:     &now,
+		}
+		c.Object.Status.Migration = migration
+	}
+
+	job, err := c.GetJob(migrationJobName)
+	if err != nil {
+		return 0, fmt.Errorf("error getting job %s: %s", migrationJobName, err)
+	}
+	if migration.Phase == claiov1beta1.DatastoreMigrationFailed {
+		if job != nil {
+			return 0, nil
+		}
+		c.LogInfo("job %s deleted, retry migration", migrationJobName)
+		now := metav1.Now()
+		migration.Phase = claiov1beta1.DatastoreMigrationRunning
+		migration.Message = "stopping the apiserver"
+		migration.StartTime = &now
+		migration.CompletionTime = nil
+	}
+
+	if job == nil {
+		// the old kine must not serve writes during the copy
+		deployment, err := c.GetClaioDeployment()
+		if err != nil {
+			return 0, err
+		}
+		if deployment != nil && (deployment.Status.Replicas > 0 ||
+			deployment.Spec.Replicas == nil || *deployment.Spec.Replicas > 0) {
+			if err := c.stopPods(deployment); err != nil {
+				return 0, err
+			}
+			return migrationInterval, nil
+		}
+		jobYaml, err := c.migrationJobYaml(source)
+		if err != nil {
+			return 0, err
+		}
+		c.LogInfo("start job %s", migrationJobName)
+		if err := c.CreateJob(migrationJobName, jobYaml); err != nil {
+			return 0, err
+		}
+		migration.Message = "copying the keys"
+		return migrationInterval, nil
+	}
+
+	finished, failed := kubernetes.JobFinished(job)
+	if !finished {
+		return migrationInterval, nil
+	}
+	result, err := JobResult(c.Client, c.Ctx, c.Namespace(), migrationJobName, backup.CommandMigrate)
+	if err != nil {
+		return 0, err
+	}
+	now := metav1.Now()
+	migration.CompletionTime = &now
+	if failed != "" || result.Error != "" {
+		message := result.Error
+		if message == "" {
+			message = failed
+		}
+		c.LogInfo("migration failed: %s", message)
+		migration.Phase = claiov1beta1.DatastoreMigrationFailed
+		migration.Message = fmt.Sprintf("%s, delete job %s to retry", message, migrationJobName)
+		return 0, nil
+	}
+
+	if err := c.switchDatastore(source); err != nil {
+		return 0, err
+	}
+	c.LogInfo("migration completed, %d keys copied", result.Keys)
+	migration.Phase = claiov1beta1.DatastoreMigrationCompleted
+	migration.Keys = result.Keys
+	migration.Message = fmt.Sprintf("%d keys copied and verified", result.Keys)
+	return 0, nil
+}
+
+// switchDatastore points the deployment to the datastore of the spec, the old datastore keeps
+// the keys of the tenant
+func (c *ControlPlane) switchDatastore(source *claiov1beta1.DatastoreSpec) error {
+	if err := c.stopDeployment(); err != nil {
+		return err
+	}
+	if c.Object.Spec.Datastore.DataStoreName != "" {
+		data, err := c.GetSecret(migrationSecretName)
+		if err != nil {
+			return fmt.Errorf("error getting %s in ns %s: %s", migrationSecretName, c.Namespace(), err)
+		}
+		if data == nil {
+			return fmt.Errorf("datastore secret %s in ns %s does not exist", migrationSecretName, c.Namespace())
+		}
+		current, err := c.GetSecret(DataStoreSecretName)
+		if err != nil {
+			return fmt.Errorf("error getting %s in ns %s: %s", DataStoreSecretName, c.Namespace(), err)
+		}
+		if current == nil {
+			err = c.CreateSecret(DataStoreSecretName, data)
+		} else {
+			err = c.UpdateSecret(DataStoreSecretName, data)
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.DeleteSecret(migrationSecretName); err != nil {
+			return err
+		}
+	} else if source.DataStoreName != "" {
+		if err := c.DeleteSecret(DataStoreSecretName); err != nil {
+			return err
+		}
+	}
+	if source.DataStoreName != "" && source.DataStoreName != c.Object.Spec.Datastore.DataStoreName {
+		if err := c.releaseDataStoreNamed(source.DataStoreName); err != nil {
+			return err
+		}
+	}
+	// the deployment is created on the new datastore with the next reconciliation
+	c.Object.Status.TargetSpec.Datastore = c.Object.Spec.Datastore
+	return nil
+}
+
+// cleanupMigration removes the job and the target secret of an unfinished migration and
+// releases the tenant from the DataStore it was moving onto
+func (c *ControlPlane) cleanupMigration(migration *claiov1beta1.DatastoreMigrationStatus) error {
+	if err := c.DeleteJob(migrationJobName); err != nil {
+		return err
+	}
+	if err := c.DeleteSecret(migrationSecretName); err != nil {
+		return err
+	}
+	name := migration.DataStoreName
+	if name == "" || name == c.activeDatastore().DataStoreName || name == c.Object.Spec.Datastore.DataStoreName {
+		return nil
+	}
+	return c.releaseDataStoreNamed(name)
+}
+
+// migrationJobYaml renders the job with a kine sidecar on each datastore
+func (c *ControlPlane) migrationJobYaml(source *claiov1beta1.DatastoreSpec) ([]byte, error) {
+	job, err := c.JobImage.NewKineJob(migrationJobName, backup.CommandMigrate)
+	if err != nil {
+		return nil, err
+	}
+	job.Args = append(job.Args, "--prefix="+c.EtcdPrefix(), "--target-endpoint=http://"+migrationTargetAddress)
+	sourceKine, err := c.kineValuesOf(source, DataStoreSecretName)
+	if err != nil {
+		return nil, fmt.Errorf("source: %s", err)
+	}
+	targetKine, err := c.kineValuesOf(&c.Object.Spec.Datastore, migrationSecretName)
+	if err != nil {
+		return nil, fmt.Errorf("target: %s", err)
+	}
+	targetKine.Suffix = migrationTargetSuffix
+	targetKine.ListenAddress = migrationTargetAddress
+	targetKine.MetricsAddress = migrationTargetMetrics
+	jobYaml, err := c.ToYaml(kineJobTemplate, &kineJobValues{
+		KineJob:   job,
+		Namespace: c.Namespace(),
+		Kine:      sourceKine,
+		Target:    &kineJobTarget{Kine: targetKine},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error generating yaml: %s", err)
+	}
+	return jobYaml, nil
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	"bytes"
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/kubernetes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/nats-io/nkeys"
+)
+
+const (
+	// keys of the NATS credentials of kine in the datastore secret of the tenant
+	DataStoreSecretKeyNATSContext = "nats.json"
+	DataStoreSecretKeyNATSSeed    = "nats.nk"
+
+	// natsEndpoint connects kine with the context file, the url of the context is used
+	natsEndpoint        = "nats://?%s&contextFile=" + natsCredentialsPath + "/" + DataStoreSecretKeyNATSContext
+	natsCredentialsPath = "/etc/kine/nats"
+
+	// NATSAccountsKey is the file the NATS config includes, the other keys of the accounts
+	// secret are the accounts of the single tenants. The config-reloader of the NATS pod
+	// reloads the server when the secret changes.
+	NATSAccountsKey = "accounts.conf"
+)
+
+// natsAccountName is the NATS account of the tenant, its JetStream is invisible to other accounts
+func (c *ControlPlane) natsAccountName() string {
+	return "tenant-" + c.Object.Spec.Name
+}
+
+// natsBucket is the JetStream KV bucket of the tenant
+func (c *ControlPlane) natsBucket() string {
+	return "tenant-" + c.Object.Spec.Name
+}
+
+// natsOptions are the kine options of the tenant bucket on the DataStore, the replicas and
+// the history of the quota only apply when kine creates the bucket
+func (c *ControlPlane) natsOptions(dataStore *claiov1beta1.DataStore) string {
+	options := "noEmbed&bucket=" + c.natsBucket()
+	if dataStore.Spec.NATS != nil && dataStore.Spec.NATS.Replicas > 0 {
+		options += fmt.Sprintf("&replicas=%d", dataStore.Spec.NATS.Replicas)
+	}
+	if quota := c.Object.Spec.Datastore.Quota; quota != nil && quota.MaxRevisionHistory != nil {
+		options += fmt.Sprintf("&revHistory=%d", *quota.MaxRevisionHistory)
+	}
+	return options
+}
+
+// natsMaxFile is the JetStream file storage of the tenant account, -1 is unlimited
+func (c *ControlPlane) natsMaxFile() int64 {
+	if quota := c.Object.Spec.Datastore.Quota; quota != nil && quota.MaxBytes != nil {
+		return quota.MaxBytes.Value()
+	}
+	return -1
+}
+
+// natsCredentials keeps the nkey user of kine from the current datastore secret of the tenant
+// or creates a new one, adds the context file and the seed to data and registers the user as
+// the only user of the tenant account on the DataStore
+func (c *ControlPlane) natsCredentials(dataStore *claiov1beta1.DataStore, current, data map[string][]byte) error {
+	user, err := nkeys.FromSeed(current[DataStoreSecretKeyNATSSeed])
+	if err != nil {
+		c.LogInfo("create nats user of tenant")
+		if user, err = nkeys.CreateUser(); err != nil {
+			return fmt.Errorf("error creating nkey: %s", err)
+		}
+	}
+	seed, err := user.Seed()
+	if err != nil {
+		return fmt.Errorf("error getting seed of nkey: %s", err)
+	}
+	publicKey, err := user.PublicKey()
+	if err != nil {
+		return fmt.Errorf("error getting public key of nkey: %s", err)
+	}
+	natsContext, err := json.Marshal(map[string]string{
+		"url":  dataStore.Spec.Endpoint,
+		"nkey": natsCredentialsPath + "/" + DataStoreSecretKeyNATSSeed,
+	})
+	if err != nil {
+		return fmt.Errorf("error generating nats context: %s", err)
+	}
+	data[claiov1beta1.DatastoreSecretKeyEndpoint] = []byte(fmt.Sprintf(natsEndpoint, c.natsOptions(dataStore)))
+	data[DataStoreSecretKeyNATSContext] = natsContext
+	data[DataStoreSecretKeyNATSSeed] = seed
+
+	account, err := c.ToYaml(natsAccountTemplate, map[string]any{
+		"Account":   c.natsAccountName(),
+		"Bucket":    c.natsBucket(),
+		"PublicKey": publicKey,
+		"MaxFile":   c.natsMaxFile(),
+	})
+	if err != nil {
+		return fmt.Errorf("error generating nats account: %s", err)
+	}
+	return c.updateNATSAccounts(dataStore, account)
+}
+
+// removeNATSAccount removes the tenant account, the bucket stays in the JetStream store
+func (c *ControlPlane) removeNATSAccount(dataStore *claiov1beta1.DataStore) error {
+	return c.updateNATSAccounts(dataStore, nil)
+}
+
+// updateNATSAccounts sets (or removes if nil) the account of the tenant in the accounts secret
+// of the DataStore and joins all accounts into the file the NATS config includes
+func (c *ControlPlane) updateNATSAccounts(dataStore *claiov1beta1.DataStore, account []byte) error {
+	ref := dataStore.Spec.NATS.AccountsSecretRef
+	accounts, err := kubernetes.GetSecret(c.Client, c.Ctx, ref.Namespace, ref.Name)
+	if err != nil {
+		return fmt.Errorf("error getting %s in ns %s: %s", ref.Name, ref.Namespace, err)
+	}
+	exists := accounts != nil
+	if !exists {
+		if account == nil {
+			return nil
+		}
+		accounts = map[string][]byte{}
+	}
+	if current, ok := accounts[c.natsAccountName()]; ok == (account != nil) && bytes.Equal(current, account) {
+		return nil
+	}
+	if account == nil {
+		c.LogInfo("remove nats account %s from datastore %s", c.natsAccountName(), dataStore.Name)
+		delete(accounts, c.natsAccountName())
+	} else {
+		c.LogInfo("register nats account %s on datastore %s", c.natsAccountName(), dataStore.Name)
+		accounts[c.natsAccountName()] = account
+	}
+
+	names := []string{}
+	for name := range accounts {
+		if name != NATSAccountsKey {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var conf bytes.Buffer
+	for _, name := range names {
+		conf.Write(accounts[name])
+	}
+	accounts[NATSAccountsKey] = conf.Bytes()
+
+	if !exists {
+		return kubernetes.CreateSecret(c.Client, c.Ctx, ref.Namespace, ref.Name, accounts, nil, c.Scheme)
+	}
+	return kubernetes.UpdateSecret(c.Client, c.Ctx, ref.Namespace, ref.Name, accounts, nil, c.Scheme)
+}
+
+// natsAccountTemplate is an account with its own JetStream, limited to the maxBytes of the
+// quota. The user of kine may only use the KV bucket of the tenant and its inbox, the jobs of
+// the deletion policy use the same user.
+const natsAccountTemplate = `{{ .Account }}: {
+  {{- if ge .MaxFile 0 }}
+  jetstream: {
+    max_file: {{ .MaxFile }}
+  }
+  {{- else }}
+  jetstream: enabled
+  {{- end }}
+  users: [
+    {
+      nkey: {{ .PublicKey }}
+      permissions: {
+        publish: {
+          allow: [
+            "$JS.API.INFO"
+            "$JS.API.STREAM.*.KV_{{ .Bucket }}"
+            "$JS.API.STREAM.MSG.GET.KV_{{ .Bucket }}"
+            "$JS.API.DIRECT.GET.KV_{{ .Bucket }}"
+            "$JS.API.DIRECT.GET.KV_{{ .Bucket }}.>"
+            "$JS.API.CONSUMER.*.KV_{{ .Bucket }}"
+            "$JS.API.CONSUMER.*.KV_{{ .Bucket }}.>"
+            "$JS.ACK.KV_{{ .Bucket }}.>"
+            "$JS.FC.KV_{{ .Bucket }}.>"
+            "$JS.SNAPSHOT.ACK.KV_{{ .Bucket }}.>"
+            "$KV.{{ .Bucket }}.>"
+          ]
+        }
+        subscribe: {
+          allow: ["_INBOX.>"]
+        }
+      }
+    }
+  ]
+}
+`
@@ -0,0 +1,195 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/kubernetes"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DataStoreSecretName is the secret next to the control-plane with the kine endpoint (and TLS
+// files) of a tenant placed on a DataStore, the manager generates it from the DataStore
+const DataStoreSecretName = "kine-datastore"
+
+// IsPlacedOn is true if the control-plane references the DataStore and its datastore has
+// been provisioned there
+func IsPlacedOn(controlPlane *claiov1beta1.ControlPlane, dataStoreName string) bool {
+	return controlPlane.Spec.Datastore.DataStoreName == dataStoreName &&
+		meta.IsStatusConditionTrue(controlPlane.Status.Conditions, claiov1beta1.ConditionDatastoreReady)
+}
+
+// getDataStore returns the DataStore of the tenant, nil if it does not reference one
+func (c *ControlPlane) getDataStore() (*claiov1beta1.DataStore, error) {
+	name := c.Object.Spec.Datastore.DataStoreName
+	if name == "" {
+		return nil, nil
+	}
+	dataStore := &claiov1beta1.DataStore{}
+	if err := c.Client.Get(c.Ctx, types.NamespacedName{Name: name}, dataStore); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("datastore %s does not exist", name)
+		}
+		return nil, fmt.Errorf("error getting datastore %s: %s", name, err)
+	}
+	return dataStore, nil
+}
+
+// checkPlacement refuses a DataStore of another driver and, unless the tenant is placed
+// already, a DataStore without room for another tenant
+func (c *ControlPlane) checkPlacement(dataStore *claiov1beta1.DataStore) error {
+	if dataStore.Spec.Driver != c.Object.Spec.Datastore.Driver {
+		return fmt.Errorf("datastore %s is a %s datastore, the tenant uses %s", dataStore.Name,
+			dataStore.Spec.Driver, c.Object.Spec.Datastore.Driver)
+	}
+	maxTenants := dataStore.Spec.Limits.MaxTenants
+	if maxTenants == nil || IsPlacedOn(c.Object, dataStore.Name) {
+		return nil
+	}
+	controlPlanes := &claiov1beta1.ControlPlaneList{}
+	if err := c.Client.List(c.Ctx, controlPlanes); err != nil {
+		return fmt.Errorf("error listing control-planes: %s", err)
+	}
+	tenants := int32(0)
+	for i := range controlPlanes.Items {
+		if IsPlacedOn(&controlPlanes.Items[i], dataStore.Name) {
+			tenants++
+		}
+	}
+	if tenants >= *maxTenants {
+		return fmt.Errorf("datastore %s is full (%d of %d tenants)", dataStore.Name, tenants, *maxTenants)
+	}
+	return nil
+}
+
+// reconcileDataStoreTenant provisions the tenant on the DataStore and writes the kine endpoint
+// into the datastore secret of the tenant (the migration secret while the tenant moves onto the
+// DataStore). kine creates the database of postgres and mysql tenants (tenant_<name>) with the
+// admin credentials, nats tenants get the bucket tenant-<name> in their own account if the
+// DataStore manages accounts.
+func (c *ControlPlane) reconcileDataStoreTenant(dataStore *claiov1beta1.DataStore) error {
+	secretName := c.dataStoreSecretName()
+	current, err := c.GetSecret(secretName)
+	if err != nil {
+		return fmt.Errorf("error getting %s in ns %s: %s", secretName, c.Namespace(), err)
+	}
+
+	data := map[string][]byte{}
+	var admin map[string][]byte
+	if ref := dataStore.Spec.SecretRef; ref != nil {
+		if admin, err = kubernetes.GetSecret(c.Client, c.Ctx, ref.Namespace, ref.Name); err != nil {
+			return fmt.Errorf("error getting secret %s of datastore %s in ns %s: %s", ref.Name, dataStore.Name, ref.Namespace, err)
+		}
+		if admin == nil {
+			return fmt.Errorf("secret %s of datastore %s in ns %s does not exist", ref.Name, dataStore.Name, ref.Namespace)
+		}
+		for _, key := range []string{
+			claiov1beta1.DatastoreSecretKeyCACert, claiov1beta1.DatastoreSecretKeyTLSCert, claiov1beta1.DatastoreSecretKeyTLSKey,
+		} {
+			if len(admin[key]) > 0 {
+				data[key] = admin[key]
+			}
+		}
+	}
+	var user *url.Userinfo
+	if username := admin[claiov1beta1.DataStoreSecretKeyUsername]; len(username) > 0 {
+		user = url.UserPassword(string(username), string(admin[claiov1beta1.DataStoreSecretKeyPassword]))
+	}
+
+	endpoint, err := url.Parse(dataStore.Spec.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint of datastore %s: %s", dataStore.Name, err)
+	}
+	switch dataStore.Spec.Driver {
+	case claiov1beta1.DatastoreDriverNATS:
+		if dataStore.Spec.NATS != nil && dataStore.Spec.NATS.AccountsSecretRef != nil {
+			if err := c.natsCredentials(dataStore, current, data); err != nil {
+				return err
+			}
+			break
+		}
+		endpoint.User = user
+		endpoint.RawQuery = c.natsOptions(dataStore)
+		data[claiov1beta1.DatastoreSecretKeyEndpoint] = []byte(endpoint.String())
+	case claiov1beta1.DatastoreDriverPostgres:
+		endpoint.User = user
+		endpoint.Path = "/" + c.sqlDatabase()
+		data[claiov1beta1.DatastoreSecretKeyEndpoint] = []byte(endpoint.String())
+	case claiov1beta1.DatastoreDriverMySQL:
+		// kine passes the rest of the endpoint to the mysql driver, it expects tcp(host:port)
+		credentials := ""
+		if user != nil {
+			credentials = user.String() + "@"
+		}
+		data[claiov1beta1.DatastoreSecretKeyEndpoint] = []byte(fmt.Sprintf("mysql://%stcp(%s)/%s",
+			credentials, endpoint.Host, c.sqlDatabase()))
+	default:
+		return fmt.Errorf("datastore %s has the unsupported driver %s", dataStore.Name, dataStore.Spec.Driver)
+	}
+
+	if reflect.DeepEqual(current, data) {
+		return nil
+	}
+	if current == nil {
+		c.LogInfo("place tenant on datastore %s", dataStore.Name)
+		return c.CreateSecret(secretName, data)
+	}
+	c.LogInfo("update %s", secretName)
+	return c.UpdateSecret(secretName, data)
+}
+
+// releaseDataStore removes the account of the tenant from its DataStore, and from the DataStore
+// of an unfinished migration, the data stays
+func (c *ControlPlane) releaseDataStore() error {
+	if source := c.migrationSource(); source != nil && source.DataStoreName != c.Object.Spec.Datastore.DataStoreName {
+		if err := c.releaseDataStoreNamed(source.DataStoreName); err != nil {
+			return err
+		}
+	}
+	return c.releaseDataStoreNamed(c.Object.Spec.Datastore.DataStoreName)
+}
+
+// releaseDataStoreNamed removes the account of the tenant from the DataStore with the name
+func (c *ControlPlane) releaseDataStoreNamed(name string) error {
+	if name == "" {
+		return nil
+	}
+	dataStore := &claiov1beta1.DataStore{}
+	if err := c.Client.Get(c.Ctx, types.NamespacedName{Name: name}, dataStore); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("error getting datastore %s: %s", name, err)
+	}
+	if dataStore.Spec.Driver == claiov1beta1.DatastoreDriverNATS && dataStore.Spec.NATS != nil &&
+		dataStore.Spec.NATS.AccountsSecretRef != nil {
+		return c.removeNATSAccount(dataStore)
+	}
+	return nil
+}
+
+// sqlDatabase is the database of the tenant on a postgres or mysql DataStore
+func (c *ControlPlane) sqlDatabase() string {
+	return "tenant_" + strings.ReplaceAll(c.Object.Spec.Name, "-", "_")
+}
@@ -0,0 +1,319 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	"bufio"
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/kubernetes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// quotaInterval is how often the usage of a tenant with a quota is read
+	quotaInterval = time.Minute
+	// quotaJobInterval is how often the job switching the database is checked
+	quotaJobInterval = 10 * time.Second
+	quotaJobName     = "kine-quota"
+
+	defaultQuotaWarningPercent = 80
+
+	jobActionReadOnly  = "readonly"
+	jobActionReadWrite = "readwrite"
+
+	// the storage metrics of the apiserver, the objects per resource and the size kine reports
+	storageObjectsMetric = "apiserver_storage_objects"
+	storageSizeMetric    = "apiserver_storage_size_bytes"
+
+	// reasons of the events of the quota
+	eventQuotaWarning  = "DatastoreQuotaWarning"
+	eventQuotaExceeded = "DatastoreQuotaExceeded"
+	eventQuotaRestored = "DatastoreQuotaRestored"
+	eventQuotaReadOnly = "DatastoreReadOnly"
+	eventQuotaFailed   = "DatastoreQuotaFailed"
+)
+
+// terminateSessions ends the sessions of kine after a change of the database defaults
+const terminateSessions = "\n" + `psql "$ADMIN_ENDPOINT" -v ON_ERROR_STOP=1 -c "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = '$DATABASE' AND pid <> pg_backend_pid()"` + "\n"
+
+// reconcileQuota reads the usage of the datastore from the apiserver of the tenant, records
+// events when it nears or reaches the quota and switches a postgres database to read-only
+// while the quota is exceeded. It returns when the usage is due again.
+func (c *ControlPlane) reconcileQuota() (time.Duration, error) {
+	c.LogHeader("check datastore quota ...")
+	quota := c.Object.Spec.Datastore.Quota
+	previous := c.Object.Status.DatastoreUsage
+	interval := addonResyncInterval
+	if quota != nil {
+		interval = quotaInterval
+	}
+
+	usage := &claiov1beta1.DatastoreUsageStatus{}
+	if previous != nil {
+		usage = previous.DeepCopy()
+	}
+	if previous == nil || time.Since(previous.LastUpdateTime.Time) >= interval {
+		keys, bytes, err := c.readDatastoreUsage()
+		if err != nil {
+			// the usage is read again with the next run, it does not fail the control-plane
+			c.LogError(err, "failed to read the datastore usage")
+			return interval, nil
+		}
+		usage.Keys, usage.Bytes, usage.LastUpdateTime = keys, bytes, metav1.Now()
+	}
+	usage.NearLimit, usage.Exceeded = quotaLimits(quota, usage)
+	c.recordQuotaEvents(quota, previous, usage)
+	c.Object.Status.DatastoreUsage = usage
+
+	requeue, err := c.enforceQuota(usage)
+	if err != nil {
+		return 0, err
+	}
+	if requeue <= 0 || requeue > interval {
+		requeue = interval
+	}
+	return requeue, nil
+}
+
+// revisionHistoryOf is the part of the quota kine is started with
+func revisionHistoryOf(quota *claiov1beta1.DatastoreQuotaSpec) *claiov1beta1.DatastoreQuotaSpec {
+	if quota == nil || quota.MaxRevisionHistory == nil {
+		return nil
+	}
+	return &claiov1beta1.DatastoreQuotaSpec{MaxRevisionHistory: quota.MaxRevisionHistory}
+}
+
+// readDatastoreUsage sums the storage metrics of the apiserver, the size is nil if it is unknown.
+// The shared kine gateway reports the size of all its tenants.
+func (c *ControlPlane) readDatastoreUsage() (int64, *int64, error) {
+	config, err := c.TenantConfig()
+	if err != nil {
+		return 0, nil, err
+	}
+	httpClient, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error creating client for tenant %s: %s", c.Object.Spec.Name, err)
+	}
+	req, err := http.NewRequestWithContext(c.Ctx, http.MethodGet, config.Host+"/metrics", nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error getting the metrics of the apiserver: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, nil, fmt.Errorf("error getting the metrics of the apiserver: %s", resp.Status)
+	}
+	keys, bytes, err := storageUsage(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if c.activeDatastore().Driver == claiov1beta1.DatastoreDriverGateway {
+		bytes = nil
+	}
+	return keys, bytes, nil
+}
+
+// storageUsage sums the objects of all resources and the size of the storage in the metrics
+// (text format), resources the apiserver could not count (-1) are skipped
+func storageUsage(r io.Reader) (int64, *int64, error) {
+	var keys int64
+	var bytes *int64
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, _, _ := strings.Cut(line, "{")
+		name, _, _ = strings.Cut(name, " ")
+		if name != storageObjectsMetric && name != storageSizeMetric {
+			continue
+		}
+		fields := strings.Fields(line)
+		value, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+		if err != nil || value < 0 {
+			continue
+		}
+		if name == storageObjectsMetric {
+			keys += int64(value)
+		} else {
+			if bytes == nil {
+				bytes = new(int64)
+			}
+			*bytes += int64(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, nil, fmt.Errorf("error reading the metrics of the apiserver: %s", err)
+	}
+	return keys, bytes, nil
+}
+
+// quotaUsage returns the usage and the limit of the quota, false if the limit is not set or
+// the usage is unknown
+func quotaUsage(quota *claiov1beta1.DatastoreQuotaSpec, usage *claiov1beta1.DatastoreUsageStatus, limit string) (int64, int64, bool) {
+	if quota == nil {
+		return 0, 0, false
+	}
+	switch limit {
+	case claiov1beta1.DatastoreQuotaMaxKeys:
+		if quota.MaxKeys != nil {
+			return usage.Keys, *quota.MaxKeys, true
+		}
+	case claiov1beta1.DatastoreQuotaMaxBytes:
+		if quota.MaxBytes != nil && usage.Bytes != nil {
+			return *usage.Bytes, quota.MaxBytes.Value(), true
+		}
+	}
+	return 0, 0, false
+}
+
+// quotaLimits returns the limits above the warning percent and the limits which are reached
+func quotaLimits(quota *claiov1beta1.DatastoreQuotaSpec, usage *claiov1beta1.DatastoreUsageStatus) ([]string, []string) {
+	var near, exceeded []string
+	if quota == nil {
+		return nil, nil
+	}
+	percent := int64(defaultQuotaWarningPercent)
+	if quota.WarningPercent != nil {
+		percent = int64(*quota.WarningPercent)
+	}
+	for _, limit := range []string{claiov1beta1.DatastoreQuotaMaxKeys, claiov1beta1.DatastoreQuotaMaxBytes} {
+		used, max, ok := quotaUsage(quota, usage, limit)
+		switch {
+		case !ok:
+		case used >= max:
+			exceeded = append(exceeded, limit)
+		case used*100 >= max*percent:
+			near = append(near, limit)
+		}
+	}
+	return near, exceeded
+}
+
+// describeQuota is the usage of a limit in the events, e.g. "900 of 1000 keys"
+func describeQuota(quota *claiov1beta1.DatastoreQuotaSpec, usage *claiov1beta1.DatastoreUsageStatus, limit string) string {
+	used, max, _ := quotaUsage(quota, usage, limit)
+	if limit == claiov1beta1.DatastoreQuotaMaxBytes {
+		return fmt.Sprintf("%s of %s in the datastore",
+			resource.NewQuantity(used, resource.BinarySI).String(), resource.NewQuantity(max, resource.BinarySI).String())
+	}
+	return fmt.Sprintf("%d of %d keys", used, max)
+}
+
+// recordQuotaEvents records the limits which were neared, reached or left since the last usage
+func (c *ControlPlane) recordQuotaEvents(quota *claiov1beta1.DatastoreQuotaSpec, previous, usage *claiov1beta1.DatastoreUsageStatus) {
+	if previous == nil {
+		previous = &claiov1beta1.DatastoreUsageStatus{}
+	}
+	for _, limit := range usage.Exceeded {
+		if !slices.Contains(previous.Exceeded, limit) {
+			c.event(corev1.EventTypeWarning, eventQuotaExceeded, "quota %s reached: %s", limit, describeQuota(quota, usage, limit))
+		}
+	}
+	for _, limit := range usage.NearLimit {
+		if !slices.Contains(previous.NearLimit, limit) && !slices.Contains(previous.Exceeded, limit) {
+			c.event(corev1.EventTypeWarning, eventQuotaWarning, "quota %s nearly reached: %s", limit, describeQuota(quota, usage, limit))
+		}
+	}
+	for _, limit := range previous.Exceeded {
+		if !slices.Contains(usage.Exceeded, limit) {
+			c.event(corev1.EventTypeNormal, eventQuotaRestored, "quota %s is no longer reached", limit)
+		}
+	}
+}
+
+// enforceQuota switches the postgres database of the tenant to read-only while the quota is
+// exceeded and back once it is not (a raised quota), other backends enforce the quota by
+// themselves or not at all. It returns when the job of the switch is checked again.
+func (c *ControlPlane) enforceQuota(usage *claiov1beta1.DatastoreUsageStatus) (time.Duration, error) {
+	if c.Object.Spec.Datastore.Driver != claiov1beta1.DatastoreDriverPostgres {
+		// the tenant moved off the database
+		usage.ReadOnly = false
+	}
+	readOnly := len(usage.Exceeded) > 0 && c.Object.Spec.Datastore.Driver == claiov1beta1.DatastoreDriverPostgres &&
+		c.migrationSource() == nil
+	action := jobActionReadWrite
+	if readOnly {
+		action = jobActionReadOnly
+	}
+	job, err := c.GetJob(quotaJobName)
+	if err != nil {
+		return 0, fmt.Errorf("error getting job %s: %s", quotaJobName, err)
+	}
+	if readOnly == usage.ReadOnly {
+		if job != nil {
+			return 0, c.DeleteJob(quotaJobName)
+		}
+		return 0, nil
+	}
+	if job == nil {
+		created, err := c.createDatastoreJob(quotaJobName, action)
+		if err != nil {
+			return 0, err
+		}
+		if !created {
+			usage.ReadOnly = false
+			return 0, nil
+		}
+		return quotaJobInterval, nil
+	}
+	// the job of the other switch is outdated
+	if len(job.Spec.Template.Spec.Containers) == 0 || job.Spec.Template.Spec.Containers[0].Name != action {
+		return quotaJobInterval, c.DeleteJob(quotaJobName)
+	}
+	finished, failed := kubernetes.JobFinished(job)
+	if !finished {
+		return quotaJobInterval, nil
+	}
+	if err := c.DeleteJob(quotaJobName); err != nil {
+		return 0, err
+	}
+	if failed != "" {
+		c.event(corev1.EventTypeWarning, eventQuotaFailed, "job %s failed: %s", quotaJobName, failed)
+		return quotaInterval, nil
+	}
+	usage.ReadOnly = readOnly
+	if readOnly {
+		c.event(corev1.EventTypeWarning, eventQuotaReadOnly, "the database of the tenant is read-only until the quota is raised")
+	} else {
+		c.event(corev1.EventTypeNormal, eventQuotaReadOnly, "the database of the tenant accepts writes again")
+	}
+	return 0, nil
+}
+
+// event records an event on the control-plane if the reconciler has a recorder
+func (c *ControlPlane) event(eventType, reason, messageFmt string, args ...any) {
+	if c.Recorder != nil {
+		c.Recorder.Eventf(c.Object, eventType, reason, messageFmt, args...)
+	}
+}
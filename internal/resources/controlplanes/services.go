@@ -17,8 +17,8 @@ limitations under the License.
 package controlplanes
 
 import (
+	claiov1beta1 "claio/api/v1beta1"
 	"fmt"
-	"reflect"
 
 	v1 "k8s.io/api/core/v1"
 )
@@ -69,20 +69,27 @@ func (c *ControlPlane) ReconcileService(apiDirty bool, mode string) error {
 			c.LogError(err, "failed to create service")
 			return err
 		}
+		c.setConditionTrue(claiov1beta1.ConditionServiceReady, reasonCreated, "service created")
 		return nil
 	}
 
-	if apiDirty || !reflect.DeepEqual(c.Object.Spec, c.Object.Status.TargetSpec) {
+	if apiDirty || c.structuralChanges() {
 		c.LogInfo("structural changes detected - need to recreate service")
 		if err := c.DeleteClaioService(); err != nil {
 			c.LogError(err, "failed to delete service")
 			return err
 		}
+		c.setConditionFalse(claiov1beta1.ConditionServiceReady, reasonRestarting, "service deleted to apply structural changes")
 
 		// the service will be startet with the next reconcilation run
 		return nil
 	}
 
+	if mode == c.STATUS_WANTDOWN {
+		c.setConditionFalse(claiov1beta1.ConditionServiceReady, reasonDeleting, "control-plane is being deleted")
+		return nil
+	}
+	c.setConditionTrue(claiov1beta1.ConditionServiceReady, reasonAvailable, "service exists")
 	return nil
 }
 
@@ -98,8 +105,12 @@ spec:
   selector:
     app: claio
   ports:
-  - port: {{ .Port }}
-    targetPort: {{ .Port }}
+  - port: {{ .Endpoint.Port }}
+    targetPort: {{ .Endpoint.Port }}
     protocol: TCP
     name: https
+  - port: 8132
+    targetPort: 8132
+    protocol: TCP
+    name: konnectivity
 `
@@ -0,0 +1,117 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// condition reasons
+const (
+	reasonIssued      = "Issued"
+	reasonCreated     = "Created"
+	reasonAvailable   = "Available"
+	reasonProgressing = "Progressing"
+	reasonRestarting  = "Restarting"
+	reasonFailed      = "Failed"
+	reasonDeleting    = "Deleting"
+	reasonRestoring   = "Restoring"
+	reasonMigrating   = "Migrating"
+	reasonNotReady    = "ComponentsNotReady"
+)
+
+var componentConditions = []string{
+	claiov1beta1.ConditionCertificatesReady,
+	claiov1beta1.ConditionKubeconfigsReady,
+	claiov1beta1.ConditionDatastoreReady,
+	claiov1beta1.ConditionDeploymentAvailable,
+	claiov1beta1.ConditionServiceReady,
+	claiov1beta1.ConditionJoinConfigurationReady,
+	claiov1beta1.ConditionAddonsReady,
+}
+
+// --- conditions -------------------------------------------------------------
+
+func (c *ControlPlane) setCondition(conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&c.Object.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: c.Object.Generation,
+	})
+}
+
+func (c *ControlPlane) setConditionTrue(conditionType, reason, message string) {
+	c.setCondition(conditionType, metav1.ConditionTrue, reason, message)
+}
+
+func (c *ControlPlane) setConditionFalse(conditionType, reason, message string) {
+	c.setCondition(conditionType, metav1.ConditionFalse, reason, message)
+}
+
+// setFailed marks a condition as failed, remembers the error and returns it unchanged
+func (c *ControlPlane) setFailed(conditionType string, err error) error {
+	c.setConditionFalse(conditionType, reasonFailed, err.Error())
+	c.Object.Status.LastError = err.Error()
+	return err
+}
+
+func (c *ControlPlane) IsReady() bool {
+	return meta.IsStatusConditionTrue(c.Object.Status.Conditions, claiov1beta1.ConditionReady)
+}
+
+// --- phase ------------------------------------------------------------------
+
+// updateReadiness derives the Ready condition and the phase from the component conditions
+func (c *ControlPlane) updateReadiness(mode string) {
+	wasReady := c.Object.Status.Phase == claiov1beta1.ControlPlanePhaseReady ||
+		c.Object.Status.Phase == claiov1beta1.ControlPlanePhaseDegraded
+
+	if mode != c.STATUS_UP {
+		c.setConditionFalse(claiov1beta1.ConditionReady, reasonDeleting, "control-plane is being deleted")
+		c.Object.Status.Phase = claiov1beta1.ControlPlanePhaseDeleting
+		return
+	}
+
+	for _, conditionType := range componentConditions {
+		if !meta.IsStatusConditionTrue(c.Object.Status.Conditions, conditionType) {
+			c.setConditionFalse(claiov1beta1.ConditionReady, reasonNotReady, conditionType+" is not true")
+			if wasReady {
+				c.Object.Status.Phase = claiov1beta1.ControlPlanePhaseDegraded
+			} else {
+				c.Object.Status.Phase = claiov1beta1.ControlPlanePhaseProvisioning
+			}
+			return
+		}
+	}
+	c.setConditionTrue(claiov1beta1.ConditionReady, reasonAvailable, "control-plane is ready")
+	c.Object.Status.Phase = claiov1beta1.ControlPlanePhaseReady
+}
+
+// updateStatus writes the status sub-resource (conditions, phase and last error)
+func (c *ControlPlane) updateStatus(mode string) error {
+	c.updateReadiness(mode)
+	if err := c.Client.Status().Update(c.Ctx, c.Object); err != nil {
+		c.LogError(err, "failed to update status")
+		return err
+	}
+	return nil
+}
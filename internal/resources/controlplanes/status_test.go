@@ -0,0 +1,115 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Status", func() {
+	var c *ControlPlane
+
+	BeforeEach(func() {
+		c = newTestControlPlane()
+	})
+
+	setComponents := func(status metav1.ConditionStatus) {
+		for _, conditionType := range componentConditions {
+			c.setCondition(conditionType, status, reasonAvailable, "")
+		}
+	}
+
+	DescribeTable("derives the phase from the component conditions",
+		func(phase claiov1beta1.ControlPlanePhase, mode func() string, ready metav1.ConditionStatus, prepare func()) {
+			prepare()
+			c.updateReadiness(mode())
+			Expect(c.Object.Status.Phase).To(Equal(phase))
+			Expect(meta.FindStatusCondition(c.Object.Status.Conditions, claiov1beta1.ConditionReady).Status).To(Equal(ready))
+		},
+		Entry("a new control-plane is provisioned", claiov1beta1.ControlPlanePhaseProvisioning,
+			func() string { return c.STATUS_UP }, metav1.ConditionFalse, func() {}),
+		Entry("a control-plane with a missing component is provisioned", claiov1beta1.ControlPlanePhaseProvisioning,
+			func() string { return c.STATUS_UP }, metav1.ConditionFalse, func() {
+				setComponents(metav1.ConditionTrue)
+				c.setConditionFalse(claiov1beta1.ConditionServiceReady, reasonProgressing, "")
+			}),
+		Entry("a control-plane with all components is ready", claiov1beta1.ControlPlanePhaseReady,
+			func() string { return c.STATUS_UP }, metav1.ConditionTrue, func() {
+				setComponents(metav1.ConditionTrue)
+			}),
+		Entry("a ready control-plane with a failed component is degraded", claiov1beta1.ControlPlanePhaseDegraded,
+			func() string { return c.STATUS_UP }, metav1.ConditionFalse, func() {
+				c.Object.Status.Phase = claiov1beta1.ControlPlanePhaseReady
+				setComponents(metav1.ConditionTrue)
+				c.setConditionFalse(claiov1beta1.ConditionDeploymentAvailable, reasonFailed, "")
+			}),
+		Entry("a degraded control-plane stays degraded", claiov1beta1.ControlPlanePhaseDegraded,
+			func() string { return c.STATUS_UP }, metav1.ConditionFalse, func() {
+				c.Object.Status.Phase = claiov1beta1.ControlPlanePhaseDegraded
+			}),
+		Entry("a degraded control-plane recovers", claiov1beta1.ControlPlanePhaseReady,
+			func() string { return c.STATUS_UP }, metav1.ConditionTrue, func() {
+				c.Object.Status.Phase = claiov1beta1.ControlPlanePhaseDegraded
+				setComponents(metav1.ConditionTrue)
+			}),
+		Entry("a deleted control-plane is deleting", claiov1beta1.ControlPlanePhaseDeleting,
+			func() string { return c.STATUS_WANTDOWN }, metav1.ConditionFalse, func() {
+				c.Object.Status.Phase = claiov1beta1.ControlPlanePhaseReady
+				setComponents(metav1.ConditionTrue)
+			}),
+	)
+
+	It("Should go through provisioning, ready and deleting", func() {
+		Expect(c.updateStatus(c.STATUS_UP)).To(Succeed())
+		Expect(c.Object.Status.Phase).To(Equal(claiov1beta1.ControlPlanePhaseProvisioning))
+
+		setComponents(metav1.ConditionTrue)
+		Expect(c.updateStatus(c.STATUS_UP)).To(Succeed())
+		Expect(c.Object.Status.Phase).To(Equal(claiov1beta1.ControlPlanePhaseReady))
+
+		Expect(c.updateStatus(c.STATUS_WANTDOWN)).To(Succeed())
+		stored := &claiov1beta1.ControlPlane{}
+		Expect(c.Client.Get(c.Ctx, client.ObjectKeyFromObject(c.Object), stored)).To(Succeed())
+		Expect(stored.Status.Phase).To(Equal(claiov1beta1.ControlPlanePhaseDeleting))
+		Expect(meta.IsStatusConditionFalse(stored.Status.Conditions, claiov1beta1.ConditionReady)).To(BeTrue())
+	})
+
+	It("Should record the observed generation", func() {
+		c.Object.Generation = 3
+		c.Object.Status.LastError = "failed before"
+		c.setConditionTrue(claiov1beta1.ConditionCertificatesReady, reasonIssued, "")
+		c.observeSpec()
+		Expect(c.Object.Status.ObservedGeneration).To(Equal(int64(3)))
+		Expect(c.Object.Status.LastError).To(BeEmpty())
+		Expect(c.Object.Status.TargetSpec).To(Equal(c.Object.Spec))
+		condition := meta.FindStatusCondition(c.Object.Status.Conditions, claiov1beta1.ConditionCertificatesReady)
+		Expect(condition.ObservedGeneration).To(Equal(int64(3)))
+	})
+
+	It("Should remember the error of a failed component", func() {
+		c.setFailed(claiov1beta1.ConditionDeploymentAvailable, errTest)
+		Expect(c.Object.Status.LastError).To(Equal(errTest.Error()))
+		condition := meta.FindStatusCondition(c.Object.Status.Conditions, claiov1beta1.ConditionDeploymentAvailable)
+		Expect(condition.Reason).To(Equal(reasonFailed))
+	})
+})
@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	"fmt"
+	"time"
+
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const tenantClientTimeout = 10 * time.Second
+
+// TenantConfig returns a rest config for the apiserver of the tenant. It uses the admin
+// kubeconfig but connects through the cluster internal service of the control-plane.
+func (c *ControlPlane) TenantConfig() (*rest.Config, error) {
+	secretData, err := c.GetSecret("kubeconfig-admin")
+	if err != nil {
+		return nil, fmt.Errorf("error getting kubeconfig-admin in ns %s: %s", c.Namespace(), err)
+	}
+	if secretData == nil {
+		return nil, fmt.Errorf("kubeconfig-admin in ns %s does not exist (yet)", c.Namespace())
+	}
+	config, err := clientcmd.RESTConfigFromKubeConfig(secretData["super-admin.conf"])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing kubeconfig-admin in ns %s: %s", c.Namespace(), err)
+	}
+	config.Host = c.InternalEndpoint()
+	// the apiserver certificate is issued for the advertised host
+	config.TLSClientConfig.ServerName = c.Object.Spec.Endpoint.Host
+	config.Timeout = tenantClientTimeout
+	return config, nil
+}
+
+// TenantClient returns a client for the apiserver of the tenant
+func (c *ControlPlane) TenantClient() (client.Client, error) {
+	if c.tenantClient != nil {
+		return c.tenantClient, nil
+	}
+	config, err := c.TenantConfig()
+	if err != nil {
+		return nil, err
+	}
+	tenantClient, err := client.New(config, client.Options{Scheme: clientgoscheme.Scheme})
+	if err != nil {
+		return nil, fmt.Errorf("error creating client for tenant %s: %s", c.Object.Spec.Name, err)
+	}
+	return tenantClient, nil
+}
+
+// InternalEndpoint is the url of the apiserver of the tenant inside the management cluster
+func (c *ControlPlane) InternalEndpoint() string {
+	return fmt.Sprintf("https://claio-apiserver.%s.svc:%d", c.Namespace(), c.Object.Spec.Endpoint.Port)
+}
+
+// ClusterDNS is the address of the DNS service of the tenant (10th address of the service network)
+func (c *ControlPlane) ClusterDNS() (string, error) {
+	ip, err := serviceIP(c.Object.Spec.Network.ServiceCIDR, 10)
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
+}
+
+// CACertificate returns the PEM encoded CAs the tenant trusts, the old and the new CA while the
+// CA is rotated
+func (c *ControlPlane) CACertificate() ([]byte, error) {
+	secretData, err := c.GetSecret(caBundleSecretName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s in ns %s: %s", caBundleSecretName, c.Namespace(), err)
+	}
+	if bundle := secretData[caBundleKey]; len(bundle) > 0 {
+		return bundle, nil
+	}
+	ca, err := c.getCertificateSecret(caSecretName)
+	if err != nil {
+		return nil, err
+	}
+	if ca == nil {
+		return nil, fmt.Errorf("ca of tenant %s does not exist (yet)", c.Object.Spec.Name)
+	}
+	return []byte(ca.Cert), nil
+}
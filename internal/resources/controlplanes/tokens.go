@@ -0,0 +1,140 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplanes
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// bootstrapTokenTTL is the lifetime of a bootstrap token
+	bootstrapTokenTTL = 24 * time.Hour
+	// bootstrapTokenRenewBefore is the time before the expiration a new token is created
+	bootstrapTokenRenewBefore = 8 * time.Hour
+	bootstrapTokenGroups      = "system:bootstrappers:kubeadm:default-node-token"
+	bootstrapTokenCharset     = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// BootstrapToken is a token of the tenant nodes join with
+type BootstrapToken struct {
+	ID         string
+	Secret     string
+	Expiration time.Time
+}
+
+// String returns the token in the form token-id.token-secret
+func (t *BootstrapToken) String() string {
+	return t.ID + "." + t.Secret
+}
+
+// needsRotation is true if the token expires within bootstrapTokenRenewBefore
+func (t *BootstrapToken) needsRotation() bool {
+	return time.Until(t.Expiration) < bootstrapTokenRenewBefore
+}
+
+// rotateIn is the time until the token needs to be rotated
+func (t *BootstrapToken) rotateIn() time.Duration {
+	return time.Until(t.Expiration) - bootstrapTokenRenewBefore
+}
+
+func newBootstrapToken() (*BootstrapToken, error) {
+	id, err := randomString(6)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	return &BootstrapToken{
+		ID:         id,
+		Secret:     secret,
+		Expiration: time.Now().Add(bootstrapTokenTTL).UTC().Truncate(time.Second),
+	}, nil
+}
+
+// parseBootstrapToken parses a token-id.token-secret token and its RFC3339 expiration
+func parseBootstrapToken(token, expiration string) (*BootstrapToken, error) {
+	if len(token) != 23 || token[6] != '.' {
+		return nil, fmt.Errorf("invalid bootstrap token")
+	}
+	expires, err := time.Parse(time.RFC3339, expiration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiration of bootstrap token: %s", err)
+	}
+	return &BootstrapToken{ID: token[:6], Secret: token[7:], Expiration: expires}, nil
+}
+
+// createBootstrapToken creates the secret of the token in the kube-system namespace of the
+// tenant. Expired tokens are removed by the tokencleaner of the tenant.
+func (c *ControlPlane) createBootstrapToken(tenantClient client.Client, token *BootstrapToken) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bootstrap-token-" + token.ID,
+			Namespace: metav1.NamespaceSystem,
+		},
+		Type: corev1.SecretTypeBootstrapToken,
+		StringData: map[string]string{
+			"description":                    fmt.Sprintf("claio control-plane %s/%s", c.Namespace(), c.Name()),
+			"token-id":                       token.ID,
+			"token-secret":                   token.Secret,
+			"expiration":                     token.Expiration.Format(time.RFC3339),
+			"usage-bootstrap-authentication": "true",
+			"usage-bootstrap-signing":        "true",
+			"auth-extra-groups":              bootstrapTokenGroups,
+		},
+	}
+	c.LogInfo("create bootstrap token %s", token.ID)
+	if err := tenantClient.Create(c.Ctx, secret); err != nil {
+		return fmt.Errorf("error creating bootstrap token: %s", err)
+	}
+	return nil
+}
+
+// bootstrapTokenExists checks whether the secret of the token exists in the tenant
+func (c *ControlPlane) bootstrapTokenExists(tenantClient client.Client, token *BootstrapToken) (bool, error) {
+	secret := &corev1.Secret{}
+	err := tenantClient.Get(c.Ctx, types.NamespacedName{Namespace: metav1.NamespaceSystem, Name: "bootstrap-token-" + token.ID}, secret)
+	if err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			return false, nil
+		}
+		return false, fmt.Errorf("error getting bootstrap token %s: %s", token.ID, err)
+	}
+	return true, nil
+}
+
+func randomString(length int) (string, error) {
+	result := make([]byte, length)
+	max := big.NewInt(int64(len(bootstrapTokenCharset)))
+	for i := range result {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("error generating random string: %s", err)
+		}
+		result[i] = bootstrapTokenCharset[n.Int64()]
+	}
+	return string(result), nil
+}
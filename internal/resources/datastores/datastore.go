@@ -0,0 +1,144 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datastores
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/resources"
+	"claio/internal/resources/controlplanes"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// checkInterval is how often the endpoint of a DataStore is checked
+	checkInterval = 1 * time.Minute
+	// dialTimeout limits a single connection attempt to the endpoint
+	dialTimeout = 5 * time.Second
+)
+
+// default ports of the drivers if the endpoint has none
+var defaultPorts = map[string]string{
+	claiov1beta1.DatastoreDriverNATS:     "4222",
+	claiov1beta1.DatastoreDriverPostgres: "5432",
+	claiov1beta1.DatastoreDriverMySQL:    "3306",
+}
+
+// condition reasons
+const (
+	reasonConnected   = "Connected"
+	reasonUnreachable = "Unreachable"
+)
+
+type DataStore struct {
+	resources.Resource[*claiov1beta1.DataStore]
+}
+
+func NewDataStore(ctx context.Context, req ctrl.Request, rClient client.Client, rScheme *runtime.Scheme) (*DataStore, error) {
+	res := &claiov1beta1.DataStore{}
+	if err := rClient.Get(ctx, types.NamespacedName{Name: req.Name}, res); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return &DataStore{
+		Resource: *resources.NewResource("DataStore", ctx, req, rClient, rScheme, res),
+	}, nil
+}
+
+// Reconcile lists the tenants placed on the datastore and checks whether its endpoint is
+// reachable. The tenants provision themselves, see ControlPlane.reconcileDataStoreTenant.
+func (d *DataStore) Reconcile() (ctrl.Result, error) {
+	d.LogHeader("check datastore %s ...", d.Object.Name)
+
+	tenants, err := d.tenants()
+	if err != nil {
+		d.LogError(err, "failed to list tenants")
+		return ctrl.Result{}, err
+	}
+	d.Object.Status.Tenants = tenants
+	d.Object.Status.TenantCount = int32(len(tenants))
+
+	if err := d.dial(); err != nil {
+		d.LogInfo("endpoint is not reachable: %s", err)
+		d.setCondition(metav1.ConditionFalse, reasonUnreachable, err.Error())
+	} else {
+		d.setCondition(metav1.ConditionTrue, reasonConnected, "endpoint is reachable")
+	}
+
+	d.Object.Status.ObservedGeneration = d.Object.Generation
+	if err := d.Client.Status().Update(d.Ctx, d.Object); err != nil {
+		d.LogError(err, "failed to update status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: checkInterval}, nil
+}
+
+// tenants are the names of the control-planes placed on the datastore
+func (d *DataStore) tenants() ([]string, error) {
+	controlPlanes := &claiov1beta1.ControlPlaneList{}
+	if err := d.Client.List(d.Ctx, controlPlanes); err != nil {
+		return nil, fmt.Errorf("error listing control-planes: %s", err)
+	}
+	tenants := []string{}
+	for i := range controlPlanes.Items {
+		if controlplanes.IsPlacedOn(&controlPlanes.Items[i], d.Object.Name) {
+			tenants = append(tenants, controlPlanes.Items[i].Spec.Name)
+		}
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// dial opens (and closes) a tcp connection to the endpoint
+func (d *DataStore) dial() error {
+	endpoint, err := url.Parse(d.Object.Spec.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint: %s", err)
+	}
+	if endpoint.Hostname() == "" {
+		return fmt.Errorf("endpoint %s has no host", d.Object.Spec.Endpoint)
+	}
+	port := endpoint.Port()
+	if port == "" {
+		port = defaultPorts[d.Object.Spec.Driver]
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(endpoint.Hostname(), port), dialTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (d *DataStore) setCondition(status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&d.Object.Status.Conditions, metav1.Condition{
+		Type:               claiov1beta1.DataStoreConditionReachable,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: d.Object.Generation,
+	})
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machines
+
+import (
+	claiov1alpha1 "claio/api/v1alpha1"
+	"claio/internal/providers"
+	"claio/internal/resources"
+	"claio/internal/resources/controlplanes"
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// pendingInterval is how often a Machine checks whether its control-plane became ready
+	pendingInterval = 15 * time.Second
+	// joiningInterval is how often a Machine looks for its node while the host joins
+	joiningInterval = 10 * time.Second
+	// runningInterval is how often the node of a running Machine is refreshed
+	runningInterval = 1 * time.Minute
+)
+
+// TenantClientFunc returns a client for the tenant of a control-plane
+type TenantClientFunc func(controlPlane *controlplanes.ControlPlane) (client.Client, error)
+
+type Machine struct {
+	resources.Resource[*claiov1alpha1.Machine]
+	Providers *providers.Registry
+	// TenantClient overrides how the tenant is reached (tests), defaults to ControlPlane.TenantClient
+	TenantClient TenantClientFunc
+}
+
+func NewMachine(ctx context.Context, req ctrl.Request, rClient client.Client, rScheme *runtime.Scheme, registry *providers.Registry) (*Machine, error) {
+	res := &claiov1alpha1.Machine{}
+	if err := rClient.Get(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace}, res); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return &Machine{
+		Resource:  *resources.NewResource("Machine", ctx, req, rClient, rScheme, res),
+		Providers: registry,
+	}, nil
+}
+
+// NodeName is the name of the node in the tenant cluster
+func (m *Machine) NodeName() string {
+	if m.Object.Spec.NodeName != "" {
+		return m.Object.Spec.NodeName
+	}
+	return m.Object.Name
+}
+
+// Version is the kubelet version of the node, it defaults to the version of the control-plane
+func (m *Machine) Version(controlPlane *controlplanes.ControlPlane) string {
+	if m.Object.Spec.Version != "" {
+		return m.Object.Spec.Version
+	}
+	return controlPlane.Object.Spec.Version
+}
+
+func (m *Machine) Check() (string, error) {
+	m.LogHeader("check machine (init) ...")
+	if m.Object.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !m.HasFinalizer() {
+			m.LogInfo("add finalizer")
+			if err := m.AddFinalizer(); err != nil {
+				return m.STATUS_UP, fmt.Errorf("adding finalizer failed")
+			}
+		}
+		return m.STATUS_UP, nil
+	} else {
+		if m.HasFinalizer() {
+			return m.STATUS_WANTDOWN, nil
+		}
+		return m.STATUS_GOINGDOWN, nil
+	}
+}
+
+func (m *Machine) Reconcile() (ctrl.Result, error) {
+	status, err := m.Check()
+	if err != nil {
+		m.LogError(err, "check failed")
+		return ctrl.Result{}, err
+	}
+	m.LogInfo("status: %s", status)
+	if status == m.STATUS_GOINGDOWN {
+		return ctrl.Result{}, nil
+	}
+	if status == m.STATUS_WANTDOWN {
+		return m.reconcileDelete()
+	}
+	if m.Object.Status.Phase == "" {
+		m.Object.Status.Phase = claiov1alpha1.MachinePhasePending
+	}
+
+	controlPlane, err := m.getControlPlane()
+	if err != nil {
+		m.LogError(err, "failed to get control-plane")
+		return ctrl.Result{}, m.abort(m.setFailed(err))
+	}
+
+	m.Object.Status.ObservedGeneration = m.Object.Generation
+	if controlPlane == nil || !controlPlane.IsReady() {
+		m.LogInfo("waiting for control-plane %s", m.Object.Spec.ControlPlaneRef.Name)
+		m.Object.Status.Phase = claiov1alpha1.MachinePhasePending
+		m.setConditionFalse(claiov1alpha1.MachineConditionNodeReady, reasonWaiting, "control-plane is not ready")
+		return ctrl.Result{RequeueAfter: pendingInterval}, m.updateStatus()
+	}
+	// the node would only trust the CA which is about to be replaced
+	if m.Object.Status.Phase == claiov1alpha1.MachinePhasePending && controlPlane.CARotating() {
+		m.LogInfo("waiting for the ca rotation of control-plane %s", m.Object.Spec.ControlPlaneRef.Name)
+		m.setConditionFalse(claiov1alpha1.MachineConditionNodeReady, reasonWaiting, "the CA of the control-plane is rotated")
+		return ctrl.Result{RequeueAfter: pendingInterval}, m.updateStatus()
+	}
+
+	// provision the host
+	provisioned, err := m.reconcileProvisioning(controlPlane)
+	if err != nil {
+		m.LogError(err, "failed to provision host")
+		return ctrl.Result{}, m.abort(m.setFailed(err))
+	}
+	if !provisioned {
+		m.Object.Status.Phase = claiov1alpha1.MachinePhaseProvisioning
+		return ctrl.Result{RequeueAfter: joiningInterval}, m.updateStatus()
+	}
+
+	// wait for the node to join and keep it in shape
+	joined, err := m.reconcileNode(controlPlane)
+	if err != nil {
+		m.LogError(err, "failed to reconcile node")
+		return ctrl.Result{}, m.abort(m.setFailed(err))
+	}
+	if !joined {
+		m.Object.Status.Phase = claiov1alpha1.MachinePhaseJoining
+		return ctrl.Result{RequeueAfter: joiningInterval}, m.updateStatus()
+	}
+
+	m.Object.Status.Phase = claiov1alpha1.MachinePhaseRunning
+	m.Object.Status.LastError = ""
+	return ctrl.Result{RequeueAfter: runningInterval}, m.updateStatus()
+}
+
+func (m *Machine) reconcileDelete() (ctrl.Result, error) {
+	m.LogHeader("check machine (finalize) ...")
+	m.Object.Status.Phase = claiov1alpha1.MachinePhaseDeleting
+	if err := m.updateStatus(); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// a vanished control-plane takes its nodes with it, the host is released anyway
+	var controlPlane *controlplanes.ControlPlane
+	if m.Object.Spec.ControlPlaneRef.Name != "" {
+		var err error
+		if controlPlane, err = m.getControlPlane(); err != nil {
+			m.LogError(err, "failed to get control-plane")
+			return ctrl.Result{}, m.abort(m.setFailed(err))
+		}
+	}
+	if controlPlane != nil {
+		if err := m.deleteNode(controlPlane); err != nil {
+			m.LogError(err, "failed to delete node")
+			return ctrl.Result{}, m.abort(m.setFailed(err))
+		}
+	}
+
+	released, err := m.releaseHost()
+	if err != nil {
+		m.LogError(err, "failed to release host")
+		return ctrl.Result{}, m.abort(m.setFailed(err))
+	}
+	if !released {
+		return ctrl.Result{RequeueAfter: joiningInterval}, nil
+	}
+
+	m.LogInfo("remove finalizer")
+	if err := m.RemoveFinalizer(); err != nil {
+		m.LogError(err, "failed to remove finalizer")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+func (m *Machine) tenantClient(controlPlane *controlplanes.ControlPlane) (client.Client, error) {
+	if m.TenantClient != nil {
+		return m.TenantClient(controlPlane)
+	}
+	return controlPlane.TenantClient()
+}
+
+func (m *Machine) getControlPlane() (*controlplanes.ControlPlane, error) {
+	if m.Object.Spec.ControlPlaneRef.Name == "" {
+		return nil, fmt.Errorf("machine %s has no controlPlaneRef", m.Object.Name)
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{
+		Name:      m.Object.Spec.ControlPlaneRef.Name,
+		Namespace: m.Namespace(),
+	}}
+	return controlplanes.NewControlPlane(m.Ctx, req, m.Client, m.Scheme)
+}
+
+// abort records the failure in the status (best effort) and returns the original error
+func (m *Machine) abort(err error) error {
+	_ = m.updateStatus()
+	return err
+}
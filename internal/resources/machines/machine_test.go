@@ -0,0 +1,105 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machines
+
+import (
+	claiov1alpha1 "claio/api/v1alpha1"
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/providers"
+	providerfake "claio/internal/providers/fake"
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("machine", func() {
+	var (
+		provider *providerfake.Provider
+		registry *providers.Registry
+		obj      *claiov1alpha1.Machine
+	)
+
+	BeforeEach(func() {
+		provider = providerfake.New()
+		registry = providers.NewRegistry()
+		Expect(registry.Register(provider)).To(Succeed())
+		obj = &claiov1alpha1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Namespace: "tenant-sample", Generation: 1},
+			Spec: claiov1alpha1.MachineSpec{
+				ControlPlaneRef: claiov1alpha1.ControlPlaneReference{Name: "sample"},
+				ProviderRef:     &claiov1alpha1.MachineProviderReference{Name: providerfake.Name},
+			},
+		}
+	})
+
+	// deleting returns the machine marked for deletion, its host exists
+	deleting := func() *Machine {
+		now := metav1.Now()
+		obj.DeletionTimestamp = &now
+		obj.Finalizers = []string{"claio.github.com/finalizer"}
+		Expect(provider.Create(context.Background(), obj, nil)).To(Succeed())
+		return newTestMachine(obj, registry)
+	}
+
+	expectReleased := func(m *Machine) {
+		_, err := m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.Host(obj)).To(BeNil())
+		err = m.Client.Get(context.Background(), client.ObjectKeyFromObject(obj), &claiov1alpha1.Machine{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue(), "machine is not released: %v", err)
+	}
+
+	It("fails without a control-plane reference", func() {
+		obj.Spec.ControlPlaneRef.Name = ""
+		_, err := newTestMachine(obj, registry).Reconcile()
+		Expect(err).To(MatchError(ContainSubstring("has no controlPlaneRef")))
+	})
+
+	It("releases the host of a machine without a control-plane reference", func() {
+		obj.Spec.ControlPlaneRef.Name = ""
+		expectReleased(deleting())
+	})
+
+	It("releases the host of a machine whose control-plane is gone", func() {
+		expectReleased(deleting())
+	})
+
+	It("waits with new hosts while the CA of the control-plane is rotated", func() {
+		controlPlane := &claiov1beta1.ControlPlane{
+			ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: "tenant-sample"},
+			Status: claiov1beta1.ControlPlaneStatus{
+				CARotation: &claiov1beta1.CARotationStatus{Stage: claiov1beta1.CARotationPublishing},
+			},
+		}
+		meta.SetStatusCondition(&controlPlane.Status.Conditions, metav1.Condition{
+			Type: claiov1beta1.ConditionReady, Status: metav1.ConditionTrue, Reason: "Available",
+		})
+		m := newTestMachine(obj, registry, controlPlane)
+		result, err := m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(pendingInterval))
+		Expect(m.Object.Status.Phase).To(Equal(claiov1alpha1.MachinePhasePending))
+		Expect(meta.FindStatusCondition(m.Object.Status.Conditions, claiov1alpha1.MachineConditionNodeReady).Message).
+			To(ContainSubstring("CA of the control-plane is rotated"))
+		Expect(provider.Host(obj)).To(BeNil())
+	})
+})
@@ -0,0 +1,71 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machines
+
+import (
+	claiov1alpha1 "claio/api/v1alpha1"
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/providers"
+	"claio/internal/resources"
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// These tests use Ginkgo (BDD-style Go testing framework). Refer to
+// http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
+// The machine works on a fake client of the management cluster.
+
+func TestMachines(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Machines Suite")
+}
+
+// testScheme knows the objects of the management cluster
+func testScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(claiov1alpha1.AddToScheme(scheme)).To(Succeed())
+	Expect(claiov1beta1.AddToScheme(scheme)).To(Succeed())
+	return scheme
+}
+
+// newTestMachine returns the machine of a fake client with the objects
+func newTestMachine(obj *claiov1alpha1.Machine, registry *providers.Registry, objects ...client.Object) *Machine {
+	scheme := testScheme()
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(append(objects, obj)...).
+		WithStatusSubresource(&claiov1alpha1.Machine{}).
+		Build()
+	current := &claiov1alpha1.Machine{}
+	Expect(fakeClient.Get(context.Background(), client.ObjectKeyFromObject(obj), current)).To(Succeed())
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: obj.Name, Namespace: obj.Namespace}}
+	return &Machine{
+		Resource:  *resources.NewResource("Machine", context.Background(), req, fakeClient, scheme, current),
+		Providers: registry,
+	}
+}
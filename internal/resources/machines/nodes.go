@@ -0,0 +1,108 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machines
+
+import (
+	claiov1alpha1 "claio/api/v1alpha1"
+	"claio/internal/resources/controlplanes"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// reconcileNode looks up the node of the machine in the tenant, applies labels and taints
+// and records it in the status. It returns false as long as the node has not joined.
+func (m *Machine) reconcileNode(controlPlane *controlplanes.ControlPlane) (bool, error) {
+	m.LogHeader("check node ...")
+	tenantClient, err := m.tenantClient(controlPlane)
+	if err != nil {
+		return false, err
+	}
+
+	node := &corev1.Node{}
+	if err := tenantClient.Get(m.Ctx, types.NamespacedName{Name: m.NodeName()}, node); err != nil {
+		if apierrors.IsNotFound(err) {
+			m.LogInfo("node %s has not joined yet", m.NodeName())
+			m.setConditionFalse(claiov1alpha1.MachineConditionNodeReady, reasonJoining, "node has not joined yet")
+			return false, nil
+		}
+		return false, fmt.Errorf("error getting node %s: %s", m.NodeName(), err)
+	}
+
+	if m.applyNodeConfig(node) {
+		m.LogInfo("update labels and taints of node %s", node.Name)
+		if err := tenantClient.Update(m.Ctx, node); err != nil {
+			return false, fmt.Errorf("error updating node %s: %s", node.Name, err)
+		}
+	}
+
+	m.Object.Status.NodeName = node.Name
+	nodeInfo := node.Status.NodeInfo
+	m.Object.Status.NodeInfo = &nodeInfo
+	m.setConditionFromNode(node)
+	return true, nil
+}
+
+// applyNodeConfig merges the labels and taints of the spec into the node and reports
+// whether the node has been changed. Labels and taints not managed by the machine are kept.
+func (m *Machine) applyNodeConfig(node *corev1.Node) bool {
+	changed := false
+	for key, value := range m.Object.Spec.Labels {
+		if current, ok := node.Labels[key]; !ok || current != value {
+			if node.Labels == nil {
+				node.Labels = map[string]string{}
+			}
+			node.Labels[key] = value
+			changed = true
+		}
+	}
+	for _, taint := range m.Object.Spec.Taints {
+		found := false
+		for i := range node.Spec.Taints {
+			if node.Spec.Taints[i].MatchTaint(&taint) {
+				found = true
+				if node.Spec.Taints[i].Value != taint.Value {
+					node.Spec.Taints[i].Value = taint.Value
+					changed = true
+				}
+				break
+			}
+		}
+		if !found {
+			node.Spec.Taints = append(node.Spec.Taints, taint)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// deleteNode removes the node of the machine from the tenant
+func (m *Machine) deleteNode(controlPlane *controlplanes.ControlPlane) error {
+	tenantClient, err := m.tenantClient(controlPlane)
+	if err != nil {
+		return err
+	}
+	node := &corev1.Node{}
+	node.Name = m.NodeName()
+	m.LogInfo("delete node %s", node.Name)
+	if err := tenantClient.Delete(m.Ctx, node); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting node %s: %s", node.Name, err)
+	}
+	return nil
+}
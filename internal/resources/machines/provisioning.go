@@ -0,0 +1,117 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machines
+
+import (
+	"claio/internal/providers"
+	"claio/internal/resources/controlplanes"
+	"fmt"
+)
+
+// provider returns the provider selected by the machine, nil if the host is provisioned out of band
+func (m *Machine) provider() (providers.Provider, error) {
+	if m.Object.Spec.ProviderRef == nil {
+		return nil, nil
+	}
+	return m.Providers.Get(m.Object.Spec.ProviderRef.Name)
+}
+
+// reconcileProvisioning makes sure the host of the machine exists. It returns false as long
+// as the provider is still provisioning. Hosts without provider are provisioned out of band
+// and join the tenant on their own.
+func (m *Machine) reconcileProvisioning(controlPlane *controlplanes.ControlPlane) (bool, error) {
+	provider, err := m.provider()
+	if err != nil {
+		return false, err
+	}
+	if provider == nil {
+		return true, nil
+	}
+	m.LogHeader("check host (%s) ...", provider.Name())
+
+	hostStatus, err := provider.Status(m.Ctx, m.Object)
+	if err != nil {
+		return false, fmt.Errorf("error getting host status from provider %s: %s", provider.Name(), err)
+	}
+	switch hostStatus.State {
+	case providers.HostStateReady:
+		return true, nil
+	case providers.HostStateFailed:
+		return false, fmt.Errorf("provider %s failed to provision host: %s", provider.Name(), hostStatus.Message)
+	case providers.HostStateAbsent:
+		// create below
+	default:
+		m.LogInfo("host is %s", hostStatus.State)
+		return false, nil
+	}
+
+	input, err := m.bootstrapInput(controlPlane)
+	if err != nil {
+		return false, err
+	}
+	machine := m.Object.DeepCopy()
+	machine.Spec.Version = input.Version
+	machine.Spec.NodeName = input.NodeName
+	bootstrapData, err := provider.BootstrapData(m.Ctx, machine, input)
+	if err != nil {
+		return false, fmt.Errorf("error creating bootstrap data with provider %s: %s", provider.Name(), err)
+	}
+	m.LogInfo("create host")
+	if err := provider.Create(m.Ctx, machine, bootstrapData); err != nil {
+		return false, fmt.Errorf("error creating host with provider %s: %s", provider.Name(), err)
+	}
+	return false, nil
+}
+
+// releaseHost deletes the host of the machine. It returns false as long as the provider
+// is still releasing it.
+func (m *Machine) releaseHost() (bool, error) {
+	provider, err := m.provider()
+	if err != nil {
+		return false, err
+	}
+	if provider == nil {
+		return true, nil
+	}
+	m.LogInfo("delete host (%s)", provider.Name())
+	if err := provider.Delete(m.Ctx, m.Object); err != nil {
+		return false, fmt.Errorf("error deleting host with provider %s: %s", provider.Name(), err)
+	}
+	hostStatus, err := provider.Status(m.Ctx, m.Object)
+	if err != nil {
+		return false, fmt.Errorf("error getting host status from provider %s: %s", provider.Name(), err)
+	}
+	return hostStatus.State == providers.HostStateAbsent, nil
+}
+
+// bootstrapInput collects what the host needs to join the tenant
+func (m *Machine) bootstrapInput(controlPlane *controlplanes.ControlPlane) (*providers.BootstrapInput, error) {
+	join, err := controlPlane.GetJoinConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	return &providers.BootstrapInput{
+		NodeName:         m.NodeName(),
+		Version:          m.Version(controlPlane),
+		Endpoint:         controlPlane.Endpoint(),
+		InternalEndpoint: controlPlane.InternalEndpoint(),
+		CACert:           join.CACert,
+		Token:            join.Token.String(),
+		KubeletConfig:    join.KubeletConfig,
+		Taints:           m.Object.Spec.Taints,
+	}, nil
+}
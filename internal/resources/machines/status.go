@@ -0,0 +1,77 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machines
+
+import (
+	claiov1alpha1 "claio/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// condition reasons
+const (
+	reasonWaiting = "WaitingForControlPlane"
+	reasonJoining = "Joining"
+	reasonUnknown = "NodeStatusUnknown"
+)
+
+func (m *Machine) setCondition(conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&m.Object.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: m.Object.Generation,
+	})
+}
+
+func (m *Machine) setConditionFalse(conditionType, reason, message string) {
+	m.setCondition(conditionType, metav1.ConditionFalse, reason, message)
+}
+
+// setFailed remembers the error and returns it unchanged
+func (m *Machine) setFailed(err error) error {
+	m.Object.Status.LastError = err.Error()
+	return err
+}
+
+// setConditionFromNode mirrors the Ready condition of the tenant node
+func (m *Machine) setConditionFromNode(node *corev1.Node) {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			reason := condition.Reason
+			if reason == "" {
+				reason = string(corev1.NodeReady)
+			}
+			m.setCondition(claiov1alpha1.MachineConditionNodeReady, metav1.ConditionStatus(condition.Status),
+				reason, condition.Message)
+			return
+		}
+	}
+	m.setCondition(claiov1alpha1.MachineConditionNodeReady, metav1.ConditionUnknown, reasonUnknown, "node reports no Ready condition")
+}
+
+// updateStatus writes the status sub-resource
+func (m *Machine) updateStatus() error {
+	if err := m.Client.Status().Update(m.Ctx, m.Object); err != nil {
+		m.LogError(err, "failed to update status")
+		return err
+	}
+	return nil
+}
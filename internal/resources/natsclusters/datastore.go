@@ -0,0 +1,131 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package natsclusters
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// NATSClusterAnnotation marks a DataStore registered by a NATSCluster (namespace/name), the
+// cluster-scoped DataStore cannot be owned by it
+const NATSClusterAnnotation = "claio.github.com/natscluster"
+
+func (n *NATSCluster) dataStoreName() string {
+	if n.Object.Spec.DataStoreName != "" {
+		return n.Object.Spec.DataStoreName
+	}
+	return n.Object.Name
+}
+
+func (n *NATSCluster) owner() string {
+	return n.Namespace() + "/" + n.Object.Name
+}
+
+// getDataStore returns the DataStore of the cluster or nil if it does not exist
+func (n *NATSCluster) getDataStore() (*claiov1beta1.DataStore, error) {
+	dataStore := &claiov1beta1.DataStore{}
+	if err := n.Client.Get(n.Ctx, types.NamespacedName{Name: n.dataStoreName()}, dataStore); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting datastore %s: %s", n.dataStoreName(), err)
+	}
+	return dataStore, nil
+}
+
+// reconcileDataStore registers the cluster as DataStore, the tenants get an account in the
+// accounts secret and a bucket with the replication factor of the cluster. Limits and the
+// secret of the DataStore are left to the user.
+func (n *NATSCluster) reconcileDataStore() error {
+	n.LogHeader("check datastore %s ...", n.dataStoreName())
+	dataStore, err := n.getDataStore()
+	if err != nil {
+		return err
+	}
+	if dataStore == nil {
+		dataStore = &claiov1beta1.DataStore{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        n.dataStoreName(),
+				Annotations: map[string]string{NATSClusterAnnotation: n.owner()},
+			},
+		}
+	} else if dataStore.Annotations[NATSClusterAnnotation] != n.owner() {
+		return fmt.Errorf("datastore %s is not managed by nats cluster %s", n.dataStoreName(), n.owner())
+	}
+
+	spec := dataStore.Spec.DeepCopy()
+	spec.Driver = claiov1beta1.DatastoreDriverNATS
+	spec.Endpoint = fmt.Sprintf("nats://%s.%s.svc:4222", n.Object.Name, n.Namespace())
+	spec.NATS = &claiov1beta1.NATSDataStoreSpec{
+		AccountsSecretRef: &corev1.SecretReference{Name: n.accountsSecretName(), Namespace: n.Namespace()},
+		Replicas:          n.Object.Spec.ReplicationFactor,
+	}
+	if dataStore.ResourceVersion == "" {
+		n.LogInfo("create datastore %s", dataStore.Name)
+		dataStore.Spec = *spec
+		if err := n.Client.Create(n.Ctx, dataStore); err != nil {
+			return fmt.Errorf("error creating datastore %s: %s", dataStore.Name, err)
+		}
+		return nil
+	}
+	if reflect.DeepEqual(&dataStore.Spec, spec) {
+		return nil
+	}
+	n.LogInfo("update datastore %s", dataStore.Name)
+	dataStore.Spec = *spec
+	if err := n.Client.Update(n.Ctx, dataStore); err != nil {
+		return fmt.Errorf("error updating datastore %s: %s", dataStore.Name, err)
+	}
+	return nil
+}
+
+// releaseDataStore deletes the DataStore of the cluster, it refuses while control-planes still
+// use it. Their buckets would be deleted with the volumes of the servers.
+func (n *NATSCluster) releaseDataStore() error {
+	dataStore, err := n.getDataStore()
+	if err != nil || dataStore == nil || dataStore.Annotations[NATSClusterAnnotation] != n.owner() {
+		return err
+	}
+	controlPlanes := &claiov1beta1.ControlPlaneList{}
+	if err := n.Client.List(n.Ctx, controlPlanes); err != nil {
+		return fmt.Errorf("error listing control-planes: %s", err)
+	}
+	tenants := []string{}
+	for _, controlPlane := range controlPlanes.Items {
+		if controlPlane.Spec.Datastore.DataStoreName == dataStore.Name {
+			tenants = append(tenants, controlPlane.Namespace+"/"+controlPlane.Name)
+		}
+	}
+	if len(tenants) > 0 {
+		sort.Strings(tenants)
+		return fmt.Errorf("datastore %s is still used by %s", dataStore.Name, strings.Join(tenants, ", "))
+	}
+	n.LogInfo("delete datastore %s", dataStore.Name)
+	if err := n.Client.Delete(n.Ctx, dataStore); err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting datastore %s: %s", dataStore.Name, err)
+	}
+	return nil
+}
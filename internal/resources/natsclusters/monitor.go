@@ -0,0 +1,195 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package natsclusters
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/kubernetes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// monitorTimeout limits a single request to the monitoring endpoint of a server
+	monitorTimeout = 5 * time.Second
+	// kvStreamPrefix is the prefix of the stream of a KV bucket
+	kvStreamPrefix = "KV_"
+)
+
+var monitorClient = &http.Client{Timeout: monitorTimeout}
+
+// monitorURL is the monitoring endpoint of a server, it is reachable before the server is ready
+func (n *NATSCluster) monitorURL(server, path string) string {
+	return fmt.Sprintf("http://%s.%s.%s.svc:8222%s", server, n.headlessServiceName(), n.Namespace(), path)
+}
+
+// servers checks the pods of the statefulset and the health of the servers in them
+func (n *NATSCluster) servers(statefulSet *appsv1.StatefulSet) ([]claiov1beta1.NATSServerStatus, error) {
+	servers := []claiov1beta1.NATSServerStatus{}
+	for i := int32(0); i < n.Object.Spec.Replicas; i++ {
+		server := claiov1beta1.NATSServerStatus{Name: n.serverName(i), UpToDate: true}
+		pod, err := kubernetes.GetPod(n.Client, n.Ctx, n.Namespace(), server.Name)
+		if err != nil {
+			return nil, fmt.Errorf("error getting pod %s: %s", server.Name, err)
+		}
+		switch {
+		case pod == nil:
+			// the statefulset creates it from the current revision
+			server.Message = "pod does not exist"
+		case !pod.DeletionTimestamp.IsZero():
+			server.Message = "pod is terminating"
+		case pod.Status.Phase != corev1.PodRunning:
+			server.Message = fmt.Sprintf("pod is %s", strings.ToLower(string(pod.Status.Phase)))
+		default:
+			if err := n.healthz(server.Name); err != nil {
+				server.Message = err.Error()
+			} else {
+				server.Healthy = true
+			}
+		}
+		if pod != nil && statefulSet.Status.UpdateRevision != "" {
+			server.UpToDate = pod.Labels[appsv1.ControllerRevisionHashLabelKey] == statefulSet.Status.UpdateRevision
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// healthz fails unless the server is connected to the cluster and its streams are current
+func (n *NATSCluster) healthz(server string) error {
+	resp, err := monitorClient.Get(n.monitorURL(server, "/healthz"))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	health := struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}{}
+	body, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(body, &health); err != nil || health.Error == "" {
+		return fmt.Errorf("health check failed with status %d", resp.StatusCode)
+	}
+	return fmt.Errorf("%s", health.Error)
+}
+
+// jsz is the part of the JetStream report of a server the manager is interested in
+type jsz struct {
+	Meta *struct {
+		Leader string `json:"leader"`
+	} `json:"meta_cluster"`
+	AccountDetails []struct {
+		Name    string `json:"name"`
+		Streams []struct {
+			Name    string `json:"name"`
+			Cluster *struct {
+				Leader   string `json:"leader"`
+				Replicas []struct {
+					Name    string `json:"name"`
+					Current bool   `json:"current"`
+					Offline bool   `json:"offline"`
+				} `json:"replicas"`
+			} `json:"cluster"`
+		} `json:"stream_detail"`
+	} `json:"account_details"`
+}
+
+// jetStream asks the healthy servers for the meta leader and the replicas of the KV buckets. A
+// server only reports the streams it has a copy of, the report of the stream leader wins.
+func (n *NATSCluster) jetStream(servers []claiov1beta1.NATSServerStatus) (string, []claiov1beta1.NATSBucketStatus) {
+	metaLeader := ""
+	buckets := map[string]claiov1beta1.NATSBucketStatus{}
+	for _, server := range servers {
+		if !server.Healthy {
+			continue
+		}
+		report, err := n.jsz(server.Name)
+		if err != nil {
+			n.LogInfo("cannot get jetstream report of %s: %s", server.Name, err)
+			continue
+		}
+		if report.Meta != nil && report.Meta.Leader != "" {
+			metaLeader = report.Meta.Leader
+		}
+		for _, account := range report.AccountDetails {
+			for _, stream := range account.Streams {
+				if !strings.HasPrefix(stream.Name, kvStreamPrefix) {
+					continue
+				}
+				bucket := claiov1beta1.NATSBucketStatus{
+					Name:            strings.TrimPrefix(stream.Name, kvStreamPrefix),
+					Account:         account.Name,
+					Leader:          server.Name,
+					Replicas:        1,
+					CurrentReplicas: 1,
+				}
+				if stream.Cluster != nil {
+					bucket.Leader = stream.Cluster.Leader
+					for _, replica := range stream.Cluster.Replicas {
+						bucket.Replicas++
+						if replica.Current && !replica.Offline {
+							bucket.CurrentReplicas++
+						}
+					}
+				}
+				key := account.Name + "/" + bucket.Name
+				if _, ok := buckets[key]; !ok || bucket.Leader == server.Name {
+					buckets[key] = bucket
+				}
+			}
+		}
+	}
+
+	result := []claiov1beta1.NATSBucketStatus{}
+	for _, bucket := range buckets {
+		result = append(result, bucket)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Account != result[j].Account {
+			return result[i].Account < result[j].Account
+		}
+		return result[i].Name < result[j].Name
+	})
+	return metaLeader, result
+}
+
+func (n *NATSCluster) jsz(server string) (*jsz, error) {
+	resp, err := monitorClient.Get(n.monitorURL(server, "/jsz?accounts=true&streams=true"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jsz failed with status %d", resp.StatusCode)
+	}
+	report := &jsz{}
+	if err := json.NewDecoder(resp.Body).Decode(report); err != nil {
+		return nil, fmt.Errorf("cannot decode jsz: %s", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package natsclusters
+
+import (
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/resources"
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// checkInterval is how often the health of a settled cluster is checked
+	checkInterval = 1 * time.Minute
+	// progressInterval is how often a cluster is checked while it rolls or is unhealthy
+	progressInterval = 15 * time.Second
+)
+
+// condition reasons
+const (
+	reasonHealthy   = "Healthy"
+	reasonUnhealthy = "Unhealthy"
+	reasonCurrent   = "Current"
+	reasonRolling   = "Rolling"
+	reasonFailed    = "Failed"
+)
+
+type NATSCluster struct {
+	resources.Resource[*claiov1beta1.NATSCluster]
+}
+
+func NewNATSCluster(ctx context.Context, req ctrl.Request, rClient client.Client, rScheme *runtime.Scheme) (*NATSCluster, error) {
+	res := &claiov1beta1.NATSCluster{}
+	if err := rClient.Get(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace}, res); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return &NATSCluster{
+		Resource: *resources.NewResource("NATSCluster", ctx, req, rClient, rScheme, res),
+	}, nil
+}
+
+func (n *NATSCluster) Check() (string, error) {
+	n.LogHeader("check nats cluster (init) ...")
+	if n.Object.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !n.HasFinalizer() {
+			n.LogInfo("add finalizer")
+			if err := n.AddFinalizer(); err != nil {
+				return n.STATUS_UP, fmt.Errorf("adding finalizer failed")
+			}
+		}
+		return n.STATUS_UP, nil
+	} else {
+		if n.HasFinalizer() {
+			return n.STATUS_WANTDOWN, nil
+		}
+		return n.STATUS_GOINGDOWN, nil
+	}
+}
+
+// Reconcile converges the servers of the cluster and rolls configuration changes one server at
+// a time. The workload is garbage collected with the NATSCluster, the finalizer only removes
+// the DataStore once no tenant is placed on it anymore.
+func (n *NATSCluster) Reconcile() (ctrl.Result, error) {
+	status, err := n.Check()
+	if err != nil {
+		n.LogError(err, "check failed")
+		return ctrl.Result{}, err
+	}
+	n.LogInfo("status: %s", status)
+
+	if status == n.STATUS_WANTDOWN {
+		n.LogHeader("check nats cluster (finalize) ...")
+		if err := n.releaseDataStore(); err != nil {
+			n.LogError(err, "failed to release datastore")
+			return ctrl.Result{}, err
+		}
+		n.LogInfo("remove finalizer")
+		if err := n.RemoveFinalizer(); err != nil {
+			n.LogError(err, "failed to remove finalizer")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	if status != n.STATUS_UP {
+		return ctrl.Result{}, nil
+	}
+
+	configHash, err := n.reconcileConfig()
+	if err != nil {
+		n.LogError(err, "failed to reconcile configuration")
+		return ctrl.Result{}, n.abort(err)
+	}
+	if err := n.reconcileServices(); err != nil {
+		n.LogError(err, "failed to reconcile services")
+		return ctrl.Result{}, n.abort(err)
+	}
+	statefulSet, err := n.reconcileStatefulSet(configHash)
+	if err != nil {
+		n.LogError(err, "failed to reconcile statefulset")
+		return ctrl.Result{}, n.abort(err)
+	}
+	if err := n.reconcileDataStore(); err != nil {
+		n.LogError(err, "failed to reconcile datastore")
+		return ctrl.Result{}, n.abort(err)
+	}
+
+	servers, err := n.servers(statefulSet)
+	if err != nil {
+		n.LogError(err, "failed to check servers")
+		return ctrl.Result{}, n.abort(err)
+	}
+	n.Object.Status.Servers = servers
+	n.Object.Status.ReadyReplicas = statefulSet.Status.ReadyReplicas
+	n.Object.Status.ConfigHash = configHash
+	n.Object.Status.MetaLeader, n.Object.Status.Buckets = n.jetStream(servers)
+
+	healthy := n.healthy()
+	if healthy {
+		n.setCondition(claiov1beta1.NATSClusterConditionAvailable, metav1.ConditionTrue, reasonHealthy, "all servers are healthy")
+	} else {
+		n.setCondition(claiov1beta1.NATSClusterConditionAvailable, metav1.ConditionFalse, reasonUnhealthy, n.unhealthyMessage())
+	}
+
+	rolling, err := n.roll(statefulSet, healthy)
+	if err != nil {
+		n.LogError(err, "failed to roll servers")
+		return ctrl.Result{}, n.abort(err)
+	}
+
+	n.Object.Status.ObservedGeneration = n.Object.Generation
+	if err := n.updateStatus(); err != nil {
+		return ctrl.Result{}, err
+	}
+	if rolling || !healthy {
+		return ctrl.Result{RequeueAfter: progressInterval}, nil
+	}
+	return ctrl.Result{RequeueAfter: checkInterval}, nil
+}
+
+// healthy is true if every server passed its health check and the JetStream cluster has
+// elected a meta leader
+func (n *NATSCluster) healthy() bool {
+	if int32(len(n.Object.Status.Servers)) != n.Object.Spec.Replicas {
+		return false
+	}
+	for _, server := range n.Object.Status.Servers {
+		if !server.Healthy {
+			return false
+		}
+	}
+	return n.Object.Spec.Replicas == 1 || n.Object.Status.MetaLeader != ""
+}
+
+func (n *NATSCluster) unhealthyMessage() string {
+	for _, server := range n.Object.Status.Servers {
+		if !server.Healthy {
+			return fmt.Sprintf("server %s: %s", server.Name, server.Message)
+		}
+	}
+	if n.Object.Spec.Replicas > 1 && n.Object.Status.MetaLeader == "" {
+		return "jetstream has no meta leader"
+	}
+	return "servers are missing"
+}
+
+func (n *NATSCluster) setCondition(conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&n.Object.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: n.Object.Generation,
+	})
+}
+
+func (n *NATSCluster) updateStatus() error {
+	if err := n.Client.Status().Update(n.Ctx, n.Object); err != nil {
+		n.LogError(err, "failed to update status")
+		return err
+	}
+	return nil
+}
+
+// abort records the failure in the status (best effort) and returns the original error
+func (n *NATSCluster) abort(err error) error {
+	n.setCondition(claiov1beta1.NATSClusterConditionAvailable, metav1.ConditionFalse, reasonFailed, err.Error())
+	_ = n.updateStatus()
+	return err
+}
@@ -0,0 +1,487 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package natsclusters
+
+import (
+	"bytes"
+	claiov1beta1 "claio/api/v1beta1"
+	"claio/internal/kubernetes"
+	"claio/internal/resources/controlplanes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// configKey is the nats config in the config secret
+	configKey = "nats.conf"
+
+	// configHashAnnotation restarts a server when its configuration changes
+	configHashAnnotation = "claio.github.com/config-hash"
+	// specHashAnnotation detects changes of the statefulset the manager has to apply
+	specHashAnnotation = "claio.github.com/spec-hash"
+)
+
+func (n *NATSCluster) headlessServiceName() string {
+	return n.Object.Name + "-headless"
+}
+
+func (n *NATSCluster) configSecretName() string {
+	return n.Object.Name + "-config"
+}
+
+func (n *NATSCluster) accountsSecretName() string {
+	return n.Object.Name + "-accounts"
+}
+
+func (n *NATSCluster) serverName(ordinal int32) string {
+	return fmt.Sprintf("%s-%d", n.Object.Name, ordinal)
+}
+
+// routes are the cluster addresses of all servers, a single server has none
+func (n *NATSCluster) routes() []string {
+	routes := []string{}
+	if n.Object.Spec.Replicas == 1 {
+		return routes
+	}
+	for i := int32(0); i < n.Object.Spec.Replicas; i++ {
+		routes = append(routes, fmt.Sprintf("%s.%s", n.serverName(i), n.headlessServiceName()))
+	}
+	return routes
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// reconcileConfig writes the nats config and creates the (empty) accounts file the ControlPlanes
+// add their accounts to, it returns the hash of the config
+func (n *NATSCluster) reconcileConfig() (string, error) {
+	n.LogHeader("check configuration ...")
+	config, err := n.ToYaml(configTemplate, map[string]any{
+		"Name":   n.Object.Name,
+		"Routes": n.routes(),
+		// leave some room on the volume for the meta data of the servers
+		"MaxFileStore": n.Object.Spec.Storage.Size.Value() / 10 * 9,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error generating nats config: %s", err)
+	}
+
+	current, err := n.GetSecret(n.configSecretName())
+	if err != nil {
+		return "", fmt.Errorf("error getting %s: %s", n.configSecretName(), err)
+	}
+	data := map[string][]byte{configKey: config}
+	if current == nil {
+		n.LogInfo("create configuration")
+		if err := n.CreateSecret(n.configSecretName(), data); err != nil {
+			return "", err
+		}
+	} else if !bytes.Equal(current[configKey], config) {
+		n.LogInfo("update configuration")
+		if err := n.UpdateSecret(n.configSecretName(), data); err != nil {
+			return "", err
+		}
+	}
+
+	// the accounts belong to the tenants, they are not garbage collected with the cluster
+	accounts, err := n.GetSecret(n.accountsSecretName())
+	if err != nil {
+		return "", fmt.Errorf("error getting %s: %s", n.accountsSecretName(), err)
+	}
+	if accounts == nil {
+		n.LogInfo("create accounts")
+		if err := kubernetes.CreateSecret(n.Client, n.Ctx, n.Namespace(), n.accountsSecretName(),
+			map[string][]byte{controlplanes.NATSAccountsKey: {}}, nil, n.Scheme); err != nil {
+			return "", err
+		}
+	}
+	return hash(config), nil
+}
+
+func (n *NATSCluster) reconcileServices() error {
+	n.LogHeader("check services ...")
+	for name, tmpl := range map[string]string{
+		n.Object.Name:           clientServiceTemplate,
+		n.headlessServiceName(): headlessServiceTemplate,
+	} {
+		service, err := n.GetService(name)
+		if err != nil {
+			return fmt.Errorf("error getting service %s: %s", name, err)
+		}
+		if service != nil {
+			continue
+		}
+		yaml, err := n.ToYaml(tmpl, n.Object)
+		if err != nil {
+			return fmt.Errorf("error generating yaml: %s", err)
+		}
+		n.LogInfo("create service %s", name)
+		if err := n.CreateService(name, yaml); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type statefulSetValues struct {
+	Name             string
+	Namespace        string
+	Image            string
+	Replicas         int32
+	Resources        string
+	StorageSize      string
+	StorageClassName string
+	ConfigSecret     string
+	AccountsSecret   string
+	HeadlessService  string
+	ConfigHash       string
+	SpecHash         string
+}
+
+// reconcileStatefulSet creates the statefulset or applies changes of the spec. A new config
+// hash changes the pod template, the statefulset does not restart the pods by itself
+// (OnDelete), see roll.
+func (n *NATSCluster) reconcileStatefulSet(configHash string) (*appsv1.StatefulSet, error) {
+	n.LogHeader("check statefulset ...")
+	resources, err := json.Marshal(n.Object.Spec.Resources)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling resources: %s", err)
+	}
+	values := statefulSetValues{
+		Name:            n.Object.Name,
+		Namespace:       n.Namespace(),
+		Image:           n.Object.Spec.Image,
+		Replicas:        n.Object.Spec.Replicas,
+		Resources:       string(resources),
+		StorageSize:     n.Object.Spec.Storage.Size.String(),
+		ConfigSecret:    n.configSecretName(),
+		AccountsSecret:  n.accountsSecretName(),
+		HeadlessService: n.headlessServiceName(),
+		ConfigHash:      configHash,
+	}
+	if n.Object.Spec.Storage.StorageClassName != nil {
+		values.StorageClassName = *n.Object.Spec.Storage.StorageClassName
+	}
+	spec, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling statefulset values: %s", err)
+	}
+	values.SpecHash = hash(spec)
+
+	statefulSet, err := n.GetStatefulSet(n.Object.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting statefulset: %s", err)
+	}
+	if statefulSet != nil && statefulSet.Annotations[specHashAnnotation] == values.SpecHash {
+		return statefulSet, nil
+	}
+
+	yaml, err := n.ToYaml(statefulSetTemplate, values)
+	if err != nil {
+		return nil, fmt.Errorf("error generating yaml: %s", err)
+	}
+	if statefulSet == nil {
+		n.LogInfo("create statefulset")
+		err = n.CreateStatefulSet(n.Object.Name, yaml)
+	} else {
+		n.LogInfo("update statefulset")
+		err = n.UpdateStatefulSet(n.Object.Name, yaml)
+	}
+	if err != nil {
+		return nil, err
+	}
+	statefulSet, err = n.GetStatefulSet(n.Object.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting statefulset: %s", err)
+	}
+	if statefulSet == nil {
+		return nil, fmt.Errorf("statefulset %s disappeared", n.Object.Name)
+	}
+	return statefulSet, nil
+}
+
+// roll restarts one outdated server if the whole cluster is healthy, the next one follows when
+// the restarted server has caught up. The meta leader is restarted last. It returns true while
+// servers are outdated.
+func (n *NATSCluster) roll(statefulSet *appsv1.StatefulSet, healthy bool) (bool, error) {
+	if statefulSet.Status.ObservedGeneration < statefulSet.Generation {
+		n.setCondition(claiov1beta1.NATSClusterConditionUpToDate, metav1.ConditionFalse, reasonRolling,
+			"waiting for the statefulset to observe the new configuration")
+		return true, nil
+	}
+	outdated := []string{}
+	for _, server := range n.Object.Status.Servers {
+		if !server.UpToDate {
+			outdated = append(outdated, server.Name)
+		}
+	}
+	if len(outdated) == 0 {
+		n.setCondition(claiov1beta1.NATSClusterConditionUpToDate, metav1.ConditionTrue, reasonCurrent,
+			"all servers run the current configuration")
+		return false, nil
+	}
+	if !healthy {
+		n.setCondition(claiov1beta1.NATSClusterConditionUpToDate, metav1.ConditionFalse, reasonRolling,
+			fmt.Sprintf("%d servers are outdated, waiting for a healthy cluster", len(outdated)))
+		return true, nil
+	}
+
+	// outdated is ordered by ordinal, start with the highest like a statefulset
+	next := outdated[len(outdated)-1]
+	for i := len(outdated) - 1; i >= 0; i-- {
+		if outdated[i] != n.Object.Status.MetaLeader {
+			next = outdated[i]
+			break
+		}
+	}
+	n.LogInfo("restart server %s with the current configuration", next)
+	if err := kubernetes.DeletePod(n.Client, n.Ctx, n.Namespace(), next); err != nil {
+		return true, err
+	}
+	n.setCondition(claiov1beta1.NATSClusterConditionUpToDate, metav1.ConditionFalse, reasonRolling,
+		fmt.Sprintf("%d servers are outdated, restarted %s", len(outdated), next))
+	return true, nil
+}
+
+// configTemplate is the config of every server, the tenants are separated by accounts which
+// the config-reloader reloads without a restart
+const configTemplate = `server_name: $SERVER_NAME
+port: 4222
+http_port: 8222
+pid_file: "/var/run/nats/nats.pid"
+lame_duck_duration: "30s"
+lame_duck_grace_period: "10s"
+jetstream {
+  store_dir: "/data"
+  max_memory_store: 0
+  max_file_store: {{ .MaxFileStore }}
+}
+{{- if .Routes }}
+cluster {
+  name: {{ .Name }}
+  port: 6222
+  connect_retries: 120
+  routes: [
+{{- range .Routes }}
+    "nats://{{ . }}:6222"
+{{- end }}
+  ]
+}
+{{- end }}
+accounts {
+  # one account per tenant, maintained by the manager
+  include "../nats-accounts/accounts.conf"
+}
+`
+
+const clientServiceTemplate = `apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+  labels:
+    app.kubernetes.io/name: nats
+    app.kubernetes.io/instance: {{ .Name }}
+spec:
+  selector:
+    app.kubernetes.io/name: nats
+    app.kubernetes.io/instance: {{ .Name }}
+  ports:
+  - name: nats
+    port: 4222
+    targetPort: nats
+    appProtocol: tcp
+`
+
+// headlessServiceTemplate resolves the servers before they are ready, they need each other to
+// form the cluster
+const headlessServiceTemplate = `apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Name }}-headless
+  namespace: {{ .Namespace }}
+  labels:
+    app.kubernetes.io/name: nats
+    app.kubernetes.io/instance: {{ .Name }}
+spec:
+  clusterIP: None
+  publishNotReadyAddresses: true
+  selector:
+    app.kubernetes.io/name: nats
+    app.kubernetes.io/instance: {{ .Name }}
+  ports:
+  - name: nats
+    port: 4222
+    targetPort: nats
+    appProtocol: tcp
+  - name: cluster
+    port: 6222
+    targetPort: cluster
+    appProtocol: tcp
+  - name: monitor
+    port: 8222
+    targetPort: monitor
+    appProtocol: http
+`
+
+const statefulSetTemplate = `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+  labels:
+    app.kubernetes.io/name: nats
+    app.kubernetes.io/instance: {{ .Name }}
+  annotations:
+    ` + specHashAnnotation + `: "{{ .SpecHash }}"
+spec:
+  replicas: {{ .Replicas }}
+  podManagementPolicy: Parallel
+  updateStrategy:
+    type: OnDelete
+  serviceName: {{ .HeadlessService }}
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: nats
+      app.kubernetes.io/instance: {{ .Name }}
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: nats
+        app.kubernetes.io/instance: {{ .Name }}
+      annotations:
+        ` + configHashAnnotation + `: "{{ .ConfigHash }}"
+    spec:
+      affinity:
+        podAntiAffinity:
+          preferredDuringSchedulingIgnoredDuringExecution:
+          - weight: 100
+            podAffinityTerm:
+              topologyKey: kubernetes.io/hostname
+              labelSelector:
+                matchLabels:
+                  app.kubernetes.io/name: nats
+                  app.kubernetes.io/instance: {{ .Name }}
+      containers:
+      - name: nats
+        image: {{ .Image }}
+        args:
+        - --config
+        - /etc/nats-config/nats.conf
+        env:
+        - name: POD_NAME
+          valueFrom:
+            fieldRef:
+              fieldPath: metadata.name
+        - name: SERVER_NAME
+          value: $(POD_NAME)
+        lifecycle:
+          preStop:
+            exec:
+              command:
+              - nats-server
+              - -sl=ldm=/var/run/nats/nats.pid
+        ports:
+        - containerPort: 4222
+          name: nats
+        - containerPort: 6222
+          name: cluster
+        - containerPort: 8222
+          name: monitor
+        resources: {{ .Resources }}
+        startupProbe:
+          httpGet:
+            path: /healthz
+            port: monitor
+          initialDelaySeconds: 10
+          periodSeconds: 10
+          timeoutSeconds: 5
+          failureThreshold: 90
+        livenessProbe:
+          httpGet:
+            path: /healthz?js-enabled-only=true
+            port: monitor
+          initialDelaySeconds: 10
+          periodSeconds: 30
+          timeoutSeconds: 5
+          failureThreshold: 3
+        readinessProbe:
+          httpGet:
+            path: /healthz?js-server-only=true
+            port: monitor
+          initialDelaySeconds: 10
+          periodSeconds: 10
+          timeoutSeconds: 5
+          failureThreshold: 3
+        volumeMounts:
+        - mountPath: /etc/nats-config
+          name: config
+        - mountPath: /etc/nats-accounts
+          name: accounts
+        - mountPath: /var/run/nats
+          name: pid
+        - mountPath: /data
+          name: data
+      # reloads nats when the manager adds or removes the account of a tenant, changes of the
+      # config are rolled by the manager
+      - name: reloader
+        image: natsio/nats-server-config-reloader:0.16.0
+        args:
+        - -pid
+        - /var/run/nats/nats.pid
+        - -config
+        - /etc/nats-accounts/accounts.conf
+        volumeMounts:
+        - mountPath: /etc/nats-accounts
+          name: accounts
+        - mountPath: /var/run/nats
+          name: pid
+      enableServiceLinks: false
+      shareProcessNamespace: true
+      terminationGracePeriodSeconds: 60
+      volumes:
+      - name: config
+        secret:
+          secretName: {{ .ConfigSecret }}
+      - name: accounts
+        secret:
+          secretName: {{ .AccountsSecret }}
+          items:
+          - key: ` + controlplanes.NATSAccountsKey + `
+            path: ` + controlplanes.NATSAccountsKey + `
+      - name: pid
+        emptyDir: {}
+  volumeClaimTemplates:
+  - metadata:
+      name: data
+    spec:
+      accessModes:
+      - ReadWriteOnce
+{{- if .StorageClassName }}
+      storageClassName: {{ .StorageClassName }}
+{{- end }}
+      resources:
+        requests:
+          storage: {{ .StorageSize }}
+`
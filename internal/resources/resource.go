@@ -20,12 +20,13 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"html/template"
 	"strings"
+	"text/template"
 
 	"claio/internal/kubernetes"
 
 	v1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -128,6 +129,10 @@ func (r *Resource[T]) CreateSecret(name string, data map[string][]byte) error {
 	return kubernetes.CreateSecret(r.Client, r.Ctx, r.Namespace(), name, data, r.Object, r.Scheme)
 }
 
+func (r *Resource[T]) UpdateSecret(name string, data map[string][]byte) error {
+	return kubernetes.UpdateSecret(r.Client, r.Ctx, r.Namespace(), name, data, r.Object, r.Scheme)
+}
+
 func (r *Resource[T]) DeleteSecret(name string) error {
 	return kubernetes.DeleteSecret(r.Client, r.Ctx, r.Namespace(), name)
 }
@@ -149,6 +154,32 @@ func (r *Resource[T]) GetDeployment(name string) (*v1.Deployment, error) {
 	return kubernetes.GetDeployment(r.Client, r.Ctx, r.Namespace(), name)
 }
 
+// statefulsets
+func (r *Resource[T]) CreateStatefulSet(name string, yaml []byte) error {
+	return kubernetes.CreateStatefulSet(r.Client, r.Ctx, r.Namespace(), name, yaml, r.Object, r.Scheme)
+}
+
+func (r *Resource[T]) UpdateStatefulSet(name string, yaml []byte) error {
+	return kubernetes.UpdateStatefulSet(r.Client, r.Ctx, r.Namespace(), name, yaml, r.Object, r.Scheme)
+}
+
+func (r *Resource[T]) GetStatefulSet(name string) (*v1.StatefulSet, error) {
+	return kubernetes.GetStatefulSet(r.Client, r.Ctx, r.Namespace(), name)
+}
+
+// jobs
+func (r *Resource[T]) CreateJob(name string, yaml []byte) error {
+	return kubernetes.CreateJob(r.Client, r.Ctx, r.Namespace(), name, yaml, r.Object, r.Scheme)
+}
+
+func (r *Resource[T]) GetJob(name string) (*batchv1.Job, error) {
+	return kubernetes.GetJob(r.Client, r.Ctx, r.Namespace(), name)
+}
+
+func (r *Resource[T]) DeleteJob(name string) error {
+	return kubernetes.DeleteJob(r.Client, r.Ctx, r.Namespace(), name)
+}
+
 // services
 func (r *Resource[T]) CreateService(name string, yaml []byte) error {
 	return kubernetes.CreateService(r.Client, r.Ctx, r.Namespace(), name, yaml, r.Object, r.Scheme)
@@ -166,6 +197,19 @@ func (r *Resource[T]) GetService(name string) (*corev1.Service, error) {
 	return kubernetes.GetService(r.Client, r.Ctx, r.Namespace(), name)
 }
 
+// persistent volume claims
+func (r *Resource[T]) CreatePersistentVolumeClaim(name string, yaml []byte) error {
+	return kubernetes.CreatePersistentVolumeClaim(r.Client, r.Ctx, r.Namespace(), name, yaml, r.Object, r.Scheme)
+}
+
+func (r *Resource[T]) DeletePersistentVolumeClaim(name string) error {
+	return kubernetes.DeletePersistentVolumeClaim(r.Client, r.Ctx, r.Namespace(), name)
+}
+
+func (r *Resource[T]) GetPersistentVolumeClaim(name string) (*corev1.PersistentVolumeClaim, error) {
+	return kubernetes.GetPersistentVolumeClaim(r.Client, r.Ctx, r.Namespace(), name)
+}
+
 // --- helper ----------------------------------------------------------------
 
 func (c *Resource[T]) ToYaml(tmpl string, obj interface{}) ([]byte, error) {
@@ -0,0 +1,431 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	claiov1beta1 "claio/api/v1beta1"
+)
+
+// log is for logging in this package.
+var controlplanelog = logf.Log.WithName("controlplane-resource")
+
+// DefaultSupportedVersions are the kubernetes minor versions claio has been tested with
+var DefaultSupportedVersions = []string{"1.29", "1.30", "1.31"}
+
+// DefaultControlPlaneDefaults are used for fields left empty in a ControlPlane spec
+var DefaultControlPlaneDefaults = ControlPlaneDefaults{
+	Port:        6543,
+	Version:     "1.31.1",
+	Database:    "nats",
+	DataStore:   "nats",
+	ClusterCIDR: "192.168.0.0/17",
+	ServiceCIDR: "192.168.128.0/17",
+	DNSDomain:   "cluster.local",
+	RenewBefore: 30 * 24 * time.Hour,
+}
+
+// leafCertificateLifetime is the validity of the certificates the manager issues to a tenant
+const leafCertificateLifetime = 365 * 24 * time.Hour
+
+// imageReference is the grammar of container image references, [registry[:port]/]path[:tag][@digest]
+var imageReference = regexp.MustCompile(`^` +
+	`(?:(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?/)?` +
+	`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+	`(?::[\w][\w.-]{0,127})?` +
+	`(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?$`)
+
+// Options configures the ControlPlane webhooks
+type Options struct {
+	// SupportedVersions lists the accepted kubernetes minor versions (e.g. "1.31")
+	SupportedVersions []string
+	// Defaults are filled into empty spec fields
+	Defaults ControlPlaneDefaults
+	// Gateway is true if the manager is configured with a kine gateway for the gateway driver
+	Gateway bool
+}
+
+// ControlPlaneDefaults holds the manager-level defaults of a ControlPlane spec
+type ControlPlaneDefaults struct {
+	Port        int
+	Version     string
+	Database    string
+	DataStore   string
+	ClusterCIDR string
+	ServiceCIDR string
+	DNSDomain   string
+	RenewBefore time.Duration
+}
+
+// SetupControlPlaneWebhookWithManager registers the webhooks for ControlPlane in the manager.
+// As v1beta1 is the conversion hub this also serves the conversion webhook.
+func SetupControlPlaneWebhookWithManager(mgr ctrl.Manager, opts Options) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&claiov1beta1.ControlPlane{}).
+		WithValidator(&ControlPlaneCustomValidator{SupportedVersions: opts.SupportedVersions, Gateway: opts.Gateway}).
+		WithDefaulter(&ControlPlaneCustomDefaulter{Defaults: opts.Defaults}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-claio-github-com-v1beta1-controlplane,mutating=true,failurePolicy=fail,sideEffects=None,groups=claio.github.com,resources=controlplanes,verbs=create;update,versions=v1beta1,name=mcontrolplane-v1beta1.kb.io,admissionReviewVersions=v1
+
+// ControlPlaneCustomDefaulter sets default values on a ControlPlane when it is created or updated.
+// The defaulted values are stored with the object, so they show what the tenant actually runs with.
+type ControlPlaneCustomDefaulter struct {
+	Defaults ControlPlaneDefaults
+}
+
+var _ webhook.CustomDefaulter = &ControlPlaneCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type ControlPlane.
+func (d *ControlPlaneCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	controlplane, ok := obj.(*claiov1beta1.ControlPlane)
+	if !ok {
+		return fmt.Errorf("expected a ControlPlane object but got %T", obj)
+	}
+	controlplanelog.Info("defaulting for ControlPlane", "name", controlplane.GetName())
+
+	spec := &controlplane.Spec
+	if spec.Endpoint.Port == 0 {
+		spec.Endpoint.Port = d.Defaults.Port
+	}
+	if spec.Version == "" {
+		spec.Version = d.Defaults.Version
+	}
+	if spec.Datastore.Driver == "" {
+		spec.Datastore.Driver = d.Defaults.Database
+	}
+	if spec.Datastore.Driver == claiov1beta1.DatastoreDriverNATS && spec.Datastore.SecretRef == nil &&
+		spec.Datastore.DataStoreName == "" {
+		spec.Datastore.DataStoreName = d.Defaults.DataStore
+	}
+	if spec.Network.ClusterCIDR == "" {
+		spec.Network.ClusterCIDR = d.Defaults.ClusterCIDR
+	}
+	if spec.Network.ServiceCIDR == "" {
+		spec.Network.ServiceCIDR = d.Defaults.ServiceCIDR
+	}
+	if spec.Network.DNSDomain == "" {
+		spec.Network.DNSDomain = d.Defaults.DNSDomain
+	}
+	if spec.Certificates.RenewBefore == nil && d.Defaults.RenewBefore > 0 {
+		spec.Certificates.RenewBefore = &metav1.Duration{Duration: d.Defaults.RenewBefore}
+	}
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-claio-github-com-v1beta1-controlplane,mutating=false,failurePolicy=fail,sideEffects=None,groups=claio.github.com,resources=controlplanes,verbs=create;update,versions=v1beta1,name=vcontrolplane-v1beta1.kb.io,admissionReviewVersions=v1
+
+// ControlPlaneCustomValidator validates a ControlPlane on create and update.
+type ControlPlaneCustomValidator struct {
+	SupportedVersions []string
+	Gateway           bool
+}
+
+var _ webhook.CustomValidator = &ControlPlaneCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type ControlPlane.
+func (v *ControlPlaneCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	controlplane, ok := obj.(*claiov1beta1.ControlPlane)
+	if !ok {
+		return nil, fmt.Errorf("expected a ControlPlane object but got %T", obj)
+	}
+	controlplanelog.Info("validation for ControlPlane upon creation", "name", controlplane.GetName())
+
+	return nil, v.toError(controlplane, v.validateSpec(&controlplane.Spec))
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type ControlPlane.
+func (v *ControlPlaneCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	controlplane, ok := newObj.(*claiov1beta1.ControlPlane)
+	if !ok {
+		return nil, fmt.Errorf("expected a ControlPlane object for the newObj but got %T", newObj)
+	}
+	oldControlplane, ok := oldObj.(*claiov1beta1.ControlPlane)
+	if !ok {
+		return nil, fmt.Errorf("expected a ControlPlane object for the oldObj but got %T", oldObj)
+	}
+	controlplanelog.Info("validation for ControlPlane upon update", "name", controlplane.GetName())
+
+	allErrs := v.validateSpec(&controlplane.Spec)
+	allErrs = append(allErrs, validateImmutable(&oldControlplane.Spec, &controlplane.Spec)...)
+	allErrs = append(allErrs, validateMigration(oldControlplane, controlplane)...)
+	return nil, v.toError(controlplane, allErrs)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type ControlPlane.
+func (v *ControlPlaneCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// --- validation -------------------------------------------------------------
+
+func (v *ControlPlaneCustomValidator) toError(controlplane *claiov1beta1.ControlPlane, allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		claiov1beta1.GroupVersion.WithKind("ControlPlane").GroupKind(),
+		controlplane.Name, allErrs)
+}
+
+func (v *ControlPlaneCustomValidator) validateSpec(spec *claiov1beta1.ControlPlaneSpec) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	// name is used for the tenant namespace and the etcd prefix
+	if spec.Name == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("name"), "name is required"))
+	} else {
+		for _, msg := range validation.IsDNS1123Label("tenant-" + spec.Name) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("name"), spec.Name, msg))
+		}
+	}
+
+	endpointPath := specPath.Child("endpoint")
+	if spec.Endpoint.Port < 1 || spec.Endpoint.Port > 65535 {
+		allErrs = append(allErrs, field.Invalid(endpointPath.Child("port"), spec.Endpoint.Port, "must be between 1 and 65535"))
+	}
+	if net.ParseIP(spec.Endpoint.Address) == nil {
+		allErrs = append(allErrs, field.Invalid(endpointPath.Child("address"), spec.Endpoint.Address, "must be a valid IP address"))
+	}
+	if spec.Endpoint.Host == "" {
+		allErrs = append(allErrs, field.Required(endpointPath.Child("host"), "host is required"))
+	} else if net.ParseIP(spec.Endpoint.Host) == nil {
+		for _, msg := range validation.IsDNS1123Subdomain(spec.Endpoint.Host) {
+			allErrs = append(allErrs, field.Invalid(endpointPath.Child("host"), spec.Endpoint.Host, msg))
+		}
+	}
+
+	if err := v.validateVersion(spec.Version); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("version"), spec.Version, err.Error()))
+	}
+
+	networkPath := specPath.Child("network")
+	_, clusterNet, err := net.ParseCIDR(spec.Network.ClusterCIDR)
+	if err != nil {
+		allErrs = append(allErrs, field.Invalid(networkPath.Child("clusterCIDR"), spec.Network.ClusterCIDR, "must be a valid CIDR"))
+	}
+	_, serviceNet, err := net.ParseCIDR(spec.Network.ServiceCIDR)
+	if err != nil {
+		allErrs = append(allErrs, field.Invalid(networkPath.Child("serviceCIDR"), spec.Network.ServiceCIDR, "must be a valid CIDR"))
+	}
+	if clusterNet != nil && serviceNet != nil && cidrsOverlap(clusterNet, serviceNet) {
+		allErrs = append(allErrs, field.Invalid(networkPath.Child("serviceCIDR"), spec.Network.ServiceCIDR,
+			fmt.Sprintf("must not overlap with clusterCIDR %s", spec.Network.ClusterCIDR)))
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(spec.Network.DNSDomain) {
+		allErrs = append(allErrs, field.Invalid(networkPath.Child("dnsDomain"), spec.Network.DNSDomain, msg))
+	}
+
+	allErrs = append(allErrs, validateDatastore(&spec.Datastore, specPath.Child("datastore"), v.Gateway)...)
+
+	componentsPath := specPath.Child("components")
+	allErrs = append(allErrs, validateComponent(&spec.Components.APIServer, componentsPath.Child("apiServer"))...)
+	allErrs = append(allErrs, validateComponent(&spec.Components.ControllerManager, componentsPath.Child("controllerManager"))...)
+	allErrs = append(allErrs, validateComponent(&spec.Components.Scheduler, componentsPath.Child("scheduler"))...)
+
+	for i, san := range spec.Certificates.ExtraSANs {
+		if net.ParseIP(san) != nil {
+			continue
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(san) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("certificates", "extraSANs").Index(i), san, msg))
+		}
+	}
+	if renewBefore := spec.Certificates.RenewBefore; renewBefore != nil &&
+		(renewBefore.Duration <= 0 || renewBefore.Duration >= leafCertificateLifetime) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("certificates", "renewBefore"), renewBefore.Duration.String(),
+			fmt.Sprintf("must be between 0 and the lifetime of the certificates (%s)", leafCertificateLifetime)))
+	}
+	if source := spec.Certificates.CA; source != nil && source.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("certificates", "ca", "secretRef", "name"), "the secret of the CA is required"))
+	}
+	if source := spec.Certificates.FrontProxyCA; source != nil && source.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("certificates", "frontProxyCA", "secretRef", "name"), "the secret of the CA is required"))
+	}
+
+	return allErrs
+}
+
+// validateVersion checks for a "major.minor.patch" version in one of the supported minor versions
+func (v *ControlPlaneCustomValidator) validateVersion(version string) error {
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("must be a version in the form major.minor.patch (without leading 'v')")
+	}
+	for _, part := range parts {
+		if _, err := strconv.ParseUint(part, 10, 32); err != nil {
+			return fmt.Errorf("must be a version in the form major.minor.patch (without leading 'v')")
+		}
+	}
+	supported := v.SupportedVersions
+	if len(supported) == 0 {
+		supported = DefaultSupportedVersions
+	}
+	minor := parts[0] + "." + parts[1]
+	for _, s := range supported {
+		if s == minor {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported version, supported minor versions are %s", strings.Join(supported, ", "))
+}
+
+// validateImmutable rejects changes of fields which cannot be changed on a running tenant
+func validateImmutable(oldSpec, newSpec *claiov1beta1.ControlPlaneSpec) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	if oldSpec.Name != newSpec.Name {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("name"), "field is immutable"))
+	}
+	if oldSpec.Network.ClusterCIDR != newSpec.Network.ClusterCIDR {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("network", "clusterCIDR"), "field is immutable"))
+	}
+	if oldSpec.Network.ServiceCIDR != newSpec.Network.ServiceCIDR {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("network", "serviceCIDR"), "field is immutable"))
+	}
+	return allErrs
+}
+
+// validateMigration rejects changes of the datastore while the keys of the tenant are copied
+// to another one, a changed driver or DataStore starts a migration
+func validateMigration(oldControlplane, controlplane *claiov1beta1.ControlPlane) field.ErrorList {
+	migration := oldControlplane.Status.Migration
+	if migration == nil || migration.Phase != claiov1beta1.DatastoreMigrationRunning ||
+		equality.Semantic.DeepEqual(oldControlplane.Spec.Datastore, controlplane.Spec.Datastore) {
+		return nil
+	}
+	return field.ErrorList{field.Forbidden(field.NewPath("spec", "datastore"),
+		fmt.Sprintf("the migration to %s is running", migration.Target))}
+}
+
+// validateDatastore checks the driver and that the endpoint comes from either a secret or a DataStore,
+// the gateway driver is only available if the manager has a kine gateway
+func validateDatastore(datastore *claiov1beta1.DatastoreSpec, datastorePath *field.Path, gateway bool) field.ErrorList {
+	var allErrs field.ErrorList
+	switch datastore.Driver {
+	case claiov1beta1.DatastoreDriverNATS, claiov1beta1.DatastoreDriverPostgres, claiov1beta1.DatastoreDriverMySQL:
+		if datastore.DataStoreName != "" && datastore.SecretRef != nil {
+			allErrs = append(allErrs, field.Forbidden(datastorePath.Child("secretRef"), "the endpoint is provided by the datastore"))
+		}
+		if datastore.DataStoreName == "" && (datastore.SecretRef == nil || datastore.SecretRef.Name == "") {
+			allErrs = append(allErrs, field.Required(datastorePath.Child("secretRef"),
+				fmt.Sprintf("the %s driver needs a secret with the endpoint or a dataStoreName", datastore.Driver)))
+		}
+	case claiov1beta1.DatastoreDriverSQLite:
+		if datastore.SecretRef != nil {
+			allErrs = append(allErrs, field.Forbidden(datastorePath.Child("secretRef"), "the sqlite driver stores the state on a volume"))
+		}
+		if datastore.DataStoreName != "" {
+			allErrs = append(allErrs, field.Forbidden(datastorePath.Child("dataStoreName"), "the sqlite driver stores the state on a volume"))
+		}
+	case claiov1beta1.DatastoreDriverEtcd:
+		if datastore.SecretRef == nil || datastore.SecretRef.Name == "" {
+			allErrs = append(allErrs, field.Required(datastorePath.Child("secretRef"), "the etcd driver needs a secret with the endpoint"))
+		}
+		if datastore.DataStoreName != "" {
+			allErrs = append(allErrs, field.Forbidden(datastorePath.Child("dataStoreName"), "tenants cannot share an etcd"))
+		}
+		if datastore.DeletionPolicy != "" && datastore.DeletionPolicy != claiov1beta1.DeletionPolicyRetain {
+			allErrs = append(allErrs, field.NotSupported(datastorePath.Child("deletionPolicy"), datastore.DeletionPolicy,
+				[]string{claiov1beta1.DeletionPolicyRetain}))
+		}
+	case claiov1beta1.DatastoreDriverGateway:
+		if !gateway {
+			allErrs = append(allErrs, field.Forbidden(datastorePath.Child("driver"), "the manager has no kine gateway (--kine-gateway-endpoint)"))
+		}
+		if datastore.SecretRef != nil {
+			allErrs = append(allErrs, field.Forbidden(datastorePath.Child("secretRef"), "the endpoint is provided by the kine gateway"))
+		}
+		if datastore.DataStoreName != "" {
+			allErrs = append(allErrs, field.Forbidden(datastorePath.Child("dataStoreName"), "the endpoint is provided by the kine gateway"))
+		}
+		if datastore.DeletionPolicy != "" && datastore.DeletionPolicy != claiov1beta1.DeletionPolicyRetain {
+			allErrs = append(allErrs, field.NotSupported(datastorePath.Child("deletionPolicy"), datastore.DeletionPolicy,
+				[]string{claiov1beta1.DeletionPolicyRetain}))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(datastorePath.Child("driver"), datastore.Driver, []string{
+			claiov1beta1.DatastoreDriverNATS, claiov1beta1.DatastoreDriverPostgres, claiov1beta1.DatastoreDriverMySQL,
+			claiov1beta1.DatastoreDriverSQLite, claiov1beta1.DatastoreDriverEtcd, claiov1beta1.DatastoreDriverGateway,
+		}))
+	}
+	if datastore.Storage != nil && datastore.Driver != claiov1beta1.DatastoreDriverSQLite {
+		allErrs = append(allErrs, field.Forbidden(datastorePath.Child("storage"), "storage is only supported by the sqlite driver"))
+	}
+	if datastore.Storage != nil && datastore.Storage.Size != nil && datastore.Storage.Size.Sign() <= 0 {
+		allErrs = append(allErrs, field.Invalid(datastorePath.Child("storage", "size"), datastore.Storage.Size.String(), "must be greater than zero"))
+	}
+	if datastore.SnapshotStorage != nil && datastore.DeletionPolicy != claiov1beta1.DeletionPolicySnapshot {
+		allErrs = append(allErrs, field.Forbidden(datastorePath.Child("snapshotStorage"), "snapshotStorage is only used by the Snapshot policy"))
+	}
+	if datastore.SnapshotStorage != nil && datastore.SnapshotStorage.Size != nil && datastore.SnapshotStorage.Size.Sign() <= 0 {
+		allErrs = append(allErrs, field.Invalid(datastorePath.Child("snapshotStorage", "size"), datastore.SnapshotStorage.Size.String(), "must be greater than zero"))
+	}
+	if quota := datastore.Quota; quota != nil {
+		if quota.MaxBytes != nil && quota.MaxBytes.Sign() <= 0 {
+			allErrs = append(allErrs, field.Invalid(datastorePath.Child("quota", "maxBytes"), quota.MaxBytes.String(), "must be greater than zero"))
+		}
+		// JetStream keeps at most 64 revisions of a key
+		if quota.MaxRevisionHistory != nil && *quota.MaxRevisionHistory > 64 && datastore.Driver == claiov1beta1.DatastoreDriverNATS {
+			allErrs = append(allErrs, field.Invalid(datastorePath.Child("quota", "maxRevisionHistory"), *quota.MaxRevisionHistory,
+				"the nats driver keeps at most 64 revisions of a key"))
+		}
+	}
+	return allErrs
+}
+
+// validateComponent checks that the image is a reference and that the extra args are single flags,
+// both end up in the container spec of the deployment
+func validateComponent(component *claiov1beta1.ComponentSpec, componentPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if component.Image != "" && !imageReference.MatchString(component.Image) {
+		allErrs = append(allErrs, field.Invalid(componentPath.Child("image"), component.Image, "must be a valid image reference"))
+	}
+	for i, arg := range component.ExtraArgs {
+		argPath := componentPath.Child("extraArgs").Index(i)
+		if !strings.HasPrefix(arg, "--") {
+			allErrs = append(allErrs, field.Invalid(argPath, arg, "must be a flag starting with --"))
+		}
+		if strings.ContainsAny(arg, "\r\n") {
+			allErrs = append(allErrs, field.Invalid(argPath, arg, "must not contain line breaks"))
+		}
+	}
+	return allErrs
+}
+
+func cidrsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
@@ -0,0 +1,397 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	fuzz "github.com/google/gofuzz"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	claiov1alpha1 "claio/api/v1alpha1"
+	claiov1beta1 "claio/api/v1beta1"
+)
+
+var _ = Describe("ControlPlane Webhook", func() {
+	var (
+		ctx       context.Context
+		obj       *claiov1beta1.ControlPlane
+		oldObj    *claiov1beta1.ControlPlane
+		validator ControlPlaneCustomValidator
+		defaulter ControlPlaneCustomDefaulter
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		obj = &claiov1beta1.ControlPlane{
+			ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: "tenant-sample"},
+			Spec: claiov1beta1.ControlPlaneSpec{
+				Name:    "sample",
+				Version: "1.31.1",
+				Endpoint: claiov1beta1.EndpointSpec{
+					Host:    "test-host",
+					Address: "1.1.1.1",
+					Port:    6543,
+				},
+				Network: claiov1beta1.NetworkSpec{
+					ClusterCIDR: "192.168.0.0/17",
+					ServiceCIDR: "192.168.128.0/17",
+					DNSDomain:   "cluster.local",
+				},
+				Datastore: claiov1beta1.DatastoreSpec{Driver: "nats", DataStoreName: "nats"},
+			},
+		}
+		oldObj = obj.DeepCopy()
+		validator = ControlPlaneCustomValidator{}
+		defaulter = ControlPlaneCustomDefaulter{Defaults: DefaultControlPlaneDefaults}
+	})
+
+	Context("When creating ControlPlane under Defaulting Webhook", func() {
+		It("Should apply defaults when fields are not set", func() {
+			obj.Spec.Endpoint.Port = 0
+			obj.Spec.Version = ""
+			obj.Spec.Network.ClusterCIDR = ""
+			obj.Spec.Network.ServiceCIDR = ""
+			obj.Spec.Network.DNSDomain = ""
+			obj.Spec.Datastore = claiov1beta1.DatastoreSpec{}
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Endpoint.Port).To(Equal(6543))
+			Expect(obj.Spec.Version).To(Equal("1.31.1"))
+			Expect(obj.Spec.Datastore.Driver).To(Equal("nats"))
+			Expect(obj.Spec.Datastore.DataStoreName).To(Equal("nats"))
+			Expect(obj.Spec.Network.ClusterCIDR).To(Equal("192.168.0.0/17"))
+			Expect(obj.Spec.Network.ServiceCIDR).To(Equal("192.168.128.0/17"))
+			Expect(obj.Spec.Network.DNSDomain).To(Equal("cluster.local"))
+			Expect(obj.Spec.Certificates.RenewBefore).To(Equal(&metav1.Duration{Duration: 720 * time.Hour}))
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should apply the defaults of the manager", func() {
+			obj.Spec.Network.DNSDomain = ""
+			defaulter.Defaults.DNSDomain = "tenant.local"
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Network.DNSDomain).To(Equal("tenant.local"))
+		})
+
+		It("Should keep values which are set", func() {
+			obj.Spec.Datastore = claiov1beta1.DatastoreSpec{Driver: "postgres"}
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Endpoint.Port).To(Equal(6543))
+			Expect(obj.Spec.Datastore.Driver).To(Equal("postgres"))
+			Expect(obj.Spec.Datastore.DataStoreName).To(BeEmpty())
+		})
+
+		It("Should not place a nats control-plane with its own secret on the default DataStore", func() {
+			obj.Spec.Datastore = claiov1beta1.DatastoreSpec{
+				Driver:    "nats",
+				SecretRef: &corev1.LocalObjectReference{Name: "datastore"},
+			}
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Datastore.DataStoreName).To(BeEmpty())
+		})
+	})
+
+	Context("When creating a ControlPlane under the Validating Webhook", func() {
+		It("Should admit a valid spec", func() {
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should deny an invalid advertise-address", func() {
+			obj.Spec.Endpoint.Address = "1.1.1"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.endpoint.address")))
+		})
+
+		It("Should deny invalid CIDRs", func() {
+			obj.Spec.Network.ClusterCIDR = "192.168.0.0"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.network.clusterCIDR")))
+		})
+
+		It("Should deny overlapping service and cluster CIDRs", func() {
+			obj.Spec.Network.ServiceCIDR = "192.168.64.0/18"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("must not overlap")))
+		})
+
+		It("Should deny invalid extra SANs", func() {
+			obj.Spec.Certificates.ExtraSANs = []string{"10.0.0.1", "api.example.com", "not a name"}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.certificates.extraSANs[2]")))
+		})
+
+		It("Should deny a missing port", func() {
+			obj.Spec.Endpoint.Port = 0
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.endpoint.port")))
+		})
+
+		It("Should deny unsupported versions", func() {
+			obj.Spec.Version = "1.20.0"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("unsupported version")))
+			obj.Spec.Version = "v1.31.1"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.version")))
+		})
+
+		It("Should deny unknown datastore drivers", func() {
+			obj.Spec.Datastore.Driver = "redis"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.datastore.driver: Unsupported value")))
+		})
+
+		It("Should require a datastore secret for external datastores", func() {
+			obj.Spec.Datastore = claiov1beta1.DatastoreSpec{Driver: "postgres"}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.datastore.secretRef: Required")))
+			obj.Spec.Datastore.SecretRef = &corev1.LocalObjectReference{Name: "datastore"}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should take the endpoint either from a secret or from a DataStore", func() {
+			obj.Spec.Datastore = claiov1beta1.DatastoreSpec{Driver: "postgres", DataStoreName: "postgres-pool"}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+			obj.Spec.Datastore.SecretRef = &corev1.LocalObjectReference{Name: "datastore"}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.datastore.secretRef: Forbidden")))
+		})
+
+		It("Should deny DataStores for sqlite and etcd", func() {
+			obj.Spec.Datastore = claiov1beta1.DatastoreSpec{Driver: "sqlite", DataStoreName: "pool"}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.datastore.dataStoreName: Forbidden")))
+			obj.Spec.Datastore = claiov1beta1.DatastoreSpec{
+				Driver:        "etcd",
+				DataStoreName: "pool",
+				SecretRef:     &corev1.LocalObjectReference{Name: "datastore"},
+			}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.datastore.dataStoreName: Forbidden")))
+		})
+
+		It("Should only allow storage for sqlite", func() {
+			size := resource.MustParse("5Gi")
+			obj.Spec.Datastore.Storage = &claiov1beta1.DatastoreStorageSpec{Size: &size}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.datastore.storage: Forbidden")))
+			obj.Spec.Datastore.Driver = "sqlite"
+			obj.Spec.Datastore.DataStoreName = ""
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should only allow the Retain policy for etcd", func() {
+			obj.Spec.Datastore = claiov1beta1.DatastoreSpec{
+				Driver:         "etcd",
+				SecretRef:      &corev1.LocalObjectReference{Name: "datastore"},
+				DeletionPolicy: claiov1beta1.DeletionPolicyDelete,
+			}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.datastore.deletionPolicy: Unsupported value")))
+			obj.Spec.Datastore.DeletionPolicy = claiov1beta1.DeletionPolicyRetain
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should only admit the gateway driver if the manager has a kine gateway", func() {
+			obj.Spec.Datastore = claiov1beta1.DatastoreSpec{Driver: "gateway"}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.datastore.driver: Forbidden")))
+			validator.Gateway = true
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should deny secrets, DataStores and deletion policies for the gateway driver", func() {
+			validator.Gateway = true
+			obj.Spec.Datastore = claiov1beta1.DatastoreSpec{
+				Driver:    "gateway",
+				SecretRef: &corev1.LocalObjectReference{Name: "datastore"},
+			}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.datastore.secretRef: Forbidden")))
+			obj.Spec.Datastore = claiov1beta1.DatastoreSpec{Driver: "gateway", DataStoreName: "nats"}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.datastore.dataStoreName: Forbidden")))
+			obj.Spec.Datastore = claiov1beta1.DatastoreSpec{Driver: "gateway", DeletionPolicy: claiov1beta1.DeletionPolicyDelete}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.datastore.deletionPolicy: Unsupported value")))
+		})
+
+		It("Should only allow snapshotStorage for the Snapshot policy", func() {
+			size := resource.MustParse("5Gi")
+			obj.Spec.Datastore.SnapshotStorage = &claiov1beta1.DatastoreStorageSpec{Size: &size}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.datastore.snapshotStorage: Forbidden")))
+			obj.Spec.Datastore.DeletionPolicy = claiov1beta1.DeletionPolicySnapshot
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should deny a renewal window longer than the certificates", func() {
+			obj.Spec.Certificates.RenewBefore = &metav1.Duration{Duration: 400 * 24 * time.Hour}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.certificates.renewBefore: Invalid value")))
+			obj.Spec.Certificates.RenewBefore = &metav1.Duration{Duration: -time.Hour}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.certificates.renewBefore: Invalid value")))
+			obj.Spec.Certificates.RenewBefore = &metav1.Duration{Duration: 60 * 24 * time.Hour}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should require the secret of an own CA", func() {
+			obj.Spec.Certificates.CA = &claiov1beta1.CASource{}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("spec.certificates.ca.secretRef.name: Required value")))
+			obj.Spec.Certificates.CA.SecretRef.Name = "sample-ca"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should validate the datastore quota", func() {
+			size := resource.MustParse("0")
+			history := int32(100)
+			obj.Spec.Datastore.Quota = &claiov1beta1.DatastoreQuotaSpec{MaxBytes: &size, MaxRevisionHistory: &history}
+			err := validator.validateSpec(&obj.Spec).ToAggregate()
+			Expect(err).To(MatchError(ContainSubstring("spec.datastore.quota.maxBytes: Invalid value")))
+			Expect(err).To(MatchError(ContainSubstring("spec.datastore.quota.maxRevisionHistory: Invalid value")))
+			size = resource.MustParse("1Gi")
+			history = 64
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should only admit image references and flags for the components", func() {
+			obj.Spec.Components.APIServer.Image = "registry.example.com/kube-apiserver:v1.31.1\n  command: [sh]"
+			obj.Spec.Components.Scheduler.ExtraArgs = []string{"-v=2", "--v=2\n            - --kubeconfig=/tmp/other"}
+			err := validator.validateSpec(&obj.Spec).ToAggregate()
+			Expect(err).To(MatchError(ContainSubstring("spec.components.apiServer.image: Invalid value")))
+			Expect(err).To(MatchError(ContainSubstring("spec.components.scheduler.extraArgs[0]: Invalid value")))
+			Expect(err).To(MatchError(ContainSubstring("spec.components.scheduler.extraArgs[1]: Invalid value")))
+			obj.Spec.Components.APIServer.Image = "registry.example.com:5000/k8s/kube-apiserver:v1.31.1@sha256:" + strings.Repeat("a", 64)
+			obj.Spec.Components.Scheduler.ExtraArgs = []string{"--v=2", "--feature-gates=A=true,B=false"}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should honor the configured supported versions", func() {
+			validator.SupportedVersions = []string{"1.32"}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(HaveOccurred())
+			obj.Spec.Version = "1.32.0"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+	})
+
+	Context("When updating a ControlPlane under the Validating Webhook", func() {
+		It("Should admit changes of mutable fields", func() {
+			obj.Spec.Version = "1.30.4"
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should deny changes of the name and the CIDRs", func() {
+			obj.Spec.Name = "other"
+			obj.Spec.Network.ClusterCIDR = "10.0.0.0/16"
+			obj.Spec.Network.ServiceCIDR = "10.1.0.0/16"
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.name: Forbidden")))
+			Expect(err).To(MatchError(ContainSubstring("spec.network.clusterCIDR: Forbidden")))
+			Expect(err).To(MatchError(ContainSubstring("spec.network.serviceCIDR: Forbidden")))
+		})
+
+		It("Should admit moving a control-plane to another datastore", func() {
+			obj.Spec.Datastore.DataStoreName = "other"
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+			obj.Spec.Datastore = claiov1beta1.DatastoreSpec{Driver: "sqlite"}
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should deny changes of the datastore while a migration is running", func() {
+			oldObj.Status.Migration = &claiov1beta1.DatastoreMigrationStatus{
+				Phase:  claiov1beta1.DatastoreMigrationRunning,
+				Source: "nats on DataStore nats",
+				Target: "postgres on DataStore postgres",
+			}
+			obj.Spec.Version = "1.30.4"
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+			obj.Spec.Datastore.DataStoreName = "other"
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().To(MatchError(ContainSubstring("spec.datastore: Forbidden")))
+			oldObj.Status.Migration.Phase = claiov1beta1.DatastoreMigrationFailed
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+		})
+	})
+
+	Context("When converting a ControlPlane under the Conversion Webhook", func() {
+		It("Should convert a v1alpha1 object to the hub", func() {
+			src := &claiov1alpha1.ControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: "sample"},
+				Spec: claiov1alpha1.ControlPlaneSpec{
+					Name:             "sample",
+					Port:             6543,
+					Version:          "1.31.1",
+					Database:         "nats",
+					ClusterCIDR:      "192.168.0.0/17",
+					ServiceCIDR:      "192.168.128.0/17",
+					AdvertiseAddress: "1.1.1.1",
+					AdvertiseHost:    "test-host",
+				},
+			}
+			dst := &claiov1beta1.ControlPlane{}
+			Expect(src.ConvertTo(dst)).To(Succeed())
+			Expect(dst.Spec.Endpoint).To(Equal(claiov1beta1.EndpointSpec{Host: "test-host", Address: "1.1.1.1", Port: 6543}))
+			Expect(dst.Spec.Network.ClusterCIDR).To(Equal("192.168.0.0/17"))
+			Expect(dst.Spec.Network.ServiceCIDR).To(Equal("192.168.128.0/17"))
+			Expect(dst.Spec.Network.DNSDomain).To(Equal("cluster.local"))
+			Expect(dst.Spec.Datastore.Driver).To(Equal("nats"))
+		})
+
+		It("Should keep v1beta1 only fields on a round trip", func() {
+			obj.Spec.Network.DNSDomain = "tenant.local"
+			obj.Spec.Components.APIServer.ExtraArgs = []string{"--v=4"}
+			obj.Spec.Certificates.ExtraSANs = []string{"api.example.com"}
+			disabled := false
+			obj.Spec.Addons.CoreDNS.Enabled = &disabled
+			obj.Spec.Datastore.SecretRef = &corev1.LocalObjectReference{Name: "datastore"}
+			obj.Spec.Datastore.DeletionPolicy = claiov1beta1.DeletionPolicyDelete
+
+			spoke := &claiov1alpha1.ControlPlane{}
+			Expect(spoke.ConvertFrom(obj)).To(Succeed())
+			Expect(spoke.Annotations).To(HaveKey(claiov1alpha1.ConversionDataAnnotation))
+			Expect(spoke.Spec.AdvertiseHost).To(Equal("test-host"))
+
+			hub := &claiov1beta1.ControlPlane{}
+			Expect(spoke.ConvertTo(hub)).To(Succeed())
+			Expect(hub.Spec).To(Equal(obj.Spec))
+			Expect(hub.Annotations).NotTo(HaveKey(claiov1alpha1.ConversionDataAnnotation))
+		})
+
+		It("Should keep the v1beta1 status on a round trip", func() {
+			obj.Status.TargetSpec = *obj.Spec.DeepCopy()
+			obj.Status.TargetSpec.Network.DNSDomain = "tenant.local"
+			obj.Status.TargetSpec.Components.APIServer.ExtraArgs = []string{"--v=4"}
+			obj.Status.Phase = claiov1beta1.ControlPlanePhaseReady
+			obj.Status.ObservedGeneration = 2
+
+			spoke := &claiov1alpha1.ControlPlane{}
+			Expect(spoke.ConvertFrom(obj)).To(Succeed())
+			hub := &claiov1beta1.ControlPlane{}
+			Expect(spoke.ConvertTo(hub)).To(Succeed())
+			Expect(hub.Status).To(Equal(obj.Status))
+		})
+
+		It("Should convert random v1beta1 objects losslessly", func() {
+			fuzzer := fuzz.New().NilChance(0.3).Funcs(
+				func(q *resource.Quantity, c fuzz.Continue) {
+					*q = *resource.NewQuantity(c.Int63n(1<<40), resource.BinarySI)
+				},
+				func(t *metav1.Time, c fuzz.Continue) {
+					*t = metav1.Unix(c.Int63n(1<<32), 0)
+				},
+			)
+			for i := 0; i < 1000; i++ {
+				src := &claiov1beta1.ControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "sample"}}
+				fuzzer.Fuzz(&src.Spec)
+				fuzzer.Fuzz(&src.Status)
+
+				spoke := &claiov1alpha1.ControlPlane{}
+				Expect(spoke.ConvertFrom(src)).To(Succeed())
+				hub := &claiov1beta1.ControlPlane{}
+				Expect(spoke.ConvertTo(hub)).To(Succeed())
+				Expect(apiequality.Semantic.DeepEqual(hub, src)).To(BeTrue(), "lossy conversion of %+v", src)
+			}
+		})
+	})
+})